@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"log/slog"
+	"os"
 
 	"github.com/RealZimboGuy/gopherflow/internal/workflows"
 	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow"
@@ -42,7 +43,11 @@ func main() {
 		},
 	}
 
-	app := gopherflow.Setup(workflowRegistry)
+	app, err := gopherflow.Setup(workflowRegistry)
+	if err != nil {
+		slog.Error("Invalid workflow definitions", "error", err)
+		os.Exit(1)
+	}
 
 	if err := app.Run(ctx); err != nil {
 		slog.Error("Engine exited with error", "error", err)
@@ -12,9 +12,13 @@ const ENGINE_SERVER_WEB_PORT = "GFLOW_ENGINE_SERVER_WEB_PORT"
 const ENGINE_CHECK_DB_INTERVAL = "GFLOW_ENGINE_CHECK_DB_INTERVAL"
 const ENGINE_STUCK_WORKFLOWS_INTERVAL = "GFLOW_ENGINE_STUCK_WORKFLOWS_INTERVAL"
 const ENGINE_STUCK_WORKFLOWS_REPAIR_AFTER_MINUTES = "GFLOW_ENGINE_STUCK_WORKFLOWS_REPAIR_AFTER_MINUTES"
-const ENGINE_BATCH_SIZE = "GFLOW_ENGINE_BATCH_SIZE"         //number of workflows to pull from the database at a time
-const ENGINE_EXECUTOR_GROUP = "GFLOW_ENGINE_EXECUTOR_GROUP" //the group id of the exexutor that it will process jobs from
-const ENGINE_EXECUTOR_SIZE = "GFLOW_ENGINE_EXECUTOR_SIZE"   //number of workers to run ie the parallel nature of the jobs
+const ENGINE_BATCH_SIZE = "GFLOW_ENGINE_BATCH_SIZE"                       //number of workflows to pull from the database at a time
+const ENGINE_EXECUTOR_GROUP = "GFLOW_ENGINE_EXECUTOR_GROUP"               //the groups the executor will process jobs from, comma separated with optional :weight
+const ENGINE_EXECUTOR_SIZE = "GFLOW_ENGINE_EXECUTOR_SIZE"                 //number of workers to run ie the parallel nature of the jobs
+const ENGINE_NOTIFY_ENABLED = "GFLOW_ENGINE_NOTIFY_ENABLED"               //on postgres, announce workflow changes with NOTIFY so executors poll straight away
+const ENGINE_LEASE_DURATION = "GFLOW_ENGINE_LEASE_DURATION"               //how long an executor holds a workflow without renewing its lease
+const ENGINE_SHUTDOWN_GRACE_PERIOD = "GFLOW_ENGINE_SHUTDOWN_GRACE_PERIOD" //how long running states may take to finish when the engine shuts down
+const ENGINE_PRIORITY_AGING = "GFLOW_ENGINE_PRIORITY_AGING"               //how long a due workflow waits to gain one priority level
 const WEB_SESSION_EXPIRY_HOURS = "GFLOW_WEB_SESSION_EXPIRY_HOURS"
 
 const DATABASE_TYPE_POSTGRES = "POSTGRES"
@@ -61,6 +65,18 @@ func GetSystemSettingString(settingKey string) string {
 	if settingKey == ENGINE_SERVER_WEB_PORT {
 		return "8080"
 	}
+	if settingKey == ENGINE_NOTIFY_ENABLED {
+		return "true"
+	}
+	if settingKey == ENGINE_LEASE_DURATION {
+		return "60s" // renewed every third of it
+	}
+	if settingKey == ENGINE_SHUTDOWN_GRACE_PERIOD {
+		return "30s"
+	}
+	if settingKey == ENGINE_PRIORITY_AGING {
+		return "1m"
+	}
 	if settingKey == WEB_SESSION_EXPIRY_HOURS {
 		return "1"
 	}
@@ -3,21 +3,30 @@ package controllers
 import "net/http"
 
 // RegisterRoutes wires the HTTP routes for this controller.
-func (c *WorkflowsController) RegisterRoutes() {
-	http.HandleFunc("/api/workflows", c.RequireAuth(c.handleCreateWorkflow))
-	http.HandleFunc("/api/workflows/{id}", c.RequireAuth(c.handleGetWorkflowById))
-	http.HandleFunc("/api/workflowByExternalId/{externalId}", c.RequireAuth(c.handleGetWorkflowByExternalId))
-	http.HandleFunc("/api/createAndWait", c.RequireAuth(c.handleCreateAndWaitWorkflow))
-	http.HandleFunc("/api/workflows/search", c.RequireAuth(c.handleSearchWorkflows))
-	http.HandleFunc("/api/definitions", c.RequireAuth(c.handleListWorkflowDefinitions))
-	http.HandleFunc("/api/definitions/{name}", c.RequireAuth(c.handleGetWorkflowDefinitionByName))
-	http.HandleFunc("POST /api/workflows/{id}/state", c.RequireAuth(c.handleUpdateWorkflowState))
-	http.HandleFunc("POST /api/workflows/{id}/stateAndWait", c.RequireAuth(c.handleUpdateWorkflowStateAndWait))
-	http.HandleFunc("POST /api/workflows/{id}/statevars", c.RequireAuth(c.handleUpdateStateVar))
+func (c *WorkflowsController) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/api/workflows", c.RequireAuth(c.handleCreateWorkflow))
+	mux.HandleFunc("/api/workflows/{id}", c.RequireAuth(c.handleGetWorkflowById))
+	mux.HandleFunc("/api/workflowByExternalId/{externalId}", c.RequireAuth(c.handleGetWorkflowByExternalId))
+	mux.HandleFunc("/api/createAndWait", c.RequireAuth(c.handleCreateAndWaitWorkflow))
+	mux.HandleFunc("/api/workflows/search", c.RequireAuth(c.handleSearchWorkflows))
+	mux.HandleFunc("/api/definitions", c.RequireAuth(c.handleListWorkflowDefinitions))
+	mux.HandleFunc("/api/definitions/{name}", c.RequireAuth(c.handleGetWorkflowDefinitionByName))
+	mux.HandleFunc("GET /api/definitions/{name}/versions", c.RequireAuth(c.handleGetWorkflowDefinitionVersions))
+	mux.HandleFunc("POST /api/workflows/{id}/state", c.RequireAuth(c.handleUpdateWorkflowState))
+	mux.HandleFunc("POST /api/workflows/{id}/stateAndWait", c.RequireAuth(c.handleUpdateWorkflowStateAndWait))
+	mux.HandleFunc("POST /api/workflows/{id}/statevars", c.RequireAuth(c.handleUpdateStateVar))
+	mux.HandleFunc("POST /api/workflows/{id}/cancel", c.RequireAuth(c.handleCancelWorkflow))
+	mux.HandleFunc("POST /api/workflows/{id}/pause", c.RequireAuth(c.handlePauseWorkflow))
+	mux.HandleFunc("POST /api/workflows/{id}/resume", c.RequireAuth(c.handleResumeWorkflow))
+	mux.HandleFunc("POST /api/workflows/{id}/priority", c.RequireAuth(c.handleUpdatePriority))
+	mux.HandleFunc("POST /api/workflows/{id}/signals/{name}", c.RequireAuth(c.handleSendSignal))
+	mux.HandleFunc("GET /api/workflows/{id}/tree", c.RequireAuth(c.handleGetWorkflowTree))
+	mux.HandleFunc("POST /api/workflows/pause", c.RequireAuth(c.handlePauseWorkflows))
+	mux.HandleFunc("POST /api/workflows/resume", c.RequireAuth(c.handleResumeWorkflows))
 }
-func (c *ActionsController) RegisterRoutes() {
-	http.HandleFunc("/api/actions/byWorkflowId/{id}", c.RequireAuth(c.handleGetActionsForWorkflow))
+func (c *ActionsController) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/api/actions/byWorkflowId/{id}", c.RequireAuth(c.handleGetActionsForWorkflow))
 }
-func (c *ExecutorsController) RegisterRoutes() {
-	http.HandleFunc("/api/executors", c.RequireAuth(c.handleGetExecutors))
+func (c *ExecutorsController) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/api/executors", c.RequireAuth(c.handleGetExecutors))
 }
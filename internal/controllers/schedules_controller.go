@@ -0,0 +1,187 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/RealZimboGuy/gopherflow/internal/engine"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/domain"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/models"
+)
+
+type SchedulesController struct {
+	AuthController
+	WorkflowManager *engine.WorkflowManager
+}
+
+func NewSchedulesController(manager *engine.WorkflowManager, userRepo engine.UserRepo) *SchedulesController {
+	return &SchedulesController{
+		WorkflowManager: manager,
+		AuthController: AuthController{
+			UserRepo: userRepo,
+		},
+	}
+}
+
+// RegisterRoutes wires up the HTTP routes for this controller
+func (c *SchedulesController) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/schedules", c.RequireAuth(c.handleGetSchedules))
+	mux.HandleFunc("POST /api/schedules", c.RequireAuth(c.handleCreateSchedule))
+	mux.HandleFunc("GET /api/schedules/{id}", c.RequireAuth(c.handleGetScheduleById))
+	mux.HandleFunc("PUT /api/schedules/{id}", c.RequireAuth(c.handleUpdateSchedule))
+	mux.HandleFunc("DELETE /api/schedules/{id}", c.RequireAuth(c.handleDeleteSchedule))
+}
+
+func (c *SchedulesController) handleGetSchedules(w http.ResponseWriter, r *http.Request) {
+	schedules, err := c.WorkflowManager.ListSchedules()
+	if err != nil {
+		slog.Error("Failed to get schedules", "error", err)
+		http.Error(w, "failed to get schedules", http.StatusInternalServerError)
+		return
+	}
+	resp := make([]models.ScheduleApiResponse, 0, len(*schedules))
+	for i := range *schedules {
+		resp = append(resp, scheduleToApiResponse(&(*schedules)[i]))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (c *SchedulesController) handleCreateSchedule(w http.ResponseWriter, r *http.Request) {
+	s, ok := decodeScheduleRequest(w, r)
+	if !ok {
+		return
+	}
+	c.saveSchedule(w, s, http.StatusCreated)
+}
+
+func (c *SchedulesController) handleGetScheduleById(w http.ResponseWriter, r *http.Request) {
+	s := c.findSchedule(w, r)
+	if s == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(scheduleToApiResponse(s))
+}
+
+func (c *SchedulesController) handleUpdateSchedule(w http.ResponseWriter, r *http.Request) {
+	existing := c.findSchedule(w, r)
+	if existing == nil {
+		return
+	}
+	s, ok := decodeScheduleRequest(w, r)
+	if !ok {
+		return
+	}
+	s.ID = existing.ID
+	s.Created = existing.Created
+	s.LastRun = existing.LastRun
+	s.LastWorkflowID = existing.LastWorkflowID
+	c.saveSchedule(w, s, http.StatusOK)
+}
+
+func (c *SchedulesController) handleDeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	s := c.findSchedule(w, r)
+	if s == nil {
+		return
+	}
+	if err := c.WorkflowManager.DeleteSchedule(s.ID); err != nil {
+		slog.Error("Failed to delete schedule", "error", err)
+		http.Error(w, "failed to delete schedule", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// findSchedule loads the schedule named by the id path value, writing the error response when there is none.
+func (c *SchedulesController) findSchedule(w http.ResponseWriter, r *http.Request) *domain.Schedule {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid schedule id", http.StatusBadRequest)
+		return nil
+	}
+	s, err := c.WorkflowManager.GetSchedule(id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && s == nil) {
+		http.Error(w, "schedule not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		slog.Error("Failed to get schedule", "error", err)
+		http.Error(w, "failed to get schedule", http.StatusInternalServerError)
+		return nil
+	}
+	return s
+}
+
+func (c *SchedulesController) saveSchedule(w http.ResponseWriter, s *domain.Schedule, status int) {
+	if err := c.WorkflowManager.SaveSchedule(s); err != nil {
+		if errors.Is(err, engine.ErrInvalidSchedule) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.Error("Failed to save schedule", "error", err)
+		http.Error(w, "failed to save schedule", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(scheduleToApiResponse(s))
+}
+
+func decodeScheduleRequest(w http.ResponseWriter, r *http.Request) (*domain.Schedule, bool) {
+	var req models.ScheduleRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "invalid JSON payload", http.StatusBadRequest)
+		return nil, false
+	}
+	s := &domain.Schedule{
+		Name:           req.Name,
+		CronExpression: req.CronExpression,
+		Timezone:       req.Timezone,
+		WorkflowType:   req.WorkflowType,
+		ExecutorGroup:  req.ExecutorGroup,
+		BusinessKey:    req.BusinessKey,
+		OverlapPolicy:  req.OverlapPolicy,
+		Enabled:        req.Enabled,
+	}
+	if len(req.StateVars) > 0 {
+		b, _ := json.Marshal(req.StateVars)
+		s.StateVars = string(b)
+	}
+	return s, true
+}
+
+func scheduleToApiResponse(s *domain.Schedule) models.ScheduleApiResponse {
+	resp := models.ScheduleApiResponse{
+		ID:             s.ID,
+		Name:           s.Name,
+		CronExpression: s.CronExpression,
+		Timezone:       s.Timezone,
+		WorkflowType:   s.WorkflowType,
+		ExecutorGroup:  s.ExecutorGroup,
+		BusinessKey:    s.BusinessKey,
+		OverlapPolicy:  s.OverlapPolicy,
+		Enabled:        s.Enabled,
+		LastWorkflowID: s.LastWorkflowID.Int64,
+		Created:        s.Created,
+		Modified:       s.Modified,
+	}
+	if s.StateVars != "" {
+		_ = json.Unmarshal([]byte(s.StateVars), &resp.StateVars)
+	}
+	if s.NextRun.Valid {
+		resp.NextRun = &s.NextRun.Time
+	}
+	if s.LastRun.Valid {
+		resp.LastRun = &s.LastRun.Time
+	}
+	return resp
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RealZimboGuy/gopherflow/internal/engine"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/core"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/domain"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/models"
+)
+
+type MockScheduleRepo struct {
+	Schedules map[int64]domain.Schedule
+}
+
+func (m *MockScheduleRepo) Save(s *domain.Schedule) (int64, error) {
+	s.ID = int64(len(m.Schedules) + 1)
+	m.Schedules[s.ID] = *s
+	return s.ID, nil
+}
+func (m *MockScheduleRepo) Update(s *domain.Schedule) error {
+	m.Schedules[s.ID] = *s
+	return nil
+}
+func (m *MockScheduleRepo) FindByID(id int64) (*domain.Schedule, error) {
+	s, ok := m.Schedules[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &s, nil
+}
+func (m *MockScheduleRepo) FindAll() (*[]domain.Schedule, error) {
+	all := []domain.Schedule{}
+	for _, s := range m.Schedules {
+		all = append(all, s)
+	}
+	return &all, nil
+}
+func (m *MockScheduleRepo) FindDueSchedules(workflowTypes []string, limit int) (*[]domain.Schedule, error) {
+	return &[]domain.Schedule{}, nil
+}
+func (m *MockScheduleRepo) ClaimScheduleRun(id int64, dueAt time.Time, nextRun sql.NullTime) bool {
+	return false
+}
+func (m *MockScheduleRepo) SetLastWorkflow(id int64, workflowID int64) error { return nil }
+func (m *MockScheduleRepo) DeleteByID(id int64) error {
+	delete(m.Schedules, id)
+	return nil
+}
+
+func TestSchedulesController_CreateAndUpdateSchedule(t *testing.T) {
+	repo := &MockScheduleRepo{Schedules: map[int64]domain.Schedule{}}
+	registry := map[string]func() core.Workflow{"Report": nil}
+	wm := engine.NewWorkflowManager(&MockWorkflowRepo{}, &MockWorkflowActionRepo{}, &MockExecutorRepo{}, &MockDefinitionRepo{}, repo, &registry, core.NewRealClock())
+	c := NewSchedulesController(wm, nil)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"valid schedule", `{"name":"daily-report","cronExpression":"30 6 * * MON-FRI","timezone":"Africa/Johannesburg","workflowType":"Report","executorGroup":"default","stateVars":{"day":"${scheduledTime}"},"overlapPolicy":"SKIP","enabled":true}`, http.StatusCreated},
+		{"invalid cron", `{"name":"broken","cronExpression":"30 25 * * *","workflowType":"Report","enabled":true}`, http.StatusBadRequest},
+		{"unknown workflow type", `{"name":"broken","cronExpression":"@daily","workflowType":"Missing","enabled":true}`, http.StatusBadRequest},
+		{"unknown field", `{"name":"broken","cron":"@daily"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/schedules", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			c.handleCreateSchedule(w, req)
+			if w.Result().StatusCode != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, w.Result().StatusCode, w.Body.String())
+			}
+		})
+	}
+
+	if len(repo.Schedules) != 1 {
+		t.Fatalf("Expected one stored schedule, got %d", len(repo.Schedules))
+	}
+	stored := repo.Schedules[1]
+	if !stored.NextRun.Valid || stored.NextRun.Time.UTC().Hour() != 4 || stored.NextRun.Time.Minute() != 30 {
+		t.Errorf("Expected next run at 06:30 Johannesburg time, got %v", stored.NextRun)
+	}
+
+	// disabling a schedule clears its next run
+	req := httptest.NewRequest("PUT", "/api/schedules/1", strings.NewReader(`{"name":"daily-report","cronExpression":"30 6 * * *","workflowType":"Report","enabled":false}`))
+	req.SetPathValue("id", "1")
+	w := httptest.NewRecorder()
+	c.handleUpdateSchedule(w, req)
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Result().StatusCode, w.Body.String())
+	}
+	var resp models.ScheduleApiResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Enabled || resp.NextRun != nil || resp.Timezone != "UTC" {
+		t.Errorf("Expected a disabled UTC schedule without next run, got %+v", resp)
+	}
+
+	req = httptest.NewRequest("GET", "/api/schedules/9", nil)
+	req.SetPathValue("id", "9")
+	w = httptest.NewRecorder()
+	c.handleGetScheduleById(w, req)
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a missing schedule, got %d", w.Result().StatusCode)
+	}
+}
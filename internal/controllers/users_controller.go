@@ -28,11 +28,11 @@ func NewUsersController(userRepo engine.UserRepo) *UsersController {
 }
 
 // RegisterRoutes wires up the HTTP routes for this controller
-func (c *UsersController) RegisterRoutes() {
-	http.HandleFunc("GET /api/users", c.RequireAuth(c.handleGetUsers))
-	http.HandleFunc("POST /api/users", c.RequireAuth(c.handleCreateUser))
-	http.HandleFunc("GET /api/users/{id}", c.RequireAuth(c.handleGetUserById))
-	http.HandleFunc("DELETE /api/users/{id}", c.RequireAuth(c.handleDeleteUser))
+func (c *UsersController) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/users", c.RequireAuth(c.handleGetUsers))
+	mux.HandleFunc("POST /api/users", c.RequireAuth(c.handleCreateUser))
+	mux.HandleFunc("GET /api/users/{id}", c.RequireAuth(c.handleGetUserById))
+	mux.HandleFunc("DELETE /api/users/{id}", c.RequireAuth(c.handleDeleteUser))
 }
 
 // handleGetUsers returns all users
@@ -12,6 +12,7 @@ import (
 	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/domain"
 	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/models"
 
+	"io"
 	"log/slog"
 	"net/http"
 	"strconv"
@@ -181,18 +182,20 @@ func createWorkflow(ctx context.Context, c *WorkflowsController, req models.Crea
 	}
 
 	wf := &domain.Workflow{
-		Status:         "NEW",
-		ExecutionCount: 0,
-		RetryCount:     0,
-		Created:        now,
-		Modified:       now,
-		NextActivation: sql.NullTime{Time: nextActivation, Valid: true},
-		Started:        sql.NullTime{},
-		ExecutorGroup:  req.ExecutorGroup,
-		WorkflowType:   req.WorkflowType,
-		ExternalID:     req.ExternalID,
-		BusinessKey:    req.BusinessKey,
-		State:          initialState,
+		Status:          "NEW",
+		ExecutionCount:  0,
+		RetryCount:      0,
+		Created:         now,
+		Modified:        now,
+		NextActivation:  sql.NullTime{Time: nextActivation, Valid: true},
+		Started:         sql.NullTime{},
+		ExecutorGroup:   req.ExecutorGroup,
+		WorkflowType:    req.WorkflowType,
+		ExternalID:      req.ExternalID,
+		BusinessKey:     req.BusinessKey,
+		State:           initialState,
+		WorkflowVersion: engine.WorkflowVersion(wfInstance),
+		Priority:        req.Priority,
 	}
 	if stateVarsJSON != "" {
 		wf.StateVars.String = stateVarsJSON
@@ -233,28 +236,38 @@ func (c *WorkflowsController) handleCreateAndWaitWorkflow(w http.ResponseWriter,
 	err, id := createWorkflow(r.Context(), c, req.CreateWorkflowRequest)
 	c.WorkflowManager.Wakeup()
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(req.WaitSeconds)*time.Second)
+	c.waitForWorkflowStates(w, id, req.WaitSeconds, req.CheckSeconds, req.WaitForStates)
+}
+
+// waitForWorkflowStates writes the workflow once it is in one of the states, or a timeout after waitSeconds.
+// It checks every checkSeconds, and straight away when a notification announces a change to the workflow.
+func (c *WorkflowsController) waitForWorkflowStates(w http.ResponseWriter, id int64, waitSeconds int, checkSeconds int, states []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(waitSeconds)*time.Second)
 	defer cancel()
-	ticker := time.NewTicker(time.Duration(req.CheckSeconds) * time.Second) // check every
+	ticker := time.NewTicker(time.Duration(checkSeconds) * time.Second) // check every
 	defer ticker.Stop()
 
 	for {
+		changed, stop := c.WorkflowManager.WorkflowChanged(id)
 		select {
 		case <-ctx.Done():
+			stop()
 			// Timeout reached
 			http.Error(w, "timeout waiting for workflow result", http.StatusGatewayTimeout)
 			return
 		case <-ticker.C:
-			// Try to fetch workflow result by ID
-			result, err := c.WorkflowManager.WorkflowRepo.FindByID(id)
-			if err == nil {
-				if len(req.WaitForStates) == 0 || contains(req.WaitForStates, result.State) {
-					w.Header().Set("Content-Type", "application/json")
-					w.WriteHeader(http.StatusOK)
-					apiResult := mapWorkflowToApiWorkflow(result, id)
-					json.NewEncoder(w).Encode(apiResult)
-					return
-				}
+		case <-changed:
+		}
+		stop()
+		// Try to fetch workflow result by ID
+		result, err := c.WorkflowManager.WorkflowRepo.FindByID(id)
+		if err == nil {
+			if len(states) == 0 || contains(states, result.State) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusOK)
+				apiResult := mapWorkflowToApiWorkflow(result, id)
+				json.NewEncoder(w).Encode(apiResult)
+				return
 			}
 		}
 	}
@@ -292,12 +305,14 @@ func mapWorkflowToApiWorkflow(result *domain.Workflow, id int64) models.Workflow
 			}
 			return ""
 		}(),
-		ExecutorGroup: result.ExecutorGroup,
-		WorkflowType:  result.WorkflowType,
-		ExternalID:    result.ExternalID,
-		BusinessKey:   result.BusinessKey,
-		State:         result.State,
-		StateVars:     stateVars,
+		ExecutorGroup:   result.ExecutorGroup,
+		WorkflowType:    result.WorkflowType,
+		ExternalID:      result.ExternalID,
+		BusinessKey:     result.BusinessKey,
+		State:           result.State,
+		StateVars:       stateVars,
+		WorkflowVersion: result.WorkflowVersion,
+		Priority:        result.Priority,
 	}
 	return apiResult
 }
@@ -505,31 +520,7 @@ func (c *WorkflowsController) handleUpdateWorkflowStateAndWait(w http.ResponseWr
 	}
 	c.WorkflowManager.Wakeup()
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(req.WaitSeconds)*time.Second)
-	defer cancel()
-	ticker := time.NewTicker(time.Duration(req.CheckSeconds) * time.Second) // check every
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			// Timeout reached
-			http.Error(w, "timeout waiting for workflow result", http.StatusGatewayTimeout)
-			return
-		case <-ticker.C:
-			// Try to fetch workflow result by ID
-			result, err := c.WorkflowManager.WorkflowRepo.FindByID(wf.ID)
-			if err == nil {
-				if len(req.WaitForStates) == 0 || contains(req.WaitForStates, result.State) {
-					w.Header().Set("Content-Type", "application/json")
-					w.WriteHeader(http.StatusOK)
-					apiResult := mapWorkflowToApiWorkflow(result, wf.ID)
-					json.NewEncoder(w).Encode(apiResult)
-					return
-				}
-			}
-		}
-	}
+	c.waitForWorkflowStates(w, wf.ID, req.WaitSeconds, req.CheckSeconds, req.WaitForStates)
 }
 
 // handleUpdateStateVar upserts a single state var key/value; only modified date should change; action created.
@@ -591,6 +582,250 @@ func (c *WorkflowsController) handleUpdateStateVar(w http.ResponseWriter, r *htt
 	json.NewEncoder(w).Encode(models.UpdateStateVarResponse{OK: true})
 }
 
+// handleCancelWorkflow cancels a workflow that has not ended yet, interrupting the running state if there is one.
+func (c *WorkflowsController) handleCancelWorkflow(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	if idStr == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+	wf := c.findWorkflow(idStr)
+	if wf == nil {
+		http.Error(w, "workflow not found", http.StatusNotFound)
+		return
+	}
+
+	cancelled, err := c.WorkflowManager.CancelWorkflow(r.Context(), wf.ID, requestUser(r))
+	if err != nil {
+		slog.Error("CancelWorkflow failed", "error", err)
+		http.Error(w, "failed to cancel workflow", http.StatusInternalServerError)
+		return
+	}
+	if !cancelled {
+		http.Error(w, "workflow has already ended with status "+wf.Status, http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.CancelWorkflowResponse{OK: true})
+}
+
+// handleSendSignal stores a signal with an optional JSON payload for a workflow and wakes it.
+func (c *WorkflowsController) handleSendSignal(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	name := r.PathValue("name")
+	if idStr == "" || name == "" {
+		http.Error(w, "id and name are required", http.StatusBadRequest)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	payload := strings.TrimSpace(string(body))
+	if payload != "" && !json.Valid([]byte(payload)) {
+		http.Error(w, "payload must be valid JSON", http.StatusBadRequest)
+		return
+	}
+	wf := c.findWorkflow(idStr)
+	if wf == nil {
+		http.Error(w, "workflow not found", http.StatusNotFound)
+		return
+	}
+	switch wf.Status {
+	case "FINISHED", "FAILED", "ERROR", "CANCELLED":
+		http.Error(w, "workflow has already ended with status "+wf.Status, http.StatusConflict)
+		return
+	}
+
+	signalID, err := c.WorkflowManager.SendSignal(r.Context(), wf.ID, name, payload, requestUser(r))
+	if err != nil {
+		slog.Error("SendSignal failed", "error", err)
+		http.Error(w, "failed to send signal", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.SendSignalResponse{SignalID: signalID})
+}
+
+// handlePauseWorkflow pauses a single workflow so that it is not picked up until it is resumed.
+func (c *WorkflowsController) handlePauseWorkflow(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	if idStr == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+	wf := c.findWorkflow(idStr)
+	if wf == nil {
+		http.Error(w, "workflow not found", http.StatusNotFound)
+		return
+	}
+	paused, err := c.WorkflowManager.PauseWorkflow(r.Context(), wf.ID, requestUser(r))
+	if err != nil {
+		slog.Error("PauseWorkflow failed", "error", err)
+		http.Error(w, "failed to pause workflow", http.StatusInternalServerError)
+		return
+	}
+	if !paused {
+		http.Error(w, "workflow cannot be paused with status "+wf.Status, http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.PauseWorkflowResponse{OK: true})
+}
+
+// handleUpdatePriority changes the priority a workflow is claimed with.
+func (c *WorkflowsController) handleUpdatePriority(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	if idStr == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+	wf := c.findWorkflow(idStr)
+	if wf == nil {
+		http.Error(w, "workflow not found", http.StatusNotFound)
+		return
+	}
+	var req models.UpdatePriorityRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	updated, err := c.WorkflowManager.UpdatePriority(r.Context(), wf.ID, req.Priority, requestUser(r))
+	if err != nil {
+		slog.Error("UpdatePriority failed", "error", err)
+		http.Error(w, "failed to update priority", http.StatusInternalServerError)
+		return
+	}
+	if !updated {
+		http.Error(w, "workflow not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.UpdatePriorityResponse{OK: true})
+}
+
+// handleGetWorkflowTree returns the tree of parent and child workflows the workflow belongs to.
+func (c *WorkflowsController) handleGetWorkflowTree(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	if idStr == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+	wf := c.findWorkflow(idStr)
+	if wf == nil {
+		http.Error(w, "workflow not found", http.StatusNotFound)
+		return
+	}
+	tree, err := c.WorkflowManager.WorkflowTree(wf.ID)
+	if err != nil {
+		slog.Error("WorkflowTree failed", "error", err)
+		http.Error(w, "failed to load workflow tree", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tree)
+}
+
+// handleResumeWorkflow restores a paused workflow to the status it had before it was paused.
+func (c *WorkflowsController) handleResumeWorkflow(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	if idStr == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+	wf := c.findWorkflow(idStr)
+	if wf == nil {
+		http.Error(w, "workflow not found", http.StatusNotFound)
+		return
+	}
+	resumed, err := c.WorkflowManager.ResumeWorkflow(r.Context(), wf.ID, requestUser(r))
+	if err != nil {
+		slog.Error("ResumeWorkflow failed", "error", err)
+		http.Error(w, "failed to resume workflow", http.StatusInternalServerError)
+		return
+	}
+	if !resumed {
+		http.Error(w, "workflow is not paused", http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.ResumeWorkflowResponse{OK: true})
+}
+
+// handlePauseWorkflows pauses every workflow matching a search filter
+func (c *WorkflowsController) handlePauseWorkflows(w http.ResponseWriter, r *http.Request) {
+	c.changeWorkflowsBySearch(w, r, c.WorkflowManager.PauseWorkflow)
+}
+
+// handleResumeWorkflows resumes every paused workflow matching a search filter
+func (c *WorkflowsController) handleResumeWorkflows(w http.ResponseWriter, r *http.Request) {
+	c.changeWorkflowsBySearch(w, r, c.WorkflowManager.ResumeWorkflow)
+}
+
+func (c *WorkflowsController) changeWorkflowsBySearch(w http.ResponseWriter, r *http.Request,
+	change func(ctx context.Context, id int64, by string) (bool, error)) {
+
+	var req models.SearchWorkflowRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	// an empty filter would match every workflow in the database
+	if req.ID == 0 && req.ExternalID == "" && req.BusinessKey == "" && req.ExecutorGroup == "" &&
+		req.WorkflowType == "" && req.State == "" && req.Status == "" {
+		http.Error(w, "at least one search filter is required", http.StatusBadRequest)
+		return
+	}
+	results, err := c.WorkflowRepo.SearchWorkflows(req)
+	if err != nil {
+		slog.Error("Failed to search workflows", "error", err)
+		http.Error(w, "failed to search workflows", http.StatusInternalServerError)
+		return
+	}
+	resp := models.BulkStatusChangeResponse{}
+	if results != nil {
+		resp.Matched = len(*results)
+		for _, wf := range *results {
+			changed, err := change(r.Context(), wf.ID, requestUser(r))
+			if err != nil {
+				slog.Error("Failed to change workflow status", "workflow_id", wf.ID, "error", err)
+				continue
+			}
+			if changed {
+				resp.Changed++
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// findWorkflow looks a workflow up by numeric id, falling back to the external id.
+func (c *WorkflowsController) findWorkflow(idStr string) *domain.Workflow {
+	var wf *domain.Workflow
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err == nil {
+		wf, _ = c.WorkflowRepo.FindByID(id)
+	}
+	if wf == nil {
+		wf, _ = c.WorkflowRepo.FindByExternalId(idStr)
+	}
+	return wf
+}
+
+// requestUser returns the authenticated username for recording in workflow actions.
+func requestUser(r *http.Request) string {
+	if userName, ok := r.Context().Value(core.CtxKeyUsername).(string); ok && userName != "" {
+		return userName
+	}
+	return "api"
+}
+
 func parseInt64(s string) int64 {
 	v, _ := strconv.ParseInt(s, 10, 64)
 	return v
@@ -640,6 +875,25 @@ func (c *WorkflowsController) handleGetWorkflowDefinitionByName(w http.ResponseW
 	json.NewEncoder(w).Encode(def)
 }
 
+// handleGetWorkflowDefinitionVersions lists every registered version of a workflow definition, newest first.
+func (c *WorkflowsController) handleGetWorkflowDefinitionVersions(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	versions, err := c.WorkflowManager.ListWorkflowDefinitionVersions(name)
+	if err != nil {
+		slog.Error("Failed to list workflow definition versions", "name", name, "error", err)
+		http.Error(w, "failed to list definition versions", http.StatusInternalServerError)
+		return
+	}
+	if len(*versions) == 0 {
+		http.Error(w, "Definition not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(versions)
+}
+
 func contains(arr []string, val string) bool {
 	for _, item := range arr {
 		if item == val {
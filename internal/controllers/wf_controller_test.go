@@ -1,9 +1,11 @@
 package controllers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -22,6 +24,12 @@ type MockWorkflowRepo struct {
 	GetDefinitionStateOverviewFunc func(workflowType string) ([]repository.DefinitionStateRow, error)
 	GetTopExecutingFunc            func(limit int) (*[]domain.Workflow, error)
 	GetNextToExecuteFunc           func(limit int) (*[]domain.Workflow, error)
+	CancelWorkflowFunc             func(id int64) (bool, error)
+	SearchWorkflowsFunc            func(req models.SearchWorkflowRequest) (*[]domain.Workflow, error)
+	PauseWorkflowFunc              func(id int64) (bool, error)
+	SaveSignalFunc                 func(sig *domain.WorkflowSignal) (int64, error)
+	UpdatePriorityFunc             func(id int64, priority int) (bool, error)
+	GetChildrenByParentIDFunc      func(parentID int64, onlyActive bool) (*[]domain.Workflow, error)
 }
 
 // Implement engine.WorkflowRepo - using panic or no-op for unused methods
@@ -58,12 +66,17 @@ func (m *MockWorkflowRepo) GetNextToExecute(limit int) (*[]domain.Workflow, erro
 
 // Stubs for others
 func (m *MockWorkflowRepo) GetChildrenByParentID(parentID int64, onlyActive bool) (*[]domain.Workflow, error) {
+	if m.GetChildrenByParentIDFunc != nil {
+		return m.GetChildrenByParentIDFunc(parentID, onlyActive)
+	}
 	return nil, nil
 }
 func (m *MockWorkflowRepo) UpdateWorkflowStatus(id int64, status string) error          { return nil }
 func (m *MockWorkflowRepo) UpdateWorkflowStartingTime(id int64) error                   { return nil }
 func (m *MockWorkflowRepo) UpdateState(id int64, state string) error                    { return nil }
 func (m *MockWorkflowRepo) SaveWorkflowVariables(id int64, vars string) error           { return nil }
+func (m *MockWorkflowRepo) SetWorkflowVariable(id int64, key string, value string) error { return nil }
+func (m *MockWorkflowRepo) FindWorkflowVariablesForUpdate(id int64) (sql.NullString, error) { return sql.NullString{}, nil }
 func (m *MockWorkflowRepo) WakeParentWorkflow(parentID int64) error                     { return nil }
 func (m *MockWorkflowRepo) Save(wf *domain.Workflow) (int64, error)                     { return 1, nil }
 func (m *MockWorkflowRepo) UpdateNextActivationSpecific(id int64, next time.Time) error { return nil }
@@ -83,10 +96,61 @@ func (m *MockWorkflowRepo) FindStuckWorkflows(minutesRepair string, executorGrou
 }
 func (m *MockWorkflowRepo) LockWorkflowByModified(id int64, modified time.Time) bool { return true }
 func (m *MockWorkflowRepo) SearchWorkflows(req models.SearchWorkflowRequest) (*[]domain.Workflow, error) {
+	if m.SearchWorkflowsFunc != nil {
+		return m.SearchWorkflowsFunc(req)
+	}
 	return nil, nil
 }
 func (m *MockWorkflowRepo) FindByExternalId(id string) (*domain.Workflow, error)      { return nil, nil }
 func (m *MockWorkflowRepo) SaveWorkflowVariablesAndTouch(id int64, vars string) error { return nil }
+func (m *MockWorkflowRepo) MarkWorkflowAsExecuting(id int64) bool                     { return true }
+func (m *MockWorkflowRepo) CancelWorkflow(id int64) (bool, error) {
+	if m.CancelWorkflowFunc != nil {
+		return m.CancelWorkflowFunc(id)
+	}
+	return true, nil
+}
+func (m *MockWorkflowRepo) FindCancelledWorkflows(executorGroup string, executorId int64, limit int) (*[]domain.Workflow, error) {
+	return nil, nil
+}
+func (m *MockWorkflowRepo) ClaimCancelledWorkflow(id int64, executorId int64) bool { return true }
+func (m *MockWorkflowRepo) CompleteCancellation(id int64) error                    { return nil }
+func (m *MockWorkflowRepo) PauseWorkflow(id int64) (bool, error) {
+	if m.PauseWorkflowFunc != nil {
+		return m.PauseWorkflowFunc(id)
+	}
+	return true, nil
+}
+func (m *MockWorkflowRepo) ResumeWorkflow(id int64) (bool, error) { return true, nil }
+func (m *MockWorkflowRepo) SaveSignal(sig *domain.WorkflowSignal) (int64, error) {
+	if m.SaveSignalFunc != nil {
+		return m.SaveSignalFunc(sig)
+	}
+	return 1, nil
+}
+func (m *MockWorkflowRepo) FindPendingSignals(workflowID int64) (*[]domain.WorkflowSignal, error) {
+	return nil, nil
+}
+func (m *MockWorkflowRepo) MarkSignalsConsumed(ids []int64) error          { return nil }
+func (m *MockWorkflowRepo) WakeWorkflow(id int64) error                    { return nil }
+func (m *MockWorkflowRepo) PinWorkflowVersion(id int64, version int) error { return nil }
+func (m *MockWorkflowRepo) RenewLeases(executorId int64, ids []int64) (int64, error) {
+	return int64(len(ids)), nil
+}
+func (m *MockWorkflowRepo) ReclaimExpiredLease(id int64, modified time.Time) bool { return true }
+func (m *MockWorkflowRepo) ReleaseWorkflow(id int64, executorId int64) (bool, error) {
+	return true, nil
+}
+func (m *MockWorkflowRepo) ReleaseWorkflows(executorId int64, ids []int64) (int64, error) {
+	return int64(len(ids)), nil
+}
+func (m *MockWorkflowRepo) CountRunningWorkflows(workflowType string) (int, error) { return 0, nil }
+func (m *MockWorkflowRepo) UpdatePriority(id int64, priority int) (bool, error) {
+	if m.UpdatePriorityFunc != nil {
+		return m.UpdatePriorityFunc(id, priority)
+	}
+	return true, nil
+}
 
 type MockWorkflowActionRepo struct{
 	FindAllByWorkflowIDFunc func(workflowID int64) (*[]domain.WorkflowAction, error)
@@ -124,6 +188,15 @@ func (m *MockDefinitionRepo) FindByName(name string) (*domain.WorkflowDefinition
 	return nil, nil
 }
 func (m *MockDefinitionRepo) Save(def *domain.WorkflowDefinition) error { return nil }
+func (m *MockDefinitionRepo) SaveVersion(v *domain.WorkflowDefinitionVersion) error {
+	return nil
+}
+func (m *MockDefinitionRepo) FindVersion(name string, version int) (*domain.WorkflowDefinitionVersion, error) {
+	return nil, nil
+}
+func (m *MockDefinitionRepo) FindVersions(name string) (*[]domain.WorkflowDefinitionVersion, error) {
+	return &[]domain.WorkflowDefinitionVersion{}, nil
+}
 
 type MockExecutorRepo struct{
 	GetExecutorsByLastActiveFunc func(limit int) ([]*domain.Executor, error)
@@ -143,6 +216,7 @@ func (m *MockExecutorRepo) UpdateLastActive(id int64, ts time.Time) error {
 	}
 	return nil 
 }
+func (m *MockExecutorRepo) MarkStopped(id int64, ts time.Time) error { return nil }
 func (m *MockExecutorRepo) GetExecutorsByLastActive(limit int) ([]*domain.Executor, error) {
 	if m.GetExecutorsByLastActiveFunc != nil {
 		return m.GetExecutorsByLastActiveFunc(limit)
@@ -163,7 +237,7 @@ func TestWorkflowsController_ListWorkflowDefinitions(t *testing.T) {
 		&MockWorkflowActionRepo{},
 		&MockExecutorRepo{},
 		defRepo,
-		nil, nil,
+		nil, nil, nil,
 	)
 
 	c := NewWorkflowsController(&MockWorkflowRepo{}, &MockWorkflowActionRepo{}, wm, nil)
@@ -189,3 +263,213 @@ func TestWorkflowsController_ListWorkflowDefinitions(t *testing.T) {
 		t.Errorf("Expected name W1, got %s", defs[0].Name)
 	}
 }
+
+func TestWorkflowsController_CancelWorkflow(t *testing.T) {
+	alreadyEnded := false
+	repo := &MockWorkflowRepo{
+		FindByIDFunc: func(id int64) (*domain.Workflow, error) {
+			return &domain.Workflow{ID: id, Status: "IN_PROGRESS"}, nil
+		},
+		CancelWorkflowFunc: func(id int64) (bool, error) {
+			return !alreadyEnded, nil
+		},
+	}
+	wm := engine.NewWorkflowManager(repo, &MockWorkflowActionRepo{}, &MockExecutorRepo{}, &MockDefinitionRepo{}, nil, nil, nil)
+	c := NewWorkflowsController(repo, &MockWorkflowActionRepo{}, wm, nil)
+
+	req := httptest.NewRequest("POST", "/api/workflows/1/cancel", nil)
+	req.SetPathValue("id", "1")
+	w := httptest.NewRecorder()
+	c.handleCancelWorkflow(w, req)
+	if w.Result().StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Result().StatusCode)
+	}
+
+	alreadyEnded = true
+	w = httptest.NewRecorder()
+	c.handleCancelWorkflow(w, req)
+	if w.Result().StatusCode != http.StatusConflict {
+		t.Errorf("Expected status 409 for an ended workflow, got %d", w.Result().StatusCode)
+	}
+}
+
+func TestWorkflowsController_PauseWorkflowsBySearch(t *testing.T) {
+	repo := &MockWorkflowRepo{
+		SearchWorkflowsFunc: func(req models.SearchWorkflowRequest) (*[]domain.Workflow, error) {
+			return &[]domain.Workflow{{ID: 1}, {ID: 2}}, nil
+		},
+		PauseWorkflowFunc: func(id int64) (bool, error) {
+			// workflow 2 has already finished
+			return id == 1, nil
+		},
+	}
+	wm := engine.NewWorkflowManager(repo, &MockWorkflowActionRepo{}, &MockExecutorRepo{}, &MockDefinitionRepo{}, nil, nil, nil)
+	c := NewWorkflowsController(repo, &MockWorkflowActionRepo{}, wm, nil)
+
+	req := httptest.NewRequest("POST", "/api/workflows/pause", strings.NewReader(`{}`))
+	w := httptest.NewRecorder()
+	c.handlePauseWorkflows(w, req)
+	if w.Result().StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an empty filter, got %d", w.Result().StatusCode)
+	}
+
+	req = httptest.NewRequest("POST", "/api/workflows/pause", strings.NewReader(`{"workflowType":"DemoWorkflow"}`))
+	w = httptest.NewRecorder()
+	c.handlePauseWorkflows(w, req)
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Result().StatusCode)
+	}
+	var resp models.BulkStatusChangeResponse
+	if err := json.NewDecoder(w.Result().Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Matched != 2 || resp.Changed != 1 {
+		t.Errorf("Expected 2 matched and 1 changed, got %+v", resp)
+	}
+}
+
+func TestWorkflowsController_SendSignal(t *testing.T) {
+	var saved *domain.WorkflowSignal
+	repo := &MockWorkflowRepo{
+		FindByIDFunc: func(id int64) (*domain.Workflow, error) {
+			status := "IN_PROGRESS"
+			if id == 2 {
+				status = "FINISHED"
+			}
+			return &domain.Workflow{ID: id, Status: status}, nil
+		},
+		SaveSignalFunc: func(sig *domain.WorkflowSignal) (int64, error) {
+			saved = sig
+			return 42, nil
+		},
+	}
+	wm := engine.NewWorkflowManager(repo, &MockWorkflowActionRepo{}, &MockExecutorRepo{}, &MockDefinitionRepo{}, nil, nil, nil)
+	c := NewWorkflowsController(repo, &MockWorkflowActionRepo{}, wm, nil)
+
+	tests := []struct {
+		name       string
+		id         string
+		body       string
+		wantStatus int
+	}{
+		{"valid payload", "1", `{"approved":true}`, http.StatusOK},
+		{"no payload", "1", ``, http.StatusOK},
+		{"invalid payload", "1", `{approved`, http.StatusBadRequest},
+		{"finished workflow", "2", `{}`, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/workflows/"+tt.id+"/signals/approval", strings.NewReader(tt.body))
+			req.SetPathValue("id", tt.id)
+			req.SetPathValue("name", "approval")
+			w := httptest.NewRecorder()
+			c.handleSendSignal(w, req)
+			if w.Result().StatusCode != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tt.wantStatus, w.Result().StatusCode)
+			}
+		})
+	}
+
+	if saved == nil || saved.Name != "approval" || saved.WorkflowID != 1 {
+		t.Errorf("Expected the approval signal to be saved for workflow 1, got %+v", saved)
+	}
+}
+
+func TestWorkflowsController_UpdatePriority(t *testing.T) {
+	priorities := map[int64]int{}
+	repo := &MockWorkflowRepo{
+		FindByIDFunc: func(id int64) (*domain.Workflow, error) {
+			return &domain.Workflow{ID: id, Status: "NEW"}, nil
+		},
+		UpdatePriorityFunc: func(id int64, priority int) (bool, error) {
+			priorities[id] = priority
+			return true, nil
+		},
+	}
+	wm := engine.NewWorkflowManager(repo, &MockWorkflowActionRepo{}, &MockExecutorRepo{}, &MockDefinitionRepo{}, nil, nil, nil)
+	c := NewWorkflowsController(repo, &MockWorkflowActionRepo{}, wm, nil)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"valid priority", `{"priority":10}`, http.StatusOK},
+		{"unknown field", `{"prio":10}`, http.StatusBadRequest},
+		{"invalid payload", `{priority`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/workflows/1/priority", strings.NewReader(tt.body))
+			req.SetPathValue("id", "1")
+			w := httptest.NewRecorder()
+			c.handleUpdatePriority(w, req)
+			if w.Result().StatusCode != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tt.wantStatus, w.Result().StatusCode)
+			}
+		})
+	}
+
+	if priorities[1] != 10 {
+		t.Errorf("Expected workflow 1 to have priority 10, got %d", priorities[1])
+	}
+}
+
+func TestWorkflowsController_GetWorkflowTree(t *testing.T) {
+	workflows := map[int64]domain.Workflow{
+		1: {ID: 1, Status: "IN_PROGRESS", State: "WaitForChildren"},
+		2: {ID: 2, Status: "IN_PROGRESS", State: "WaitForChildren", ParentWorkflowID: sql.NullInt64{Int64: 1, Valid: true}},
+		3: {ID: 3, Status: "FINISHED", State: "Done", ParentWorkflowID: sql.NullInt64{Int64: 2, Valid: true}},
+		4: {ID: 4, Status: "FAILED", State: "Failed", ParentWorkflowID: sql.NullInt64{Int64: 1, Valid: true}},
+	}
+	repo := &MockWorkflowRepo{
+		FindByIDFunc: func(id int64) (*domain.Workflow, error) {
+			wf, ok := workflows[id]
+			if !ok {
+				return nil, sql.ErrNoRows
+			}
+			return &wf, nil
+		},
+		GetChildrenByParentIDFunc: func(parentID int64, onlyActive bool) (*[]domain.Workflow, error) {
+			var children []domain.Workflow
+			for _, wf := range workflows {
+				if wf.ParentWorkflowID.Valid && wf.ParentWorkflowID.Int64 == parentID {
+					children = append(children, wf)
+				}
+			}
+			return &children, nil
+		},
+	}
+	wm := engine.NewWorkflowManager(repo, &MockWorkflowActionRepo{}, &MockExecutorRepo{}, &MockDefinitionRepo{}, nil, nil, nil)
+	c := NewWorkflowsController(repo, &MockWorkflowActionRepo{}, wm, nil)
+
+	req := httptest.NewRequest("GET", "/api/workflows/2/tree", nil)
+	req.SetPathValue("id", "2")
+	w := httptest.NewRecorder()
+	c.handleGetWorkflowTree(w, req)
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Result().StatusCode)
+	}
+	var tree models.WorkflowTreeResponse
+	if err := json.NewDecoder(w.Body).Decode(&tree); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if tree.Root.ID != 1 || len(tree.Root.Children) != 2 {
+		t.Fatalf("Expected the tree to start at workflow 1 with 2 children, got %+v", tree.Root)
+	}
+	if want := (models.WorkflowTreeCounts{Running: 2, Finished: 1, Failed: 1}); tree.Root.Counts != want {
+		t.Errorf("Expected root counts %+v, got %+v", want, tree.Root.Counts)
+	}
+	child := tree.Root.Children[0]
+	if child.ID != 2 || !child.Requested || len(child.Children) != 1 || child.Children[0].State != "Done" {
+		t.Errorf("Expected requested workflow 2 with its finished child, got %+v", child)
+	}
+
+	req = httptest.NewRequest("GET", "/api/workflows/99/tree", nil)
+	req.SetPathValue("id", "99")
+	w = httptest.NewRecorder()
+	c.handleGetWorkflowTree(w, req)
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown workflow, got %d", w.Result().StatusCode)
+	}
+}
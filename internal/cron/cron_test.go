@@ -0,0 +1,67 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse_Invalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "*/0 * * * *", "5-1 * * * *", "* * * FOO *"} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Expected %q to be rejected", expr)
+		}
+	}
+}
+
+func TestSchedule_Next(t *testing.T) {
+	from := time.Date(2025, time.January, 31, 10, 7, 30, 0, time.UTC) // a Friday
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2025, 1, 31, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2025, 1, 31, 10, 15, 0, 0, time.UTC)},
+		{"0 9 * * *", time.Date(2025, 2, 1, 9, 0, 0, 0, time.UTC)},
+		{"30 8 * * MON-FRI", time.Date(2025, 2, 3, 8, 30, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * JUN 7", time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)},
+		// day of month and day of week are ORed when both are restricted
+		{"0 0 15 * SAT", time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"5,10-20/5 * * * *", time.Date(2025, 1, 31, 10, 10, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2025, 1, 31, 11, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2025, 2, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", tt.expr, err)
+		}
+		if got := s.Next(from); !got.Equal(tt.want) {
+			t.Errorf("Next(%q) = %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestSchedule_NextNeverMatches(t *testing.T) {
+	s, err := Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Next(time.Now()); !got.IsZero() {
+		t.Errorf("Expected no activation for 30 February, got %s", got)
+	}
+}
+
+func TestSchedule_NextInLocation(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("timezone data not available")
+	}
+	s, _ := Parse("30 2 * * *")
+	// 30 March 2025 has no 02:30 in Berlin, clocks jump from 02:00 to 03:00
+	got := s.Next(time.Date(2025, 3, 29, 12, 0, 0, 0, loc))
+	if got.Day() != 31 || got.Hour() != 2 || got.Minute() != 30 {
+		t.Errorf("Expected the skipped time to move to the next day, got %s", got)
+	}
+}
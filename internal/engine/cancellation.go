@@ -0,0 +1,180 @@
+package engine
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/RealZimboGuy/gopherflow/internal/config"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/core"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/domain"
+)
+
+// runningWorkflows tracks the workflows this executor has queued or is executing, so that a
+// cancellation can reach the context of the state method that is currently running.
+type runningWorkflows struct {
+	mu      sync.Mutex
+	cancels map[int64]context.CancelCauseFunc
+}
+
+func newRunningWorkflows() *runningWorkflows {
+	return &runningWorkflows{cancels: make(map[int64]context.CancelCauseFunc)}
+}
+
+// queued registers a workflow that has been claimed but not yet picked up by a worker.
+func (rw *runningWorkflows) queued(id int64) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.cancels[id] = nil
+}
+
+// start derives the context a workflow runs with; the returned func must be called when it is done.
+func (rw *runningWorkflows) start(ctx context.Context, id int64) (context.Context, func()) {
+	runCtx, cancel := context.WithCancelCause(ctx)
+	rw.mu.Lock()
+	rw.cancels[id] = cancel
+	rw.mu.Unlock()
+	return runCtx, func() {
+		rw.remove(id)
+		cancel(nil)
+	}
+}
+
+func (rw *runningWorkflows) remove(id int64) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	delete(rw.cancels, id)
+}
+
+// unstarted returns the workflows this executor holds that no worker has started.
+func (rw *runningWorkflows) unstarted() []int64 {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	var ids []int64
+	for id, cancel := range rw.cancels {
+		if cancel == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// cancelAll interrupts every running workflow with the cause.
+func (rw *runningWorkflows) cancelAll(cause error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	for _, cancel := range rw.cancels {
+		if cancel != nil {
+			cancel(cause)
+		}
+	}
+}
+
+// ids returns the workflows this executor holds, queued or running.
+func (rw *runningWorkflows) ids() []int64 {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	ids := make([]int64, 0, len(rw.cancels))
+	for id := range rw.cancels {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// cancel interrupts the workflow if it is running, it returns false if this executor does not hold it at all.
+func (rw *runningWorkflows) cancel(id int64) bool {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	cancel, ok := rw.cancels[id]
+	if ok && cancel != nil {
+		cancel(core.ErrWorkflowCancelled)
+	}
+	return ok
+}
+
+// isCancelled reports whether the workflow context was cancelled by a cancel request rather than a shutdown.
+func isCancelled(ctx context.Context) bool {
+	return context.Cause(ctx) == core.ErrWorkflowCancelled
+}
+
+// CancelWorkflow marks a workflow as cancelled and interrupts its running state when this executor owns it.
+// Executors owning the workflow elsewhere pick up the cancellation through the cancellation service.
+// It returns false when the workflow had already ended.
+func (wm *WorkflowManager) CancelWorkflow(ctx context.Context, id int64, cancelledBy string) (bool, error) {
+	cancelled, err := wm.WorkflowRepo.CancelWorkflow(id)
+	if err != nil || !cancelled {
+		return false, err
+	}
+	slog.InfoContext(ctx, "Workflow cancelled", "workflow_id", id, "by", cancelledBy)
+	_, _ = wm.WorkflowActionRepo.Save(&domain.WorkflowAction{WorkflowID: id, ExecutorID: wm.executorID, ExecutionCount: 1, Type: "CANCELLED", Name: "CANCELLED", Text: "Cancel requested by " + cancelledBy, DateTime: time.Now()})
+
+	wm.running.cancel(id)
+	wm.Wakeup()
+	return true, nil
+}
+
+// startCancellationService interrupts cancelled workflows that are running on this executor and
+// queues idle cancelled workflows so that their cleanup state is run
+func startCancellationService(ctx context.Context, wm *WorkflowManager, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.InfoContext(ctx, "Workflow cancellation service stopping due to context cancel")
+			return
+		case <-ticker.C:
+			wm.processCancelledWorkflows(ctx)
+		}
+	}
+}
+
+func (wm *WorkflowManager) processCancelledWorkflows(ctx context.Context) {
+	var workflows []domain.Workflow
+	for _, group := range groupNames(wm.groups.groups) {
+		found, err := wm.WorkflowRepo.FindCancelledWorkflows(group, wm.executorID, config.GetSystemSettingInteger(config.ENGINE_BATCH_SIZE))
+		if err != nil {
+			slog.Error("Error finding cancelled workflows", "error", err, "executor_group", group)
+			continue
+		}
+		workflows = append(workflows, *found...)
+	}
+	for _, wf := range workflows {
+		if wf.ExecutorID.Valid && wf.ExecutorID.String == strconv.FormatInt(wm.executorID, 10) {
+			// owned by this executor, either running, waiting in the queue or left behind by an earlier run
+			if !wm.running.cancel(wf.ID) {
+				slog.WarnContext(ctx, "Releasing cancelled workflow no longer held by this executor", "workflow_id", wf.ID)
+				if err := wm.WorkflowRepo.ClearExecutorId(wf.ID); err != nil {
+					slog.ErrorContext(ctx, "Failed to release cancelled workflow", "workflow_id", wf.ID, "error", err)
+				}
+			}
+			continue
+		}
+
+		// unowned or held by an executor whose lease expired, the claim takes it over
+		wm.running.queued(wf.ID)
+		if !wm.WorkflowRepo.ClaimCancelledWorkflow(wf.ID, wm.executorID) {
+			wm.running.remove(wf.ID)
+			continue
+		}
+		instance, err := wm.instanceFor(ctx, &wf)
+		if err != nil {
+			wm.running.remove(wf.ID)
+			if wm.WorkflowRepo.CompleteCancellation(wf.ID) == nil {
+				notifyRelatives(ctx, &wf, wm.WorkflowRepo, wm.WorkflowActionRepo, wm.executorID, "CANCELLED")
+			}
+			continue
+		}
+		instance.Setup(&wf)
+		select {
+		case wm.queue <- instance:
+		default:
+			// queue is full, hand it back and try again on the next tick
+			wm.running.remove(wf.ID)
+			_ = wm.WorkflowRepo.ClearExecutorId(wf.ID)
+		}
+	}
+}
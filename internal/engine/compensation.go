@@ -0,0 +1,85 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/core"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/domain"
+)
+
+// runCompensations undoes the completed states of a workflow that failed or ended in an error state. The states are
+// taken from the recorded TRANSITION actions and compensated newest first, once each; states already COMPENSATED by
+// an earlier run are skipped, as are states left through an ERROR_TRANSITION, which never completed. A failing
+// compensation is recorded and the remaining ones are still run.
+func runCompensations(ctx context.Context, w core.Workflow, wa WorkflowActionRepo, executorID int64, workerID string) {
+	compensations := make(map[string]string)
+	for _, s := range w.GetAllStates() {
+		if s.Compensation != "" {
+			compensations[s.Name] = s.Compensation
+		}
+	}
+	if len(compensations) == 0 {
+		return
+	}
+
+	actions, err := wa.FindAllByWorkflowID(w.GetWorkflowData().ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error loading actions for compensation", "error", err, "worker_id", workerID)
+		return
+	}
+	if actions == nil {
+		return
+	}
+	history := append([]domain.WorkflowAction(nil), *actions...)
+	sort.SliceStable(history, func(i, j int) bool { return history[i].ID > history[j].ID })
+
+	done := make(map[string]bool)
+	for _, a := range history {
+		if a.Type == "COMPENSATED" {
+			done[a.Name] = true
+		}
+	}
+
+	val := reflect.ValueOf(w)
+	for _, a := range history {
+		method, ok := compensations[a.Name]
+		if a.Type != "TRANSITION" || !ok || done[a.Name] {
+			continue
+		}
+		done[a.Name] = true
+
+		slog.InfoContext(ctx, "Compensating state", "state", a.Name, "method", method, "worker_id", workerID)
+		if err := callCompensation(ctx, val, method); err != nil {
+			slog.ErrorContext(ctx, "Error compensating state", "state", a.Name, "error", err, "worker_id", workerID)
+			_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().ExecutionCount, Type: "COMPENSATION_FAILED", Name: a.Name, Text: err.Error(), DateTime: time.Now()})
+			continue
+		}
+		_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().ExecutionCount, Type: "COMPENSATED", Name: a.Name, Text: "Compensated by " + method, DateTime: time.Now()})
+	}
+}
+
+// callCompensation invokes a compensation method, a panic is reported as an error so the others still run.
+func callCompensation(ctx context.Context, val reflect.Value, name string) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("panic in compensation %s: %v", name, rec)
+		}
+	}()
+	method := val.MethodByName(name)
+	if !method.IsValid() {
+		return fmt.Errorf("compensation method %s not found", name)
+	}
+	results := method.Call([]reflect.Value{reflect.ValueOf(ctx)})
+	if len(results) != 1 || !results[0].Type().Implements(reflect.TypeOf((*error)(nil)).Elem()) {
+		return fmt.Errorf("compensation method %s should return error", name)
+	}
+	if results[0].IsNil() {
+		return nil
+	}
+	return results[0].Interface().(error)
+}
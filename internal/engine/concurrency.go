@@ -0,0 +1,12 @@
+package engine
+
+import "github.com/RealZimboGuy/gopherflow/pkg/gopherflow/core"
+
+// MaxConcurrency returns the most workflows of the type that may be held by executors at the same time, 0 when it
+// does not implement core.ConcurrencyLimited.
+func MaxConcurrency(w core.Workflow) int {
+	if l, ok := w.(core.ConcurrencyLimited); ok && l.MaxConcurrency() > 0 {
+		return l.MaxConcurrency()
+	}
+	return 0
+}
@@ -0,0 +1,136 @@
+package engine
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/RealZimboGuy/gopherflow/internal/config"
+)
+
+// ExecutorGroup is one of the executor groups an executor takes workflows from. While several groups have work
+// waiting an executor takes workflows from them in proportion to their weights.
+type ExecutorGroup struct {
+	Name   string
+	Weight int
+}
+
+// ExecutorGroups parses ENGINE_EXECUTOR_GROUP, the groups an executor serves unless its manager was given others.
+func ExecutorGroups() []ExecutorGroup {
+	return ParseExecutorGroups(config.GetSystemSettingString(config.ENGINE_EXECUTOR_GROUP))
+}
+
+// ParseExecutorGroups parses a comma separated list of groups each optionally followed by :weight, for example
+// "default:3,reports". A group without a valid weight has weight 1.
+func ParseExecutorGroups(setting string) []ExecutorGroup {
+	var groups []ExecutorGroup
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(setting, ",") {
+		name, weight, weighted := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		g := ExecutorGroup{Name: name, Weight: 1}
+		if weighted {
+			w, err := strconv.Atoi(strings.TrimSpace(weight))
+			if err != nil || w < 1 {
+				slog.Warn("Invalid executor group weight, using 1", "group", name, "weight", weight)
+			} else {
+				g.Weight = w
+			}
+		}
+		seen[name] = true
+		groups = append(groups, g)
+	}
+	if len(groups) == 0 {
+		return []ExecutorGroup{{Name: "default", Weight: 1}}
+	}
+	return groups
+}
+
+// formatExecutorGroups returns the groups as they are recorded for the executor, the weight is left out when it is 1.
+func formatExecutorGroups(groups []ExecutorGroup) string {
+	parts := make([]string, 0, len(groups))
+	for _, g := range groups {
+		if g.Weight == 1 {
+			parts = append(parts, g.Name)
+		} else {
+			parts = append(parts, g.Name+":"+strconv.Itoa(g.Weight))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// groupNames returns the names of the groups in the order they were configured.
+func groupNames(groups []ExecutorGroup) []string {
+	names := make([]string, 0, len(groups))
+	for _, g := range groups {
+		names = append(names, g.Name)
+	}
+	return names
+}
+
+// groupShares splits every batch of workflows across the executor groups by weight. It uses smooth weighted round
+// robin and keeps its state between batches, so a group whose share of a single batch rounds to nothing still gets
+// its turn in a later one.
+type groupShares struct {
+	mu      sync.Mutex
+	groups  []ExecutorGroup
+	current []int
+}
+
+func newGroupShares(groups []ExecutorGroup) *groupShares {
+	return &groupShares{groups: groups, current: make([]int, len(groups))}
+}
+
+// split returns how many of size workflows to take from each group, indexed like the groups.
+func (s *groupShares) split(size int) []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	shares := make([]int, len(s.groups))
+	total := 0
+	for _, g := range s.groups {
+		total += g.Weight
+	}
+	for n := 0; n < size; n++ {
+		best := 0
+		for i, g := range s.groups {
+			s.current[i] += g.Weight
+			if s.current[i] > s.current[best] {
+				best = i
+			}
+		}
+		s.current[best] -= total
+		shares[best]++
+	}
+	return shares
+}
+
+// pollGroups takes up to size workflows across the executor groups. Every group is offered its share first, the
+// capacity left by groups with less work waiting is then offered to the groups that filled theirs.
+func (wm *WorkflowManager) pollGroups(ctx context.Context, size int) {
+	shares := wm.groups.split(size)
+	spare := 0
+	var busy []string
+	for i, g := range wm.groups.groups {
+		if shares[i] == 0 {
+			busy = append(busy, g.Name)
+			continue
+		}
+		taken := wm.pollGroup(ctx, g.Name, shares[i])
+		if taken < shares[i] {
+			spare += shares[i] - taken
+		} else {
+			busy = append(busy, g.Name)
+		}
+	}
+	for _, group := range busy {
+		if spare == 0 {
+			return
+		}
+		spare -= wm.pollGroup(ctx, group, spare)
+	}
+}
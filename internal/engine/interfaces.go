@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"context"
 	"database/sql"
 	"time"
 
@@ -9,31 +10,101 @@ import (
 	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/models"
 )
 
-// WorkflowRepo defines the interface for workflow persistence, matching repository.WorkflowRepository.
+// WorkflowRepo defines the interface for workflow persistence, matching repository.WorkflowRepository. It is made
+// up of the focused interfaces below, code that needs only one of them takes that one.
 type WorkflowRepo interface {
-	GetChildrenByParentID(parentID int64, onlyActive bool) (*[]domain.Workflow, error)
+	WorkflowStateRepo
+	WorkflowVariableRepo
+	WorkflowLeaseRepo
+	WorkflowControlRepo
+	WorkflowSignalRepo
+	WorkflowTreeRepo
+	WorkflowQueryRepo
+}
+
+// WorkflowStateRepo stores workflows and moves them through their states and statuses.
+type WorkflowStateRepo interface {
+	Save(wf *domain.Workflow) (int64, error)
+	FindByID(id int64) (*domain.Workflow, error)
+	FindByExternalId(id string) (*domain.Workflow, error)
 	UpdateWorkflowStatus(id int64, status string) error
 	UpdateWorkflowStartingTime(id int64) error
 	UpdateState(id int64, state string) error
-	SaveWorkflowVariables(id int64, vars string) error
-	WakeParentWorkflow(parentID int64) error
-	Save(wf *domain.Workflow) (int64, error)
-	FindByID(id int64) (*domain.Workflow, error)
 	UpdateNextActivationSpecific(id int64, next time.Time) error
 	UpdateNextActivationOffset(id int64, offset string) error
-	ClearExecutorId(id int64) error
 	IncrementRetryCounterAndSetNextActivation(id int64, activation time.Time) error
+	ClearExecutorId(id int64) error
+	MarkWorkflowAsExecuting(id int64) bool
+	PinWorkflowVersion(id int64, version int) error
+	UpdatePriority(id int64, priority int) (bool, error)
+}
+
+// WorkflowVariableRepo reads and writes the state variables of workflows.
+type WorkflowVariableRepo interface {
+	SaveWorkflowVariables(id int64, vars string) error
+	SaveWorkflowVariablesAndTouch(id int64, vars string) error
+	SetWorkflowVariable(id int64, key string, value string) error
+	FindWorkflowVariablesForUpdate(id int64) (sql.NullString, error)
+}
+
+// WorkflowLeaseRepo hands pending workflows to executors and takes them back from executors that stopped or lost
+// their lease. Repositories that can claim a batch in one query also implement WorkflowClaimer.
+type WorkflowLeaseRepo interface {
 	FindPendingWorkflows(size int, executorGroup string) (*[]domain.Workflow, error)
 	MarkWorkflowAsScheduledForExecution(id int64, executorId int64, modified time.Time) bool
+	CountRunningWorkflows(workflowType string) (int, error)
+	RenewLeases(executorId int64, ids []int64) (int64, error)
 	FindStuckWorkflows(minutesRepair string, executorGroup string, limit int) (*[]domain.Workflow, error)
 	LockWorkflowByModified(id int64, modified time.Time) bool
+	ReclaimExpiredLease(id int64, modified time.Time) bool
+	ReleaseWorkflow(id int64, executorId int64) (bool, error)
+	ReleaseWorkflows(executorId int64, ids []int64) (int64, error)
+}
+
+// WorkflowControlRepo applies the operator commands that cancel, pause and resume workflows.
+type WorkflowControlRepo interface {
+	CancelWorkflow(id int64) (bool, error)
+	FindCancelledWorkflows(executorGroup string, executorId int64, limit int) (*[]domain.Workflow, error)
+	ClaimCancelledWorkflow(id int64, executorId int64) bool
+	CompleteCancellation(id int64) error
+	PauseWorkflow(id int64) (bool, error)
+	ResumeWorkflow(id int64) (bool, error)
+}
+
+// WorkflowSignalRepo stores the signals sent to workflows and wakes the workflows they are for.
+type WorkflowSignalRepo interface {
+	SaveSignal(s *domain.WorkflowSignal) (int64, error)
+	FindPendingSignals(workflowID int64) (*[]domain.WorkflowSignal, error)
+	MarkSignalsConsumed(ids []int64) error
+	WakeWorkflow(id int64) error
+}
+
+// WorkflowTreeRepo follows the links between parent and child workflows.
+type WorkflowTreeRepo interface {
+	GetChildrenByParentID(parentID int64, onlyActive bool) (*[]domain.Workflow, error)
+	WakeParentWorkflow(parentID int64) error
+}
+
+// WorkflowQueryRepo answers the searches and overviews of the web UI and the API.
+type WorkflowQueryRepo interface {
 	SearchWorkflows(req models.SearchWorkflowRequest) (*[]domain.Workflow, error)
 	GetTopExecuting(limit int) (*[]domain.Workflow, error)
 	GetNextToExecute(limit int) (*[]domain.Workflow, error)
 	GetWorkflowOverview() ([]repository.WorkflowOverviewRow, error)
 	GetDefinitionStateOverview(workflowType string) ([]repository.DefinitionStateRow, error)
-	FindByExternalId(id string) (*domain.Workflow, error)
-	SaveWorkflowVariablesAndTouch(id int64, vars string) error
+}
+
+// Transactor is implemented by a WorkflowRepo that can apply several writes as one database transaction,
+// such as repository.WorkflowRepository. Repositories without it apply the writes one by one.
+type Transactor interface {
+	InTransaction(ctx context.Context, fn func(tx *repository.Tx) error) error
+}
+
+// WorkflowClaimer is implemented by a WorkflowRepo that can claim a batch of pending workflows in one query,
+// such as repository.WorkflowRepository. It returns repository.ErrClaimUnsupported when the database cannot,
+// the workflows are then found and marked one by one.
+type WorkflowClaimer interface {
+	ClaimPendingWorkflows(size int, executorGroup string, executorId int64) (*[]domain.Workflow, error)
 }
 
 // WorkflowActionRepo defines the interface for workflow action persistence.
@@ -46,6 +117,7 @@ type WorkflowActionRepo interface {
 type ExecutorRepo interface {
 	Save(e *domain.Executor) (int64, error)
 	UpdateLastActive(id int64, ts time.Time) error
+	MarkStopped(id int64, ts time.Time) error
 	GetExecutorsByLastActive(limit int) ([]*domain.Executor, error)
 }
 
@@ -54,6 +126,21 @@ type DefinitionRepo interface {
 	FindAll() (*[]domain.WorkflowDefinition, error)
 	FindByName(name string) (*domain.WorkflowDefinition, error)
 	Save(def *domain.WorkflowDefinition) error
+	SaveVersion(v *domain.WorkflowDefinitionVersion) error
+	FindVersion(name string, version int) (*domain.WorkflowDefinitionVersion, error)
+	FindVersions(name string) (*[]domain.WorkflowDefinitionVersion, error)
+}
+
+// ScheduleRepo defines the interface for schedule persistence.
+type ScheduleRepo interface {
+	Save(s *domain.Schedule) (int64, error)
+	Update(s *domain.Schedule) error
+	FindByID(id int64) (*domain.Schedule, error)
+	FindAll() (*[]domain.Schedule, error)
+	FindDueSchedules(workflowTypes []string, limit int) (*[]domain.Schedule, error)
+	ClaimScheduleRun(id int64, dueAt time.Time, nextRun sql.NullTime) bool
+	SetLastWorkflow(id int64, workflowID int64) error
+	DeleteByID(id int64) error
 }
 
 // UserRepo defines the interface for user persistence.
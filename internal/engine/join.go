@@ -0,0 +1,297 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"strings"
+	"time"
+
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/core"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/domain"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/models"
+)
+
+// joinPolicyFor returns the join policy the state declares, nil when it does not wait for children.
+func joinPolicyFor(w core.Workflow, state string) *models.JoinPolicy {
+	for _, s := range w.GetAllStates() {
+		if s.Name == state {
+			return s.Join
+		}
+	}
+	return nil
+}
+
+// summarizeChildren counts the child workflows by outcome. A child that completed in an error state of its type
+// failed, even though it is stored as FINISHED.
+func summarizeChildren(ctx context.Context, children []domain.Workflow) models.ChildSummary {
+	summary := models.ChildSummary{Total: len(children)}
+	states := map[string][]models.WorkflowState{}
+	for _, child := range children {
+		switch child.Status {
+		case "FINISHED":
+			if completedInErrorState(ctx, child, states) {
+				summary.Failed++
+			} else {
+				summary.Finished++
+			}
+		case "FAILED", "ERROR", "CANCELLED":
+			summary.Failed++
+		default:
+			summary.Running++
+		}
+	}
+	return summary
+}
+
+// completedInErrorState reports whether a child stopped in a state its type declares as an error state. The states of
+// each type are looked up once and kept in states.
+func completedInErrorState(ctx context.Context, child domain.Workflow, states map[string][]models.WorkflowState) bool {
+	lookup, ok := ctx.Value(workflowStatesKey{}).(func(string) ([]models.WorkflowState, bool))
+	if !ok {
+		return false
+	}
+	typeStates, cached := states[child.WorkflowType]
+	if !cached {
+		typeStates, _ = lookup(child.WorkflowType)
+		states[child.WorkflowType] = typeStates
+	}
+	for _, s := range typeStates {
+		if s.Name == child.State {
+			return s.StateType == models.StateError
+		}
+	}
+	return false
+}
+
+// checkJoin loads the children of the workflow and evaluates the join of the state. Once it resolved the workflow
+// is given its children and their summary and true is returned. Otherwise the workflow is parked until a child wakes
+// it or the recheck interval is over, and false is returned for the run to stop.
+func checkJoin(ctx context.Context, w core.Workflow, r WorkflowRepo, wa WorkflowActionRepo, executorID int64, workerID string, state string, join *models.JoinPolicy) bool {
+	id := w.GetWorkflowData().ID
+	summary, resolved, err := evaluateJoin(ctx, w, r, join)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load child workflows for join", "workflow_id", id, "state", state, "error", err, "worker_id", workerID)
+	}
+	if resolved {
+		slog.InfoContext(ctx, "Join resolved", "workflow_id", id, "state", state, "finished", summary.Finished, "failed", summary.Failed, "running", summary.Running, "worker_id", workerID)
+		_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: id, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().ExecutionCount, Type: "JOIN", Name: state, Text: describeJoin(join, summary), DateTime: time.Now()})
+		if setter, ok := w.(interface{ SetChildSummary(models.ChildSummary) }); ok {
+			setter.SetChildSummary(summary)
+		}
+		if err := receiveChildResults(w, r); err != nil {
+			slog.ErrorContext(ctx, "Failed to read child workflow results", "workflow_id", id, "error", err, "worker_id", workerID)
+		}
+		return true
+	}
+
+	next := time.Now().Add(join.Recheck())
+	slog.InfoContext(ctx, "Waiting for child workflows", "workflow_id", id, "state", state, "running", summary.Running, "recheck", next, "worker_id", workerID)
+	if err := r.UpdateNextActivationSpecific(id, next); err != nil {
+		slog.ErrorContext(ctx, "Failed to park workflow waiting for children", "workflow_id", id, "error", err, "worker_id", workerID)
+		return false
+	}
+	_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: id, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().ExecutionCount, Type: "JOIN_WAIT", Name: state, Text: describeJoin(join, summary), DateTime: time.Now()})
+
+	// a child that ended while the children were counted could not wake the workflow, it was still executing
+	if _, resolved, err := evaluateJoin(ctx, w, r, join); err == nil && resolved {
+		if err := r.UpdateNextActivationSpecific(id, time.Now()); err != nil {
+			slog.ErrorContext(ctx, "Failed to wake workflow after its join resolved", "workflow_id", id, "error", err, "worker_id", workerID)
+		}
+	}
+	return false
+}
+
+func evaluateJoin(ctx context.Context, w core.Workflow, r WorkflowTreeRepo, join *models.JoinPolicy) (models.ChildSummary, bool, error) {
+	children, err := r.GetChildrenByParentID(w.GetWorkflowData().ID, false)
+	if err != nil || children == nil {
+		return models.ChildSummary{}, false, err
+	}
+	if setter, ok := w.(interface{ SetChildWorkflows([]domain.Workflow) }); ok {
+		setter.SetChildWorkflows(*children)
+	}
+	summary := summarizeChildren(ctx, *children)
+	return summary, join.Resolved(&summary), nil
+}
+
+func describeJoin(join *models.JoinPolicy, s models.ChildSummary) string {
+	joinType := join.Type
+	if joinType == "" {
+		joinType = models.JoinAll
+	}
+	text := fmt.Sprintf("%s join: %d children, %d finished, %d failed, %d running", joinType, s.Total, s.Finished, s.Failed, s.Running)
+	if joinType == models.JoinNOfM {
+		text = fmt.Sprintf("%d of %d %s", join.Count, s.Total, text)
+	}
+	return text
+}
+
+// notifyRelatives applies the end of a workflow with the given status to its relatives. Its parent is woken, and told
+// about a failure when the failure policy asks for it, and its children are cancelled as their close policies ask.
+func notifyRelatives(ctx context.Context, wf *domain.Workflow, r WorkflowRepo, wa WorkflowActionRepo, executorID int64, status string) {
+	wakeParentOnEnd(ctx, wf, r, wa, executorID, status)
+	closeChildren(ctx, wf, r, wa, executorID, status)
+}
+
+// wakeParentOnEnd wakes the parent of a workflow that ended with the given status, so that a parent waiting for its
+// children checks its join straight away.
+func wakeParentOnEnd(ctx context.Context, wf *domain.Workflow, r WorkflowRepo, wa WorkflowActionRepo, executorID int64, status string) {
+	if !wf.ParentWorkflowID.Valid {
+		return
+	}
+	parentID := wf.ParentWorkflowID.Int64
+	policy := models.ChildFailurePolicy(wf.FailurePolicy)
+	if status != "FINISHED" && (policy == models.ChildFailureNotify || policy == models.ChildFailureFailParent) {
+		payload, _ := json.Marshal(models.ChildFailure{WorkflowID: wf.ID, ExternalID: wf.ExternalID, Status: status, Policy: policy})
+		if _, err := r.SaveSignal(&domain.WorkflowSignal{WorkflowID: parentID, Name: models.ChildFailedSignal, Payload: string(payload), Created: time.Now()}); err != nil {
+			slog.ErrorContext(ctx, "Error signalling child failure to parent workflow", "workflow_id", wf.ID, "parent_id", parentID, "error", err)
+		} else {
+			_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: parentID, ExecutorID: executorID, ExecutionCount: 1, Type: "SIGNAL", Name: models.ChildFailedSignal, Text: fmt.Sprintf("Child workflow %d ended with status %s, failure policy %s", wf.ID, status, policy), DateTime: time.Now()})
+		}
+	}
+	if err := r.WakeParentWorkflow(parentID); err != nil {
+		slog.ErrorContext(ctx, "Error waking up parent workflow", "workflow_id", wf.ID, "parent_id", parentID, "error", err)
+		return
+	}
+	_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: parentID, ExecutorID: executorID, ExecutionCount: 1, Type: "CHILD_WAKE", Name: status, Text: fmt.Sprintf("Child workflow %d ended with status %s", wf.ID, status), DateTime: time.Now()})
+}
+
+// closeChildren cancels the children of a workflow that ended with the given status whose close policy asks for it,
+// children that ended already are left alone. A cancelled child closes its own children in turn.
+func closeChildren(ctx context.Context, wf *domain.Workflow, r WorkflowRepo, wa WorkflowActionRepo, executorID int64, status string) {
+	children, err := r.GetChildrenByParentID(wf.ID, false)
+	if err != nil || children == nil {
+		if err != nil {
+			slog.ErrorContext(ctx, "Error loading child workflows to close", "workflow_id", wf.ID, "error", err)
+		}
+		return
+	}
+	for _, child := range *children {
+		policy := models.ParentClosePolicy(child.ParentClosePolicy)
+		if policy != models.ParentCloseCancel && (policy != models.ParentCloseCancelOnFailure || status == "FINISHED") {
+			continue
+		}
+		cancelled, err := r.CancelWorkflow(child.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error cancelling child workflow", "workflow_id", wf.ID, "child_id", child.ID, "error", err)
+			continue
+		}
+		if cancelled {
+			slog.InfoContext(ctx, "Child workflow cancelled with its parent", "workflow_id", wf.ID, "child_id", child.ID, "policy", policy)
+			_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: child.ID, ExecutorID: executorID, ExecutionCount: 1, Type: "CANCELLED", Name: "CANCELLED", Text: fmt.Sprintf("Cancelled as parent workflow %d ended with status %s, close policy %s", wf.ID, status, policy), DateTime: time.Now()})
+		}
+	}
+}
+
+// failingChild returns the pending signal of a child whose failure fails the workflow, nil when there is none.
+func failingChild(signals []domain.WorkflowSignal) (*domain.WorkflowSignal, *models.ChildFailure) {
+	for i, s := range signals {
+		if s.Name != models.ChildFailedSignal {
+			continue
+		}
+		var failure models.ChildFailure
+		if json.Unmarshal([]byte(s.Payload), &failure) == nil && failure.Policy == models.ChildFailureFailParent {
+			return &signals[i], &failure
+		}
+	}
+	return nil, nil
+}
+
+// failForChild fails the workflow because a child with the FAIL_PARENT policy failed. Like when the retries of a
+// state are exhausted a failure state is moved to instead, it is returned for the run to carry on from there.
+func failForChild(ctx context.Context, w core.Workflow, r WorkflowRepo, wa WorkflowActionRepo, executorID int64, workerID string, currentState string, signal *domain.WorkflowSignal, failure *models.ChildFailure) string {
+	if err := r.MarkSignalsConsumed([]int64{signal.ID}); err != nil {
+		slog.ErrorContext(ctx, "Error marking signals consumed", "error", err, "worker_id", workerID)
+	}
+	cause := fmt.Errorf("child workflow %d ended with status %s", failure.WorkflowID, failure.Status)
+	if failureState := failureStateFor(w, currentState); failureState != "" {
+		return moveToErrorState(ctx, w, r, wa, executorID, workerID, currentState, failureState, "after a child workflow failed", cause)
+	}
+	return processPermanentError(ctx, w, r, wa, executorID, workerID, currentState, &core.PermanentError{Err: cause})
+}
+
+// lockParent takes the row lock of the parent of a workflow that is about to end. Transactions that lock both a
+// parent and its children lock the parent first, a parent and a child ending at once then wait on each other
+// instead of deadlocking.
+func lockParent(wf *domain.Workflow, r WorkflowVariableRepo) error {
+	if !wf.ParentWorkflowID.Valid {
+		return nil
+	}
+	if _, err := r.FindWorkflowVariablesForUpdate(wf.ParentWorkflowID.Int64); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("locking parent workflow %d: %w", wf.ParentWorkflowID.Int64, err)
+	}
+	return nil
+}
+
+// handOverResult copies the state variables a finished child names as its result into its parent. Workflows without
+// a parent, or of a type that is not a core.ResultProducer, hand over nothing.
+func handOverResult(ctx context.Context, w core.Workflow, r WorkflowRepo, wa WorkflowActionRepo, executorID int64) error {
+	wf := w.GetWorkflowData()
+	producer, ok := w.(core.ResultProducer)
+	if !ok || !wf.ParentWorkflowID.Valid {
+		return nil
+	}
+	vars := w.GetStateVariables()
+	result := make(map[string]string)
+	if names := producer.ResultVariables(); len(names) > 0 {
+		for _, name := range names {
+			if value, ok := vars[name]; ok {
+				result[name] = value
+			}
+		}
+	} else {
+		maps.Copy(result, vars)
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	parentID := wf.ParentWorkflowID.Int64
+	key := models.ChildResultKey(wf.ExternalID)
+	if err := r.SetWorkflowVariable(parentID, key, string(b)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			slog.WarnContext(ctx, "Parent workflow not found, result not handed over", "workflow_id", wf.ID, "parent_id", parentID)
+			return nil
+		}
+		return fmt.Errorf("handing result to parent workflow %d: %w", parentID, err)
+	}
+	_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: parentID, ExecutorID: executorID, ExecutionCount: 1, Type: "CHILD_RESULT", Name: key, Text: fmt.Sprintf("Child workflow %d handed over %d state variables", wf.ID, len(result)), DateTime: time.Now()})
+	return nil
+}
+
+// receiveChildResults adds the results children handed over since the workflow was loaded to its state variables.
+// Results it was loaded with are left to the workflow, it may have removed them on purpose. Within a transaction the
+// workflow row stays locked, so no child hands a result over before the variables are saved.
+func receiveChildResults(w core.Workflow, r WorkflowVariableRepo) error {
+	vars := w.GetStateVariables()
+	if vars == nil {
+		return nil
+	}
+	stored, err := r.FindWorkflowVariablesForUpdate(w.GetWorkflowData().ID)
+	if err != nil || !stored.Valid || stored.String == "" {
+		return err
+	}
+	current := make(map[string]string)
+	if err := json.Unmarshal([]byte(stored.String), &current); err != nil {
+		return err
+	}
+	loaded := make(map[string]string)
+	_ = json.Unmarshal([]byte(w.GetWorkflowData().StateVars.String), &loaded)
+	for key, value := range current {
+		if !strings.HasPrefix(key, models.ChildResultPrefix) {
+			continue
+		}
+		if _, ok := loaded[key]; ok {
+			continue
+		}
+		if _, ok := vars[key]; !ok {
+			vars[key] = value
+		}
+	}
+	return nil
+}
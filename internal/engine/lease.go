@@ -0,0 +1,42 @@
+package engine
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/RealZimboGuy/gopherflow/internal/repository"
+)
+
+// startLeaseService renews the leases on the workflows this executor holds, queued or running, three times per
+// lease duration. A workflow whose lease runs out is reclaimed by the repair service of any executor.
+func startLeaseService(ctx context.Context, wm *WorkflowManager) {
+	ticker := time.NewTicker(repository.LeaseDuration() / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.InfoContext(ctx, "Lease service stopping due to context cancel")
+			return
+		case <-ticker.C:
+			wm.renewLeases(ctx)
+		}
+	}
+}
+
+func (wm *WorkflowManager) renewLeases(ctx context.Context) {
+	ids := wm.running.ids()
+	if len(ids) == 0 {
+		return
+	}
+	renewed, err := wm.WorkflowRepo.RenewLeases(wm.executorID, ids)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to renew workflow leases", "count", len(ids), "error", err)
+		return
+	}
+	if renewed < int64(len(ids)) {
+		// a workflow that was just released is not renewed either, which is harmless
+		slog.WarnContext(ctx, "Some workflow leases could not be renewed", "held", len(ids), "renewed", renewed)
+	}
+}
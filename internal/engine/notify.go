@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/RealZimboGuy/gopherflow/internal/config"
+	"github.com/RealZimboGuy/gopherflow/internal/repository"
+)
+
+// startNotificationService polls straight away when a workflow of one of the executor groups is created, woken or changes
+// state on any executor. It only runs on Postgres, MySQL and SQLite rely on polling alone.
+func startNotificationService(ctx context.Context, wm *WorkflowManager) {
+	if config.GetSystemSettingString(config.DATABASE_TYPE) != config.DATABASE_TYPE_POSTGRES ||
+		config.GetSystemSettingString(config.ENGINE_NOTIFY_ENABLED) == "false" {
+		return
+	}
+	groups := groupNames(wm.groups.groups)
+	ids, err := repository.ListenForWorkflows(ctx, wm.databaseURL, groups)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to listen for workflow notifications, polling only", "error", err)
+		return
+	}
+	slog.InfoContext(ctx, "Listening for workflow notifications", "executor_groups", groups)
+
+	for id := range ids {
+		// the wakeup is buffered, notifications arriving while a poll is pending are folded into it
+		select {
+		case wm.wakeup <- struct{}{}:
+		default:
+		}
+		wm.changes.notify(id)
+	}
+	slog.InfoContext(ctx, "Notification service stopping due to context cancel")
+}
+
+// workflowChanges hands out channels that are closed when a notification announces a change to a workflow.
+type workflowChanges struct {
+	mu      sync.Mutex
+	waiters map[int64][]chan struct{}
+}
+
+func newWorkflowChanges() *workflowChanges {
+	return &workflowChanges{waiters: make(map[int64][]chan struct{})}
+}
+
+func (c *workflowChanges) wait(id int64) (<-chan struct{}, func()) {
+	ch := make(chan struct{})
+	c.mu.Lock()
+	c.waiters[id] = append(c.waiters[id], ch)
+	c.mu.Unlock()
+	return ch, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		waiters := c.waiters[id]
+		for i, w := range waiters {
+			if w == ch {
+				c.waiters[id] = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(c.waiters[id]) == 0 {
+			delete(c.waiters, id)
+		}
+	}
+}
+
+// notify wakes the waiters of a workflow, an id of 0 wakes every waiter as notifications may have been missed.
+func (c *workflowChanges) notify(id int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for wid, waiters := range c.waiters {
+		if id != 0 && wid != id {
+			continue
+		}
+		for _, ch := range waiters {
+			close(ch)
+		}
+		delete(c.waiters, wid)
+	}
+}
+
+// WorkflowChanged returns a channel that is closed the next time a notification announces a change to the
+// workflow, and a function to stop waiting. Without notifications the channel is never closed, callers keep polling.
+func (wm *WorkflowManager) WorkflowChanged(id int64) (<-chan struct{}, func()) {
+	return wm.changes.wait(id)
+}
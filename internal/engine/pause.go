@@ -0,0 +1,34 @@
+package engine
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/domain"
+)
+
+// PauseWorkflow freezes a workflow that has not ended. A running state is allowed to finish, after which
+// the executor releases the workflow. It returns false when the workflow could not be paused.
+func (wm *WorkflowManager) PauseWorkflow(ctx context.Context, id int64, pausedBy string) (bool, error) {
+	paused, err := wm.WorkflowRepo.PauseWorkflow(id)
+	if err != nil || !paused {
+		return false, err
+	}
+	slog.InfoContext(ctx, "Workflow paused", "workflow_id", id, "by", pausedBy)
+	_, _ = wm.WorkflowActionRepo.Save(&domain.WorkflowAction{WorkflowID: id, ExecutorID: wm.executorID, ExecutionCount: 1, Type: "PAUSED", Name: "PAUSED", Text: "Paused by " + pausedBy, DateTime: time.Now()})
+	return true, nil
+}
+
+// ResumeWorkflow restores the status a paused workflow had before it was paused.
+// It returns false when the workflow was not paused.
+func (wm *WorkflowManager) ResumeWorkflow(ctx context.Context, id int64, resumedBy string) (bool, error) {
+	resumed, err := wm.WorkflowRepo.ResumeWorkflow(id)
+	if err != nil || !resumed {
+		return false, err
+	}
+	slog.InfoContext(ctx, "Workflow resumed", "workflow_id", id, "by", resumedBy)
+	_, _ = wm.WorkflowActionRepo.Save(&domain.WorkflowAction{WorkflowID: id, ExecutorID: wm.executorID, ExecutionCount: 1, Type: "RESUMED", Name: "RESUMED", Text: "Resumed by " + resumedBy, DateTime: time.Now()})
+	wm.Wakeup()
+	return true, nil
+}
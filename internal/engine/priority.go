@@ -0,0 +1,21 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/domain"
+)
+
+// UpdatePriority changes the priority a workflow is claimed with. It returns false when the workflow does not exist.
+func (wm *WorkflowManager) UpdatePriority(ctx context.Context, id int64, priority int, changedBy string) (bool, error) {
+	updated, err := wm.WorkflowRepo.UpdatePriority(id, priority)
+	if err != nil || !updated {
+		return false, err
+	}
+	slog.InfoContext(ctx, "Workflow priority changed", "workflow_id", id, "priority", priority, "by", changedBy)
+	_, _ = wm.WorkflowActionRepo.Save(&domain.WorkflowAction{WorkflowID: id, ExecutorID: wm.executorID, ExecutionCount: 1, Type: "PRIORITY", Name: "PRIORITY", Text: fmt.Sprintf("Priority set to %d by %s", priority, changedBy), DateTime: time.Now()})
+	return true, nil
+}
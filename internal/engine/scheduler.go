@@ -0,0 +1,301 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RealZimboGuy/gopherflow/internal/cron"
+	"github.com/RealZimboGuy/gopherflow/internal/repository"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/domain"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/models"
+)
+
+// ErrInvalidSchedule is returned by SaveSchedule when the schedule is rejected by validation.
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// startScheduleService creates the workflows of schedules that have become due.
+// Every executor runs it; each run of a schedule is claimed by exactly one of them.
+func startScheduleService(ctx context.Context, wm *WorkflowManager, pollInterval time.Duration) {
+	if wm.scheduleRepo == nil {
+		return
+	}
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.InfoContext(ctx, "Schedule service stopping due to context cancel")
+			return
+		case <-ticker.C:
+			wm.runDueSchedules(ctx)
+		}
+	}
+}
+
+func (wm *WorkflowManager) runDueSchedules(ctx context.Context) {
+	schedules, err := wm.scheduleRepo.FindDueSchedules(wm.registeredWorkflowTypes(), 100)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error finding due schedules", "error", err)
+		return
+	}
+	created := false
+	for _, s := range *schedules {
+		if wm.runSchedule(ctx, &s) {
+			created = true
+		}
+	}
+	if created {
+		wm.Wakeup()
+	}
+}
+
+// registeredWorkflowTypes returns the workflow types this executor can create.
+func (wm *WorkflowManager) registeredWorkflowTypes() []string {
+	types := make([]string, 0, len(*wm.WorkflowRegistry))
+	for workflowType := range *wm.WorkflowRegistry {
+		types = append(types, workflowType)
+	}
+	return types
+}
+
+// errScheduleRunClaimed rolls back a scheduled workflow whose run was claimed by another executor.
+var errScheduleRunClaimed = errors.New("schedule run claimed by another executor")
+
+// runSchedule creates the workflow of the due run of a schedule and then claims the run, it returns true when one
+// was created. Both are written in one transaction, a run whose workflow could not be created stays due.
+func (wm *WorkflowManager) runSchedule(ctx context.Context, s *domain.Schedule) bool {
+	dueAt := s.NextRun.Time
+	// runs missed while no executor was up are not caught up, the schedule continues from now
+	next, err := nextScheduleRun(s, wm.clock.Now())
+	if err != nil {
+		slog.ErrorContext(ctx, "Schedule can no longer be evaluated, disabling its runs", "schedule", s.Name, "error", err)
+	}
+
+	created := false
+	err = wm.inScheduleTransaction(ctx, func(r WorkflowRepo, sr ScheduleRepo) error {
+		created = false
+		if s.OverlapPolicy == models.OverlapSkip && s.LastWorkflowID.Valid {
+			last, err := r.FindByID(s.LastWorkflowID.Int64)
+			if err == nil && last != nil && !workflowEnded(last.Status) {
+				if !sr.ClaimScheduleRun(s.ID, dueAt, next) {
+					return errScheduleRunClaimed
+				}
+				slog.InfoContext(ctx, "Skipping schedule run, previous workflow still active", "schedule", s.Name, "due", dueAt, "workflow_id", last.ID)
+				return nil
+			}
+		}
+
+		id, err := wm.createScheduledWorkflow(ctx, r, s, dueAt)
+		if err != nil {
+			return fmt.Errorf("failed to create scheduled workflow: %w", err)
+		}
+		if !sr.ClaimScheduleRun(s.ID, dueAt, next) {
+			return errScheduleRunClaimed
+		}
+		if err := sr.SetLastWorkflow(s.ID, id); err != nil {
+			return err
+		}
+		created = true
+		return nil
+	})
+	if errors.Is(err, errScheduleRunClaimed) {
+		slog.DebugContext(ctx, "Schedule run claimed by another executor", "schedule", s.Name, "due", dueAt)
+		return false
+	}
+	// the external id of a run is unique, an executor that inserted the same run first wins the race
+	if repository.IsUniqueViolation(err) {
+		slog.DebugContext(ctx, "Schedule run already created by another scheduler", "schedule", s.Name, "due", dueAt)
+		return false
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Schedule run failed, it is retried on the next poll", "schedule", s.Name, "due", dueAt, "error", err)
+		return false
+	}
+	return created
+}
+
+// inScheduleTransaction runs fn with workflow and schedule repositories that commit its writes together, like
+// inTransaction. When the workflow repository is not a Transactor fn runs with the repositories as they are.
+func (wm *WorkflowManager) inScheduleTransaction(ctx context.Context, fn func(r WorkflowRepo, sr ScheduleRepo) error) error {
+	t, ok := wm.WorkflowRepo.(Transactor)
+	if !ok {
+		return fn(wm.WorkflowRepo, wm.scheduleRepo)
+	}
+	return t.InTransaction(context.WithoutCancel(ctx), func(tx *repository.Tx) error {
+		return fn(tx.Workflows, tx.Schedules)
+	})
+}
+
+// scheduleExternalID is derived from the schedule and the run time, so a run is never created twice.
+func scheduleExternalID(s *domain.Schedule, dueAt time.Time) string {
+	return fmt.Sprintf("schedule-%d-%d", s.ID, dueAt.Unix())
+}
+
+func (wm *WorkflowManager) createScheduledWorkflow(ctx context.Context, r WorkflowStateRepo, s *domain.Schedule, dueAt time.Time) (int64, error) {
+	externalID := scheduleExternalID(s, dueAt)
+	if existing, _ := r.FindByExternalId(externalID); existing != nil {
+		slog.WarnContext(ctx, "Scheduled workflow already exists", "externalId", externalID)
+		return existing.ID, nil
+	}
+
+	instance, err := CreateWorkflowInstance(wm, s.WorkflowType)
+	if err != nil {
+		return 0, err
+	}
+
+	replacer := scheduleTemplateReplacer(s, dueAt)
+	vars := map[string]string{}
+	if s.StateVars != "" {
+		if err := json.Unmarshal([]byte(s.StateVars), &vars); err != nil {
+			return 0, fmt.Errorf("invalid state vars template: %w", err)
+		}
+	}
+	for k, v := range vars {
+		vars[k] = replacer.Replace(v)
+	}
+	vars["createdBy"] = "schedule:" + s.Name
+	b, err := json.Marshal(vars)
+	if err != nil {
+		return 0, err
+	}
+
+	businessKey := replacer.Replace(s.BusinessKey)
+	if businessKey == "" {
+		businessKey = externalID
+	}
+
+	now := wm.clock.Now().UTC()
+	slog.InfoContext(ctx, "Creating scheduled workflow", "schedule", s.Name, "externalId", externalID, "workflowType", s.WorkflowType)
+	return r.Save(&domain.Workflow{
+		Status:          "NEW",
+		Created:         now,
+		Modified:        now,
+		NextActivation:  sql.NullTime{Time: now, Valid: true},
+		ExecutorGroup:   s.ExecutorGroup,
+		WorkflowType:    s.WorkflowType,
+		ExternalID:      externalID,
+		BusinessKey:     businessKey,
+		State:           instance.InitialState(),
+		StateVars:       sql.NullString{String: string(b), Valid: true},
+		WorkflowVersion: WorkflowVersion(instance),
+	})
+}
+
+// scheduleTemplateReplacer fills the placeholders allowed in the business key and state vars of a schedule.
+func scheduleTemplateReplacer(s *domain.Schedule, dueAt time.Time) *strings.Replacer {
+	if loc, err := time.LoadLocation(s.Timezone); err == nil {
+		dueAt = dueAt.In(loc)
+	}
+	return strings.NewReplacer(
+		"${scheduleId}", strconv.FormatInt(s.ID, 10),
+		"${scheduleName}", s.Name,
+		"${scheduledTime}", dueAt.Format(time.RFC3339),
+	)
+}
+
+func workflowEnded(status string) bool {
+	switch status {
+	case "FINISHED", "FAILED", "ERROR", "CANCELLED":
+		return true
+	}
+	return false
+}
+
+// nextScheduleRun returns the first run of the schedule after t, it is not set when the schedule is disabled
+// or its expression never fires again.
+func nextScheduleRun(s *domain.Schedule, t time.Time) (sql.NullTime, error) {
+	if !s.Enabled {
+		return sql.NullTime{}, nil
+	}
+	expr, err := cron.Parse(s.CronExpression)
+	if err != nil {
+		return sql.NullTime{}, err
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return sql.NullTime{}, err
+	}
+	next := expr.Next(t.In(loc))
+	if next.IsZero() {
+		return sql.NullTime{}, nil
+	}
+	return sql.NullTime{Time: next.UTC(), Valid: true}, nil
+}
+
+// validateSchedule checks a schedule before it is stored and fills in the defaults.
+func (wm *WorkflowManager) validateSchedule(s *domain.Schedule) error {
+	if strings.TrimSpace(s.Name) == "" {
+		return errors.New("name is required")
+	}
+	if _, err := cron.Parse(s.CronExpression); err != nil {
+		return err
+	}
+	if s.Timezone == "" {
+		s.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("unknown timezone %q", s.Timezone)
+	}
+	if _, ok := (*wm.WorkflowRegistry)[s.WorkflowType]; !ok {
+		return fmt.Errorf("unknown workflow type %q", s.WorkflowType)
+	}
+	if s.ExecutorGroup == "" {
+		// schedules without a group run on the first group of this executor
+		s.ExecutorGroup = wm.groups.groups[0].Name
+	}
+	switch s.OverlapPolicy {
+	case "":
+		s.OverlapPolicy = models.OverlapAllow
+	case models.OverlapAllow, models.OverlapSkip:
+	default:
+		return fmt.Errorf("overlap policy must be %s or %s", models.OverlapAllow, models.OverlapSkip)
+	}
+	if s.StateVars != "" {
+		var vars map[string]string
+		if err := json.Unmarshal([]byte(s.StateVars), &vars); err != nil {
+			return errors.New("state vars must be a JSON object of strings")
+		}
+	}
+	return nil
+}
+
+// ListSchedules returns all schedules ordered by name.
+func (wm *WorkflowManager) ListSchedules() (*[]domain.Schedule, error) {
+	return wm.scheduleRepo.FindAll()
+}
+
+// GetSchedule returns a schedule by id.
+func (wm *WorkflowManager) GetSchedule(id int64) (*domain.Schedule, error) {
+	return wm.scheduleRepo.FindByID(id)
+}
+
+// SaveSchedule validates a schedule, computes its next run and creates it, or updates it when it has an id.
+func (wm *WorkflowManager) SaveSchedule(s *domain.Schedule) error {
+	if err := wm.validateSchedule(s); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSchedule, err)
+	}
+	next, err := nextScheduleRun(s, wm.clock.Now())
+	if err != nil {
+		return err
+	}
+	s.NextRun = next
+	if s.ID == 0 {
+		_, err = wm.scheduleRepo.Save(s)
+	} else {
+		err = wm.scheduleRepo.Update(s)
+	}
+	return err
+}
+
+// DeleteSchedule removes a schedule, workflows it already created keep running.
+func (wm *WorkflowManager) DeleteSchedule(id int64) error {
+	return wm.scheduleRepo.DeleteByID(id)
+}
@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/RealZimboGuy/gopherflow/internal/config"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/core"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/domain"
+)
+
+// errEngineShutdown interrupts the states still running when the shutdown grace period is over.
+var errEngineShutdown = errors.New("engine shut down before the state finished")
+
+// interruptWait is how long the workers get to return once their states were interrupted.
+const interruptWait = 5 * time.Second
+
+type drainingKey struct{}
+
+// runContext derives the context a workflow runs with from the context of its worker. It is not cancelled when the
+// worker context is, the running state is given the grace period instead, but isDraining reports the shutdown.
+func runContext(workerCtx context.Context) context.Context {
+	return context.WithValue(context.WithoutCancel(workerCtx), drainingKey{}, workerCtx)
+}
+
+// isDraining reports whether the engine is shutting down, the workflow should not start another state.
+func isDraining(ctx context.Context) bool {
+	workerCtx, ok := ctx.Value(drainingKey{}).(context.Context)
+	return ok && workerCtx.Err() != nil
+}
+
+// shutdownGracePeriod is how long running states may take to finish once the engine is shutting down.
+func shutdownGracePeriod() time.Duration {
+	d, err := time.ParseDuration(config.GetSystemSettingString(config.ENGINE_SHUTDOWN_GRACE_PERIOD))
+	if err != nil || d < 0 {
+		slog.Warn("Invalid shutdown grace period, using 30s", "value", config.GetSystemSettingString(config.ENGINE_SHUTDOWN_GRACE_PERIOD))
+		return 30 * time.Second
+	}
+	return d
+}
+
+// shutdown runs once polling has stopped. It waits for the running states, interrupting those still running after
+// the grace period, hands the workflows that were taken but never started back and marks the executor as stopped.
+func (wm *WorkflowManager) shutdown(ctx context.Context, workers *sync.WaitGroup) {
+	grace := shutdownGracePeriod()
+	slog.InfoContext(ctx, "Workflow engine draining", "grace_period", grace.String())
+	if !waitTimeout(workers, grace) {
+		slog.WarnContext(ctx, "Shutdown grace period over, interrupting running states")
+		wm.running.cancelAll(errEngineShutdown)
+		if !waitTimeout(workers, interruptWait) {
+			slog.WarnContext(ctx, "Workers still running, their workflows are repaired once the leases expire")
+		}
+	}
+
+	wm.releaseUnstarted(ctx)
+
+	if wm.executorRepo != nil && wm.executorID != 0 {
+		if err := wm.executorRepo.MarkStopped(wm.executorID, time.Now()); err != nil {
+			slog.ErrorContext(ctx, "Failed to mark executor as stopped", "executor_id", wm.executorID, "error", err)
+		}
+	}
+	slog.InfoContext(ctx, "Workflow engine stopped", "executor_id", wm.executorID)
+}
+
+// releaseUnstarted empties the queue and hands every workflow this executor took but did not start back.
+func (wm *WorkflowManager) releaseUnstarted(ctx context.Context) {
+	for drained := false; !drained; {
+		select {
+		case <-wm.queue:
+		default:
+			drained = true
+		}
+	}
+	// released in one update, a workflow is never left half released when the executor stops
+	ids := wm.running.unstarted()
+	released, err := wm.WorkflowRepo.ReleaseWorkflows(wm.executorID, ids)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to release queued workflows, their leases expire", "workflow_ids", ids, "error", err)
+	} else if released > 0 {
+		slog.InfoContext(ctx, "Released queued workflows", "count", released)
+	}
+	for _, id := range ids {
+		wm.running.remove(id)
+	}
+}
+
+// releaseTaken hands back a workflow this executor took but never started.
+func (wm *WorkflowManager) releaseTaken(ctx context.Context, id int64) {
+	released, err := wm.WorkflowRepo.ReleaseWorkflow(id, wm.executorID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to release queued workflow", "workflow_id", id, "error", err)
+	} else if released {
+		slog.InfoContext(ctx, "Released queued workflow", "workflow_id", id)
+	}
+	wm.running.remove(id)
+}
+
+// waitTimeout waits for the group and reports whether it finished within d.
+func waitTimeout(wg *sync.WaitGroup, d time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+// releaseDrainedWorkflow hands a workflow back between two states while the engine shuts down, any executor
+// continues it straight away.
+func releaseDrainedWorkflow(ctx context.Context, w core.Workflow, r WorkflowRepo, wa WorkflowActionRepo, executorID int64, workerID string, currentState string) {
+	id := w.GetWorkflowData().ID
+	slog.InfoContext(ctx, "Engine shutting down, releasing workflow", "workflow_id", id, "state", currentState, "worker_id", workerID)
+	err := inTransaction(ctx, r, wa, func(r WorkflowRepo, wa WorkflowActionRepo) error {
+		// status and executor are released in one update, the workflow is due already
+		if _, err := r.ReleaseWorkflow(id, executorID); err != nil {
+			return err
+		}
+		_, err := wa.Save(&domain.WorkflowAction{WorkflowID: id, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().ExecutionCount, Type: "RELEASED", Name: currentState, Text: "Released by shutting down executor", DateTime: time.Now()})
+		return err
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error releasing workflow", "workflow_id", id, "error", err, "worker_id", workerID)
+	}
+}
@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/core"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/domain"
+)
+
+// signalReceiver is implemented by workflows embedding core.BaseWorkflow.
+type signalReceiver interface {
+	SetSignals(signals []domain.WorkflowSignal)
+	TakeConsumedSignals() []int64
+}
+
+// saveConsumedSignals persists the signals taken by the state that has just transitioned.
+func saveConsumedSignals(ctx context.Context, w core.Workflow, r WorkflowSignalRepo, workerID string) {
+	receiver, ok := w.(signalReceiver)
+	if !ok {
+		return
+	}
+	if ids := receiver.TakeConsumedSignals(); len(ids) > 0 {
+		if err := r.MarkSignalsConsumed(ids); err != nil {
+			slog.ErrorContext(ctx, "Error marking signals consumed", "error", err, "worker_id", workerID)
+		}
+	}
+}
+
+// SendSignal stores a signal for the workflow and wakes it so that a state waiting for it runs straight away.
+func (wm *WorkflowManager) SendSignal(ctx context.Context, id int64, name string, payload string, sentBy string) (int64, error) {
+	signalID, err := wm.WorkflowRepo.SaveSignal(&domain.WorkflowSignal{WorkflowID: id, Name: name, Payload: payload, Created: time.Now()})
+	if err != nil {
+		return 0, err
+	}
+	slog.InfoContext(ctx, "Signal received", "workflow_id", id, "signal", name, "by", sentBy)
+	_, _ = wm.WorkflowActionRepo.Save(&domain.WorkflowAction{WorkflowID: id, ExecutorID: wm.executorID, ExecutionCount: 1, Type: "SIGNAL", Name: name, Text: "Signal " + name + " sent by " + sentBy, DateTime: time.Now()})
+
+	if err := wm.WorkflowRepo.WakeWorkflow(id); err != nil {
+		slog.ErrorContext(ctx, "Error waking signalled workflow", "workflow_id", id, "error", err)
+	}
+	wm.Wakeup()
+	return signalID, nil
+}
@@ -0,0 +1,46 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/core"
+	models "github.com/RealZimboGuy/gopherflow/pkg/gopherflow/models"
+)
+
+// stateTimeout returns the timeout declared on the state, or the workflow default when the state has none.
+func stateTimeout(w core.Workflow, state string) time.Duration {
+	for _, s := range w.GetAllStates() {
+		if s.Name == state && s.Timeout > 0 {
+			return s.Timeout
+		}
+	}
+	if provider, ok := w.(core.DefaultTimeoutProvider); ok {
+		return provider.DefaultStateTimeout()
+	}
+	return 0
+}
+
+// callStateWithTimeout runs the state method with a deadline bound context and returns an error wrapping
+// core.ErrStateTimeout once the deadline has passed. The method still runs until it returns, the workflow's state
+// variables belong to it until then, so a method that ignores its context holds the worker for as long as it runs.
+func callStateWithTimeout(ctx context.Context, val reflect.Value, state string, timeout time.Duration) (*models.NextState, error) {
+	if timeout <= 0 {
+		return callState(ctx, val, state)
+	}
+	stateCtx, cancel := context.WithTimeoutCause(ctx, timeout, core.ErrStateTimeout)
+	defer cancel()
+
+	ns, err := callState(stateCtx, val, state)
+	if context.Cause(stateCtx) == core.ErrStateTimeout {
+		// whatever the method made of its late return, the state did not complete in time
+		return nil, timeoutError(state, timeout)
+	}
+	return ns, err
+}
+
+func timeoutError(state string, timeout time.Duration) error {
+	return fmt.Errorf("%w: %s did not complete within %s", core.ErrStateTimeout, state, timeout)
+}
@@ -0,0 +1,25 @@
+package engine
+
+import (
+	"context"
+	"errors"
+
+	"github.com/RealZimboGuy/gopherflow/internal/repository"
+)
+
+// errStateVarsNotSaved rolls back a transition whose state variables could not be stored, the cause is logged
+// where it happened.
+var errStateVarsNotSaved = errors.New("state variables not saved")
+
+// inTransaction runs fn with repositories that commit all of its writes together, or none of them when fn returns
+// an error. When r is not a Transactor fn runs with r and wa as they are.
+func inTransaction(ctx context.Context, r WorkflowRepo, wa WorkflowActionRepo, fn func(r WorkflowRepo, wa WorkflowActionRepo) error) error {
+	t, ok := r.(Transactor)
+	if !ok {
+		return fn(r, wa)
+	}
+	// a transition that has started is completed even when the workflow is cancelled or the engine shuts down
+	return t.InTransaction(context.WithoutCancel(ctx), func(tx *repository.Tx) error {
+		return fn(tx.Workflows, tx.Actions)
+	})
+}
@@ -0,0 +1,213 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/core"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/models"
+)
+
+// ValidateRegistry checks the state graph of every registered workflow. It returns a *core.DefinitionError
+// holding the issues of error severity, warnings are left to be logged when the engine starts.
+func ValidateRegistry(registry map[string]func() core.Workflow) error {
+	var errs []core.DefinitionIssue
+	for _, key := range sortedKeys(registry) {
+		for _, issue := range validateRegistryEntry(key, registry[key]) {
+			if issue.Severity == core.IssueError {
+				errs = append(errs, issue)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return &core.DefinitionError{Issues: errs}
+	}
+	return nil
+}
+
+// DefinitionIssues returns the issues found in the registered versions of a workflow type, newest version first.
+func (wm *WorkflowManager) DefinitionIssues(name string) []core.DefinitionIssue {
+	if wm.WorkflowRegistry == nil {
+		return nil
+	}
+	var issues []core.DefinitionIssue
+	for _, key := range sortedKeys(*wm.WorkflowRegistry) {
+		if n, _ := splitVersionKey(key); n == name {
+			issues = append(issues, validateRegistryEntry(key, (*wm.WorkflowRegistry)[key])...)
+		}
+	}
+	sort.SliceStable(issues, func(i, j int) bool { return issues[i].Version > issues[j].Version })
+	return issues
+}
+
+func sortedKeys(registry map[string]func() core.Workflow) []string {
+	keys := make([]string, 0, len(registry))
+	for k := range registry {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// validateRegistryEntry validates the workflow registered under key, including that a key made with
+// core.VersionKey matches the version the workflow reports.
+func validateRegistryEntry(key string, factory func() core.Workflow) []core.DefinitionIssue {
+	name, keyVersion := splitVersionKey(key)
+	instance := factory()
+	issues := ValidateWorkflow(name, instance)
+	if version := WorkflowVersion(instance); keyVersion != 0 && keyVersion != version {
+		issues = append(issues, core.DefinitionIssue{Workflow: name, Version: keyVersion, Severity: core.IssueError,
+			Message: fmt.Sprintf("registered as version %d but reports version %d", keyVersion, version)})
+	}
+	return issues
+}
+
+// ValidateWorkflow checks the state graph and state methods of a workflow implementation.
+func ValidateWorkflow(name string, w core.Workflow) []core.DefinitionIssue {
+	version := WorkflowVersion(w)
+	var issues []core.DefinitionIssue
+	report := func(severity core.IssueSeverity, state string, format string, args ...any) {
+		issues = append(issues, core.DefinitionIssue{Workflow: name, Version: version, State: state, Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+
+	states := w.GetAllStates()
+	types := make(map[string]models.StateType, len(states))
+	for _, s := range states {
+		if _, ok := types[s.Name]; ok {
+			report(core.IssueError, s.Name, "state is declared more than once")
+			continue
+		}
+		types[s.Name] = s.StateType
+	}
+
+	transitions := w.StateTransitions()
+	initial := w.InitialState()
+	if _, ok := types[initial]; !ok {
+		report(core.IssueError, initial, "initial state is not declared")
+	}
+
+	for _, from := range sortedTransitionKeys(transitions) {
+		typ, ok := types[from]
+		if !ok {
+			report(core.IssueError, from, "has transitions but is not declared")
+		}
+		for _, to := range transitions[from] {
+			if _, ok := types[to]; !ok {
+				report(core.IssueError, from, "transition to %s, which is not declared", to)
+			}
+		}
+		if ok && isTerminalStateType(typ) && len(transitions[from]) > 0 {
+			report(core.IssueError, from, "%s state has outgoing transitions", typ)
+		}
+	}
+
+	for _, s := range states {
+		if s.Join != nil {
+			validateJoin(s, report)
+		}
+	}
+
+	ctxType := reflect.TypeOf((*context.Context)(nil)).Elem()
+	typ := reflect.TypeOf(w)
+	for _, s := range states {
+		if s.StateType != models.StateNormal && s.StateType != models.StateStart {
+			continue
+		}
+		if len(transitions[s.Name]) == 0 {
+			report(core.IssueError, s.Name, "has no outgoing transitions")
+		}
+		// Method signatures in Go always include the receiver as the first param.
+		// So to enforce: func (w *Workflow) Foo(ctx context.Context)
+		// method.Type.NumIn() must be 2 (receiver + ctx)
+		m, ok := typ.MethodByName(s.Name)
+		switch {
+		case !ok:
+			report(core.IssueError, s.Name, "method %s not found", s.Name)
+		case m.Type.NumIn() != 2:
+			report(core.IssueError, s.Name, "method must have exactly one parameter: context.Context (found %d parameters)", m.Type.NumIn()-1)
+		case m.Type.In(1) != ctxType:
+			report(core.IssueError, s.Name, "method must take context.Context as its only parameter")
+		}
+		if s.FailureState != "" {
+			if _, ok := types[s.FailureState]; !ok {
+				report(core.IssueError, s.Name, "failure state %s is not declared", s.FailureState)
+			}
+		}
+	}
+
+	failureState := ""
+	if handler, ok := w.(core.FailureHandler); ok && handler.FailureState() != "" {
+		failureState = handler.FailureState()
+		if _, ok := types[failureState]; !ok {
+			report(core.IssueError, "", "failure state %s is not declared", failureState)
+		}
+	}
+
+	reachable := reachableStates(initial, transitions, states, failureState)
+	for _, s := range states {
+		// error states are entered when a state fails rather than through a transition
+		if !reachable[s.Name] && s.StateType != models.StateError {
+			report(core.IssueWarning, s.Name, "is not reachable from the initial state %s", initial)
+		}
+	}
+	return issues
+}
+
+// validateJoin checks the join policy of a state, only states whose method runs can wait for children.
+func validateJoin(s models.WorkflowState, report func(severity core.IssueSeverity, state string, format string, args ...any)) {
+	if s.StateType != models.StateNormal && s.StateType != models.StateStart {
+		report(core.IssueError, s.Name, "%s state cannot wait for child workflows", s.StateType)
+	}
+	switch s.Join.Type {
+	case "", models.JoinAll, models.JoinAny, models.JoinFailFast:
+	case models.JoinNOfM:
+		if s.Join.Count < 1 {
+			report(core.IssueError, s.Name, "%s join needs a count of at least 1", s.Join.Type)
+		}
+	default:
+		report(core.IssueError, s.Name, "unknown join type %s", s.Join.Type)
+	}
+}
+
+// isTerminalStateType reports whether the engine completes a workflow once it arrives in a state of this type.
+func isTerminalStateType(t models.StateType) bool {
+	return t == models.StateEnd || t == models.StateError
+}
+
+// reachableStates walks the transitions from the initial state, following failure states as well.
+func reachableStates(initial string, transitions map[string][]string, states []models.WorkflowState, failureState string) map[string]bool {
+	failures := make(map[string]string, len(states))
+	for _, s := range states {
+		if s.FailureState != "" {
+			failures[s.Name] = s.FailureState
+		} else if failureState != "" {
+			failures[s.Name] = failureState
+		}
+	}
+	seen := map[string]bool{}
+	queue := []string{initial}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if seen[cur] {
+			continue
+		}
+		seen[cur] = true
+		queue = append(queue, transitions[cur]...)
+		if f, ok := failures[cur]; ok {
+			queue = append(queue, f)
+		}
+	}
+	return seen
+}
+
+func sortedTransitionKeys(transitions map[string][]string) []string {
+	keys := make([]string, 0, len(transitions))
+	for k := range transitions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,146 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/core"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/domain"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/models"
+)
+
+// WorkflowVersion returns the definition version a workflow implementation declares, 1 unless it is core.Versioned.
+func WorkflowVersion(w core.Workflow) int {
+	if v, ok := w.(core.Versioned); ok && v.Version() > 0 {
+		return v.Version()
+	}
+	return 1
+}
+
+// splitVersionKey splits a registry key into the workflow type and, for keys made with core.VersionKey, the version.
+func splitVersionKey(key string) (string, int) {
+	if i := strings.LastIndex(key, "@v"); i > 0 {
+		if v, err := strconv.Atoi(key[i+2:]); err == nil && v > 0 {
+			return key[:i], v
+		}
+	}
+	return key, 0
+}
+
+// ErrVersionNotRegistered is returned for a workflow pinned to a version whose code is no longer registered. Such a
+// workflow is not run with another version, whose states may differ.
+var ErrVersionNotRegistered = errors.New("workflow version is not registered")
+
+// CreateWorkflowInstanceVersion creates an instance of a version of a workflow type, 0 meaning the current version.
+// It returns ErrVersionNotRegistered when an older version is no longer registered.
+func CreateWorkflowInstanceVersion(wm *WorkflowManager, name string, version int) (core.Workflow, error) {
+	if version > 0 {
+		if factory, ok := (*wm.WorkflowRegistry)[core.VersionKey(name, version)]; ok {
+			return factory(), nil
+		}
+	}
+	inst, err := CreateWorkflowInstance(wm, name)
+	if err != nil {
+		return nil, err
+	}
+	if version > 0 && WorkflowVersion(inst) != version {
+		return nil, fmt.Errorf("%w: %s version %d, the current version is %d", ErrVersionNotRegistered, name, version, WorkflowVersion(inst))
+	}
+	return inst, nil
+}
+
+// instanceFor creates the implementation a stored workflow is pinned to. Workflows stored without a version, written
+// before versioning or as children of a type no executor had registered yet, are pinned to the current version the
+// first time they are picked up.
+func (wm *WorkflowManager) instanceFor(ctx context.Context, wf *domain.Workflow) (core.Workflow, error) {
+	inst, err := CreateWorkflowInstanceVersion(wm, wf.WorkflowType, wf.WorkflowVersion)
+	if err != nil {
+		return nil, err
+	}
+	if wf.WorkflowVersion == 0 {
+		wf.WorkflowVersion = WorkflowVersion(inst)
+		if err := wm.WorkflowRepo.PinWorkflowVersion(wf.ID, wf.WorkflowVersion); err != nil {
+			slog.ErrorContext(ctx, "Failed to pin workflow version", "workflow_id", wf.ID, "error", err)
+		}
+	}
+	return inst, nil
+}
+
+// currentWorkflowVersion returns the current version of a workflow type, from its implementation when this executor
+// has one and otherwise from the definition another executor registered. It returns 0 for an unknown type.
+func (wm *WorkflowManager) currentWorkflowVersion(workflowType string) int {
+	if wm.WorkflowRegistry != nil {
+		if factory, ok := (*wm.WorkflowRegistry)[workflowType]; ok {
+			return WorkflowVersion(factory())
+		}
+	}
+	if wm.DefinitionRepo == nil {
+		return 0
+	}
+	def, err := wm.DefinitionRepo.FindByName(workflowType)
+	if err != nil || def == nil {
+		return 0
+	}
+	return def.Version
+}
+
+// workflowStates returns the states of the current version of a workflow type and whether the type is known, from
+// its implementation when this executor has one and otherwise from the definition another executor registered.
+func (wm *WorkflowManager) workflowStates(workflowType string) ([]models.WorkflowState, bool) {
+	if wm.WorkflowRegistry != nil {
+		if factory, ok := (*wm.WorkflowRegistry)[workflowType]; ok {
+			return factory().GetAllStates(), true
+		}
+	}
+	if wm.DefinitionRepo == nil {
+		return nil, false
+	}
+	def, err := wm.DefinitionRepo.FindByName(workflowType)
+	if err != nil || def == nil {
+		return nil, false
+	}
+	v, err := wm.DefinitionRepo.FindVersion(workflowType, def.Version)
+	if err != nil || v == nil {
+		return nil, false
+	}
+	var states []models.WorkflowState
+	if err := json.Unmarshal([]byte(v.States), &states); err != nil {
+		return nil, false
+	}
+	return states, true
+}
+
+// saveDefinitionVersion records the states, transitions and flow chart of one version of a workflow type.
+func saveDefinitionVersion(ctx context.Context, wm *WorkflowManager, name string, instance core.Workflow, flow string) {
+	states, _ := json.Marshal(instance.GetAllStates())
+	transitions, _ := json.Marshal(instance.StateTransitions())
+	v := &domain.WorkflowDefinitionVersion{
+		Name:        name,
+		Version:     WorkflowVersion(instance),
+		Description: instance.Description(),
+		States:      string(states),
+		Transitions: string(transitions),
+		FlowChart:   flow,
+		Created:     time.Now(),
+		Updated:     time.Now(),
+	}
+	if err := wm.DefinitionRepo.SaveVersion(v); err != nil {
+		slog.ErrorContext(ctx, "Failed to save workflow definition version", "name", name, "version", v.Version, "error", err)
+	}
+}
+
+// ListWorkflowDefinitionVersions returns the registered versions of a workflow type, newest first.
+func (wm *WorkflowManager) ListWorkflowDefinitionVersions(name string) (*[]domain.WorkflowDefinitionVersion, error) {
+	return wm.DefinitionRepo.FindVersions(name)
+}
+
+// GetWorkflowDefinitionVersion returns one registered version of a workflow type.
+func (wm *WorkflowManager) GetWorkflowDefinitionVersion(name string, version int) (*domain.WorkflowDefinitionVersion, error) {
+	return wm.DefinitionRepo.FindVersion(name, version)
+}
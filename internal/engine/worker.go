@@ -9,7 +9,7 @@ import (
 )
 
 // Worker function that processes workflows from the queue
-func Worker(ctx context.Context, id int, executorID int64, workflowRepository WorkflowRepo, workflowActionRepository WorkflowActionRepo, workflowQueue <-chan core.Workflow) {
+func Worker(ctx context.Context, id int, executorID int64, workflowRepository WorkflowRepo, workflowActionRepository WorkflowActionRepo, workflowQueue <-chan core.Workflow, running *runningWorkflows) {
 	for {
 		for {
 			select {
@@ -23,8 +23,15 @@ func Worker(ctx context.Context, id int, executorID int64, workflowRepository Wo
 					return
 				}
 
+				if ctx.Err() != nil {
+					// the engine is shutting down, the workflow is released with the others that were not started
+					slog.InfoContext(ctx, "Worker shutting down", "worker_id", id)
+					return
+				}
 				slog.InfoContext(ctx, "Worker starting workflow", "worker_id", id)
-				RunWorkflow(ctx, wf, workflowRepository, workflowActionRepository, executorID, strconv.Itoa(id))
+				runCtx, done := running.start(runContext(ctx), wf.GetWorkflowData().ID)
+				RunWorkflow(runCtx, wf, workflowRepository, workflowActionRepository, executorID, strconv.Itoa(id))
+				done()
 				slog.InfoContext(ctx, "Worker finished workflow", "worker_id", id)
 			}
 		}
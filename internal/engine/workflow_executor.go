@@ -4,13 +4,14 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"reflect"
 	"runtime/debug"
 	"time"
 
-	"github.com/RealZimboGuy/gopherflow/internal/config"
+	"github.com/RealZimboGuy/gopherflow/internal/repository"
 	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/core"
 	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/domain"
 	models "github.com/RealZimboGuy/gopherflow/pkg/gopherflow/models"
@@ -33,6 +34,7 @@ func RunWorkflow(ctx context.Context, w core.Workflow, r WorkflowRepo, wa Workfl
 				DateTime:       time.Now(),
 			})
 			_ = r.UpdateWorkflowStatus(w.GetWorkflowData().ID, "ERROR")
+			notifyRelatives(ctx, w.GetWorkflowData(), r, wa, executorID, "ERROR")
 		}
 	}()
 
@@ -51,16 +53,17 @@ func RunWorkflow(ctx context.Context, w core.Workflow, r WorkflowRepo, wa Workfl
 		}
 	}
 
-	err := r.UpdateWorkflowStatus(w.GetWorkflowData().ID, "EXECUTING")
-	_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().ExecutionCount, Type: "EXECUTING", Name: "EXECUTING", Text: "EXECUTING", DateTime: time.Now()})
-
-	if err != nil {
-		slog.ErrorContext(ctx, "Error updating workflow status", "error", err, "worker_id", workerID)
-		return
+	var pending []domain.WorkflowSignal
+	if receiver, ok := w.(signalReceiver); ok && w.GetWorkflowData().ID > 0 {
+		signals, err := r.FindPendingSignals(w.GetWorkflowData().ID)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to load signals", "error", err, "workflow_id", w.GetWorkflowData().ID)
+		} else if signals != nil {
+			receiver.SetSignals(*signals)
+			pending = *signals
+		}
 	}
 
-	stateMap := w.StateTransitions()
-
 	//the database determines where we are and start at
 	currentState := w.GetWorkflowData().State
 
@@ -69,8 +72,25 @@ func RunWorkflow(ctx context.Context, w core.Workflow, r WorkflowRepo, wa Workfl
 		currentState = w.InitialState()
 	}
 
-	//if we are on the starting state then update the starting time
-	if currentState == w.InitialState() {
+	if !r.MarkWorkflowAsExecuting(w.GetWorkflowData().ID) {
+		// expected when the workflow was cancelled or paused while it waited in the queue
+		latest, err := r.FindByID(w.GetWorkflowData().ID)
+		switch {
+		case err == nil && latest != nil && latest.Status == "CANCELLED":
+			processWorkflowCancelled(ctx, w, r, wa, executorID, workerID, currentState)
+		case err == nil && latest != nil && latest.Status == "PAUSED":
+			releasePausedWorkflow(ctx, w, r, workerID)
+		default:
+			slog.ErrorContext(ctx, "Error updating workflow status", "error", err, "worker_id", workerID)
+		}
+		return
+	}
+	_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().ExecutionCount, Type: "EXECUTING", Name: "EXECUTING", Text: "EXECUTING", DateTime: time.Now()})
+
+	stateMap := w.StateTransitions()
+
+	//if we are on the starting state, or a child starting in another state, then update the starting time
+	if currentState == w.InitialState() || !w.GetWorkflowData().Started.Valid {
 		err := r.UpdateWorkflowStartingTime(w.GetWorkflowData().ID)
 		_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().ExecutionCount, Type: "STARTING", Name: "EXECUTING", Text: "Starting Workflow", DateTime: time.Now()})
 		if err != nil {
@@ -79,58 +99,78 @@ func RunWorkflow(ctx context.Context, w core.Workflow, r WorkflowRepo, wa Workfl
 		}
 	}
 
+	// a child whose failure policy fails its parent has failed since the last run
+	if signal, failure := failingChild(pending); signal != nil {
+		slog.WarnContext(ctx, "Child workflow failed, failing parent", "workflow_id", w.GetWorkflowData().ID, "child_id", failure.WorkflowID, "worker_id", workerID)
+		if currentState = failForChild(ctx, w, r, wa, executorID, workerID, currentState, signal, failure); currentState == "" {
+			return
+		}
+	}
+
 	val := reflect.ValueOf(w)
 
-	for {
+	for firstState := true; ; firstState = false {
+
+		// a pause takes effect between states, the state that was running when it arrived is allowed to finish. It is
+		// checked before an end state too, a workflow paused during its last state completes once it is resumed.
+		if !firstState && isPaused(w, r) {
+			releasePausedWorkflow(ctx, w, r, workerID)
+			return
+		}
 
 		isEndState := false
+		isErrorState := false
 		for _, state := range w.GetAllStates() {
 			if state.Name == currentState && (state.StateType == models.StateEnd ||
 				state.StateType == models.StateManual ||
 				state.StateType == models.StateError) {
 				isEndState = true
+				isErrorState = state.StateType == models.StateError
 				break
 			}
 		}
 		if isEndState {
+			// an error state may have a method to alert or compensate, it is run once before the workflow completes
+			if isErrorState && val.MethodByName(currentState).IsValid() && !runErrorState(ctx, w, r, wa, val, executorID, workerID, currentState) {
+				return
+			}
 			if processWorflowCompleted(ctx, w, r, wa, executorID, workerID, currentState) {
 				return
 			}
 			break
 		}
 
-		method := val.MethodByName(currentState)
-		if !method.IsValid() {
-			panic(fmt.Sprintf("method %s not found", currentState))
+		if isCancelled(ctx) {
+			processWorkflowCancelled(ctx, w, r, wa, executorID, workerID, currentState)
+			return
 		}
-
-		// Call the method and get the next state
-		results := method.Call([]reflect.Value{reflect.ValueOf(ctx)})
-		if len(results) != 2 || !(results[0].Type().AssignableTo(reflect.TypeOf(models.NextState{})) || results[0].Type().AssignableTo(reflect.TypeOf(&models.NextState{}))) {
-			panic(fmt.Sprintf("method %s should return (NextState or *NextState, error)", currentState))
+		// so does a shutdown, the next state runs on another executor
+		if !firstState && isDraining(ctx) {
+			releaseDrainedWorkflow(ctx, w, r, wa, executorID, workerID, currentState)
+			return
 		}
 
-		var ns *models.NextState
-		if results[0].Kind() == reflect.Ptr {
-			if val, ok := results[0].Interface().(*models.NextState); ok {
-				ns = val
-			}
-		} else if results[0].Kind() == reflect.Struct {
-			if val, ok := results[0].Interface().(models.NextState); ok {
-				ns = &val
-			}
+		// a state with a join only runs once the children it waits for have ended
+		if join := joinPolicyFor(w, currentState); join != nil && !checkJoin(ctx, w, r, wa, executorID, workerID, currentState, join) {
+			break
 		}
 
-		if ns == nil {
-			panic(fmt.Sprintf("method %s did not return a NextState as first value", currentState))
+		ns, callErr := callStateWithTimeout(ctx, val, currentState, stateTimeout(w, currentState))
+
+		// whatever the state returned, a cancelled workflow does not transition any further
+		if isCancelled(ctx) {
+			processWorkflowCancelled(ctx, w, r, wa, executorID, workerID, currentState)
+			return
 		}
-		// Second return value = error
-		var callErr error
-		if !results[1].IsNil() {
-			callErr = results[1].Interface().(error)
+		if errors.Is(callErr, core.ErrStateTimeout) {
+			slog.WarnContext(ctx, "State timed out", "state", currentState, "workflow_id", w.GetWorkflowData().ID, "worker_id", workerID)
+			_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().ExecutionCount, Type: "TIMEOUT", Name: currentState, Text: callErr.Error(), DateTime: time.Now()})
 		}
 		if callErr != nil {
-			processStateExecutionError(ctx, w, r, wa, executorID, workerID, currentState, callErr)
+			if errorState := processStateExecutionError(ctx, w, r, wa, executorID, workerID, currentState, callErr); errorState != "" {
+				currentState = errorState
+				continue
+			}
 			return
 		}
 
@@ -154,156 +194,380 @@ func RunWorkflow(ctx context.Context, w core.Workflow, r WorkflowRepo, wa Workfl
 		}
 
 		slog.InfoContext(ctx, "Transitioning state", "from", currentState, "to", nextState, "worker_id", workerID)
-		_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().RetryCount, Type: "TRANSITION", Name: currentState, Text: "From " + currentState + " to " + nextState, DateTime: time.Now()})
-
-		currentState = nextState
-
-		slog.InfoContext(ctx, "Updating workflow state", "workflow_id", w.GetWorkflowData().ID, "state", currentState, "worker_id", workerID)
-		//this also resets the retry count
-		err := r.UpdateState(w.GetWorkflowData().ID, currentState)
+		scheduled := false
+		err := inTransaction(ctx, r, wa, func(r WorkflowRepo, wa WorkflowActionRepo) error {
+			var err error
+			scheduled, err = applyTransition(ctx, w, r, wa, executorID, workerID, currentState, ns)
+			return err
+		})
 		if err != nil {
+			slog.ErrorContext(ctx, "Transition not applied", "from", currentState, "to", nextState, "error", err, "worker_id", workerID)
 			return
 		}
+		currentState = nextState
+		if scheduled {
+			break
+		}
 
-		if compareAndSaveWorkflowStateVars(ctx, w, r, workerID) {
-			return
+	}
+
+	_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().RetryCount, Type: "FINISHED", Name: currentState, Text: "FINISHED", DateTime: time.Now()})
+	//clear out the executor id for another to possibly pick up the workflow
+	err := r.ClearExecutorId(w.GetWorkflowData().ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error clearing executor id", "error", err, "worker_id", workerID)
+		return
+	}
+	slog.InfoContext(ctx, "Workflow finished", "worker_id", workerID)
+
+}
+
+// applyTransition writes a transition to the next state together with its state variables, consumed signals,
+// actions and child workflows. It returns true when the workflow was scheduled for a later activation, in which
+// case the run stops after the transition.
+func applyTransition(ctx context.Context, w core.Workflow, r WorkflowRepo, wa WorkflowActionRepo, executorID int64, workerID string, previousState string, ns *models.NextState) (bool, error) {
+	currentState := ns.Name
+	// a child asked to start in a state its type does not have would only fail once it runs
+	for _, childReq := range ns.ChildWorkflows {
+		if err := checkChildState(ctx, childReq); err != nil {
+			return false, fmt.Errorf("creating child workflow: %w", err)
 		}
+	}
+	_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().RetryCount, Type: "TRANSITION", Name: previousState, Text: "From " + previousState + " to " + currentState, DateTime: time.Now()})
 
-		if ns.ActionLog != "" {
-			_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().RetryCount, Type: "LOG", Name: currentState, Text: ns.ActionLog, DateTime: time.Now()})
+	slog.InfoContext(ctx, "Updating workflow state", "workflow_id", w.GetWorkflowData().ID, "state", currentState, "worker_id", workerID)
+	//this also resets the retry count
+	if err := r.UpdateState(w.GetWorkflowData().ID, currentState); err != nil {
+		return false, err
+	}
+
+	if compareAndSaveWorkflowStateVars(ctx, w, r, workerID) {
+		return false, errStateVarsNotSaved
+	}
+	saveConsumedSignals(ctx, w, r, workerID)
+
+	if ns.ActionLog != "" {
+		_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().RetryCount, Type: "LOG", Name: currentState, Text: ns.ActionLog, DateTime: time.Now()})
+	}
+
+	//wake up parent if set
+	if ns.WakeParent {
+		if w.GetWorkflowData().ParentWorkflowID.Valid {
+			slog.InfoContext(ctx, "Waking up parent workflow", "workflow_id", w.GetWorkflowData().ID, "worker_id", workerID)
+			_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ParentWorkflowID.Int64, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().ExecutionCount, Type: "CHILD_WAKE", Name: currentState, Text: "Child Initiated Wake", DateTime: time.Now()})
+			err := r.WakeParentWorkflow(w.GetWorkflowData().ParentWorkflowID.Int64)
+			if err != nil {
+				slog.ErrorContext(ctx, "Error waking up parent workflow", "error", err, "worker_id", workerID)
+			}
 		}
+	}
+
+	// Process any child workflow requests
+	childWorkflows := ns.ChildWorkflows
+	if len(childWorkflows) > 0 {
+		slog.InfoContext(ctx, "Processing child workflow requests", "workflow_id", w.GetWorkflowData().ID, "count", len(childWorkflows), "worker_id", workerID)
+		for _, childReq := range childWorkflows {
 
-		//wake up parent if set
-		if ns.WakeParent {
-			if w.GetWorkflowData().ParentWorkflowID.Valid {
-				slog.InfoContext(ctx, "Waking up parent workflow", "workflow_id", w.GetWorkflowData().ID, "worker_id", workerID)
-				_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ParentWorkflowID.Int64, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().ExecutionCount, Type: "CHILD_WAKE", Name: currentState, Text: "Child Initiated Wake", DateTime: time.Now()})
-				err := r.WakeParentWorkflow(w.GetWorkflowData().ParentWorkflowID.Int64)
+			//if the externalId is not set then set to a uuid
+			if childReq.ExternalId == "" {
+				uuid, _ := uuid.NewUUID()
+				childReq.ExternalId = uuid.String()
+			}
+
+			// the child runs alongside its parent unless it is routed to another group
+			if childReq.ExecutorGroup == "" {
+				childReq.ExecutorGroup = w.GetWorkflowData().ExecutorGroup
+			}
+			activation := childActivation(childReq, time.Now())
+
+			slog.InfoContext(ctx, "Creating child workflow",
+				"parent_id", w.GetWorkflowData().ID,
+				"type", childReq.WorkflowType,
+				"initial_state", childReq.InitialState,
+				"executor_group", childReq.ExecutorGroup,
+				"next_activation", activation,
+				"worker_id", workerID)
+
+			// Convert state variables to JSON
+			stateVarsJSON := "{}"
+			if childReq.StateVariables != nil && len(childReq.StateVariables) > 0 {
+				stateVarsBytes, err := json.Marshal(childReq.StateVariables)
 				if err != nil {
-					slog.ErrorContext(ctx, "Error waking up parent workflow", "error", err, "worker_id", workerID)
+					slog.ErrorContext(ctx, "Error marshaling child workflow state variables", "error", err)
+				} else {
+					stateVarsJSON = string(stateVarsBytes)
 				}
 			}
+
+			// Create child workflow directly using Save
+			childWf := &domain.Workflow{
+				Status:            "NEW",
+				ExecutionCount:    0,
+				RetryCount:        0,
+				Created:           time.Now(),
+				Modified:          time.Now(),
+				NextActivation:    sql.NullTime{Time: activation, Valid: true},
+				ExecutorGroup:     childReq.ExecutorGroup,
+				ExternalID:        childReq.ExternalId,
+				WorkflowType:      childReq.WorkflowType,
+				BusinessKey:       childReq.BusinessKey,
+				State:             childReq.InitialState,
+				StateVars:         sql.NullString{String: stateVarsJSON, Valid: stateVarsJSON != ""},
+				ParentWorkflowID:  sql.NullInt64{Int64: w.GetWorkflowData().ID, Valid: true},
+				WorkflowVersion:   childVersion(ctx, childReq.WorkflowType),
+				Priority:          childReq.Priority,
+				ParentClosePolicy: string(childReq.ParentClosePolicy),
+				FailurePolicy:     string(childReq.FailurePolicy),
+			}
+
+			// a child that cannot be created rolls back the whole transition, the parent never advances without it
+			childID, err := r.Save(childWf)
+			if err != nil {
+				return false, fmt.Errorf("creating child workflow: %w", err)
+			}
+
+			child, err := r.FindByID(childID)
+
+			if err != nil {
+				return false, fmt.Errorf("creating child workflow: %w", err)
+			}
+
+			_, _ = wa.Save(&domain.WorkflowAction{
+				WorkflowID:     w.GetWorkflowData().ID,
+				ExecutorID:     executorID,
+				ExecutionCount: w.GetWorkflowData().RetryCount,
+				Type:           "CHILD_CREATED",
+				Name:           currentState,
+				Text:           fmt.Sprintf("Created child workflow ID %d of type %s in group %s", child.ID, childReq.WorkflowType, childReq.ExecutorGroup),
+				DateTime:       time.Now(),
+			})
 		}
+	}
 
-		// Process any child workflow requests
-		childWorkflows := ns.ChildWorkflows
-		if len(childWorkflows) > 0 {
-			slog.InfoContext(ctx, "Processing child workflow requests", "workflow_id", w.GetWorkflowData().ID, "count", len(childWorkflows), "worker_id", workerID)
-			for _, childReq := range childWorkflows {
+	nextExecution := ns.NextExecution
+	// if the next execution is a valid date and time in the future then set it and break processing
+	if !nextExecution.IsZero() {
+		//if nextExecution.After(time.Now()) { // no need, if its in the past it will just run on the next pick up
+		slog.InfoContext(ctx, "Setting next activation (specific)", "workflow_id", w.GetWorkflowData().ID, "next_activation", nextExecution, "worker_id", workerID)
+		if err := r.UpdateNextActivationSpecific(w.GetWorkflowData().ID, nextExecution); err != nil {
+			return false, fmt.Errorf("updating next activation: %w", err)
+		}
+		_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().RetryCount, Type: "SCHEDULE_ACTIVATION", Name: currentState, Text: nextExecution.String(), DateTime: time.Now()})
+		return true, nil
+		//}
+	}
+	nextExecutionOffset := ns.NextExecutionOffset
+	if nextExecutionOffset != "" {
+		slog.InfoContext(ctx, "Setting next activation (offset)", "workflow_id", w.GetWorkflowData().ID, "offset", nextExecutionOffset, "worker_id", workerID)
+		if err := r.UpdateNextActivationOffset(w.GetWorkflowData().ID, nextExecutionOffset); err != nil {
+			return false, fmt.Errorf("updating next activation: %w", err)
+		}
+		_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().RetryCount, Type: "SCHEDULE_ACTIVATION", Name: currentState, Text: nextExecutionOffset, DateTime: time.Now()})
+		return true, nil
+	}
+	return false, nil
+}
 
-				//if the externalId is not set then set to a uuid
-				if childReq.ExternalId == "" {
-					uuid, _ := uuid.NewUUID()
-					childReq.ExternalId = uuid.String()
-				}
+// childActivation returns when a child workflow starts, at its NextActivation, after its NextActivationOffset or now.
+func childActivation(req models.ChildWorkflowRequest, now time.Time) time.Time {
+	if !req.NextActivation.IsZero() {
+		return req.NextActivation
+	}
+	if req.NextActivationOffset != "" {
+		return now.Add(repository.OffsetDuration(req.NextActivationOffset))
+	}
+	return now
+}
 
-				slog.InfoContext(ctx, "Creating child workflow",
-					"parent_id", w.GetWorkflowData().ID,
-					"type", childReq.WorkflowType,
-					"initial_state", childReq.InitialState,
-					"worker_id", workerID)
-
-				// Convert state variables to JSON
-				stateVarsJSON := "{}"
-				if childReq.StateVariables != nil && len(childReq.StateVariables) > 0 {
-					stateVarsBytes, err := json.Marshal(childReq.StateVariables)
-					if err != nil {
-						slog.ErrorContext(ctx, "Error marshaling child workflow state variables", "error", err)
-					} else {
-						stateVarsJSON = string(stateVarsBytes)
-					}
-				}
+type workflowStatesKey struct{}
 
-				// Create child workflow directly using Save
-				childWf := &domain.Workflow{
-					Status:           "NEW",
-					ExecutionCount:   0,
-					RetryCount:       0,
-					Created:          time.Now(),
-					Modified:         time.Now(),
-					NextActivation:   sql.NullTime{Time: time.Now(), Valid: true},
-					ExecutorGroup:    config.GetSystemSettingString(config.ENGINE_EXECUTOR_GROUP),
-					ExternalID:       childReq.ExternalId,
-					WorkflowType:     childReq.WorkflowType,
-					BusinessKey:      childReq.BusinessKey,
-					StateVars:        sql.NullString{String: stateVarsJSON, Valid: stateVarsJSON != ""},
-					ParentWorkflowID: sql.NullInt64{Int64: w.GetWorkflowData().ID, Valid: true},
-				}
+// withWorkflowStates gives the workflows run with the context a lookup of the states of a workflow type, which
+// returns false for a type it does not know.
+func withWorkflowStates(ctx context.Context, lookup func(workflowType string) ([]models.WorkflowState, bool)) context.Context {
+	return context.WithValue(ctx, workflowStatesKey{}, lookup)
+}
 
-				childID, err := r.Save(childWf)
-				if err != nil {
-					slog.ErrorContext(ctx, "Error creating child workflow", "error", err)
-					continue
-				}
+type workflowVersionKey struct{}
 
-				child, err := r.FindByID(childID)
+// withWorkflowVersions gives the workflows run with the context a lookup of the current version of a workflow type,
+// which returns 0 for a type it does not know.
+func withWorkflowVersions(ctx context.Context, lookup func(workflowType string) int) context.Context {
+	return context.WithValue(ctx, workflowVersionKey{}, lookup)
+}
 
-				if err != nil {
-					slog.ErrorContext(ctx, "Error creating child workflow", "error", err)
-					continue
-				}
+// childVersion returns the version a new child of the given type is pinned to, 0 when it is not known.
+func childVersion(ctx context.Context, workflowType string) int {
+	lookup, ok := ctx.Value(workflowVersionKey{}).(func(string) int)
+	if !ok {
+		return 0
+	}
+	return lookup(workflowType)
+}
 
-				_, _ = wa.Save(&domain.WorkflowAction{
-					WorkflowID:     w.GetWorkflowData().ID,
-					ExecutorID:     executorID,
-					ExecutionCount: w.GetWorkflowData().RetryCount,
-					Type:           "CHILD_CREATED",
-					Name:           currentState,
-					Text:           fmt.Sprintf("Created child workflow ID %d of type %s", child.ID, childReq.WorkflowType),
-					DateTime:       time.Now(),
-				})
-			}
+// checkChildState returns an error when a child is asked to start in a state its type does not declare. Children of
+// a type that is not known are left to the executor that picks them up.
+func checkChildState(ctx context.Context, req models.ChildWorkflowRequest) error {
+	lookup, ok := ctx.Value(workflowStatesKey{}).(func(string) ([]models.WorkflowState, bool))
+	if req.InitialState == "" || !ok {
+		return nil
+	}
+	states, known := lookup(req.WorkflowType)
+	if !known {
+		return nil
+	}
+	for _, s := range states {
+		if s.Name == req.InitialState {
+			return nil
 		}
+	}
+	return fmt.Errorf("workflow type %s has no state %s", req.WorkflowType, req.InitialState)
+}
 
-		nextExecution := ns.NextExecution
-		// if the next execution is a valid date and time in the future then set it and break processing
-		if !nextExecution.IsZero() {
-			//if nextExecution.After(time.Now()) { // no need, if its in the past it will just run on the next pick up
-			slog.InfoContext(ctx, "Setting next activation (specific)", "workflow_id", w.GetWorkflowData().ID, "next_activation", nextExecution, "worker_id", workerID)
-			if err := r.UpdateNextActivationSpecific(w.GetWorkflowData().ID, nextExecution); err != nil {
-				slog.ErrorContext(ctx, "Error updating next activation", "error", err, "worker_id", workerID)
-				return
-			}
-			_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().RetryCount, Type: "SCHEDULE_ACTIVATION", Name: currentState, Text: nextExecution.String(), DateTime: time.Now()})
-			break
-			//}
+// callState invokes the state method by name and unpacks its (NextState or *NextState, error) result.
+func callState(ctx context.Context, val reflect.Value, state string) (*models.NextState, error) {
+	method := val.MethodByName(state)
+	if !method.IsValid() {
+		panic(fmt.Sprintf("method %s not found", state))
+	}
+
+	// Call the method and get the next state
+	results := method.Call([]reflect.Value{reflect.ValueOf(ctx)})
+	if len(results) != 2 || !(results[0].Type().AssignableTo(reflect.TypeOf(models.NextState{})) || results[0].Type().AssignableTo(reflect.TypeOf(&models.NextState{}))) {
+		panic(fmt.Sprintf("method %s should return (NextState or *NextState, error)", state))
+	}
+
+	var ns *models.NextState
+	if results[0].Kind() == reflect.Ptr {
+		if val, ok := results[0].Interface().(*models.NextState); ok {
+			ns = val
 		}
-		nextExecutionOffset := ns.NextExecutionOffset
-		if nextExecutionOffset != "" {
-			slog.InfoContext(ctx, "Setting next activation (offset)", "workflow_id", w.GetWorkflowData().ID, "offset", nextExecutionOffset, "worker_id", workerID)
-			if err := r.UpdateNextActivationOffset(w.GetWorkflowData().ID, nextExecutionOffset); err != nil {
-				slog.ErrorContext(ctx, "Error updating next activation", "error", err, "worker_id", workerID)
-				return
+	} else if results[0].Kind() == reflect.Struct {
+		if val, ok := results[0].Interface().(models.NextState); ok {
+			ns = &val
+		}
+	}
+
+	if ns == nil {
+		panic(fmt.Sprintf("method %s did not return a NextState as first value", state))
+	}
+	// Second return value = error
+	var callErr error
+	if !results[1].IsNil() {
+		callErr = results[1].Interface().(error)
+	}
+	return ns, callErr
+}
+
+// processWorkflowCancelled runs the optional cleanup state of a cancelled workflow and releases it.
+func processWorkflowCancelled(ctx context.Context, w core.Workflow, r WorkflowRepo, wa WorkflowActionRepo, executorID int64, workerID string, currentState string) {
+	slog.InfoContext(ctx, "Workflow cancelled", "workflow_id", w.GetWorkflowData().ID, "state", currentState, "worker_id", workerID)
+
+	if handler, ok := w.(core.CancelHandler); ok && handler.CancelState() != "" {
+		cancelState := handler.CancelState()
+		// the workflow context is already cancelled, the cleanup gets one that is not
+		ns, err := runCancelState(context.WithoutCancel(ctx), w, cancelState)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error executing cancel state", "state", cancelState, "error", err, "worker_id", workerID)
+			_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().ExecutionCount, Type: "ERROR", Name: cancelState, Text: err.Error(), DateTime: time.Now()})
+		} else {
+			compareAndSaveWorkflowStateVars(ctx, w, r, workerID)
+			if ns.ActionLog != "" {
+				_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().ExecutionCount, Type: "LOG", Name: cancelState, Text: ns.ActionLog, DateTime: time.Now()})
 			}
-			_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().RetryCount, Type: "SCHEDULE_ACTIVATION", Name: currentState, Text: nextExecutionOffset, DateTime: time.Now()})
-			break
 		}
+	}
 
+	_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().ExecutionCount, Type: "CANCELLED", Name: currentState, Text: "Workflow cancelled in state " + currentState, DateTime: time.Now()})
+	if err := r.CompleteCancellation(w.GetWorkflowData().ID); err != nil {
+		slog.ErrorContext(ctx, "Error completing cancellation", "error", err, "worker_id", workerID)
+		return
 	}
+	notifyRelatives(ctx, w.GetWorkflowData(), r, wa, executorID, "CANCELLED")
+}
 
-	_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().RetryCount, Type: "FINISHED", Name: currentState, Text: "FINISHED", DateTime: time.Now()})
-	//clear out the executor id for another to possibly pick up the workflow
-	err = r.ClearExecutorId(w.GetWorkflowData().ID)
-	if err != nil {
+func isPaused(w core.Workflow, r WorkflowStateRepo) bool {
+	latest, err := r.FindByID(w.GetWorkflowData().ID)
+	return err == nil && latest != nil && latest.Status == "PAUSED"
+}
+
+// releasePausedWorkflow hands a paused workflow back so that it can be picked up again after it is resumed.
+func releasePausedWorkflow(ctx context.Context, w core.Workflow, r WorkflowStateRepo, workerID string) {
+	slog.InfoContext(ctx, "Workflow paused, releasing", "workflow_id", w.GetWorkflowData().ID, "worker_id", workerID)
+	if err := r.ClearExecutorId(w.GetWorkflowData().ID); err != nil {
 		slog.ErrorContext(ctx, "Error clearing executor id", "error", err, "worker_id", workerID)
-		return
 	}
-	slog.InfoContext(ctx, "Workflow finished", "worker_id", workerID)
+}
 
+// runCancelState runs the cleanup state, a panic is reported as an error so the cancellation still completes.
+func runCancelState(ctx context.Context, w core.Workflow, state string) (ns *models.NextState, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("panic in cancel state %s: %v", state, rec)
+		}
+	}()
+	return callState(ctx, reflect.ValueOf(w), state)
 }
 
 func processWorflowCompleted(ctx context.Context, w core.Workflow, r WorkflowRepo, wa WorkflowActionRepo, executorID int64, workerID string, currentState string) bool {
 	slog.InfoContext(ctx, "Workflow completed", "worker_id", workerID)
-	err := r.UpdateWorkflowStatus(w.GetWorkflowData().ID, "FINISHED")
-	_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().ExecutionCount, Type: "END", Name: currentState, Text: "workflow complete", DateTime: time.Now()})
+	// a workflow that completed in an error state or was cancelled meanwhile hands over no result, its relatives
+	// are told it failed or was cancelled
+	endStatus := endStatusFor(w, currentState)
+	// the result reaches the parent together with the status, a parent joining its children never sees one without the other
+	err := inTransaction(ctx, r, wa, func(r WorkflowRepo, wa WorkflowActionRepo) error {
+		if err := lockParent(w.GetWorkflowData(), r); err != nil {
+			return err
+		}
+		if err := r.UpdateWorkflowStatus(w.GetWorkflowData().ID, "FINISHED"); err != nil {
+			return err
+		}
+		// an operator cancellation that arrived while the workflow completed is kept, the row is locked by the update
+		// from here on, so the status read back is the one the workflow ends with
+		latest, err := r.FindByID(w.GetWorkflowData().ID)
+		if err != nil {
+			return err
+		}
+		if latest != nil && latest.Status == "CANCELLED" {
+			endStatus = "CANCELLED"
+			_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().ExecutionCount, Type: "CANCELLED", Name: currentState, Text: "Workflow cancelled while completing in state " + currentState, DateTime: time.Now()})
+		} else {
+			_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().ExecutionCount, Type: "END", Name: currentState, Text: "workflow complete", DateTime: time.Now()})
+		}
+		if endStatus == "FINISHED" {
+			if err := handOverResult(ctx, w, r, wa, executorID); err != nil {
+				return err
+			}
+		}
+		wakeParentOnEnd(ctx, w.GetWorkflowData(), r, wa, executorID, endStatus)
+		return nil
+	})
 	if err != nil {
 		slog.ErrorContext(ctx, "Error updating workflow status", "error", err, "worker_id", workerID)
 		return true
 	}
+	// ending in an error state is a failure, the completed states are undone as when the workflow fails
+	if endStatus == "FAILED" {
+		runCompensations(ctx, w, wa, executorID, workerID)
+	}
+	// children are cancelled once the workflow row is no longer locked, each in a transaction of its own
+	closeChildren(ctx, w.GetWorkflowData(), r, wa, executorID, endStatus)
 	return false
 }
 
-func processStateExecutionError(ctx context.Context, w core.Workflow, r WorkflowRepo, wa WorkflowActionRepo, executorID int64, workerID string, currentState string, callErr error) {
+// endStatusFor returns the status the relatives of a workflow completing in the given state are told about. A
+// workflow that completes in an error state failed, even though it is stored as FINISHED.
+func endStatusFor(w core.Workflow, state string) string {
+	for _, s := range w.GetAllStates() {
+		if s.Name == state && s.StateType == models.StateError {
+			return "FAILED"
+		}
+	}
+	return "FINISHED"
+}
+
+// processStateExecutionError records a failed state and schedules the retry. A permanent error fails the workflow
+// straight away, unless it names a state to move to, which is returned so that the caller carries on from there.
+func processStateExecutionError(ctx context.Context, w core.Workflow, r WorkflowRepo, wa WorkflowActionRepo, executorID int64, workerID string, currentState string, callErr error) string {
 	slog.ErrorContext(ctx, "Error executing state method", "state", currentState, "error", callErr, "worker_id", workerID)
 	_, _ = wa.Save(&domain.WorkflowAction{
 		WorkflowID:     w.GetWorkflowData().ID,
@@ -314,39 +578,177 @@ func processStateExecutionError(ctx context.Context, w core.Workflow, r Workflow
 		Text:           callErr.Error(),
 		DateTime:       time.Now(),
 	})
+
+	var permanent *core.PermanentError
+	if errors.As(callErr, &permanent) {
+		return processPermanentError(ctx, w, r, wa, executorID, workerID, currentState, permanent)
+	}
+
+	retryConfig, policy := retryConfigFor(w, currentState)
 	//increment workflow retry counter
-	if w.GetWorkflowData().RetryCount > w.GetRetryConfig().MaxRetryCount {
+	if w.GetWorkflowData().RetryCount > retryConfig.MaxRetryCount {
 		slog.ErrorContext(ctx, "Max retry count reached", "worker_id", workerID)
+		if failureState := failureStateFor(w, currentState); failureState != "" {
+			return moveToErrorState(ctx, w, r, wa, executorID, workerID, currentState, failureState, "after retries were exhausted", callErr)
+		}
 		_ = r.UpdateWorkflowStatus(w.GetWorkflowData().ID, "FAILED")
 		_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().ExecutionCount,
 			Type: "FAILED", Name: currentState, Text: fmt.Sprintf("Max retry count reached for workflow id:%d count :%d", w.GetWorkflowData().ID, w.GetWorkflowData().RetryCount), DateTime: time.Now()})
-		return
+		runCompensations(ctx, w, wa, executorID, workerID)
+		notifyRelatives(ctx, w.GetWorkflowData(), r, wa, executorID, "FAILED")
+		return ""
 	}
 
 	if compareAndSaveWorkflowStateVars(ctx, w, r, workerID) {
-		return
+		return ""
 	}
 
-	config := w.GetRetryConfig()
-	nextActivation := time.Now().Add(config.SlidingInterval(w.GetWorkflowData().RetryCount))
+	interval := retryConfig.NextInterval(w.GetWorkflowData().RetryCount)
+	applied := policy + " policy " + retryConfig.String()
+	var retryAfter *core.RetryAfterError
+	if errors.As(callErr, &retryAfter) && retryAfter.After > 0 {
+		interval = retryAfter.After
+		applied = "retry-after hint of " + retryAfter.After.String()
+	}
+	nextActivation := time.Now().Add(interval)
 	err := r.IncrementRetryCounterAndSetNextActivation(w.GetWorkflowData().ID, nextActivation)
 	if err != nil {
 		slog.ErrorContext(ctx, "Error incrementing retry count", "error", err, "worker_id", workerID)
-		return
+		return ""
 	}
 	_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().ExecutionCount,
-		Type: "RETRY", Name: currentState, Text: fmt.Sprintf("Retry at  :%s", nextActivation), DateTime: time.Now()})
-	return
+		Type: "RETRY", Name: currentState, Text: fmt.Sprintf("Retry at  :%s using %s", nextActivation, applied), DateTime: time.Now()})
+	return ""
+}
+
+// processPermanentError fails the workflow without retrying, or moves it to the error state named by the error.
+func processPermanentError(ctx context.Context, w core.Workflow, r WorkflowRepo, wa WorkflowActionRepo, executorID int64, workerID string, currentState string, permanent *core.PermanentError) string {
+	if permanent.State != "" {
+		if isDeclaredState(w, permanent.State) {
+			return moveToErrorState(ctx, w, r, wa, executorID, workerID, currentState, permanent.State, "after a permanent error", permanent)
+		}
+		slog.ErrorContext(ctx, "Permanent error names an undeclared state", "state", permanent.State, "worker_id", workerID)
+	}
+
+	slog.ErrorContext(ctx, "Permanent error, failing workflow", "state", currentState, "worker_id", workerID)
+	_ = r.UpdateWorkflowStatus(w.GetWorkflowData().ID, "FAILED")
+	_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().ExecutionCount,
+		Type: "FAILED", Name: currentState, Text: "Permanent error, not retried: " + permanent.Error(), DateTime: time.Now()})
+	runCompensations(ctx, w, wa, executorID, workerID)
+	notifyRelatives(ctx, w.GetWorkflowData(), r, wa, executorID, "FAILED")
+	return ""
+}
+
+// moveToErrorState records the failure in the state variables and moves the workflow to the error state,
+// which is returned so that the caller carries on from there. The move is recorded as an ERROR_TRANSITION, the
+// state it leaves did not complete and is not compensated.
+func moveToErrorState(ctx context.Context, w core.Workflow, r WorkflowRepo, wa WorkflowActionRepo, executorID int64, workerID string, currentState string, errorState string, reason string, callErr error) string {
+	slog.InfoContext(ctx, "Moving to error state", "from", currentState, "to", errorState, "worker_id", workerID)
+	if vars := w.GetStateVariables(); vars != nil {
+		vars[core.VarFailedState] = currentState
+		vars[core.VarFailureError] = callErr.Error()
+	}
+	err := inTransaction(ctx, r, wa, func(r WorkflowRepo, wa WorkflowActionRepo) error {
+		if compareAndSaveWorkflowStateVars(ctx, w, r, workerID) {
+			return errStateVarsNotSaved
+		}
+		if err := r.UpdateState(w.GetWorkflowData().ID, errorState); err != nil {
+			return err
+		}
+		_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().ExecutionCount,
+			Type: "ERROR_TRANSITION", Name: currentState, Text: "From " + currentState + " to " + errorState, DateTime: time.Now()})
+		_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().ExecutionCount,
+			Type: "LOG", Name: errorState, Text: "Moved to " + errorState + " " + reason + ": " + callErr.Error(), DateTime: time.Now()})
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error updating workflow state", "error", err, "worker_id", workerID)
+		return ""
+	}
+	return errorState
+}
+
+// failureStateFor returns the declared state to move to once the retries of the state are exhausted, if any.
+func failureStateFor(w core.Workflow, state string) string {
+	failureState := ""
+	for _, s := range w.GetAllStates() {
+		if s.Name == state {
+			failureState = s.FailureState
+			break
+		}
+	}
+	if handler, ok := w.(core.FailureHandler); ok && failureState == "" {
+		failureState = handler.FailureState()
+	}
+	// never loop back into the state that just failed
+	if failureState == state || !isDeclaredState(w, failureState) {
+		return ""
+	}
+	return failureState
+}
+
+// runErrorState runs the method of an error state once. It returns false when the method failed, in which case
+// the workflow has been marked FAILED.
+func runErrorState(ctx context.Context, w core.Workflow, r WorkflowRepo, wa WorkflowActionRepo, val reflect.Value, executorID int64, workerID string, currentState string) bool {
+	ns, err := callStateWithTimeout(ctx, val, currentState, stateTimeout(w, currentState))
+	if err != nil {
+		slog.ErrorContext(ctx, "Error executing error state", "state", currentState, "error", err, "worker_id", workerID)
+		_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().ExecutionCount, Type: "ERROR", Name: currentState, Text: err.Error(), DateTime: time.Now()})
+		_ = r.UpdateWorkflowStatus(w.GetWorkflowData().ID, "FAILED")
+		_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().ExecutionCount, Type: "FAILED", Name: currentState, Text: "Error state failed", DateTime: time.Now()})
+		runCompensations(ctx, w, wa, executorID, workerID)
+		notifyRelatives(ctx, w.GetWorkflowData(), r, wa, executorID, "FAILED")
+		return false
+	}
+	if compareAndSaveWorkflowStateVars(ctx, w, r, workerID) {
+		return false
+	}
+	if ns.ActionLog != "" {
+		_, _ = wa.Save(&domain.WorkflowAction{WorkflowID: w.GetWorkflowData().ID, ExecutorID: executorID, ExecutionCount: w.GetWorkflowData().ExecutionCount, Type: "LOG", Name: currentState, Text: ns.ActionLog, DateTime: time.Now()})
+	}
+	return true
+}
+
+func isDeclaredState(w core.Workflow, state string) bool {
+	for _, s := range w.GetAllStates() {
+		if s.Name == state {
+			return true
+		}
+	}
+	return false
+}
+
+// retryConfigFor returns the retry policy of the state when it declares one, otherwise the workflow's,
+// together with which of the two it is.
+func retryConfigFor(w core.Workflow, state string) (models.RetryConfig, string) {
+	for _, s := range w.GetAllStates() {
+		if s.Name == state && s.RetryConfig != nil {
+			return *s.RetryConfig, "state"
+		}
+	}
+	return w.GetRetryConfig(), "workflow"
 }
 
 func compareAndSaveWorkflowStateVars(ctx context.Context, w core.Workflow, r WorkflowRepo, workerID string) bool {
 	jsonString, _ := json.Marshal(w.GetStateVariables())
 
 	if string(jsonString) != w.GetWorkflowData().StateVars.String {
-		slog.InfoContext(ctx, "Updating workflow variables", "workflow_id", w.GetWorkflowData().ID, "state_vars", string(jsonString), "worker_id", workerID)
-		err2 := r.SaveWorkflowVariables(w.GetWorkflowData().ID, string(jsonString))
-		if err2 != nil {
-			slog.ErrorContext(ctx, "Error saving workflow variables", "error", err2, "worker_id", workerID)
+		// children may have handed over results since the workflow was loaded, they are merged in while the row is
+		// locked so that saving does not drop them
+		err := inTransaction(ctx, r, nil, func(r WorkflowRepo, _ WorkflowActionRepo) error {
+			if err := receiveChildResults(w, r); err != nil {
+				slog.ErrorContext(ctx, "Error reading child workflow results", "error", err, "worker_id", workerID)
+				return err
+			}
+			jsonString, _ = json.Marshal(w.GetStateVariables())
+			slog.InfoContext(ctx, "Updating workflow variables", "workflow_id", w.GetWorkflowData().ID, "state_vars", string(jsonString), "worker_id", workerID)
+			if err := r.SaveWorkflowVariables(w.GetWorkflowData().ID, string(jsonString)); err != nil {
+				slog.ErrorContext(ctx, "Error saving workflow variables", "error", err, "worker_id", workerID)
+				return err
+			}
+			return nil
+		})
+		if err != nil {
 			return true
 		}
 	} else {
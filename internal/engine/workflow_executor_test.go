@@ -2,7 +2,12 @@ package engine
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -18,7 +23,10 @@ type MockWorkflowRepo struct {
 	UpdateWorkflowStartingTimeFunc                func(id int64) error
 	UpdateStateFunc                               func(id int64, state string) error
 	SaveWorkflowVariablesFunc                     func(id int64, vars string) error
+	SetWorkflowVariableFunc                       func(id int64, key string, value string) error
+	FindWorkflowVariablesForUpdateFunc            func(id int64) (sql.NullString, error)
 	WakeParentWorkflowFunc                        func(parentID int64) error
+	GetChildrenByParentIDFunc                     func(parentID int64, onlyActive bool) (*[]domain.Workflow, error)
 	SaveFunc                                      func(wf *domain.Workflow) (int64, error)
 	FindByIDFunc                                  func(id int64) (*domain.Workflow, error)
 	UpdateNextActivationSpecificFunc              func(id int64, next time.Time) error
@@ -29,6 +37,23 @@ type MockWorkflowRepo struct {
 	MarkWorkflowAsScheduledForExecutionFunc       func(id int64, executorId int64, modified time.Time) bool
 	FindStuckWorkflowsFunc                        func(minutesRepair string, executorGroup string, limit int) (*[]domain.Workflow, error)
 	LockWorkflowByModifiedFunc                    func(id int64, modified time.Time) bool
+	MarkWorkflowAsExecutingFunc                   func(id int64) bool
+	CancelWorkflowFunc                            func(id int64) (bool, error)
+	FindCancelledWorkflowsFunc                    func(executorGroup string, executorId int64, limit int) (*[]domain.Workflow, error)
+	ClaimCancelledWorkflowFunc                    func(id int64, executorId int64) bool
+	CompleteCancellationFunc                      func(id int64) error
+	PauseWorkflowFunc                             func(id int64) (bool, error)
+	ResumeWorkflowFunc                            func(id int64) (bool, error)
+	SaveSignalFunc                                func(s *domain.WorkflowSignal) (int64, error)
+	FindPendingSignalsFunc                        func(workflowID int64) (*[]domain.WorkflowSignal, error)
+	MarkSignalsConsumedFunc                       func(ids []int64) error
+	WakeWorkflowFunc                              func(id int64) error
+	PinWorkflowVersionFunc                        func(id int64, version int) error
+	RenewLeasesFunc                               func(executorId int64, ids []int64) (int64, error)
+	ReclaimExpiredLeaseFunc                       func(id int64, modified time.Time) bool
+	ReleaseWorkflowFunc                           func(id int64, executorId int64) (bool, error)
+	ReleaseWorkflowsFunc                          func(executorId int64, ids []int64) (int64, error)
+	FindByExternalIdFunc                          func(id string) (*domain.Workflow, error)
 }
 
 func (m *MockWorkflowRepo) UpdateWorkflowStatus(id int64, status string) error {
@@ -49,6 +74,19 @@ func (m *MockWorkflowRepo) UpdateState(id int64, state string) error {
 	}
 	return nil
 }
+func (m *MockWorkflowRepo) SetWorkflowVariable(id int64, key string, value string) error {
+	if m.SetWorkflowVariableFunc != nil {
+		return m.SetWorkflowVariableFunc(id, key, value)
+	}
+	return nil
+}
+func (m *MockWorkflowRepo) FindWorkflowVariablesForUpdate(id int64) (sql.NullString, error) {
+	if m.FindWorkflowVariablesForUpdateFunc != nil {
+		return m.FindWorkflowVariablesForUpdateFunc(id)
+	}
+	return sql.NullString{}, nil
+}
+
 func (m *MockWorkflowRepo) SaveWorkflowVariables(id int64, vars string) error {
 	if m.SaveWorkflowVariablesFunc != nil {
 		return m.SaveWorkflowVariablesFunc(id, vars)
@@ -100,6 +138,9 @@ func (m *MockWorkflowRepo) IncrementRetryCounterAndSetNextActivation(id int64, a
 
 // Stubs for other interface methods not typically used in basic RunWorkflow tests but required by interface
 func (m *MockWorkflowRepo) GetChildrenByParentID(parentID int64, onlyActive bool) (*[]domain.Workflow, error) {
+	if m.GetChildrenByParentIDFunc != nil {
+		return m.GetChildrenByParentIDFunc(parentID, onlyActive)
+	}
 	return nil, nil
 }
 func (m *MockWorkflowRepo) FindPendingWorkflows(size int, executorGroup string) (*[]domain.Workflow, error) {
@@ -137,12 +178,116 @@ func (m *MockWorkflowRepo) GetWorkflowOverview() ([]repository.WorkflowOverviewR
 func (m *MockWorkflowRepo) GetDefinitionStateOverview(workflowType string) ([]repository.DefinitionStateRow, error) {
 	return nil, nil
 }
-func (m *MockWorkflowRepo) FindByExternalId(id string) (*domain.Workflow, error)      { return nil, nil }
+func (m *MockWorkflowRepo) FindByExternalId(id string) (*domain.Workflow, error) {
+	if m.FindByExternalIdFunc != nil {
+		return m.FindByExternalIdFunc(id)
+	}
+	return nil, nil
+}
 func (m *MockWorkflowRepo) SaveWorkflowVariablesAndTouch(id int64, vars string) error { return nil }
+func (m *MockWorkflowRepo) MarkWorkflowAsExecuting(id int64) bool {
+	if m.MarkWorkflowAsExecutingFunc != nil {
+		return m.MarkWorkflowAsExecutingFunc(id)
+	}
+	return true
+}
+func (m *MockWorkflowRepo) CancelWorkflow(id int64) (bool, error) {
+	if m.CancelWorkflowFunc != nil {
+		return m.CancelWorkflowFunc(id)
+	}
+	return true, nil
+}
+func (m *MockWorkflowRepo) FindCancelledWorkflows(executorGroup string, executorId int64, limit int) (*[]domain.Workflow, error) {
+	if m.FindCancelledWorkflowsFunc != nil {
+		return m.FindCancelledWorkflowsFunc(executorGroup, executorId, limit)
+	}
+	return &[]domain.Workflow{}, nil
+}
+func (m *MockWorkflowRepo) ClaimCancelledWorkflow(id int64, executorId int64) bool {
+	if m.ClaimCancelledWorkflowFunc != nil {
+		return m.ClaimCancelledWorkflowFunc(id, executorId)
+	}
+	return true
+}
+func (m *MockWorkflowRepo) CompleteCancellation(id int64) error {
+	if m.CompleteCancellationFunc != nil {
+		return m.CompleteCancellationFunc(id)
+	}
+	return nil
+}
+func (m *MockWorkflowRepo) PauseWorkflow(id int64) (bool, error) {
+	if m.PauseWorkflowFunc != nil {
+		return m.PauseWorkflowFunc(id)
+	}
+	return true, nil
+}
+func (m *MockWorkflowRepo) ResumeWorkflow(id int64) (bool, error) {
+	if m.ResumeWorkflowFunc != nil {
+		return m.ResumeWorkflowFunc(id)
+	}
+	return true, nil
+}
+func (m *MockWorkflowRepo) SaveSignal(sig *domain.WorkflowSignal) (int64, error) {
+	if m.SaveSignalFunc != nil {
+		return m.SaveSignalFunc(sig)
+	}
+	return 1, nil
+}
+func (m *MockWorkflowRepo) FindPendingSignals(workflowID int64) (*[]domain.WorkflowSignal, error) {
+	if m.FindPendingSignalsFunc != nil {
+		return m.FindPendingSignalsFunc(workflowID)
+	}
+	return nil, nil
+}
+func (m *MockWorkflowRepo) MarkSignalsConsumed(ids []int64) error {
+	if m.MarkSignalsConsumedFunc != nil {
+		return m.MarkSignalsConsumedFunc(ids)
+	}
+	return nil
+}
+func (m *MockWorkflowRepo) WakeWorkflow(id int64) error {
+	if m.WakeWorkflowFunc != nil {
+		return m.WakeWorkflowFunc(id)
+	}
+	return nil
+}
+func (m *MockWorkflowRepo) PinWorkflowVersion(id int64, version int) error {
+	if m.PinWorkflowVersionFunc != nil {
+		return m.PinWorkflowVersionFunc(id, version)
+	}
+	return nil
+}
+func (m *MockWorkflowRepo) RenewLeases(executorId int64, ids []int64) (int64, error) {
+	if m.RenewLeasesFunc != nil {
+		return m.RenewLeasesFunc(executorId, ids)
+	}
+	return int64(len(ids)), nil
+}
+func (m *MockWorkflowRepo) ReclaimExpiredLease(id int64, modified time.Time) bool {
+	if m.ReclaimExpiredLeaseFunc != nil {
+		return m.ReclaimExpiredLeaseFunc(id, modified)
+	}
+	return true
+}
+func (m *MockWorkflowRepo) ReleaseWorkflow(id int64, executorId int64) (bool, error) {
+	if m.ReleaseWorkflowFunc != nil {
+		return m.ReleaseWorkflowFunc(id, executorId)
+	}
+	return true, nil
+}
+func (m *MockWorkflowRepo) ReleaseWorkflows(executorId int64, ids []int64) (int64, error) {
+	if m.ReleaseWorkflowsFunc != nil {
+		return m.ReleaseWorkflowsFunc(executorId, ids)
+	}
+	return int64(len(ids)), nil
+}
+func (m *MockWorkflowRepo) CountRunningWorkflows(workflowType string) (int, error) { return 0, nil }
+func (m *MockWorkflowRepo) UpdatePriority(id int64, priority int) (bool, error)    { return true, nil }
 
 // MockWorkflowActionRepo
 type MockWorkflowActionRepo struct {
-	SaveFunc func(a *domain.WorkflowAction) (int64, error)
+	SaveFunc                func(a *domain.WorkflowAction) (int64, error)
+	FindAllByWorkflowIDFunc func(workflowID int64) (*[]domain.WorkflowAction, error)
 }
 
 func (m *MockWorkflowActionRepo) Save(a *domain.WorkflowAction) (int64, error) {
@@ -152,6 +297,9 @@ func (m *MockWorkflowActionRepo) Save(a *domain.WorkflowAction) (int64, error) {
 	return 1, nil
 }
 func (m *MockWorkflowActionRepo) FindAllByWorkflowID(workflowID int64) (*[]domain.WorkflowAction, error) {
+	if m.FindAllByWorkflowIDFunc != nil {
+		return m.FindAllByWorkflowIDFunc(workflowID)
+	}
 	return nil, nil
 }
 
@@ -294,3 +442,1295 @@ func TestRunWorkflow_RetryLogic(t *testing.T) {
 		t.Error("Expected increment retry counter to be called")
 	}
 }
+
+// CancellableMockWorkflow declares a cleanup state to run when the workflow is cancelled
+type CancellableMockWorkflow struct {
+	MockWorkflow
+	CleanupCalled bool
+}
+
+func (m *CancellableMockWorkflow) CancelState() string {
+	return "Cleanup"
+}
+func (m *CancellableMockWorkflow) Cleanup(ctx context.Context) (models.NextState, error) {
+	m.CleanupCalled = ctx.Err() == nil
+	return models.NextState{ActionLog: "cleaned up"}, nil
+}
+
+func TestRunWorkflow_CancelledRunsCancelState(t *testing.T) {
+	completed := false
+	var states []string
+	repo := &MockWorkflowRepo{
+		UpdateStateFunc: func(id int64, state string) error {
+			states = append(states, state)
+			return nil
+		},
+		CompleteCancellationFunc: func(id int64) error {
+			completed = true
+			return nil
+		},
+	}
+	var actionTypes []string
+	actionRepo := &MockWorkflowActionRepo{
+		SaveFunc: func(a *domain.WorkflowAction) (int64, error) {
+			actionTypes = append(actionTypes, a.Type)
+			return 1, nil
+		},
+	}
+
+	wf := &CancellableMockWorkflow{
+		MockWorkflow: MockWorkflow{WorkflowData: domain.Workflow{ID: 1, State: "Step1"}},
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(core.ErrWorkflowCancelled)
+	RunWorkflow(ctx, wf, repo, actionRepo, 1, "worker1")
+
+	if !wf.CleanupCalled {
+		t.Error("Expected cleanup state to run with a live context")
+	}
+	if !completed {
+		t.Error("Expected cancellation to be completed")
+	}
+	if len(states) != 0 {
+		t.Errorf("Expected no state transitions, got %v", states)
+	}
+	if actionTypes[len(actionTypes)-1] != "CANCELLED" {
+		t.Errorf("Expected last action to be CANCELLED, got %v", actionTypes)
+	}
+}
+
+func TestRunWorkflow_CancelledWhileQueued(t *testing.T) {
+	completed := false
+	repo := &MockWorkflowRepo{
+		MarkWorkflowAsExecutingFunc: func(id int64) bool {
+			return false
+		},
+		FindByIDFunc: func(id int64) (*domain.Workflow, error) {
+			return &domain.Workflow{ID: id, Status: "CANCELLED"}, nil
+		},
+		CompleteCancellationFunc: func(id int64) error {
+			completed = true
+			return nil
+		},
+	}
+
+	wf := &MockWorkflow{
+		WorkflowData: domain.Workflow{ID: 1, State: "Step1"},
+		ShouldPanic:  true,
+	}
+
+	RunWorkflow(context.Background(), wf, repo, &MockWorkflowActionRepo{}, 1, "worker1")
+
+	if !completed {
+		t.Error("Expected cancellation to be completed without running the state")
+	}
+}
+
+func TestRunWorkflow_PausedBetweenStates(t *testing.T) {
+	released := false
+	var states []string
+	repo := &MockWorkflowRepo{
+		FindByIDFunc: func(id int64) (*domain.Workflow, error) {
+			return &domain.Workflow{ID: id, Status: "PAUSED"}, nil
+		},
+		UpdateStateFunc: func(id int64, state string) error {
+			states = append(states, state)
+			return nil
+		},
+		ClearExecutorIdFunc: func(id int64) error {
+			released = true
+			return nil
+		},
+	}
+
+	// Step1 panics, so the test fails if the pause is not honoured after Start
+	wf := &MockWorkflow{
+		WorkflowData: domain.Workflow{ID: 1, State: string(models.StateStart)},
+		ShouldPanic:  true,
+	}
+
+	RunWorkflow(context.Background(), wf, repo, &MockWorkflowActionRepo{}, 1, "worker1")
+
+	if len(states) != 1 || states[0] != "Step1" {
+		t.Errorf("Expected only the transition to Step1, got %v", states)
+	}
+	if !released {
+		t.Error("Expected the paused workflow to be released")
+	}
+}
+
+func TestRunWorkflow_PausedDuringFinalState(t *testing.T) {
+	released := false
+	var statuses []string
+	woken := false
+	repo := &MockWorkflowRepo{
+		FindByIDFunc: func(id int64) (*domain.Workflow, error) {
+			return &domain.Workflow{ID: id, Status: "PAUSED"}, nil
+		},
+		UpdateWorkflowStatusFunc: func(id int64, status string) error {
+			statuses = append(statuses, status)
+			return nil
+		},
+		WakeParentWorkflowFunc: func(parentID int64) error {
+			woken = true
+			return nil
+		},
+		ClearExecutorIdFunc: func(id int64) error {
+			released = true
+			return nil
+		},
+	}
+
+	// Step1 moves to the end state, the pause arrives while it runs
+	wf := &MockWorkflow{
+		WorkflowData: domain.Workflow{ID: 1, State: "Step1", ParentWorkflowID: sql.NullInt64{Int64: 9, Valid: true}},
+	}
+
+	RunWorkflow(context.Background(), wf, repo, &MockWorkflowActionRepo{}, 1, "worker1")
+
+	if len(statuses) != 0 {
+		t.Errorf("Expected the paused workflow not to complete, got status updates %v", statuses)
+	}
+	if woken {
+		t.Error("Expected the parent not to be notified before the workflow completes")
+	}
+	if !released {
+		t.Error("Expected the paused workflow to be released")
+	}
+}
+
+// SlowMockWorkflow has a Step1 that never finishes on its own
+type SlowMockWorkflow struct {
+	MockWorkflow
+	StepTimeout    time.Duration
+	DefaultTimeout time.Duration
+}
+
+func (m *SlowMockWorkflow) GetAllStates() []models.WorkflowState {
+	return []models.WorkflowState{
+		{Name: string(models.StateStart), StateType: models.StateStart},
+		{Name: "Step1", StateType: models.StateNormal, Timeout: m.StepTimeout},
+		{Name: string(models.StateEnd), StateType: models.StateEnd},
+	}
+}
+func (m *SlowMockWorkflow) DefaultStateTimeout() time.Duration {
+	return m.DefaultTimeout
+}
+func (m *SlowMockWorkflow) Step1(ctx context.Context) (models.NextState, error) {
+	<-ctx.Done()
+	return models.NextState{}, ctx.Err()
+}
+
+func TestRunWorkflow_StateTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		wf   *SlowMockWorkflow
+	}{
+		{"state timeout", &SlowMockWorkflow{StepTimeout: 20 * time.Millisecond}},
+		{"workflow default timeout", &SlowMockWorkflow{DefaultTimeout: 20 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retryCalled := false
+			repo := &MockWorkflowRepo{
+				IncrementRetryCounterAndSetNextActivationFunc: func(id int64, activation time.Time) error {
+					retryCalled = true
+					return nil
+				},
+			}
+			var actionTypes []string
+			actionRepo := &MockWorkflowActionRepo{
+				SaveFunc: func(a *domain.WorkflowAction) (int64, error) {
+					actionTypes = append(actionTypes, a.Type)
+					return 1, nil
+				},
+			}
+			tt.wf.WorkflowData = domain.Workflow{ID: 1, State: "Step1"}
+
+			RunWorkflow(context.Background(), tt.wf, repo, actionRepo, 1, "worker1")
+
+			if !slices.Contains(actionTypes, "TIMEOUT") {
+				t.Errorf("Expected a TIMEOUT action, got %v", actionTypes)
+			}
+			if !retryCalled {
+				t.Error("Expected the timed out state to be retried")
+			}
+		})
+	}
+}
+
+// LateWriterMockWorkflow has a Step1 that keeps writing state variables after its deadline has passed
+type LateWriterMockWorkflow struct {
+	SlowMockWorkflow
+}
+
+func (m *LateWriterMockWorkflow) GetStateVariables() map[string]string {
+	return m.StateVariables
+}
+func (m *LateWriterMockWorkflow) Step1(ctx context.Context) (models.NextState, error) {
+	<-ctx.Done()
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 100; i++ {
+		m.StateVariables[fmt.Sprintf("late-%d", i)] = "written"
+	}
+	return models.NextState{}, ctx.Err()
+}
+
+func TestRunWorkflow_StateTimeoutWaitsForLateWrites(t *testing.T) {
+	var saved string
+	repo := &MockWorkflowRepo{
+		SaveWorkflowVariablesFunc: func(id int64, vars string) error {
+			saved = vars
+			return nil
+		},
+	}
+	var actionTypes []string
+	actionRepo := &MockWorkflowActionRepo{
+		SaveFunc: func(a *domain.WorkflowAction) (int64, error) {
+			actionTypes = append(actionTypes, a.Type)
+			return 1, nil
+		},
+	}
+	wf := &LateWriterMockWorkflow{SlowMockWorkflow{StepTimeout: 10 * time.Millisecond}}
+	wf.StateVariables = map[string]string{}
+	wf.WorkflowData = domain.Workflow{ID: 1, State: "Step1"}
+
+	RunWorkflow(context.Background(), wf, repo, actionRepo, 1, "worker1")
+
+	if !slices.Contains(actionTypes, "TIMEOUT") {
+		t.Errorf("Expected a TIMEOUT action, got %v", actionTypes)
+	}
+	if !strings.Contains(saved, "late-99") {
+		t.Errorf("Expected the variables written before the state returned to be saved, got %q", saved)
+	}
+}
+
+// StatePolicyMockWorkflow gives Step1 its own exponential retry policy
+type StatePolicyMockWorkflow struct {
+	MockWorkflow
+}
+
+func (m *StatePolicyMockWorkflow) GetAllStates() []models.WorkflowState {
+	return []models.WorkflowState{
+		{Name: string(models.StateStart), StateType: models.StateStart},
+		{Name: "Step1", StateType: models.StateNormal, RetryConfig: &models.RetryConfig{
+			MaxRetryCount:    5,
+			RetryIntervalMin: 1 * time.Minute,
+			RetryIntervalMax: 1 * time.Hour,
+			Backoff:          models.BackoffExponential,
+			Multiplier:       3,
+		}},
+		{Name: string(models.StateEnd), StateType: models.StateEnd},
+	}
+}
+
+func TestRunWorkflow_StateRetryPolicy(t *testing.T) {
+	var nextActivation time.Time
+	repo := &MockWorkflowRepo{
+		IncrementRetryCounterAndSetNextActivationFunc: func(id int64, activation time.Time) error {
+			nextActivation = activation
+			return nil
+		},
+	}
+	var retryText string
+	actionRepo := &MockWorkflowActionRepo{
+		SaveFunc: func(a *domain.WorkflowAction) (int64, error) {
+			if a.Type == "RETRY" {
+				retryText = a.Text
+			}
+			return 1, nil
+		},
+	}
+
+	// the workflow policy allows 3 retries, the state policy 5
+	wf := &StatePolicyMockWorkflow{MockWorkflow{
+		WorkflowData: domain.Workflow{ID: 1, State: "Step1", RetryCount: 4},
+		ShouldError:  true,
+	}}
+	start := time.Now()
+	RunWorkflow(context.Background(), wf, repo, actionRepo, 1, "worker1")
+
+	// 1 minute * 3^4 = 81 minutes, capped at the 1 hour maximum
+	if delay := nextActivation.Sub(start); delay < time.Hour || delay > time.Hour+time.Minute {
+		t.Errorf("Expected the retry to be capped at 1 hour, got %s", delay)
+	}
+	if !strings.Contains(retryText, "state policy Exponential") {
+		t.Errorf("Expected the RETRY action to record the state policy, got %q", retryText)
+	}
+}
+
+func TestRetryConfig_NextInterval(t *testing.T) {
+	rc := models.RetryConfig{MaxRetryCount: 10, RetryIntervalMin: time.Second, RetryIntervalMax: time.Minute, Backoff: models.BackoffExponential}
+	if got := rc.NextInterval(3); got != 8*time.Second {
+		t.Errorf("Expected 8s with the default multiplier, got %s", got)
+	}
+
+	rc.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := rc.NextInterval(3); got < 4*time.Second || got > 12*time.Second {
+			t.Fatalf("Expected 8s +/- 50%%, got %s", got)
+		}
+	}
+
+	linear := models.RetryConfig{MaxRetryCount: 4, RetryIntervalMin: time.Second, RetryIntervalMax: 5 * time.Second}
+	if got := linear.NextInterval(2); got != linear.SlidingInterval(2) {
+		t.Errorf("Expected linear backoff by default, got %s", got)
+	}
+
+	// without a max the interval is capped before it could overflow
+	uncapped := models.RetryConfig{MaxRetryCount: 10000, RetryIntervalMin: time.Second, Backoff: models.BackoffExponential}
+	for _, retryNum := range []int{40, 100, 5000} {
+		if got := uncapped.NextInterval(retryNum); got != models.DefaultExponentialMax {
+			t.Errorf("Expected retry %d capped at %s, got %s", retryNum, models.DefaultExponentialMax, got)
+		}
+	}
+
+	// jitter does not push an uncapped policy past the default max
+	uncapped.Jitter = 1
+	for i := 0; i < 100; i++ {
+		if got := uncapped.NextInterval(100); got > models.DefaultExponentialMax {
+			t.Fatalf("Expected jitter capped at %s, got %s", models.DefaultExponentialMax, got)
+		}
+	}
+}
+
+// ErrorMockWorkflow returns Err from Step1 and declares a Rejected error state
+type ErrorMockWorkflow struct {
+	MockWorkflow
+	Err error
+}
+
+func (m *ErrorMockWorkflow) GetAllStates() []models.WorkflowState {
+	return append(m.MockWorkflow.GetAllStates(), models.WorkflowState{Name: "Rejected", StateType: models.StateError})
+}
+func (m *ErrorMockWorkflow) Step1(ctx context.Context) (models.NextState, error) {
+	return models.NextState{}, m.Err
+}
+
+func TestRunWorkflow_ErrorClassification(t *testing.T) {
+	invalid := errors.New("invalid input")
+	tests := []struct {
+		name         string
+		err          error
+		wantStatus   string
+		wantState    string
+		wantRetry    time.Duration
+		wantRetryLog string
+	}{
+		{name: "permanent", err: core.Permanent(invalid), wantStatus: "FAILED"},
+		{name: "permanent with state", err: core.PermanentWithState(invalid, "Rejected"), wantStatus: "FINISHED", wantState: "Rejected"},
+		{name: "permanent with undeclared state", err: core.PermanentWithState(invalid, "Missing"), wantStatus: "FAILED"},
+		{name: "retry after", err: core.RetryAfter(invalid, 10*time.Minute), wantRetry: 10 * time.Minute, wantRetryLog: "retry-after hint of 10m0s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var status, state string
+			var nextActivation time.Time
+			repo := &MockWorkflowRepo{
+				UpdateWorkflowStatusFunc: func(id int64, s string) error {
+					status = s
+					return nil
+				},
+				UpdateStateFunc: func(id int64, s string) error {
+					state = s
+					return nil
+				},
+				IncrementRetryCounterAndSetNextActivationFunc: func(id int64, activation time.Time) error {
+					nextActivation = activation
+					return nil
+				},
+			}
+			var retryText string
+			actionRepo := &MockWorkflowActionRepo{
+				SaveFunc: func(a *domain.WorkflowAction) (int64, error) {
+					if a.Type == "RETRY" {
+						retryText = a.Text
+					}
+					return 1, nil
+				},
+			}
+			wf := &ErrorMockWorkflow{MockWorkflow: MockWorkflow{WorkflowData: domain.Workflow{ID: 1, State: "Step1"}}, Err: tt.err}
+
+			start := time.Now()
+			RunWorkflow(context.Background(), wf, repo, actionRepo, 1, "worker1")
+
+			if status != tt.wantStatus {
+				t.Errorf("Expected status %q, got %q", tt.wantStatus, status)
+			}
+			if state != tt.wantState {
+				t.Errorf("Expected state %q, got %q", tt.wantState, state)
+			}
+			if tt.wantRetry == 0 && !nextActivation.IsZero() {
+				t.Error("Expected no retry")
+			}
+			if tt.wantRetry > 0 {
+				if delay := nextActivation.Sub(start); delay < tt.wantRetry || delay > tt.wantRetry+time.Second {
+					t.Errorf("Expected retry after %s, got %s", tt.wantRetry, delay)
+				}
+				if !strings.Contains(retryText, tt.wantRetryLog) {
+					t.Errorf("Expected RETRY action to mention %q, got %q", tt.wantRetryLog, retryText)
+				}
+			}
+		})
+	}
+}
+
+// FailingMockWorkflow moves to Failed once Step1 has exhausted its retries and alerts from there
+type FailingMockWorkflow struct {
+	ErrorMockWorkflow
+	AlertedWith string
+}
+
+func (m *FailingMockWorkflow) GetAllStates() []models.WorkflowState {
+	return append(m.MockWorkflow.GetAllStates(), models.WorkflowState{Name: "Failed", StateType: models.StateError})
+}
+func (m *FailingMockWorkflow) GetStateVariables() map[string]string {
+	return m.StateVariables
+}
+func (m *FailingMockWorkflow) FailureState() string {
+	return "Failed"
+}
+func (m *FailingMockWorkflow) Failed(ctx context.Context) (models.NextState, error) {
+	m.AlertedWith = m.StateVariables[core.VarFailedState] + ": " + m.StateVariables[core.VarFailureError]
+	return models.NextState{ActionLog: "alert sent"}, nil
+}
+
+func TestRunWorkflow_ExhaustedRetriesMoveToFailureState(t *testing.T) {
+	var status, savedVars string
+	var states []string
+	repo := &MockWorkflowRepo{
+		UpdateWorkflowStatusFunc: func(id int64, s string) error {
+			status = s
+			return nil
+		},
+		UpdateStateFunc: func(id int64, s string) error {
+			states = append(states, s)
+			return nil
+		},
+		SaveWorkflowVariablesFunc: func(id int64, vars string) error {
+			savedVars = vars
+			return nil
+		},
+	}
+
+	wf := &FailingMockWorkflow{ErrorMockWorkflow: ErrorMockWorkflow{
+		MockWorkflow: MockWorkflow{BaseWorkflow: core.BaseWorkflow{StateVariables: map[string]string{}}, WorkflowData: domain.Workflow{ID: 1, State: "Step1", RetryCount: 4}},
+		Err:          errors.New("service unavailable"),
+	}}
+	RunWorkflow(context.Background(), wf, repo, &MockWorkflowActionRepo{}, 1, "worker1")
+
+	if len(states) != 1 || states[0] != "Failed" {
+		t.Errorf("Expected a move to Failed, got %v", states)
+	}
+	if wf.AlertedWith != "Step1: service unavailable" {
+		t.Errorf("Expected the error state to see the original error, got %q", wf.AlertedWith)
+	}
+	if !strings.Contains(savedVars, "service unavailable") {
+		t.Errorf("Expected the error to be saved in the state vars, got %q", savedVars)
+	}
+	if status != "FINISHED" {
+		t.Errorf("Expected the workflow to complete in its error state, got %q", status)
+	}
+}
+
+// SagaMockWorkflow undoes Start and Step1 when a later state fails
+type SagaMockWorkflow struct {
+	MockWorkflow
+	Compensated []string
+}
+
+func (m *SagaMockWorkflow) GetAllStates() []models.WorkflowState {
+	return []models.WorkflowState{
+		{Name: string(models.StateStart), StateType: models.StateStart, Compensation: "UndoStart"},
+		{Name: "Step1", StateType: models.StateNormal, Compensation: "UndoStep1"},
+		{Name: "Step2", StateType: models.StateNormal, Compensation: "UndoStep2"},
+		{Name: string(models.StateEnd), StateType: models.StateEnd},
+	}
+}
+func (m *SagaMockWorkflow) Step2(ctx context.Context) (models.NextState, error) {
+	return models.NextState{}, core.Permanent(errors.New("payment declined"))
+}
+func (m *SagaMockWorkflow) UndoStart(ctx context.Context) error {
+	m.Compensated = append(m.Compensated, "Start")
+	return nil
+}
+func (m *SagaMockWorkflow) UndoStep1(ctx context.Context) error {
+	m.Compensated = append(m.Compensated, "Step1")
+	return nil
+}
+func (m *SagaMockWorkflow) UndoStep2(ctx context.Context) error {
+	m.Compensated = append(m.Compensated, "Step2")
+	return nil
+}
+
+func TestRunWorkflow_FailureRunsCompensationsInReverse(t *testing.T) {
+	tests := []struct {
+		name    string
+		history []domain.WorkflowAction
+		want    []string
+	}{
+		{
+			name: "reverse transition order",
+			history: []domain.WorkflowAction{
+				{ID: 3, Type: "TRANSITION", Name: "Step1", Text: "From Step1 to Step2"},
+				{ID: 2, Type: "TRANSITION", Name: "Step1", Text: "From Step1 to Step1"},
+				{ID: 1, Type: "TRANSITION", Name: "Start", Text: "From Start to Step1"},
+			},
+			want: []string{"Step1", "Start"},
+		},
+		{
+			name: "already compensated skipped",
+			history: []domain.WorkflowAction{
+				{ID: 4, Type: "COMPENSATED", Name: "Step1"},
+				{ID: 3, Type: "TRANSITION", Name: "Step1", Text: "From Step1 to Step2"},
+				{ID: 1, Type: "TRANSITION", Name: "Start", Text: "From Start to Step1"},
+			},
+			want: []string{"Start"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var compensatedActions []string
+			actionRepo := &MockWorkflowActionRepo{
+				SaveFunc: func(a *domain.WorkflowAction) (int64, error) {
+					if a.Type == "COMPENSATED" {
+						compensatedActions = append(compensatedActions, a.Name)
+					}
+					return 1, nil
+				},
+				FindAllByWorkflowIDFunc: func(workflowID int64) (*[]domain.WorkflowAction, error) {
+					return &tt.history, nil
+				},
+			}
+			wf := &SagaMockWorkflow{MockWorkflow: MockWorkflow{WorkflowData: domain.Workflow{ID: 1, State: "Step2"}}}
+
+			RunWorkflow(context.Background(), wf, &MockWorkflowRepo{}, actionRepo, 1, "worker1")
+
+			if !slices.Equal(wf.Compensated, tt.want) {
+				t.Errorf("Expected compensations %v, got %v", tt.want, wf.Compensated)
+			}
+			if !slices.Equal(compensatedActions, tt.want) {
+				t.Errorf("Expected COMPENSATED actions %v, got %v", tt.want, compensatedActions)
+			}
+		})
+	}
+}
+
+// RevertingSagaMockWorkflow ends in its Reverted error state, moved there by a permanent error of Step2 when
+// FailStep2 is set and through a regular transition otherwise
+type RevertingSagaMockWorkflow struct {
+	SagaMockWorkflow
+	FailStep2 bool
+}
+
+func (m *RevertingSagaMockWorkflow) GetAllStates() []models.WorkflowState {
+	return append(m.SagaMockWorkflow.GetAllStates(), models.WorkflowState{Name: "Reverted", StateType: models.StateError})
+}
+func (m *RevertingSagaMockWorkflow) StateTransitions() map[string][]string {
+	return map[string][]string{"Step2": {"Reverted"}}
+}
+func (m *RevertingSagaMockWorkflow) Step2(ctx context.Context) (models.NextState, error) {
+	if m.FailStep2 {
+		return models.NextState{}, core.PermanentWithState(errors.New("payment declined"), "Reverted")
+	}
+	return models.NextState{Name: "Reverted"}, nil
+}
+
+func TestRunWorkflow_ErrorStateCompensatesCompletedStates(t *testing.T) {
+	tests := []struct {
+		name      string
+		failStep2 bool
+		want      []string
+	}{
+		{name: "moved by the engine, the failed state is not compensated", failStep2: true, want: []string{"Step1", "Start"}},
+		{name: "reached by a transition", failStep2: false, want: []string{"Step2", "Step1", "Start"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history := []domain.WorkflowAction{
+				{ID: 2, Type: "TRANSITION", Name: "Step1", Text: "From Step1 to Step2"},
+				{ID: 1, Type: "TRANSITION", Name: "Start", Text: "From Start to Step1"},
+			}
+			actionRepo := &MockWorkflowActionRepo{
+				SaveFunc: func(a *domain.WorkflowAction) (int64, error) {
+					a.ID = int64(len(history) + 1)
+					history = append(history, *a)
+					return a.ID, nil
+				},
+				FindAllByWorkflowIDFunc: func(workflowID int64) (*[]domain.WorkflowAction, error) {
+					return &history, nil
+				},
+			}
+			var status string
+			repo := &MockWorkflowRepo{
+				UpdateWorkflowStatusFunc: func(id int64, s string) error {
+					status = s
+					return nil
+				},
+			}
+			wf := &RevertingSagaMockWorkflow{
+				SagaMockWorkflow: SagaMockWorkflow{MockWorkflow: MockWorkflow{WorkflowData: domain.Workflow{ID: 1, State: "Step2"}}},
+				FailStep2:        tt.failStep2,
+			}
+
+			RunWorkflow(context.Background(), wf, repo, actionRepo, 1, "worker1")
+
+			if status != "FINISHED" {
+				t.Errorf("Expected the workflow to complete in its error state, got %q", status)
+			}
+			if !slices.Equal(wf.Compensated, tt.want) {
+				t.Errorf("Expected compensations %v, got %v", tt.want, wf.Compensated)
+			}
+			if tt.failStep2 && !slices.ContainsFunc(history, func(a domain.WorkflowAction) bool {
+				return a.Type == "ERROR_TRANSITION" && a.Name == "Step2"
+			}) {
+				t.Errorf("Expected the move to the error state to be recorded as an ERROR_TRANSITION, got %v", history)
+			}
+		})
+	}
+}
+
+// SignalMockWorkflow needs an approval signal to get past Step1
+type SignalMockWorkflow struct {
+	MockWorkflow
+	Payload string
+}
+
+func (m *SignalMockWorkflow) Step1(ctx context.Context) (models.NextState, error) {
+	signal, ok := m.ConsumeSignal("approved")
+	if !ok {
+		return models.NextState{}, errors.New("not approved yet")
+	}
+	m.Payload = signal.Payload
+	return models.NextState{Name: string(models.StateEnd)}, nil
+}
+
+func TestRunWorkflow_ConsumesSignals(t *testing.T) {
+	var consumed []int64
+	repo := &MockWorkflowRepo{
+		FindPendingSignalsFunc: func(workflowID int64) (*[]domain.WorkflowSignal, error) {
+			return &[]domain.WorkflowSignal{
+				{ID: 6, WorkflowID: workflowID, Name: "comment", Payload: `{"text":"hi"}`},
+				{ID: 7, WorkflowID: workflowID, Name: "approved", Payload: `{"by":"ops"}`},
+			}, nil
+		},
+		MarkSignalsConsumedFunc: func(ids []int64) error {
+			consumed = append(consumed, ids...)
+			return nil
+		},
+	}
+	wf := &SignalMockWorkflow{MockWorkflow: MockWorkflow{WorkflowData: domain.Workflow{ID: 1, State: "Step1"}}}
+
+	RunWorkflow(context.Background(), wf, repo, &MockWorkflowActionRepo{}, 1, "worker1")
+
+	if wf.Payload != `{"by":"ops"}` {
+		t.Errorf("Expected the approval payload, got %q", wf.Payload)
+	}
+	if !slices.Equal(consumed, []int64{7}) {
+		t.Errorf("Expected only the approval to be consumed, got %v", consumed)
+	}
+	if !wf.HasSignal("comment") {
+		t.Error("Expected the comment signal to stay pending")
+	}
+}
+
+// ChildSpawningMockWorkflow spawns a child workflow on its way to the end state
+type ChildSpawningMockWorkflow struct {
+	MockWorkflow
+}
+
+func (m *ChildSpawningMockWorkflow) Step1(ctx context.Context) (models.NextState, error) {
+	return models.NextState{
+		Name:           string(models.StateEnd),
+		ChildWorkflows: []models.ChildWorkflowRequest{{WorkflowType: "MockWorkflow", BusinessKey: "child"}},
+	}, nil
+}
+
+// ChildRoutingMockWorkflow fans a child workflow out to another executor group, starting later in a chosen state
+type ChildRoutingMockWorkflow struct {
+	MockWorkflow
+}
+
+func (m *ChildRoutingMockWorkflow) Step1(ctx context.Context) (models.NextState, error) {
+	return models.NextState{
+		Name: string(models.StateEnd),
+		ChildWorkflows: []models.ChildWorkflowRequest{
+			{WorkflowType: "HeavyWorkflow", BusinessKey: "heavy", InitialState: "Crunch", ExecutorGroup: "heavy", NextActivationOffset: "10 minutes", Priority: 5},
+			{WorkflowType: "MockWorkflow", BusinessKey: "light"},
+		},
+	}, nil
+}
+
+func TestRunWorkflow_RoutesChildWorkflows(t *testing.T) {
+	var saved []domain.Workflow
+	repo := &MockWorkflowRepo{
+		SaveFunc: func(wf *domain.Workflow) (int64, error) {
+			saved = append(saved, *wf)
+			return int64(len(saved) + 1), nil
+		},
+		FindByIDFunc: func(id int64) (*domain.Workflow, error) {
+			return &domain.Workflow{ID: id}, nil
+		},
+	}
+	wf := &ChildRoutingMockWorkflow{MockWorkflow: MockWorkflow{WorkflowData: domain.Workflow{ID: 1, State: "Step1", ExecutorGroup: "light"}}}
+
+	start := time.Now()
+	RunWorkflow(context.Background(), wf, repo, &MockWorkflowActionRepo{}, 1, "worker1")
+
+	if len(saved) != 2 {
+		t.Fatalf("Expected 2 child workflows, got %d", len(saved))
+	}
+	heavy, light := saved[0], saved[1]
+	if heavy.ExecutorGroup != "heavy" || heavy.State != "Crunch" || heavy.Priority != 5 {
+		t.Errorf("Expected the heavy child in group heavy, state Crunch and priority 5, got %s, %s and %d", heavy.ExecutorGroup, heavy.State, heavy.Priority)
+	}
+	if delay := heavy.NextActivation.Time.Sub(start); delay < 10*time.Minute || delay > 11*time.Minute {
+		t.Errorf("Expected the heavy child to start in 10 minutes, got %v", delay)
+	}
+	if light.ExecutorGroup != "light" || light.State != "" || light.NextActivation.Time.After(time.Now()) {
+		t.Errorf("Expected the light child to start now in the group of its parent, got %+v", light)
+	}
+}
+
+func TestRunWorkflow_FailedChildStopsTransition(t *testing.T) {
+	cleared := false
+	var actions []string
+	repo := &MockWorkflowRepo{
+		SaveFunc: func(wf *domain.Workflow) (int64, error) {
+			return 0, errors.New("insert failed")
+		},
+		ClearExecutorIdFunc: func(id int64) error {
+			cleared = true
+			return nil
+		},
+	}
+	actionRepo := &MockWorkflowActionRepo{
+		SaveFunc: func(a *domain.WorkflowAction) (int64, error) {
+			actions = append(actions, a.Type)
+			return 1, nil
+		},
+	}
+	wf := &ChildSpawningMockWorkflow{MockWorkflow: MockWorkflow{WorkflowData: domain.Workflow{ID: 1, State: "Step1"}}}
+
+	RunWorkflow(context.Background(), wf, repo, actionRepo, 1, "worker1")
+
+	if cleared || slices.Contains(actions, "FINISHED") {
+		t.Errorf("Expected the run to stop at the failed transition, got actions %v", actions)
+	}
+	if slices.Contains(actions, "CHILD_CREATED") {
+		t.Errorf("Expected no child to be recorded, got actions %v", actions)
+	}
+}
+
+func TestRunWorkflow_UnknownChildStateStopsTransition(t *testing.T) {
+	tests := []struct {
+		name       string
+		heavy      []models.WorkflowState
+		wantSaved  int
+		wantUpdate bool
+	}{
+		{"state declared", []models.WorkflowState{{Name: "Crunch", StateType: models.StateStart}}, 2, true},
+		{"state not declared", []models.WorkflowState{{Name: "Start", StateType: models.StateStart}}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := 0
+			updated := false
+			repo := &MockWorkflowRepo{
+				SaveFunc: func(wf *domain.Workflow) (int64, error) {
+					saved++
+					return int64(saved + 1), nil
+				},
+				FindByIDFunc: func(id int64) (*domain.Workflow, error) {
+					return &domain.Workflow{ID: id}, nil
+				},
+				UpdateStateFunc: func(id int64, state string) error {
+					updated = updated || state == string(models.StateEnd)
+					return nil
+				},
+			}
+			// the light child is of a type the lookup does not know, it is not checked
+			ctx := withWorkflowStates(context.Background(), func(workflowType string) ([]models.WorkflowState, bool) {
+				return tt.heavy, workflowType == "HeavyWorkflow"
+			})
+			wf := &ChildRoutingMockWorkflow{MockWorkflow: MockWorkflow{WorkflowData: domain.Workflow{ID: 1, State: "Step1"}}}
+
+			RunWorkflow(ctx, wf, repo, &MockWorkflowActionRepo{}, 1, "worker1")
+
+			if saved != tt.wantSaved || updated != tt.wantUpdate {
+				t.Errorf("Expected %d children saved and transition applied %v, got %d and %v", tt.wantSaved, tt.wantUpdate, saved, updated)
+			}
+		})
+	}
+}
+
+func TestJoinPolicy_Resolved(t *testing.T) {
+	tests := []struct {
+		name          string
+		join          models.JoinPolicy
+		statuses      []string
+		wantResolved  bool
+		wantSatisfied bool
+	}{
+		{"all waits for the last child", models.JoinPolicy{}, []string{"FINISHED", "EXECUTING"}, false, true},
+		{"all with a failed child", models.JoinPolicy{Type: models.JoinAll}, []string{"FINISHED", "FAILED"}, true, false},
+		{"any takes the first finished", models.JoinPolicy{Type: models.JoinAny}, []string{"FINISHED", "NEW"}, true, true},
+		{"any keeps waiting past failures", models.JoinPolicy{Type: models.JoinAny}, []string{"ERROR", "NEW"}, false, false},
+		{"any with every child failed", models.JoinPolicy{Type: models.JoinAny}, []string{"ERROR", "CANCELLED"}, true, false},
+		{"n of m reached", models.JoinPolicy{Type: models.JoinNOfM, Count: 2}, []string{"FINISHED", "FINISHED", "IN_PROGRESS"}, true, true},
+		{"n of m waiting", models.JoinPolicy{Type: models.JoinNOfM, Count: 2}, []string{"FINISHED", "FAILED", "IN_PROGRESS"}, false, false},
+		{"n of m out of reach", models.JoinPolicy{Type: models.JoinNOfM, Count: 2}, []string{"FINISHED", "FAILED", "FAILED"}, true, false},
+		{"fail fast on the first failure", models.JoinPolicy{Type: models.JoinFailFast}, []string{"FAILED", "EXECUTING"}, true, false},
+		{"fail fast waits while all is well", models.JoinPolicy{Type: models.JoinFailFast}, []string{"FINISHED", "EXECUTING"}, false, true},
+		{"no children", models.JoinPolicy{}, nil, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var children []domain.Workflow
+			for _, status := range tt.statuses {
+				children = append(children, domain.Workflow{Status: status})
+			}
+			summary := summarizeChildren(context.Background(), children)
+			if resolved := tt.join.Resolved(&summary); resolved != tt.wantResolved || summary.Satisfied != tt.wantSatisfied {
+				t.Errorf("Expected resolved %v and satisfied %v, got %v and %v", tt.wantResolved, tt.wantSatisfied, resolved, summary.Satisfied)
+			}
+		})
+	}
+}
+
+func TestSummarizeChildren_ErrorStateCountsAsFailed(t *testing.T) {
+	ctx := withWorkflowStates(context.Background(), func(workflowType string) ([]models.WorkflowState, bool) {
+		return (&FailingMockWorkflow{}).GetAllStates(), workflowType == "FailingWorkflow"
+	})
+	children := []domain.Workflow{
+		{ID: 2, WorkflowType: "FailingWorkflow", Status: "FINISHED", State: "Failed"},
+		{ID: 3, WorkflowType: "FailingWorkflow", Status: "FINISHED", State: string(models.StateEnd)},
+		{ID: 4, WorkflowType: "FailingWorkflow", Status: "EXECUTING", State: "Step1"},
+	}
+
+	summary := summarizeChildren(ctx, children)
+
+	if summary.Finished != 1 || summary.Failed != 1 || summary.Running != 1 {
+		t.Errorf("Expected 1 finished, 1 failed and 1 running, got %+v", summary)
+	}
+	if join := (models.JoinPolicy{Type: models.JoinFailFast}); !join.Resolved(&summary) || summary.Satisfied {
+		t.Errorf("Expected a fail fast join to trip on the child in its error state, got %+v", summary)
+	}
+}
+
+func TestRunWorkflow_JoinWaitsForChildren(t *testing.T) {
+	children := []domain.Workflow{{ID: 2, Status: "EXECUTING"}, {ID: 3, Status: "FINISHED"}}
+	var parkedUntil time.Time
+	state := "Step1"
+	repo := &MockWorkflowRepo{
+		GetChildrenByParentIDFunc: func(parentID int64, onlyActive bool) (*[]domain.Workflow, error) {
+			return &children, nil
+		},
+		UpdateNextActivationSpecificFunc: func(id int64, next time.Time) error {
+			parkedUntil = next
+			return nil
+		},
+		UpdateStateFunc: func(id int64, s string) error {
+			state = s
+			return nil
+		},
+	}
+	newParent := func() *GraphMockWorkflow {
+		return &GraphMockWorkflow{
+			MockWorkflow: MockWorkflow{WorkflowData: domain.Workflow{ID: 1, State: "Step1"}},
+			States: []models.WorkflowState{
+				{Name: "Start", StateType: models.StateStart},
+				{Name: "Step1", StateType: models.StateNormal, Join: &models.JoinPolicy{Type: models.JoinAll, RecheckInterval: time.Hour}},
+				{Name: "End", StateType: models.StateEnd},
+			},
+			Transitions: map[string][]string{"Start": {"Step1"}, "Step1": {"End"}},
+		}
+	}
+
+	RunWorkflow(context.Background(), newParent(), repo, &MockWorkflowActionRepo{}, 1, "worker1")
+	if state != "Step1" || time.Until(parkedUntil) < 59*time.Minute {
+		t.Fatalf("Expected the workflow parked in Step1 for the recheck interval, got state %s until %v", state, parkedUntil)
+	}
+
+	children[0].Status = "FAILED"
+	parent := newParent()
+	RunWorkflow(context.Background(), parent, repo, &MockWorkflowActionRepo{}, 1, "worker1")
+	if state != "End" {
+		t.Fatalf("Expected the workflow to run Step1 once the children ended, got state %s", state)
+	}
+	if s := parent.ChildSummary; s.Total != 2 || s.Finished != 1 || s.Failed != 1 || s.Satisfied {
+		t.Errorf("Expected a summary of 1 finished and 1 failed child, got %+v", s)
+	}
+}
+
+func TestRunWorkflow_EndedChildWakesParent(t *testing.T) {
+	woken := int64(0)
+	repo := &MockWorkflowRepo{
+		WakeParentWorkflowFunc: func(parentID int64) error {
+			woken = parentID
+			return nil
+		},
+	}
+	wf := &MockWorkflow{WorkflowData: domain.Workflow{ID: 2, State: "Step1", ParentWorkflowID: sql.NullInt64{Int64: 1, Valid: true}}}
+
+	RunWorkflow(context.Background(), wf, repo, &MockWorkflowActionRepo{}, 1, "worker1")
+
+	if woken != 1 {
+		t.Errorf("Expected the finished child to wake parent 1, got %d", woken)
+	}
+}
+
+// ResultMockWorkflow keeps its state variables between states and hands its answer to its parent.
+type ResultMockWorkflow struct {
+	GraphMockWorkflow
+	Vars map[string]string
+}
+
+func (m *ResultMockWorkflow) GetStateVariables() map[string]string { return m.Vars }
+func (m *ResultMockWorkflow) ResultVariables() []string            { return []string{"answer"} }
+
+func TestRunWorkflow_FinishedChildHandsOverResult(t *testing.T) {
+	var parentID int64
+	var key, value string
+	woken := false
+	repo := &MockWorkflowRepo{
+		SetWorkflowVariableFunc: func(id int64, k string, v string) error {
+			parentID, key, value = id, k, v
+			return nil
+		},
+		WakeParentWorkflowFunc: func(id int64) error {
+			woken = true
+			return nil
+		},
+	}
+	wf := &ResultMockWorkflow{
+		GraphMockWorkflow: GraphMockWorkflow{MockWorkflow: MockWorkflow{WorkflowData: domain.Workflow{
+			ID: 2, ExternalID: "child-2", State: "Step1", ParentWorkflowID: sql.NullInt64{Int64: 1, Valid: true}}}},
+		Vars: map[string]string{"answer": "42", "scratch": "x"},
+	}
+	wf.States = (&MockWorkflow{}).GetAllStates()
+	wf.Transitions = (&MockWorkflow{}).StateTransitions()
+
+	RunWorkflow(context.Background(), wf, repo, &MockWorkflowActionRepo{}, 1, "worker1")
+
+	if parentID != 1 || key != models.ChildResultKey("child-2") || value != `{"answer":"42"}` {
+		t.Errorf("Expected the answer handed to parent 1, got %q=%q on %d", key, value, parentID)
+	}
+	if !woken {
+		t.Error("Expected the parent to be woken")
+	}
+}
+
+func TestRunWorkflow_ChildCancelledWhileCompletingHandsOverNothing(t *testing.T) {
+	handedOver := false
+	finished := false
+	var signal *domain.WorkflowSignal
+	var actions []string
+	repo := &MockWorkflowRepo{
+		UpdateWorkflowStatusFunc: func(id int64, status string) error {
+			finished = status == "FINISHED"
+			return nil
+		},
+		// the operator cancelled the child while it completed, the status update kept CANCELLED
+		FindByIDFunc: func(id int64) (*domain.Workflow, error) {
+			if finished {
+				return &domain.Workflow{ID: id, Status: "CANCELLED"}, nil
+			}
+			return &domain.Workflow{ID: id, Status: "EXECUTING"}, nil
+		},
+		SetWorkflowVariableFunc: func(id int64, k string, v string) error {
+			handedOver = true
+			return nil
+		},
+		SaveSignalFunc: func(sig *domain.WorkflowSignal) (int64, error) {
+			signal = sig
+			return 1, nil
+		},
+	}
+	actionRepo := &MockWorkflowActionRepo{
+		SaveFunc: func(a *domain.WorkflowAction) (int64, error) {
+			actions = append(actions, a.Type)
+			return 1, nil
+		},
+	}
+	wf := &ResultMockWorkflow{
+		GraphMockWorkflow: GraphMockWorkflow{MockWorkflow: MockWorkflow{WorkflowData: domain.Workflow{
+			ID: 2, ExternalID: "child-2", State: "Step1", ParentWorkflowID: sql.NullInt64{Int64: 1, Valid: true},
+			FailurePolicy: string(models.ChildFailureNotify)}}},
+		Vars: map[string]string{"answer": "42"},
+	}
+	wf.States = (&MockWorkflow{}).GetAllStates()
+	wf.Transitions = (&MockWorkflow{}).StateTransitions()
+
+	RunWorkflow(context.Background(), wf, repo, actionRepo, 1, "worker1")
+
+	if handedOver {
+		t.Error("Expected no result handed over by a child cancelled while completing")
+	}
+	if slices.Contains(actions, "END") || !slices.Contains(actions, "CANCELLED") {
+		t.Errorf("Expected the child recorded as cancelled rather than complete, got %v", actions)
+	}
+	var failure models.ChildFailure
+	if signal == nil || json.Unmarshal([]byte(signal.Payload), &failure) != nil || failure.Status != "CANCELLED" {
+		t.Errorf("Expected the parent told the child was cancelled, got %+v", signal)
+	}
+}
+
+func TestRunWorkflow_FinishedChildLocksParentFirst(t *testing.T) {
+	var calls []string
+	repo := &MockWorkflowRepo{
+		FindWorkflowVariablesForUpdateFunc: func(id int64) (sql.NullString, error) {
+			calls = append(calls, fmt.Sprintf("lock %d", id))
+			return sql.NullString{}, nil
+		},
+		UpdateWorkflowStatusFunc: func(id int64, status string) error {
+			calls = append(calls, fmt.Sprintf("%s %d", status, id))
+			return nil
+		},
+	}
+	wf := &MockWorkflow{WorkflowData: domain.Workflow{ID: 2, State: "Step1", ParentWorkflowID: sql.NullInt64{Int64: 1, Valid: true}}}
+
+	RunWorkflow(context.Background(), wf, repo, &MockWorkflowActionRepo{}, 1, "worker1")
+
+	finished := slices.Index(calls, "FINISHED 2")
+	if finished < 1 || calls[finished-1] != "lock 1" {
+		t.Errorf("Expected the parent locked right before the child finishes, got %v", calls)
+	}
+}
+
+func TestRunWorkflow_JoinReceivesChildResults(t *testing.T) {
+	result := `{"answer":"42"}`
+	stored, _ := json.Marshal(map[string]string{models.ChildResultKey("child-2"): result})
+	var saved string
+	repo := &MockWorkflowRepo{
+		GetChildrenByParentIDFunc: func(parentID int64, onlyActive bool) (*[]domain.Workflow, error) {
+			return &[]domain.Workflow{{ID: 2, ExternalID: "child-2", Status: "FINISHED"}}, nil
+		},
+		FindWorkflowVariablesForUpdateFunc: func(id int64) (sql.NullString, error) {
+			return sql.NullString{String: string(stored), Valid: true}, nil
+		},
+		SaveWorkflowVariablesFunc: func(id int64, vars string) error {
+			saved = vars
+			return nil
+		},
+	}
+	// the child handed its result over after the parent was loaded
+	parent := &ResultMockWorkflow{
+		GraphMockWorkflow: GraphMockWorkflow{
+			MockWorkflow: MockWorkflow{WorkflowData: domain.Workflow{ID: 1, State: "Step1", StateVars: sql.NullString{String: "{}", Valid: true}}},
+			States: []models.WorkflowState{
+				{Name: "Start", StateType: models.StateStart},
+				{Name: "Step1", StateType: models.StateNormal, Join: &models.JoinPolicy{}},
+				{Name: "End", StateType: models.StateEnd},
+			},
+			Transitions: map[string][]string{"Start": {"Step1"}, "Step1": {"End"}},
+		},
+		Vars: map[string]string{},
+	}
+
+	RunWorkflow(context.Background(), parent, repo, &MockWorkflowActionRepo{}, 1, "worker1")
+
+	if parent.Vars[models.ChildResultKey("child-2")] != result {
+		t.Errorf("Expected the child result in the state variables, got %v", parent.Vars)
+	}
+	if !strings.Contains(saved, "child_result.child-2") {
+		t.Errorf("Expected the child result kept when the state variables were saved, got %q", saved)
+	}
+}
+
+func TestRunWorkflow_SaveKeepsChildResultsWithoutJoin(t *testing.T) {
+	result := `{"answer":"42"}`
+	stored, _ := json.Marshal(map[string]string{models.ChildResultKey("child-2"): result})
+	var saved string
+	repo := &MockWorkflowRepo{
+		FindWorkflowVariablesForUpdateFunc: func(id int64) (sql.NullString, error) {
+			return sql.NullString{String: string(stored), Valid: true}, nil
+		},
+		SaveWorkflowVariablesFunc: func(id int64, vars string) error {
+			saved = vars
+			return nil
+		},
+	}
+	// a parent polling its children, the child handed its result over after the parent was loaded
+	parent := &ResultMockWorkflow{
+		GraphMockWorkflow: GraphMockWorkflow{MockWorkflow: MockWorkflow{WorkflowData: domain.Workflow{ID: 1, State: "Step1", StateVars: sql.NullString{String: "{}", Valid: true}}}},
+		Vars:              map[string]string{"polled": "yes"},
+	}
+	parent.States = (&MockWorkflow{}).GetAllStates()
+	parent.Transitions = (&MockWorkflow{}).StateTransitions()
+
+	RunWorkflow(context.Background(), parent, repo, &MockWorkflowActionRepo{}, 1, "worker1")
+
+	if !strings.Contains(saved, "child_result.child-2") || !strings.Contains(saved, "polled") {
+		t.Errorf("Expected the child result kept next to the parent's own variables, got %q", saved)
+	}
+}
+
+func TestRunWorkflow_ParentEndClosesChildren(t *testing.T) {
+	children := []domain.Workflow{
+		{ID: 2, Status: "EXECUTING", ParentClosePolicy: string(models.ParentCloseCancel)},
+		{ID: 3, Status: "IN_PROGRESS", ParentClosePolicy: string(models.ParentCloseCancelOnFailure)},
+		{ID: 4, Status: "IN_PROGRESS", ParentClosePolicy: string(models.ParentCloseAbandon)},
+		{ID: 5, Status: "NEW"},
+	}
+	tests := []struct {
+		name          string
+		fail          bool
+		wantCancelled []int64
+	}{
+		{"parent finished", false, []int64{2}},
+		{"parent failed", true, []int64{2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cancelled []int64
+			repo := &MockWorkflowRepo{
+				GetChildrenByParentIDFunc: func(parentID int64, onlyActive bool) (*[]domain.Workflow, error) {
+					return &children, nil
+				},
+				CancelWorkflowFunc: func(id int64) (bool, error) {
+					cancelled = append(cancelled, id)
+					return true, nil
+				},
+			}
+			// past its retries a failing state fails the workflow
+			wf := &MockWorkflow{WorkflowData: domain.Workflow{ID: 1, State: "Step1", RetryCount: 10}, ShouldError: tt.fail}
+
+			RunWorkflow(context.Background(), wf, repo, &MockWorkflowActionRepo{}, 1, "worker1")
+
+			if !slices.Equal(cancelled, tt.wantCancelled) {
+				t.Errorf("Expected children %v cancelled, got %v", tt.wantCancelled, cancelled)
+			}
+		})
+	}
+}
+
+func TestRunWorkflow_FailedChildSignalsParent(t *testing.T) {
+	for _, policy := range []models.ChildFailurePolicy{"", models.ChildFailureIgnore, models.ChildFailureNotify, models.ChildFailureFailParent} {
+		t.Run(string(policy), func(t *testing.T) {
+			var signal *domain.WorkflowSignal
+			repo := &MockWorkflowRepo{
+				SaveSignalFunc: func(sig *domain.WorkflowSignal) (int64, error) {
+					signal = sig
+					return 1, nil
+				},
+			}
+			wf := &MockWorkflow{WorkflowData: domain.Workflow{ID: 2, ExternalID: "child-2", State: "Step1", RetryCount: 10,
+				ParentWorkflowID: sql.NullInt64{Int64: 1, Valid: true}, FailurePolicy: string(policy)}, ShouldError: true}
+
+			RunWorkflow(context.Background(), wf, repo, &MockWorkflowActionRepo{}, 1, "worker1")
+
+			if policy != models.ChildFailureNotify && policy != models.ChildFailureFailParent {
+				if signal != nil {
+					t.Errorf("Expected no signal for policy %q, got %+v", policy, signal)
+				}
+				return
+			}
+			if signal == nil || signal.WorkflowID != 1 || signal.Name != models.ChildFailedSignal {
+				t.Fatalf("Expected a %s signal to the parent, got %+v", models.ChildFailedSignal, signal)
+			}
+			var failure models.ChildFailure
+			if err := json.Unmarshal([]byte(signal.Payload), &failure); err != nil || failure != (models.ChildFailure{WorkflowID: 2, ExternalID: "child-2", Status: "FAILED", Policy: policy}) {
+				t.Errorf("Unexpected signal payload %s (%v)", signal.Payload, err)
+			}
+		})
+	}
+}
+
+// FailingResultMockWorkflow is a FailingMockWorkflow that would hand its state variables to its parent
+type FailingResultMockWorkflow struct {
+	FailingMockWorkflow
+}
+
+func (m *FailingResultMockWorkflow) ResultVariables() []string { return nil }
+
+func TestRunWorkflow_ChildEndingInErrorStateFailsRelatives(t *testing.T) {
+	var signal *domain.WorkflowSignal
+	var status string
+	handedOver := false
+	var cancelled []int64
+	repo := &MockWorkflowRepo{
+		SaveSignalFunc: func(sig *domain.WorkflowSignal) (int64, error) {
+			signal = sig
+			return 1, nil
+		},
+		UpdateWorkflowStatusFunc: func(id int64, s string) error {
+			status = s
+			return nil
+		},
+		SetWorkflowVariableFunc: func(id int64, key string, value string) error {
+			handedOver = true
+			return nil
+		},
+		GetChildrenByParentIDFunc: func(parentID int64, onlyActive bool) (*[]domain.Workflow, error) {
+			return &[]domain.Workflow{{ID: 3, Status: "EXECUTING", ParentClosePolicy: string(models.ParentCloseCancelOnFailure)}}, nil
+		},
+		CancelWorkflowFunc: func(id int64) (bool, error) {
+			cancelled = append(cancelled, id)
+			return true, nil
+		},
+	}
+	wf := &FailingResultMockWorkflow{FailingMockWorkflow{ErrorMockWorkflow: ErrorMockWorkflow{
+		MockWorkflow: MockWorkflow{BaseWorkflow: core.BaseWorkflow{StateVariables: map[string]string{}}, WorkflowData: domain.Workflow{
+			ID: 2, ExternalID: "child-2", State: "Step1", RetryCount: 4,
+			ParentWorkflowID: sql.NullInt64{Int64: 1, Valid: true}, FailurePolicy: string(models.ChildFailureFailParent)}},
+		Err: errors.New("service unavailable"),
+	}}}
+
+	RunWorkflow(context.Background(), wf, repo, &MockWorkflowActionRepo{}, 1, "worker1")
+
+	if status != "FINISHED" {
+		t.Errorf("Expected the child to complete in its error state, got %q", status)
+	}
+	if signal == nil || signal.WorkflowID != 1 || signal.Name != models.ChildFailedSignal {
+		t.Fatalf("Expected a %s signal to the parent, got %+v", models.ChildFailedSignal, signal)
+	}
+	var failure models.ChildFailure
+	if err := json.Unmarshal([]byte(signal.Payload), &failure); err != nil || failure.Status != "FAILED" || failure.Policy != models.ChildFailureFailParent {
+		t.Errorf("Expected the parent told the child failed, got %s (%v)", signal.Payload, err)
+	}
+	if handedOver {
+		t.Error("Expected no result handed over by a child that ended in its error state")
+	}
+	if !slices.Equal(cancelled, []int64{3}) {
+		t.Errorf("Expected the CANCEL_ON_FAILURE grandchild cancelled, got %v", cancelled)
+	}
+}
+
+func TestRunWorkflow_FailedChildFailsParent(t *testing.T) {
+	payload, _ := json.Marshal(models.ChildFailure{WorkflowID: 2, Status: "FAILED", Policy: models.ChildFailureFailParent})
+	status := ""
+	var consumed []int64
+	stateUpdated := false
+	repo := &MockWorkflowRepo{
+		FindPendingSignalsFunc: func(workflowID int64) (*[]domain.WorkflowSignal, error) {
+			return &[]domain.WorkflowSignal{
+				{ID: 7, WorkflowID: 1, Name: "approval"},
+				{ID: 8, WorkflowID: 1, Name: models.ChildFailedSignal, Payload: string(payload)},
+			}, nil
+		},
+		MarkSignalsConsumedFunc: func(ids []int64) error {
+			consumed = append(consumed, ids...)
+			return nil
+		},
+		UpdateWorkflowStatusFunc: func(id int64, s string) error {
+			status = s
+			return nil
+		},
+		UpdateStateFunc: func(id int64, s string) error {
+			stateUpdated = true
+			return nil
+		},
+	}
+	wf := &MockWorkflow{WorkflowData: domain.Workflow{ID: 1, State: "Step1"}}
+
+	RunWorkflow(context.Background(), wf, repo, &MockWorkflowActionRepo{}, 1, "worker1")
+
+	if status != "FAILED" || stateUpdated {
+		t.Errorf("Expected the workflow failed without running Step1, got status %q", status)
+	}
+	if !slices.Equal(consumed, []int64{8}) {
+		t.Errorf("Expected the child failure signal consumed, got %v", consumed)
+	}
+}
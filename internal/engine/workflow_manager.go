@@ -2,11 +2,12 @@ package engine
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
-	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/RealZimboGuy/gopherflow/internal/config"
@@ -16,17 +17,21 @@ import (
 	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/models"
 )
 
-var workflowQueue chan core.Workflow // Initialized in StartEngine using system setting
-
 type WorkflowManager struct {
 	WorkflowRegistry   *map[string]func() core.Workflow
 	WorkflowRepo       WorkflowRepo
 	WorkflowActionRepo WorkflowActionRepo
 	executorRepo       ExecutorRepo
 	DefinitionRepo     DefinitionRepo
+	scheduleRepo       ScheduleRepo
 	executorID         int64
 	wakeup             chan struct{}
 	clock              core.Clock
+	running            *runningWorkflows
+	changes            *workflowChanges
+	queue              chan core.Workflow
+	groups             *groupShares
+	databaseURL        string
 }
 
 // ListWorkflowDefinitions exposes repository list for web/API layers.
@@ -64,50 +69,86 @@ func (wm *WorkflowManager) Overview() ([]repository.WorkflowOverviewRow, error)
 	return wm.WorkflowRepo.GetWorkflowOverview()
 }
 
+// RunningWorkflows exposes how many workflows of a type are held by executors, the usage its max concurrency limits
+func (wm *WorkflowManager) RunningWorkflows(workflowType string) (int, error) {
+	return wm.WorkflowRepo.CountRunningWorkflows(workflowType)
+}
+
 // DefinitionOverview exposes counts by state for a workflow type
 func (wm *WorkflowManager) DefinitionOverview(workflowType string) ([]repository.DefinitionStateRow, error) {
 	return wm.WorkflowRepo.GetDefinitionStateOverview(workflowType)
 }
 
 func NewWorkflowManager(workflowRepo WorkflowRepo, workflowActionRepo WorkflowActionRepo, executorRepo ExecutorRepo,
-	definitionRepo DefinitionRepo, WorkflowRegistry *map[string]func() core.Workflow, clock core.Clock) *WorkflowManager {
+	definitionRepo DefinitionRepo, scheduleRepo ScheduleRepo, WorkflowRegistry *map[string]func() core.Workflow, clock core.Clock) *WorkflowManager {
+	// the queue holds one batch of workflows taken for the workers, ENGINE_BATCH_SIZE
+	queueSize := config.GetSystemSettingInteger(config.ENGINE_BATCH_SIZE)
+	if queueSize <= 0 {
+		queueSize = 10 // fallback default
+	}
 	return &WorkflowManager{
 		WorkflowRegistry:   WorkflowRegistry,
 		WorkflowRepo:       workflowRepo,
 		WorkflowActionRepo: workflowActionRepo,
 		executorRepo:       executorRepo,
 		DefinitionRepo:     definitionRepo,
+		scheduleRepo:       scheduleRepo,
 		wakeup:             make(chan struct{}, 1),
 		clock:              clock,
+		running:            newRunningWorkflows(),
+		changes:            newWorkflowChanges(),
+		queue:              make(chan core.Workflow, queueSize),
+		groups:             newGroupShares(ExecutorGroups()),
+		databaseURL:        config.GetSystemSettingString(config.DATABASE_URL),
 	}
 }
 
-// StartEngine starts polling for new workflows at the given interval
+// SetExecutorGroups replaces the groups read from ENGINE_EXECUTOR_GROUP, in the same format. It has to be called
+// before StartEngine.
+func (wm *WorkflowManager) SetExecutorGroups(setting string) {
+	wm.groups = newGroupShares(ParseExecutorGroups(setting))
+}
+
+// SetDatabaseURL replaces DATABASE_URL as the database the engine listens on for workflow notifications. It has to
+// be called before StartEngine.
+func (wm *WorkflowManager) SetDatabaseURL(url string) {
+	wm.databaseURL = url
+}
+
+// StartEngine starts polling for new workflows at the given interval. Once ctx is cancelled it stops polling,
+// drains the workers and only returns when the executor has handed its workflows back.
 func (wm *WorkflowManager) StartEngine(ctx context.Context, pollInterval time.Duration) {
 	ticker := time.NewTicker(pollInterval)
 	defer ticker.Stop()
 
+	// the heartbeat and lease renewal keep going while the workers drain
+	lifeCtx, stop := context.WithCancel(context.WithoutCancel(ctx))
+	defer stop()
+
 	// Register this executor instance
-	registerExecutorInstance(ctx, wm)
+	registerExecutorInstance(lifeCtx, wm)
 
 	registerWorkflowDefinitions(ctx, wm)
 
 	go startWorkflowRepairService(ctx, wm)
-
-	// Initialize workflow queue size from system setting ENGINE_BATCH_SIZE
-	queueSize := config.GetSystemSettingInteger(config.ENGINE_BATCH_SIZE)
-	if queueSize <= 0 {
-		queueSize = 10 // fallback default
-	}
-	workflowQueue = make(chan core.Workflow, queueSize)
+	go startLeaseService(lifeCtx, wm)
+	go startCancellationService(ctx, wm, pollInterval)
+	go startScheduleService(ctx, wm, pollInterval)
+	go startNotificationService(ctx, wm)
 
 	// log starting and number of workers
-	slog.Info("Starting workflow engine", "workers", config.GetSystemSettingInteger(config.ENGINE_EXECUTOR_SIZE), "queue_size", queueSize)
+	slog.Info("Starting workflow engine", "workers", config.GetSystemSettingInteger(config.ENGINE_EXECUTOR_SIZE), "queue_size", cap(wm.queue))
+	var workers sync.WaitGroup
+	// child workflows are checked against the states of their type and pinned to its version when they are created
+	runCtx := withWorkflowVersions(withWorkflowStates(ctx, wm.workflowStates), wm.currentWorkflowVersion)
 	for i := 0; i < config.GetSystemSettingInteger(config.ENGINE_EXECUTOR_SIZE); i++ {
 		//create a new context for each worker
-		workerContext, _ := context.WithCancel(ctx)
-		workerContext = context.WithValue(ctx, "worker_id", i)
-		go Worker(workerContext, i, wm.executorID, wm.WorkflowRepo, wm.WorkflowActionRepo, workflowQueue)
+		workerContext := context.WithValue(runCtx, "worker_id", i)
+		workers.Add(1)
+		go func(i int) {
+			defer workers.Done()
+			Worker(workerContext, i, wm.executorID, wm.WorkflowRepo, wm.WorkflowActionRepo, wm.queue, wm.running)
+		}(i)
 	}
 
 	slog.Info("Workflow engine started", "poll_interval", pollInterval.String())
@@ -116,6 +157,7 @@ func (wm *WorkflowManager) StartEngine(ctx context.Context, pollInterval time.Du
 		select {
 		case <-ctx.Done():
 			slog.InfoContext(ctx, "Workflow engine stopping due to context cancel")
+			wm.shutdown(lifeCtx, &workers)
 			return
 		case <-ticker.C:
 			wm.pollAndRunWorkflows(ctx)
@@ -127,7 +169,7 @@ func (wm *WorkflowManager) StartEngine(ctx context.Context, pollInterval time.Du
 }
 
 // responsible for finding workflows that might have crashed half way and waking them up again
-// these workflows will be in a state of SCHEDULED or EXECUTING and the executor will be last active more than 5 minutes ago
+// these workflows will be in a state of SCHEDULED or EXECUTING and the lease of their executor will have expired
 func startWorkflowRepairService(ctx context.Context, wm *WorkflowManager) {
 	dur, _ := time.ParseDuration(config.GetSystemSettingString(config.ENGINE_STUCK_WORKFLOWS_INTERVAL))
 	ticker := time.NewTicker(dur)
@@ -139,20 +181,23 @@ func startWorkflowRepairService(ctx context.Context, wm *WorkflowManager) {
 			slog.InfoContext(ctx, "Workflow repair service stopping due to context cancel")
 			return
 		case <-ticker.C:
-			// Find stuck workflows and attempt to wake them up
-			stuckWorkflows, err := wm.WorkflowRepo.FindStuckWorkflows(
-				config.GetSystemSettingString(config.ENGINE_STUCK_WORKFLOWS_REPAIR_AFTER_MINUTES), config.GetSystemSettingString(config.ENGINE_EXECUTOR_GROUP),
-				100)
-			if err != nil {
-				slog.Error("Error finding stuck workflows", "error", err)
-				continue
+			// Find stuck workflows of every group and attempt to wake them up
+			var stuckWorkflows []domain.Workflow
+			for _, group := range groupNames(wm.groups.groups) {
+				stuck, err := wm.WorkflowRepo.FindStuckWorkflows(
+					config.GetSystemSettingString(config.ENGINE_STUCK_WORKFLOWS_REPAIR_AFTER_MINUTES), group,
+					100)
+				if err != nil {
+					slog.Error("Error finding stuck workflows", "error", err, "executor_group", group)
+					continue
+				}
+				stuckWorkflows = append(stuckWorkflows, *stuck...)
 			}
-			for _, wf := range *stuckWorkflows {
+			for _, wf := range stuckWorkflows {
 				slog.Warn("Repairing stuck workflow", "workflow_id", wf.ID, "business_key", wf.BusinessKey, "Current State", wf.State, "Status", wf.Status)
-				// Mark as scheduled and add to queue
+				// release the workflow and make it due now, in one update guarded by the expired lease
 				previousExecutorId := wf.ExecutorID
-				exclusiveLock := wm.WorkflowRepo.LockWorkflowByModified(wf.ID, wf.Modified)
-				if exclusiveLock {
+				if wm.WorkflowRepo.ReclaimExpiredLease(wf.ID, wf.Modified) {
 					_, _ = wm.WorkflowActionRepo.Save(&domain.WorkflowAction{
 						WorkflowID:     wf.ID,
 						ExecutorID:     wm.executorID,
@@ -162,16 +207,6 @@ func startWorkflowRepairService(ctx context.Context, wm *WorkflowManager) {
 						Text:           "Repaired and scheduled, previous executor was: " + fmt.Sprint(previousExecutorId.String),
 						DateTime:       time.Now(),
 					})
-					//set the workflow to next execute now
-					err := wm.WorkflowRepo.UpdateNextActivationSpecific(wf.ID, time.Now())
-					if err != nil {
-						slog.ErrorContext(ctx, "Failed to repair update workflow next activation", "workflow_id", wf.ID, "error", err)
-					}
-					err = wm.WorkflowRepo.ClearExecutorId(wf.ID)
-					if err != nil {
-						slog.ErrorContext(ctx, "Failed to repair clear executor id", "workflow_id", wf.ID, "error", err)
-					}
-
 				}
 			}
 		}
@@ -181,57 +216,48 @@ func startWorkflowRepairService(ctx context.Context, wm *WorkflowManager) {
 
 func registerWorkflowDefinitions(ctx context.Context, wm *WorkflowManager) {
 
-	for name := range *wm.WorkflowRegistry {
+	for key, factory := range *wm.WorkflowRegistry {
+		name, keyVersion := splitVersionKey(key)
+		hasErrors := false
+		for _, issue := range validateRegistryEntry(key, factory) {
+			if issue.Severity == core.IssueError {
+				hasErrors = true
+				slog.ErrorContext(ctx, "Invalid workflow definition", "name", name, "version", issue.Version, "state", issue.State, "issue", issue.Message)
+			} else {
+				slog.WarnContext(ctx, "Workflow definition warning", "name", name, "version", issue.Version, "state", issue.State, "issue", issue.Message)
+			}
+		}
+		if hasErrors {
+			// Setup refuses to start with these, an engine started directly does not register them
+			continue
+		}
+		instance := factory()
+		version := WorkflowVersion(instance)
+		flow := flowChartOf(instance)
+		saveDefinitionVersion(ctx, wm, name, instance, flow)
+		if keyVersion != 0 {
+			// older versions only keep their in-flight instances running
+			continue
+		}
+
 		def, err := wm.DefinitionRepo.FindByName(name)
 		if err != nil {
 			// If not found, we'll create it; for other errors, log and continue
 			slog.WarnContext(ctx, "Workflow definition lookup error, will attempt create", "name", name, "error", err)
 			def = nil
 		}
-
-		flow := buildFlowChart(wm, name)
-		instance, _ := CreateWorkflowInstance(wm, name)
 		desc := fmt.Sprintf("%s", instance.Description())
 
-		for _, state := range instance.GetAllStates() {
-			if state.StateType == models.StateNormal ||
-				state.StateType == models.StateStart {
-				//need to ensure that this function on the workflow correctly only has the ctx as a parameter
-				typ := reflect.TypeOf(instance)
-				m, ok := typ.MethodByName(state.Name)
-				if !ok {
-					panic(fmt.Sprintf("method %s not found in workflow definition %s", state.Name, name))
-				}
-
-				// Method signatures in Go always include the receiver as the first param.
-				// So to enforce: func (w *Workflow) Foo(ctx context.Context)
-				// method.Type.NumIn() must be 2 (receiver + ctx)
-				if m.Type.NumIn() != 2 {
-					panic(fmt.Sprintf(
-						"Workflow:%s method:%s must have exactly one parameter: context.Context (found %d parameters)",
-						name, state.Name, m.Type.NumIn()-1,
-					))
-				}
-
-				ctxType := reflect.TypeOf((*context.Context)(nil)).Elem()
-				if m.Type.In(1) != ctxType {
-					panic(fmt.Sprintf(
-						"method %s must take context.Context as its only parameter",
-						state.Name,
-					))
-				}
-			}
-
-		}
-
 		if def == nil {
 			// Create new definition
 			def = &domain.WorkflowDefinition{
-				Name:        name,
-				Description: desc,
-				Created:     time.Now(),
-				Updated:     time.Now(),
-				FlowChart:   flow,
+				Name:           name,
+				Description:    desc,
+				Created:        time.Now(),
+				Updated:        time.Now(),
+				FlowChart:      flow,
+				Version:        version,
+				MaxConcurrency: MaxConcurrency(instance),
 			}
 			slog.InfoContext(ctx, "Saving workflow definition", "name", name)
 			if err := wm.DefinitionRepo.Save(def); err != nil {
@@ -245,13 +271,17 @@ func registerWorkflowDefinitions(ctx context.Context, wm *WorkflowManager) {
 		def.Description = desc
 		def.Updated = time.Now()
 		def.FlowChart = flow
+		def.Version = version
+		def.MaxConcurrency = MaxConcurrency(instance)
 		if err := wm.DefinitionRepo.Save(def); err != nil {
 			slog.Error("Failed to update workflow definition", "name", name, "error", err)
 		}
 	}
 
 }
-func buildFlowChart(wm *WorkflowManager, name string) string {
+
+// flowChartOf renders the mermaid flow chart of a workflow implementation.
+func flowChartOf(wf core.Workflow) string {
 	var sb strings.Builder
 
 	// Modern class styles
@@ -261,15 +291,6 @@ func buildFlowChart(wm *WorkflowManager, name string) string {
 	manualClass := "fill:#FFD93D,stroke:#E6C200,stroke-width:2px,color:#333,stroke-dasharray: 4 2,rx:10,ry:10;"
 	normalClass := "fill:#F0F4F8,stroke:#B0C4DE,stroke-width:1px,color:#333,rx:10,ry:10;"
 
-	inst, err := createWorkflow(wm, name)
-	if err != nil {
-		return fmt.Sprintf("flowchart TD\n    %s[Uninitialized]\n", name)
-	}
-	wf, ok := inst.(core.Workflow)
-	if !ok {
-		return fmt.Sprintf("flowchart TD\n    %s[Invalid Workflow]\n", name)
-	}
-
 	states := wf.GetAllStates()
 	transitions := wf.StateTransitions()
 	//start := wf.InitialState()
@@ -283,6 +304,16 @@ func buildFlowChart(wm *WorkflowManager, name string) string {
 		}
 	}
 
+	// failure routes are drawn dashed, they are taken once the retries of a state are exhausted
+	for _, st := range states {
+		if st.StateType != models.StateStart && st.StateType != models.StateNormal {
+			continue
+		}
+		if to := failureStateFor(wf, st.Name); to != "" {
+			sb.WriteString(fmt.Sprintf("    %s -.->|failure| %s\n", st.Name, to))
+		}
+	}
+
 	// classDefs
 	sb.WriteString(fmt.Sprintf("    classDef errorClass %s\n", errorClass))
 	sb.WriteString(fmt.Sprintf("    classDef doneClass %s\n", doneClass))
@@ -319,17 +350,23 @@ func registerExecutorInstance(ctx context.Context, wm *WorkflowManager) {
 			name = hostname
 		}
 	}
-	exec := &domain.Executor{Name: name, Started: time.Now(), LastActive: time.Now()}
+	exec := &domain.Executor{Name: name, Groups: formatExecutorGroups(wm.groups.groups), Started: time.Now(), LastActive: time.Now()}
 	id, err := wm.executorRepo.Save(exec)
 	if err != nil {
 		slog.Error("Failed to register executor", "error", err)
 	} else {
 		wm.executorID = id
 		slog.Info("Registered executor", "executor_id", id, "name", name)
-		// Start heartbeat ticker to update last_active every 30s
+		// Start heartbeat ticker to update last_active every 30s, until the engine has shut down
 		hb := time.NewTicker(30 * time.Second)
 		go func(executorID int64) {
-			for range hb.C {
+			defer hb.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-hb.C:
+				}
 				if err := wm.executorRepo.UpdateLastActive(executorID, time.Now()); err != nil {
 					slog.Error("Failed to update executor last_active", "executor_id", executorID, "error", err)
 				} else {
@@ -345,45 +382,112 @@ func (wm *WorkflowManager) pollAndRunWorkflows(ctx context.Context) {
 
 	slog.Debug("Polling for new workflows")
 
-	if len(workflowQueue) >= config.GetSystemSettingInteger(config.ENGINE_BATCH_SIZE) {
+	free := cap(wm.queue) - len(wm.queue)
+	if free <= 0 {
 		slog.Warn("workflow queue full, skipping pollAndRunWorkflows, possibly stuck workflows or long running workflows")
 		return
 	}
 
-	workflows, err := wm.WorkflowRepo.FindPendingWorkflows(
-		config.GetSystemSettingInteger(config.ENGINE_BATCH_SIZE),
-		config.GetSystemSettingString(config.ENGINE_EXECUTOR_GROUP),
-	)
+	// never take more than the queue has room for, queueing them must not wait on the workers
+	wm.pollGroups(ctx, min(config.GetSystemSettingInteger(config.ENGINE_BATCH_SIZE), free))
+}
+
+// pollGroup takes up to size pending workflows of an executor group for this executor, queues them for the workers
+// and returns how many it took.
+func (wm *WorkflowManager) pollGroup(ctx context.Context, group string, size int) int {
+	if claimer, ok := wm.WorkflowRepo.(WorkflowClaimer); ok {
+		workflows, err := claimer.ClaimPendingWorkflows(size, group, wm.executorID)
+		if err == nil {
+			taken := 0
+			for _, wf := range *workflows {
+				wm.running.queued(wf.ID)
+				if wm.queueWorkflow(ctx, wf) {
+					taken++
+				}
+			}
+			return taken
+		}
+		if !errors.Is(err, repository.ErrClaimUnsupported) {
+			slog.Error("Error claiming workflows", "error", err, "executor_group", group)
+			return 0
+		}
+	}
+
+	workflows, err := wm.WorkflowRepo.FindPendingWorkflows(size, group)
 	if err != nil {
-		slog.Error("Error fetching workflows", "error", err)
-		return
+		slog.Error("Error fetching workflows", "error", err, "executor_group", group)
+		return 0
 	}
 
+	taken := 0
 	for _, wf := range *workflows {
 
 		// first we mark the workflow as running
 		slog.InfoContext(ctx, "Marking workflow as scheduled for execution", "business_key", wf.BusinessKey, "externalId", wf.ExternalID)
+		wm.running.queued(wf.ID)
 		exclusiveLock := wm.WorkflowRepo.MarkWorkflowAsScheduledForExecution(wf.ID, wm.executorID, wf.Modified)
 
 		if exclusiveLock == false {
+			wm.running.remove(wf.ID)
 			slog.InfoContext(ctx, "Unable to gain lock on workflow, possibly piced up by other executor", "business_key", wf.BusinessKey, "externalId", wf.ExternalID)
 			_, _ = wm.WorkflowActionRepo.Save(&domain.WorkflowAction{WorkflowID: wf.ID, ExecutorID: wm.executorID, ExecutionCount: 1, Type: "LOCK_FAILED", Name: "LOCK_FAILED", Text: "Failed to Acquier a lock on the workflow", DateTime: time.Now()})
 			continue
 		}
-		_, _ = wm.WorkflowActionRepo.Save(&domain.WorkflowAction{WorkflowID: wf.ID, ExecutorID: wm.executorID, ExecutionCount: 1, Type: "SCHEDULED", Name: "SCHEDULED", Text: "Scheduled for Execution", DateTime: time.Now()})
+		if wm.queueWorkflow(ctx, wf) {
+			taken++
+		}
+	}
+	return taken
+}
 
-		// create an instance of the workflow based on the type
-		instance, _ := createWorkflow(wm, wf.WorkflowType)
+// queueWorkflow records that a workflow was scheduled for this executor and hands it to the workers. It returns false
+// when this executor cannot run the workflow or is shutting down, the workflow is then handed back.
+func (wm *WorkflowManager) queueWorkflow(ctx context.Context, wf domain.Workflow) bool {
+	_, _ = wm.WorkflowActionRepo.Save(&domain.WorkflowAction{WorkflowID: wf.ID, ExecutorID: wm.executorID, ExecutionCount: 1, Type: "SCHEDULED", Name: "SCHEDULED", Text: "Scheduled for Execution", DateTime: time.Now()})
 
-		slog.InfoContext(ctx, "Add workflow to execution channel", "business_key", wf.BusinessKey, "externalId", wf.ExternalID)
-		ptr := instance.(core.Workflow)
-		ptr.Setup(&wf)
-		workflowQueue <- ptr
+	// create an instance of the version of the workflow it is pinned to
+	instance, err := wm.instanceFor(ctx, &wf)
+	if err != nil {
+		wm.releaseUnrunnable(ctx, wf, err)
+		return false
+	}
 
-		slog.InfoContext(ctx, "Running workflow", "business_key", wf.BusinessKey, "externalId", wf.ExternalID)
-		// RunWorkflow(wf) // call your workflow runner here
+	slog.InfoContext(ctx, "Add workflow to execution channel", "business_key", wf.BusinessKey, "externalId", wf.ExternalID)
+	instance.Setup(&wf)
+	select {
+	case wm.queue <- instance:
+	case <-ctx.Done():
+		// the workers have stopped, nothing takes it from the queue any more
+		wm.releaseTaken(ctx, wf.ID)
+		return false
 	}
 
+	slog.InfoContext(ctx, "Running workflow", "business_key", wf.BusinessKey, "externalId", wf.ExternalID)
+	// RunWorkflow(wf) // call your workflow runner here
+	return true
+}
+
+// unrunnableDelay is how long a workflow this executor has no implementation for waits before it is picked up
+// again, by an executor that has one or by this one once it was redeployed.
+const unrunnableDelay = time.Minute
+
+// releaseUnrunnable hands back a workflow this executor took but has no implementation for.
+func (wm *WorkflowManager) releaseUnrunnable(ctx context.Context, wf domain.Workflow, cause error) {
+	slog.ErrorContext(ctx, "Cannot run workflow, releasing it", "workflow_id", wf.ID, "workflow_type", wf.WorkflowType, "version", wf.WorkflowVersion, "error", cause)
+	wm.running.remove(wf.ID)
+	err := inTransaction(ctx, wm.WorkflowRepo, wm.WorkflowActionRepo, func(r WorkflowRepo, wa WorkflowActionRepo) error {
+		if err := r.UpdateNextActivationSpecific(wf.ID, time.Now().Add(unrunnableDelay)); err != nil {
+			return err
+		}
+		if _, err := r.ReleaseWorkflow(wf.ID, wm.executorID); err != nil {
+			return err
+		}
+		_, err := wa.Save(&domain.WorkflowAction{WorkflowID: wf.ID, ExecutorID: wm.executorID, ExecutionCount: wf.ExecutionCount, Type: "RELEASED", Name: "UNRUNNABLE", Text: "Released, no implementation to run it: " + cause.Error(), DateTime: time.Now()})
+		return err
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to release workflow", "workflow_id", wf.ID, "error", err)
+	}
 }
 
 func createWorkflow(wm *WorkflowManager, name string) (core.Workflow, error) {
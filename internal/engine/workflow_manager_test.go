@@ -1,13 +1,25 @@
 package engine
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
 	"os"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/RealZimboGuy/gopherflow/internal/config"
+	"github.com/RealZimboGuy/gopherflow/internal/repository"
 	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/core"
 	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/domain"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/models"
+	"github.com/lib/pq"
 )
 
 // Reusing Mocks from workflow_executor_test.go where possible,
@@ -18,6 +30,7 @@ type MockExecutorRepo struct {
 	SaveFunc                     func(e *domain.Executor) (int64, error)
 	UpdateLastActiveFunc         func(id int64, ts time.Time) error
 	GetExecutorsByLastActiveFunc func(limit int) ([]*domain.Executor, error)
+	MarkStoppedFunc              func(id int64, ts time.Time) error
 }
 
 func (m *MockExecutorRepo) Save(e *domain.Executor) (int64, error) {
@@ -32,6 +45,12 @@ func (m *MockExecutorRepo) UpdateLastActive(id int64, ts time.Time) error {
 	}
 	return nil
 }
+func (m *MockExecutorRepo) MarkStopped(id int64, ts time.Time) error {
+	if m.MarkStoppedFunc != nil {
+		return m.MarkStoppedFunc(id, ts)
+	}
+	return nil
+}
 func (m *MockExecutorRepo) GetExecutorsByLastActive(limit int) ([]*domain.Executor, error) {
 	if m.GetExecutorsByLastActiveFunc != nil {
 		return m.GetExecutorsByLastActiveFunc(limit)
@@ -40,9 +59,10 @@ func (m *MockExecutorRepo) GetExecutorsByLastActive(limit int) ([]*domain.Execut
 }
 
 type MockDefinitionRepo struct {
-	FindAllFunc    func() (*[]domain.WorkflowDefinition, error)
-	FindByNameFunc func(name string) (*domain.WorkflowDefinition, error)
-	SaveFunc       func(def *domain.WorkflowDefinition) error
+	FindAllFunc     func() (*[]domain.WorkflowDefinition, error)
+	FindByNameFunc  func(name string) (*domain.WorkflowDefinition, error)
+	SaveFunc        func(def *domain.WorkflowDefinition) error
+	SaveVersionFunc func(v *domain.WorkflowDefinitionVersion) error
 }
 
 func (m *MockDefinitionRepo) FindAll() (*[]domain.WorkflowDefinition, error) {
@@ -63,6 +83,18 @@ func (m *MockDefinitionRepo) Save(def *domain.WorkflowDefinition) error {
 	}
 	return nil
 }
+func (m *MockDefinitionRepo) SaveVersion(v *domain.WorkflowDefinitionVersion) error {
+	if m.SaveVersionFunc != nil {
+		return m.SaveVersionFunc(v)
+	}
+	return nil
+}
+func (m *MockDefinitionRepo) FindVersion(name string, version int) (*domain.WorkflowDefinitionVersion, error) {
+	return nil, nil
+}
+func (m *MockDefinitionRepo) FindVersions(name string) (*[]domain.WorkflowDefinitionVersion, error) {
+	return &[]domain.WorkflowDefinitionVersion{}, nil
+}
 
 func TestWorkflowManager_ListWorkflowDefinitions(t *testing.T) {
 	expectedDefs := []domain.WorkflowDefinition{
@@ -75,7 +107,7 @@ func TestWorkflowManager_ListWorkflowDefinitions(t *testing.T) {
 		},
 	}
 
-	wm := NewWorkflowManager(nil, nil, nil, defRepo, nil, nil)
+	wm := NewWorkflowManager(nil, nil, nil, defRepo, nil, nil, nil)
 	defs, err := wm.ListWorkflowDefinitions()
 	if err != nil {
 		t.Fatalf("ListWorkflowDefinitions returned error: %v", err)
@@ -92,10 +124,6 @@ func TestWorkflowManager_PollAndRunWorkflows(t *testing.T) {
 	defer os.Unsetenv("ENGINE_BATCH_SIZE")
 	defer os.Unsetenv("ENGINE_EXECUTOR_GROUP")
 
-	// Initialize workflowQueue global variable
-	workflowQueue = make(chan core.Workflow, 10)
-	defer func() { close(workflowQueue) }()
-
 	// Mocks
 	wfRepo := &MockWorkflowRepo{
 		FindPendingWorkflowsFunc: func(size int, executorGroup string) (*[]domain.Workflow, error) {
@@ -123,7 +151,7 @@ func TestWorkflowManager_PollAndRunWorkflows(t *testing.T) {
 		},
 	}
 
-	wm := NewWorkflowManager(wfRepo, waRepo, nil, nil, &registry, nil)
+	wm := NewWorkflowManager(wfRepo, waRepo, nil, nil, nil, &registry, nil)
 	wm.executorID = 123
 
 	// Run poll
@@ -131,7 +159,7 @@ func TestWorkflowManager_PollAndRunWorkflows(t *testing.T) {
 
 	// Check if workflow was added to queue
 	select {
-	case wf := <-workflowQueue:
+	case wf := <-wm.queue:
 		if wf.GetWorkflowData().ID != 1 {
 			t.Errorf("Expected workflow ID 1, got %d", wf.GetWorkflowData().ID)
 		}
@@ -140,6 +168,74 @@ func TestWorkflowManager_PollAndRunWorkflows(t *testing.T) {
 	}
 }
 
+// ClaimingMockWorkflowRepo is a MockWorkflowRepo that implements WorkflowClaimer.
+type ClaimingMockWorkflowRepo struct {
+	MockWorkflowRepo
+	ClaimPendingWorkflowsFunc func(size int, executorGroup string, executorId int64) (*[]domain.Workflow, error)
+}
+
+func (m *ClaimingMockWorkflowRepo) ClaimPendingWorkflows(size int, executorGroup string, executorId int64) (*[]domain.Workflow, error) {
+	return m.ClaimPendingWorkflowsFunc(size, executorGroup, executorId)
+}
+
+func TestWorkflowManager_PollAndRunWorkflowsClaims(t *testing.T) {
+	os.Setenv("ENGINE_BATCH_SIZE", "10")
+	defer os.Unsetenv("ENGINE_BATCH_SIZE")
+
+	claimedBy := int64(0)
+	wfRepo := &ClaimingMockWorkflowRepo{
+		MockWorkflowRepo: MockWorkflowRepo{
+			FindPendingWorkflowsFunc: func(size int, executorGroup string) (*[]domain.Workflow, error) {
+				t.Error("Expected pending workflows to be claimed, not found")
+				return &[]domain.Workflow{}, nil
+			},
+		},
+		ClaimPendingWorkflowsFunc: func(size int, executorGroup string, executorId int64) (*[]domain.Workflow, error) {
+			claimedBy = executorId
+			return &[]domain.Workflow{
+				{ID: 1, WorkflowType: "MockWorkflow", Status: "SCHEDULED"},
+				{ID: 2, WorkflowType: "MockWorkflow", Status: "SCHEDULED"},
+			}, nil
+		},
+	}
+	waRepo := &MockWorkflowActionRepo{
+		SaveFunc: func(a *domain.WorkflowAction) (int64, error) {
+			if a.Type != "SCHEDULED" {
+				t.Errorf("Expected only SCHEDULED actions, got %s", a.Type)
+			}
+			return 1, nil
+		},
+	}
+	registry := map[string]func() core.Workflow{
+		"MockWorkflow": func() core.Workflow { return &MockWorkflow{} },
+	}
+
+	wm := NewWorkflowManager(wfRepo, waRepo, nil, nil, nil, &registry, nil)
+	wm.executorID = 123
+	wm.pollAndRunWorkflows(context.Background())
+
+	if claimedBy != 123 {
+		t.Errorf("Expected workflows to be claimed for executor 123, got %d", claimedBy)
+	}
+	if len(wm.queue) != 2 {
+		t.Errorf("Expected 2 workflows in the queue, got %d", len(wm.queue))
+	}
+
+	// databases without SKIP LOCKED find and mark the workflows instead
+	found := false
+	wfRepo.FindPendingWorkflowsFunc = func(size int, executorGroup string) (*[]domain.Workflow, error) {
+		found = true
+		return &[]domain.Workflow{}, nil
+	}
+	wfRepo.ClaimPendingWorkflowsFunc = func(size int, executorGroup string, executorId int64) (*[]domain.Workflow, error) {
+		return nil, repository.ErrClaimUnsupported
+	}
+	wm.pollAndRunWorkflows(context.Background())
+	if !found {
+		t.Error("Expected pending workflows to be found when claiming is unsupported")
+	}
+}
+
 func TestRegisterWorkflowDefinitions(t *testing.T) {
 	// Setup registry with valid workflow
 	registry := map[string]func() core.Workflow{
@@ -163,7 +259,7 @@ func TestRegisterWorkflowDefinitions(t *testing.T) {
 		},
 	}
 
-	wm := NewWorkflowManager(nil, nil, nil, defRepo, &registry, nil)
+	wm := NewWorkflowManager(nil, nil, nil, defRepo, nil, &registry, nil)
 
 	registerWorkflowDefinitions(context.Background(), wm)
 
@@ -172,5 +268,963 @@ func TestRegisterWorkflowDefinitions(t *testing.T) {
 	}
 }
 
+// VersionedMockWorkflow declares the definition version it implements
+type VersionedMockWorkflow struct {
+	MockWorkflow
+	V int
+}
+
+func (m *VersionedMockWorkflow) Version() int {
+	return m.V
+}
+
+func TestRegisterWorkflowDefinitions_SavesEveryVersion(t *testing.T) {
+	registry := map[string]func() core.Workflow{
+		"TestWorkflow":                     func() core.Workflow { return &VersionedMockWorkflow{V: 2} },
+		core.VersionKey("TestWorkflow", 1): func() core.Workflow { return &VersionedMockWorkflow{V: 1} },
+	}
+
+	saved := map[int]bool{}
+	var current *domain.WorkflowDefinition
+	defRepo := &MockDefinitionRepo{
+		SaveFunc: func(def *domain.WorkflowDefinition) error {
+			current = def
+			return nil
+		},
+		SaveVersionFunc: func(v *domain.WorkflowDefinitionVersion) error {
+			if v.Name != "TestWorkflow" {
+				t.Errorf("Expected version of TestWorkflow, got %s", v.Name)
+			}
+			saved[v.Version] = true
+			return nil
+		},
+	}
+
+	wm := NewWorkflowManager(nil, nil, nil, defRepo, nil, &registry, nil)
+	registerWorkflowDefinitions(context.Background(), wm)
+
+	if !saved[1] || !saved[2] {
+		t.Errorf("Expected versions 1 and 2 to be saved, got %v", saved)
+	}
+	if current == nil || current.Name != "TestWorkflow" || current.Version != 2 {
+		t.Errorf("Expected the current definition to be TestWorkflow version 2, got %+v", current)
+	}
+}
+
+// LimitedMockWorkflow allows a few of its instances to run at the same time
+type LimitedMockWorkflow struct {
+	MockWorkflow
+}
+
+func (m *LimitedMockWorkflow) MaxConcurrency() int {
+	return 3
+}
+
+func TestRegisterWorkflowDefinitions_SavesMaxConcurrency(t *testing.T) {
+	registry := map[string]func() core.Workflow{
+		"Limited":   func() core.Workflow { return &LimitedMockWorkflow{} },
+		"Unlimited": func() core.Workflow { return &MockWorkflow{} },
+	}
+
+	saved := map[string]int{}
+	defRepo := &MockDefinitionRepo{
+		FindByNameFunc: func(name string) (*domain.WorkflowDefinition, error) {
+			// an existing definition picks up a changed limit
+			return &domain.WorkflowDefinition{Name: name, MaxConcurrency: 10}, nil
+		},
+		SaveFunc: func(def *domain.WorkflowDefinition) error {
+			saved[def.Name] = def.MaxConcurrency
+			return nil
+		},
+	}
+
+	wm := NewWorkflowManager(nil, nil, nil, defRepo, nil, &registry, nil)
+	registerWorkflowDefinitions(context.Background(), wm)
+
+	if saved["Limited"] != 3 || saved["Unlimited"] != 0 {
+		t.Errorf("Expected max concurrency 3 and unlimited, got %v", saved)
+	}
+}
+
+func TestWorkflowManager_InstanceForPinnedVersion(t *testing.T) {
+	registry := map[string]func() core.Workflow{
+		"TestWorkflow":                     func() core.Workflow { return &VersionedMockWorkflow{V: 2} },
+		core.VersionKey("TestWorkflow", 1): func() core.Workflow { return &VersionedMockWorkflow{V: 1} },
+	}
+	pinned := map[int64]int{}
+	wfRepo := &MockWorkflowRepo{
+		PinWorkflowVersionFunc: func(id int64, version int) error {
+			pinned[id] = version
+			return nil
+		},
+	}
+	wm := NewWorkflowManager(wfRepo, nil, nil, nil, nil, &registry, nil)
+
+	tests := []struct {
+		name    string
+		wf      domain.Workflow
+		want    int
+		wantPin bool
+		wantErr bool
+	}{
+		{"old version keeps its code", domain.Workflow{ID: 1, WorkflowType: "TestWorkflow", WorkflowVersion: 1}, 1, false, false},
+		{"current version", domain.Workflow{ID: 2, WorkflowType: "TestWorkflow", WorkflowVersion: 2}, 2, false, false},
+		{"unpinned is pinned to current", domain.Workflow{ID: 3, WorkflowType: "TestWorkflow"}, 2, true, false},
+		{"unregistered version is refused", domain.Workflow{ID: 4, WorkflowType: "TestWorkflow", WorkflowVersion: 5}, 0, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inst, err := wm.instanceFor(context.Background(), &tt.wf)
+			if tt.wantErr {
+				if !errors.Is(err, ErrVersionNotRegistered) {
+					t.Errorf("Expected ErrVersionNotRegistered, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got := WorkflowVersion(inst); got != tt.want {
+				t.Errorf("Expected version %d, got %d", tt.want, got)
+			}
+			if _, ok := pinned[tt.wf.ID]; ok != tt.wantPin {
+				t.Errorf("Expected pinned %v, got %v", tt.wantPin, ok)
+			}
+			if tt.wantPin && (pinned[tt.wf.ID] != tt.want || tt.wf.WorkflowVersion != tt.want) {
+				t.Errorf("Expected workflow pinned to %d, got %d", tt.want, pinned[tt.wf.ID])
+			}
+		})
+	}
+}
+
+func TestRunWorkflow_ChildPinnedToVersionAtCreation(t *testing.T) {
+	registry := map[string]func() core.Workflow{
+		"MockWorkflow": func() core.Workflow { return &VersionedMockWorkflow{V: 3} },
+	}
+	// HeavyWorkflow is only registered by another executor
+	defRepo := &MockDefinitionRepo{
+		FindByNameFunc: func(name string) (*domain.WorkflowDefinition, error) {
+			if name == "HeavyWorkflow" {
+				return &domain.WorkflowDefinition{Name: name, Version: 4}, nil
+			}
+			return nil, sql.ErrNoRows
+		},
+	}
+	versions := map[string]int{}
+	repo := &MockWorkflowRepo{
+		SaveFunc: func(wf *domain.Workflow) (int64, error) {
+			versions[wf.WorkflowType] = wf.WorkflowVersion
+			return int64(len(versions) + 1), nil
+		},
+		FindByIDFunc: func(id int64) (*domain.Workflow, error) {
+			return &domain.Workflow{ID: id}, nil
+		},
+	}
+	wm := NewWorkflowManager(repo, nil, nil, defRepo, nil, &registry, nil)
+	ctx := withWorkflowVersions(context.Background(), wm.currentWorkflowVersion)
+	wf := &ChildRoutingMockWorkflow{MockWorkflow: MockWorkflow{WorkflowData: domain.Workflow{ID: 1, State: "Step1"}}}
+
+	RunWorkflow(ctx, wf, repo, &MockWorkflowActionRepo{}, 1, "worker1")
+
+	if versions["MockWorkflow"] != 3 || versions["HeavyWorkflow"] != 4 {
+		t.Errorf("Expected children pinned to versions 3 and 4, got %v", versions)
+	}
+	if v := wm.currentWorkflowVersion("Unknown"); v != 0 {
+		t.Errorf("Expected an unknown type to be left unpinned, got version %d", v)
+	}
+}
+
+// GraphMockWorkflow lets a test declare any state graph on top of the MockWorkflow state methods
+type GraphMockWorkflow struct {
+	MockWorkflow
+	States      []models.WorkflowState
+	Transitions map[string][]string
+}
+
+func (m *GraphMockWorkflow) GetAllStates() []models.WorkflowState  { return m.States }
+func (m *GraphMockWorkflow) StateTransitions() map[string][]string { return m.Transitions }
+
+func TestValidateWorkflow(t *testing.T) {
+	start := models.WorkflowState{Name: "Start", StateType: models.StateStart}
+	step := models.WorkflowState{Name: "Step1", StateType: models.StateNormal}
+	end := models.WorkflowState{Name: "End", StateType: models.StateEnd}
+
+	tests := []struct {
+		name        string
+		states      []models.WorkflowState
+		transitions map[string][]string
+		want        []core.DefinitionIssue
+	}{
+		{"valid graph", []models.WorkflowState{start, step, end},
+			map[string][]string{"Start": {"Step1"}, "Step1": {"End"}}, nil},
+		{"undeclared target", []models.WorkflowState{start, step, end},
+			map[string][]string{"Start": {"Step1"}, "Step1": {"End", "Missing"}},
+			[]core.DefinitionIssue{{State: "Step1", Severity: core.IssueError, Message: "transition to Missing, which is not declared"}}},
+		{"unreachable state", []models.WorkflowState{start, step, end},
+			map[string][]string{"Start": {"End"}, "Step1": {"End"}},
+			[]core.DefinitionIssue{{State: "Step1", Severity: core.IssueWarning, Message: "is not reachable from the initial state Start"}}},
+		{"dead end", []models.WorkflowState{start, step, end},
+			map[string][]string{"Start": {"Step1", "End"}},
+			[]core.DefinitionIssue{{State: "Step1", Severity: core.IssueError, Message: "has no outgoing transitions"}}},
+		{"end with transitions", []models.WorkflowState{start, step, end},
+			map[string][]string{"Start": {"Step1"}, "Step1": {"End"}, "End": {"Step1"}},
+			[]core.DefinitionIssue{{State: "End", Severity: core.IssueError, Message: "End state has outgoing transitions"}}},
+		{"duplicate state", []models.WorkflowState{start, step, step, end},
+			map[string][]string{"Start": {"Step1"}, "Step1": {"End"}},
+			[]core.DefinitionIssue{{State: "Step1", Severity: core.IssueError, Message: "state is declared more than once"}}},
+		{"missing method", []models.WorkflowState{start, step, {Name: "Step2", StateType: models.StateNormal}, end},
+			map[string][]string{"Start": {"Step1"}, "Step1": {"Step2"}, "Step2": {"End"}},
+			[]core.DefinitionIssue{{State: "Step2", Severity: core.IssueError, Message: "method Step2 not found"}}},
+		{"join without count", []models.WorkflowState{start, {Name: "Step1", StateType: models.StateNormal, Join: &models.JoinPolicy{Type: models.JoinNOfM}}, end},
+			map[string][]string{"Start": {"Step1"}, "Step1": {"End"}},
+			[]core.DefinitionIssue{{State: "Step1", Severity: core.IssueError, Message: "NOfM join needs a count of at least 1"}}},
+		{"join on end state", []models.WorkflowState{start, step, {Name: "End", StateType: models.StateEnd, Join: &models.JoinPolicy{}}},
+			map[string][]string{"Start": {"Step1"}, "Step1": {"End"}},
+			[]core.DefinitionIssue{{State: "End", Severity: core.IssueError, Message: "End state cannot wait for child workflows"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ValidateWorkflow("TestWorkflow", &GraphMockWorkflow{States: tt.states, Transitions: tt.transitions})
+			if len(got) != len(tt.want) {
+				t.Fatalf("Expected %d issues, got %v", len(tt.want), got)
+			}
+			for i, want := range tt.want {
+				want.Workflow, want.Version = "TestWorkflow", 1
+				if got[i] != want {
+					t.Errorf("Expected issue %+v, got %+v", want, got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateRegistryReturnsDefinitionError(t *testing.T) {
+	registry := map[string]func() core.Workflow{
+		"Good": func() core.Workflow { return &MockWorkflow{} },
+		"Bad": func() core.Workflow {
+			return &GraphMockWorkflow{
+				States:      []models.WorkflowState{{Name: "Start", StateType: models.StateStart}},
+				Transitions: map[string][]string{"Start": {"Nowhere"}},
+			}
+		},
+		core.VersionKey("Good", 3): func() core.Workflow { return &MockWorkflow{} },
+	}
+
+	err := ValidateRegistry(registry)
+	var defErr *core.DefinitionError
+	if !errors.As(err, &defErr) {
+		t.Fatalf("Expected a DefinitionError, got %v", err)
+	}
+	if len(defErr.Issues) != 2 {
+		t.Fatalf("Expected 2 issues, got %v", defErr.Issues)
+	}
+	if defErr.Issues[0].Workflow != "Bad" || defErr.Issues[1].Workflow != "Good" || defErr.Issues[1].Version != 3 {
+		t.Errorf("Unexpected issues %v", defErr.Issues)
+	}
+
+	delete(registry, "Bad")
+	delete(registry, core.VersionKey("Good", 3))
+	if err := ValidateRegistry(registry); err != nil {
+		t.Errorf("Expected a valid registry, got %v", err)
+	}
+}
+
 // Ensure MockWorkflow satisfies core.Workflow (it was improved in workflow_executor_test.go)
 // We rely on MockWorkflow being available in the package test build.
+
+func TestWorkflowManager_CancelWorkflowInterruptsRunningState(t *testing.T) {
+	wfRepo := &MockWorkflowRepo{
+		CancelWorkflowFunc: func(id int64) (bool, error) {
+			return true, nil
+		},
+	}
+	wm := NewWorkflowManager(wfRepo, &MockWorkflowActionRepo{}, nil, nil, nil, nil, nil)
+
+	runCtx, done := wm.running.start(context.Background(), 7)
+	defer done()
+
+	cancelled, err := wm.CancelWorkflow(context.Background(), 7, "tester")
+	if err != nil || !cancelled {
+		t.Fatalf("Expected workflow to be cancelled, got %v %v", cancelled, err)
+	}
+	if !isCancelled(runCtx) {
+		t.Errorf("Expected running context to be cancelled with ErrWorkflowCancelled, got %v", context.Cause(runCtx))
+	}
+}
+
+func TestWorkflowManager_ProcessCancelledTakesOverFromDeadExecutor(t *testing.T) {
+	var claimed []int64
+	var cleared []int64
+	wfRepo := &MockWorkflowRepo{
+		FindCancelledWorkflowsFunc: func(executorGroup string, executorId int64, limit int) (*[]domain.Workflow, error) {
+			return &[]domain.Workflow{
+				// held by an executor whose lease expired
+				{ID: 1, Status: "CANCELLED", WorkflowType: "MockWorkflow", ExecutorID: sql.NullString{String: "9", Valid: true}},
+				// left behind by this executor
+				{ID: 2, Status: "CANCELLED", WorkflowType: "MockWorkflow", ExecutorID: sql.NullString{String: "3", Valid: true}},
+			}, nil
+		},
+		ClaimCancelledWorkflowFunc: func(id int64, executorId int64) bool {
+			claimed = append(claimed, id)
+			return true
+		},
+		ClearExecutorIdFunc: func(id int64) error {
+			cleared = append(cleared, id)
+			return nil
+		},
+	}
+	registry := map[string]func() core.Workflow{
+		"MockWorkflow": func() core.Workflow { return &MockWorkflow{} },
+	}
+	wm := NewWorkflowManager(wfRepo, &MockWorkflowActionRepo{}, nil, nil, nil, &registry, core.NewRealClock())
+	wm.executorID = 3
+
+	wm.processCancelledWorkflows(context.Background())
+
+	if fmt.Sprint(claimed) != "[1]" || fmt.Sprint(cleared) != "[2]" {
+		t.Errorf("Expected workflow 1 claimed and workflow 2 released, got claimed %v released %v", claimed, cleared)
+	}
+	if len(wm.queue) != 1 {
+		t.Errorf("Expected the taken over workflow queued for its cleanup, got %d queued", len(wm.queue))
+	}
+}
+
+type MockScheduleRepo struct {
+	Schedules        []domain.Schedule
+	DueTypes         []string
+	ClaimFunc        func(id int64, dueAt time.Time, nextRun sql.NullTime) bool
+	LastWorkflowIDs  map[int64]int64
+	SavedSchedules   []domain.Schedule
+	UpdatedSchedules []domain.Schedule
+}
+
+func (m *MockScheduleRepo) Save(s *domain.Schedule) (int64, error) {
+	s.ID = int64(len(m.SavedSchedules) + 1)
+	m.SavedSchedules = append(m.SavedSchedules, *s)
+	return s.ID, nil
+}
+func (m *MockScheduleRepo) Update(s *domain.Schedule) error {
+	m.UpdatedSchedules = append(m.UpdatedSchedules, *s)
+	return nil
+}
+func (m *MockScheduleRepo) FindByID(id int64) (*domain.Schedule, error) { return nil, sql.ErrNoRows }
+func (m *MockScheduleRepo) FindAll() (*[]domain.Schedule, error)        { return &m.Schedules, nil }
+func (m *MockScheduleRepo) FindDueSchedules(workflowTypes []string, limit int) (*[]domain.Schedule, error) {
+	m.DueTypes = workflowTypes
+	return &m.Schedules, nil
+}
+func (m *MockScheduleRepo) ClaimScheduleRun(id int64, dueAt time.Time, nextRun sql.NullTime) bool {
+	if m.ClaimFunc != nil {
+		return m.ClaimFunc(id, dueAt, nextRun)
+	}
+	return true
+}
+func (m *MockScheduleRepo) SetLastWorkflow(id int64, workflowID int64) error {
+	if m.LastWorkflowIDs == nil {
+		m.LastWorkflowIDs = map[int64]int64{}
+	}
+	m.LastWorkflowIDs[id] = workflowID
+	return nil
+}
+func (m *MockScheduleRepo) DeleteByID(id int64) error { return nil }
+
+func TestWorkflowManager_RunDueSchedules(t *testing.T) {
+	dueAt := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	scheduleRepo := &MockScheduleRepo{
+		Schedules: []domain.Schedule{
+			{ID: 1, Name: "nightly", CronExpression: "0 9 * * *", Timezone: "Europe/London", WorkflowType: "MockWorkflow",
+				ExecutorGroup: "default", BusinessKey: "${scheduleName}-${scheduledTime}", StateVars: `{"runAt":"${scheduledTime}"}`,
+				OverlapPolicy: models.OverlapAllow, Enabled: true, NextRun: sql.NullTime{Time: dueAt, Valid: true}},
+			// claimed by another executor, which created its workflow
+			{ID: 2, Name: "other", CronExpression: "0 9 * * *", Timezone: "UTC", WorkflowType: "MockWorkflow",
+				ExecutorGroup: "default", OverlapPolicy: models.OverlapAllow, Enabled: true, NextRun: sql.NullTime{Time: dueAt, Valid: true}},
+		},
+	}
+	var claimedNext sql.NullTime
+	scheduleRepo.ClaimFunc = func(id int64, due time.Time, nextRun sql.NullTime) bool {
+		if id == 1 {
+			claimedNext = nextRun
+		}
+		return id == 1 && due.Equal(dueAt)
+	}
+
+	var saved []domain.Workflow
+	wfRepo := &MockWorkflowRepo{
+		SaveFunc: func(wf *domain.Workflow) (int64, error) {
+			saved = append(saved, *wf)
+			return 42, nil
+		},
+		FindByExternalIdFunc: func(id string) (*domain.Workflow, error) {
+			if strings.HasPrefix(id, "schedule-2-") {
+				return &domain.Workflow{ID: 43, ExternalID: id}, nil
+			}
+			return nil, nil
+		},
+	}
+	registry := map[string]func() core.Workflow{
+		"MockWorkflow": func() core.Workflow { return &MockWorkflow{} },
+	}
+	wm := NewWorkflowManager(wfRepo, &MockWorkflowActionRepo{}, nil, nil, scheduleRepo, &registry, core.NewRealClock())
+
+	wm.runDueSchedules(context.Background())
+
+	if len(saved) != 1 {
+		t.Fatalf("Expected exactly one scheduled workflow, got %d", len(saved))
+	}
+	wf := saved[0]
+	if wf.ExternalID != fmt.Sprintf("schedule-1-%d", dueAt.Unix()) {
+		t.Errorf("Unexpected external id %s", wf.ExternalID)
+	}
+	if wf.BusinessKey != "nightly-2026-03-02T09:00:00Z" {
+		t.Errorf("Unexpected business key %s", wf.BusinessKey)
+	}
+	if wf.Status != "NEW" || wf.State != "Start" {
+		t.Errorf("Expected a NEW workflow in its initial state, got %s %s", wf.Status, wf.State)
+	}
+	if !strings.Contains(wf.StateVars.String, `"runAt":"2026-03-02T09:00:00Z"`) || !strings.Contains(wf.StateVars.String, `"createdBy":"schedule:nightly"`) {
+		t.Errorf("Unexpected state vars %s", wf.StateVars.String)
+	}
+	if !claimedNext.Valid || !claimedNext.Time.After(time.Now()) {
+		t.Errorf("Expected the claim to move the schedule to a future run, got %v", claimedNext)
+	}
+	if scheduleRepo.LastWorkflowIDs[1] != 42 {
+		t.Errorf("Expected the created workflow to be recorded on the schedule, got %v", scheduleRepo.LastWorkflowIDs)
+	}
+	if _, ok := scheduleRepo.LastWorkflowIDs[2]; ok {
+		t.Errorf("Expected the run claimed by another executor not to be recorded, got %v", scheduleRepo.LastWorkflowIDs)
+	}
+	if fmt.Sprint(scheduleRepo.DueTypes) != "[MockWorkflow]" {
+		t.Errorf("Expected only schedules of registered workflow types, got %v", scheduleRepo.DueTypes)
+	}
+}
+
+func TestWorkflowManager_ScheduleRunStaysDueWhenCreationFails(t *testing.T) {
+	dueAt := time.Now().Add(-time.Minute).Truncate(time.Minute)
+	scheduleRepo := &MockScheduleRepo{
+		Schedules: []domain.Schedule{
+			{ID: 1, Name: "broken", CronExpression: "* * * * *", Timezone: "UTC", WorkflowType: "MockWorkflow",
+				ExecutorGroup: "default", OverlapPolicy: models.OverlapAllow, Enabled: true, NextRun: sql.NullTime{Time: dueAt, Valid: true}},
+		},
+	}
+	claims := 0
+	scheduleRepo.ClaimFunc = func(id int64, due time.Time, nextRun sql.NullTime) bool {
+		claims++
+		return true
+	}
+	saveErr := errors.New("database unavailable")
+	wfRepo := &MockWorkflowRepo{
+		SaveFunc: func(wf *domain.Workflow) (int64, error) {
+			if saveErr != nil {
+				return 0, saveErr
+			}
+			return 9, nil
+		},
+	}
+	registry := map[string]func() core.Workflow{
+		"MockWorkflow": func() core.Workflow { return &MockWorkflow{} },
+	}
+	wm := NewWorkflowManager(wfRepo, &MockWorkflowActionRepo{}, nil, nil, scheduleRepo, &registry, core.NewRealClock())
+
+	wm.runDueSchedules(context.Background())
+	if claims != 0 {
+		t.Fatalf("Expected the run to stay due when its workflow could not be created, got %d claims", claims)
+	}
+
+	saveErr = nil
+	wm.runDueSchedules(context.Background())
+	if claims != 1 || scheduleRepo.LastWorkflowIDs[1] != 9 {
+		t.Errorf("Expected the run claimed once its workflow was created, got %d claims and %v", claims, scheduleRepo.LastWorkflowIDs)
+	}
+}
+
+func TestWorkflowManager_ScheduleRunLostInsertRaceIsNotAnError(t *testing.T) {
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	scheduleRepo := &MockScheduleRepo{
+		Schedules: []domain.Schedule{
+			{ID: 1, Name: "nightly", CronExpression: "0 9 * * *", Timezone: "UTC", WorkflowType: "MockWorkflow",
+				ExecutorGroup: "default", OverlapPolicy: models.OverlapAllow, Enabled: true,
+				NextRun: sql.NullTime{Time: time.Now().Add(-time.Minute), Valid: true}},
+		},
+	}
+	claims := 0
+	scheduleRepo.ClaimFunc = func(id int64, due time.Time, nextRun sql.NullTime) bool {
+		claims++
+		return true
+	}
+	// another executor inserted the workflow of the same run first
+	wfRepo := &MockWorkflowRepo{
+		SaveFunc: func(wf *domain.Workflow) (int64, error) {
+			return 0, &pq.Error{Code: "23505", Message: "duplicate key value violates unique constraint"}
+		},
+	}
+	registry := map[string]func() core.Workflow{
+		"MockWorkflow": func() core.Workflow { return &MockWorkflow{} },
+	}
+	wm := NewWorkflowManager(wfRepo, &MockWorkflowActionRepo{}, nil, nil, scheduleRepo, &registry, core.NewRealClock())
+
+	wm.runDueSchedules(context.Background())
+
+	if claims != 0 {
+		t.Errorf("Expected the lost run not to be claimed, got %d claims", claims)
+	}
+	if strings.Contains(logs.String(), "level=ERROR") || !strings.Contains(logs.String(), "already created by another scheduler") {
+		t.Errorf("Expected the lost race logged at debug only, got %s", logs.String())
+	}
+}
+
+func TestWorkflowManager_ScheduleSkipsOverlappingRun(t *testing.T) {
+	scheduleRepo := &MockScheduleRepo{
+		Schedules: []domain.Schedule{
+			{ID: 1, Name: "sync", CronExpression: "*/5 * * * *", Timezone: "UTC", WorkflowType: "MockWorkflow",
+				ExecutorGroup: "default", OverlapPolicy: models.OverlapSkip, Enabled: true,
+				NextRun:        sql.NullTime{Time: time.Now().Add(-time.Minute).Truncate(time.Minute), Valid: true},
+				LastWorkflowID: sql.NullInt64{Int64: 7, Valid: true}},
+		},
+	}
+	lastStatus := "EXECUTING"
+	saves := 0
+	wfRepo := &MockWorkflowRepo{
+		FindByIDFunc: func(id int64) (*domain.Workflow, error) {
+			return &domain.Workflow{ID: id, Status: lastStatus}, nil
+		},
+		SaveFunc: func(wf *domain.Workflow) (int64, error) {
+			saves++
+			return 8, nil
+		},
+	}
+	registry := map[string]func() core.Workflow{
+		"MockWorkflow": func() core.Workflow { return &MockWorkflow{} },
+	}
+	wm := NewWorkflowManager(wfRepo, &MockWorkflowActionRepo{}, nil, nil, scheduleRepo, &registry, core.NewRealClock())
+
+	wm.runDueSchedules(context.Background())
+	if saves != 0 {
+		t.Fatalf("Expected the run to be skipped while the previous workflow is active, got %d saves", saves)
+	}
+
+	lastStatus = "FINISHED"
+	wm.runDueSchedules(context.Background())
+	if saves != 1 {
+		t.Fatalf("Expected a workflow once the previous one finished, got %d saves", saves)
+	}
+}
+
+func TestWorkflowManager_SaveScheduleValidates(t *testing.T) {
+	scheduleRepo := &MockScheduleRepo{}
+	registry := map[string]func() core.Workflow{
+		"MockWorkflow": func() core.Workflow { return &MockWorkflow{} },
+	}
+	wm := NewWorkflowManager(nil, nil, nil, nil, scheduleRepo, &registry, core.NewRealClock())
+
+	invalid := []domain.Schedule{
+		{Name: "bad cron", CronExpression: "61 * * * *", WorkflowType: "MockWorkflow"},
+		{Name: "bad zone", CronExpression: "@daily", Timezone: "Mars/Olympus", WorkflowType: "MockWorkflow"},
+		{Name: "bad type", CronExpression: "@daily", WorkflowType: "Unknown"},
+		{Name: "bad policy", CronExpression: "@daily", WorkflowType: "MockWorkflow", OverlapPolicy: "QUEUE"},
+		{Name: "bad vars", CronExpression: "@daily", WorkflowType: "MockWorkflow", StateVars: `["a"]`},
+	}
+	for _, s := range invalid {
+		if err := wm.SaveSchedule(&s); !errors.Is(err, ErrInvalidSchedule) {
+			t.Errorf("%s: expected ErrInvalidSchedule, got %v", s.Name, err)
+		}
+	}
+
+	s := domain.Schedule{Name: "hourly", CronExpression: "@hourly", WorkflowType: "MockWorkflow", Enabled: true}
+	if err := wm.SaveSchedule(&s); err != nil {
+		t.Fatalf("Expected schedule to be saved, got %v", err)
+	}
+	if s.Timezone != "UTC" || s.OverlapPolicy != models.OverlapAllow {
+		t.Errorf("Expected defaults to be applied, got %q %q", s.Timezone, s.OverlapPolicy)
+	}
+	if !s.NextRun.Valid || s.NextRun.Time.Minute() != 0 || !s.NextRun.Time.After(time.Now()) {
+		t.Errorf("Expected next run at the top of a future hour, got %v", s.NextRun)
+	}
+}
+
+func TestWorkflowManager_WorkflowChanged(t *testing.T) {
+	wm := NewWorkflowManager(nil, nil, nil, nil, nil, nil, core.NewRealClock())
+
+	changed, stop := wm.WorkflowChanged(1)
+	other, stopOther := wm.WorkflowChanged(2)
+	defer stopOther()
+
+	wm.changes.notify(1)
+	select {
+	case <-changed:
+	default:
+		t.Fatal("Expected waiter of workflow 1 to be woken")
+	}
+	stop()
+	select {
+	case <-other:
+		t.Fatal("Expected waiter of workflow 2 to keep waiting")
+	default:
+	}
+
+	// after a reconnect every waiter is woken
+	wm.changes.notify(0)
+	select {
+	case <-other:
+	default:
+		t.Fatal("Expected waiter of workflow 2 to be woken by a reconnect")
+	}
+
+	_, stop = wm.WorkflowChanged(3)
+	stop()
+	if len(wm.changes.waiters) != 0 {
+		t.Errorf("Expected no waiters left, got %d", len(wm.changes.waiters))
+	}
+}
+
+func TestWorkflowManager_RenewLeases(t *testing.T) {
+	var renewedFor int64
+	var renewedIDs []int64
+	wfRepo := &MockWorkflowRepo{
+		RenewLeasesFunc: func(executorId int64, ids []int64) (int64, error) {
+			renewedFor = executorId
+			renewedIDs = ids
+			return int64(len(ids)), nil
+		},
+	}
+	wm := NewWorkflowManager(wfRepo, nil, nil, nil, nil, nil, core.NewRealClock())
+	wm.executorID = 7
+
+	// nothing held, nothing to renew
+	wm.renewLeases(context.Background())
+	if renewedIDs != nil {
+		t.Fatalf("Expected no renewal without workflows, got %v", renewedIDs)
+	}
+
+	wm.running.queued(1)
+	_, done := wm.running.start(context.Background(), 2)
+	wm.renewLeases(context.Background())
+	done()
+
+	sort.Slice(renewedIDs, func(i, j int) bool { return renewedIDs[i] < renewedIDs[j] })
+	if renewedFor != 7 || len(renewedIDs) != 2 || renewedIDs[0] != 1 || renewedIDs[1] != 2 {
+		t.Errorf("Expected leases on queued and running workflows renewed for executor 7, got %d %v", renewedFor, renewedIDs)
+	}
+}
+
+func TestWorkflowManager_ShutdownReleasesUnstartedWorkflows(t *testing.T) {
+	var released []int64
+	updates := 0
+	wfRepo := &MockWorkflowRepo{
+		ReleaseWorkflowsFunc: func(executorId int64, ids []int64) (int64, error) {
+			if executorId != 9 {
+				t.Errorf("Expected release by executor 9, got %d", executorId)
+			}
+			updates++
+			released = append(released, ids...)
+			return int64(len(ids)), nil
+		},
+	}
+	stoppedID := int64(0)
+	execRepo := &MockExecutorRepo{
+		MarkStoppedFunc: func(id int64, ts time.Time) error {
+			stoppedID = id
+			return nil
+		},
+	}
+	wm := NewWorkflowManager(wfRepo, nil, execRepo, nil, nil, nil, core.NewRealClock())
+	wm.executorID = 9
+
+	// 1 waits in the queue, 2 was taken by a worker that saw the shutdown, 3 finished while draining
+	wm.running.queued(1)
+	wm.queue <- &MockWorkflow{WorkflowData: domain.Workflow{ID: 1}}
+	wm.running.queued(2)
+	wm.running.queued(3)
+	_, done := wm.running.start(context.Background(), 3)
+	done()
+
+	var workers sync.WaitGroup
+	wm.shutdown(context.Background(), &workers)
+
+	sort.Slice(released, func(i, j int) bool { return released[i] < released[j] })
+	if updates != 1 || len(released) != 2 || released[0] != 1 || released[1] != 2 {
+		t.Errorf("Expected workflows 1 and 2 to be released in one update, got %v in %d", released, updates)
+	}
+	if len(wm.queue) != 0 {
+		t.Errorf("Expected the queue to be emptied, %d left", len(wm.queue))
+	}
+	if len(wm.running.ids()) != 0 {
+		t.Errorf("Expected no workflows held after shutdown, got %v", wm.running.ids())
+	}
+	if stoppedID != 9 {
+		t.Errorf("Expected executor 9 to be marked stopped, got %d", stoppedID)
+	}
+}
+
+func TestWorkflowManager_PollTakesNoMoreThanQueueRoom(t *testing.T) {
+	t.Setenv(config.ENGINE_BATCH_SIZE, "3")
+
+	claimedSize := 0
+	wfRepo := &ClaimingMockWorkflowRepo{
+		ClaimPendingWorkflowsFunc: func(size int, executorGroup string, executorId int64) (*[]domain.Workflow, error) {
+			claimedSize += size
+			return &[]domain.Workflow{}, nil
+		},
+	}
+	wm := NewWorkflowManager(wfRepo, &MockWorkflowActionRepo{}, nil, nil, nil, nil, core.NewRealClock())
+	wm.queue <- &MockWorkflow{WorkflowData: domain.Workflow{ID: 1}}
+	wm.queue <- &MockWorkflow{WorkflowData: domain.Workflow{ID: 2}}
+
+	wm.pollAndRunWorkflows(context.Background())
+
+	if claimedSize != 1 {
+		t.Errorf("Expected only the room left in the queue to be claimed, got %d", claimedSize)
+	}
+}
+
+func TestWorkflowManager_QueueGivesUpOnShutdown(t *testing.T) {
+	t.Setenv(config.ENGINE_BATCH_SIZE, "1")
+
+	var released []int64
+	wfRepo := &ClaimingMockWorkflowRepo{
+		MockWorkflowRepo: MockWorkflowRepo{
+			ReleaseWorkflowFunc: func(id int64, executorId int64) (bool, error) {
+				released = append(released, id)
+				return true, nil
+			},
+		},
+	}
+	registry := map[string]func() core.Workflow{
+		"MockWorkflow": func() core.Workflow { return &MockWorkflow{} },
+	}
+	wm := NewWorkflowManager(wfRepo, &MockWorkflowActionRepo{}, nil, nil, nil, &registry, core.NewRealClock())
+	// the queue is full and no worker takes from it any more
+	wm.queue <- &MockWorkflow{WorkflowData: domain.Workflow{ID: 1}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	wm.running.queued(2)
+	done := make(chan bool)
+	go func() {
+		done <- wm.queueWorkflow(ctx, domain.Workflow{ID: 2, WorkflowType: "MockWorkflow"})
+	}()
+	select {
+	case queued := <-done:
+		if queued {
+			t.Error("Expected the workflow not to be queued")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected queueing to give up once the engine is shutting down")
+	}
+	if fmt.Sprint(released) != "[2]" {
+		t.Errorf("Expected workflow 2 released, got %v", released)
+	}
+	if ids := wm.running.ids(); len(ids) != 0 {
+		t.Errorf("Expected no workflows held, got %v", ids)
+	}
+}
+
+func TestRunContextOutlivesWorkerContext(t *testing.T) {
+	workerCtx, cancel := context.WithCancel(context.Background())
+	runCtx := runContext(workerCtx)
+	if isDraining(runCtx) {
+		t.Fatal("Expected no drain before the worker context is cancelled")
+	}
+	cancel()
+	if runCtx.Err() != nil {
+		t.Error("Expected the running state to keep its context during the grace period")
+	}
+	if !isDraining(runCtx) {
+		t.Error("Expected the shutdown to be reported between states")
+	}
+}
+
+func TestWorkflowManager_QueuePerManager(t *testing.T) {
+	t.Setenv(config.ENGINE_BATCH_SIZE, "3")
+
+	first := NewWorkflowManager(&MockWorkflowRepo{}, nil, nil, nil, nil, nil, core.NewRealClock())
+	second := NewWorkflowManager(&MockWorkflowRepo{}, nil, nil, nil, nil, nil, core.NewRealClock())
+	if cap(first.queue) != 3 {
+		t.Errorf("Expected a queue of ENGINE_BATCH_SIZE, got %d", cap(first.queue))
+	}
+
+	first.queue <- &MockWorkflow{WorkflowData: domain.Workflow{ID: 1}}
+	if len(first.queue) != 1 || len(second.queue) != 0 {
+		t.Errorf("Expected each manager to have its own queue, got %d and %d", len(first.queue), len(second.queue))
+	}
+}
+
+func TestExecutorGroups(t *testing.T) {
+	tests := []struct {
+		setting string
+		want    []ExecutorGroup
+	}{
+		{"", []ExecutorGroup{{Name: "default", Weight: 1}}},
+		{"reports", []ExecutorGroup{{Name: "reports", Weight: 1}}},
+		{"default:3, reports", []ExecutorGroup{{Name: "default", Weight: 3}, {Name: "reports", Weight: 1}}},
+		{"default:0,reports:x,default:2", []ExecutorGroup{{Name: "default", Weight: 1}, {Name: "reports", Weight: 1}}},
+		{" , ", []ExecutorGroup{{Name: "default", Weight: 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.setting, func(t *testing.T) {
+			t.Setenv(config.ENGINE_EXECUTOR_GROUP, tt.setting)
+			got := ExecutorGroups()
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+	if got := formatExecutorGroups([]ExecutorGroup{{Name: "default", Weight: 3}, {Name: "reports", Weight: 1}}); got != "default:3,reports" {
+		t.Errorf("Expected default:3,reports, got %s", got)
+	}
+}
+
+func TestGroupShares_Split(t *testing.T) {
+	shares := newGroupShares([]ExecutorGroup{{Name: "a", Weight: 3}, {Name: "b", Weight: 1}})
+	if got := shares.split(8); got[0] != 6 || got[1] != 2 {
+		t.Errorf("Expected a batch of 8 split 6 and 2, got %v", got)
+	}
+
+	// a batch smaller than the number of groups still gives every group its turn over time
+	shares = newGroupShares([]ExecutorGroup{{Name: "a", Weight: 1}, {Name: "b", Weight: 1}, {Name: "c", Weight: 1}})
+	total := make([]int, 3)
+	for i := 0; i < 3; i++ {
+		for g, n := range shares.split(1) {
+			total[g] += n
+		}
+	}
+	if total[0] != 1 || total[1] != 1 || total[2] != 1 {
+		t.Errorf("Expected each group to get one of three single batches, got %v", total)
+	}
+}
+
+func TestWorkflowManager_PollGroupsHandsSpareCapacityOn(t *testing.T) {
+	t.Setenv(config.ENGINE_BATCH_SIZE, "8")
+	t.Setenv(config.ENGINE_EXECUTOR_GROUP, "busy:1,quiet:1")
+
+	var mu sync.Mutex
+	asked := make(map[string][]int)
+	nextID := int64(0)
+	wfRepo := &ClaimingMockWorkflowRepo{
+		ClaimPendingWorkflowsFunc: func(size int, executorGroup string, executorId int64) (*[]domain.Workflow, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			asked[executorGroup] = append(asked[executorGroup], size)
+			n := size
+			if executorGroup == "quiet" {
+				n = 1
+			}
+			workflows := make([]domain.Workflow, 0, n)
+			for i := 0; i < n; i++ {
+				nextID++
+				workflows = append(workflows, domain.Workflow{ID: nextID, WorkflowType: "MockWorkflow", ExecutorGroup: executorGroup})
+			}
+			return &workflows, nil
+		},
+	}
+	registry := map[string]func() core.Workflow{
+		"MockWorkflow": func() core.Workflow { return &MockWorkflow{} },
+	}
+	wm := NewWorkflowManager(wfRepo, &MockWorkflowActionRepo{}, nil, nil, nil, &registry, core.NewRealClock())
+
+	wm.pollAndRunWorkflows(context.Background())
+
+	if fmt.Sprint(asked["busy"]) != "[4 3]" || fmt.Sprint(asked["quiet"]) != "[4]" {
+		t.Errorf("Expected busy to be offered its share and the 3 quiet did not need, got %v", asked)
+	}
+	if len(wm.queue) != 8 {
+		t.Errorf("Expected a full batch of 8 queued, got %d", len(wm.queue))
+	}
+}
+
+func TestWorkflowManager_WorkflowTreeStopsAtCyclesAndLimit(t *testing.T) {
+	parentOf := func(id int64) sql.NullInt64 { return sql.NullInt64{Int64: id, Valid: true} }
+	// workflow 1 and 2 are each other's parent, 2 has more children than a tree is loaded with
+	wfRepo := &MockWorkflowRepo{
+		FindByIDFunc: func(id int64) (*domain.Workflow, error) {
+			switch id {
+			case 1:
+				return &domain.Workflow{ID: 1, Status: "IN_PROGRESS", ParentWorkflowID: parentOf(2)}, nil
+			case 2:
+				return &domain.Workflow{ID: 2, Status: "IN_PROGRESS", ParentWorkflowID: parentOf(1)}, nil
+			}
+			return nil, sql.ErrNoRows
+		},
+		GetChildrenByParentIDFunc: func(parentID int64, onlyActive bool) (*[]domain.Workflow, error) {
+			var children []domain.Workflow
+			switch parentID {
+			case 1:
+				children = append(children, domain.Workflow{ID: 2, Status: "IN_PROGRESS", ParentWorkflowID: parentOf(1)})
+			case 2:
+				children = append(children, domain.Workflow{ID: 1, Status: "IN_PROGRESS", ParentWorkflowID: parentOf(2)})
+				for i := int64(0); i < maxTreeWorkflows; i++ {
+					children = append(children, domain.Workflow{ID: 100 + i, Status: "FINISHED", ParentWorkflowID: parentOf(2)})
+				}
+			}
+			return &children, nil
+		},
+	}
+	wm := NewWorkflowManager(wfRepo, &MockWorkflowActionRepo{}, nil, nil, nil, nil, core.NewRealClock())
+
+	tree, err := wm.WorkflowTree(1)
+	if err != nil {
+		t.Fatalf("WorkflowTree failed: %v", err)
+	}
+	if tree.Root.ID != 2 || len(tree.Root.Children) != maxTreeWorkflows-1 {
+		t.Fatalf("Expected the tree to start at workflow 2 cut at %d workflows, got root %d with %d children", maxTreeWorkflows, tree.Root.ID, len(tree.Root.Children))
+	}
+	if !tree.Truncated {
+		t.Error("Expected the tree to be marked truncated")
+	}
+	if got := tree.Root.Counts; got.Running != 2 || got.Finished != maxTreeWorkflows-2 {
+		t.Errorf("Expected 2 running and %d finished, got %+v", maxTreeWorkflows-2, got)
+	}
+}
+
+func TestWorkflowManager_PollReleasesWorkflowWithoutImplementation(t *testing.T) {
+	var released []int64
+	var delayed time.Time
+	var actions []string
+	wfRepo := &ClaimingMockWorkflowRepo{
+		MockWorkflowRepo: MockWorkflowRepo{
+			UpdateNextActivationSpecificFunc: func(id int64, next time.Time) error {
+				delayed = next
+				return nil
+			},
+			ReleaseWorkflowFunc: func(id int64, executorId int64) (bool, error) {
+				released = append(released, id)
+				return true, nil
+			},
+		},
+		ClaimPendingWorkflowsFunc: func(size int, executorGroup string, executorId int64) (*[]domain.Workflow, error) {
+			return &[]domain.Workflow{
+				{ID: 1, WorkflowType: "RemovedWorkflow", ExecutorGroup: executorGroup},
+				{ID: 2, WorkflowType: "MockWorkflow", ExecutorGroup: executorGroup},
+			}, nil
+		},
+	}
+	actionRepo := &MockWorkflowActionRepo{
+		SaveFunc: func(a *domain.WorkflowAction) (int64, error) {
+			actions = append(actions, a.Type)
+			return 1, nil
+		},
+	}
+	registry := map[string]func() core.Workflow{
+		"MockWorkflow": func() core.Workflow { return &MockWorkflow{} },
+	}
+	wm := NewWorkflowManager(wfRepo, actionRepo, nil, nil, nil, &registry, core.NewRealClock())
+
+	if taken := wm.pollGroup(context.Background(), "default", 2); taken != 1 {
+		t.Errorf("Expected 1 workflow taken, got %d", taken)
+	}
+	if len(wm.queue) != 1 {
+		t.Errorf("Expected the runnable workflow queued, got %d queued", len(wm.queue))
+	}
+	if fmt.Sprint(released) != "[1]" || time.Until(delayed) <= 0 {
+		t.Errorf("Expected workflow 1 released with a delay, got released %v next activation %v", released, delayed)
+	}
+	if fmt.Sprint(actions) != "[SCHEDULED RELEASED SCHEDULED]" {
+		t.Errorf("Expected the release recorded, got actions %v", actions)
+	}
+	if ids := wm.running.ids(); len(ids) != 1 || ids[0] != 2 {
+		t.Errorf("Expected only workflow 2 held, got %v", ids)
+	}
+}
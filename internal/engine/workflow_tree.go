@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"sort"
+
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/domain"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/models"
+)
+
+// maxTreeWorkflows caps how many workflows a tree is loaded with, the children of the deepest workflows are left out
+// of a larger tree.
+const maxTreeWorkflows = 1000
+
+// WorkflowTree returns the tree the workflow belongs to, from its topmost ancestor down to every descendant, with
+// the counts of each subtree by outcome.
+func (wm *WorkflowManager) WorkflowTree(id int64) (*models.WorkflowTreeResponse, error) {
+	wf, err := wm.WorkflowRepo.FindByID(id)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[int64]bool{wf.ID: true}
+	root := wf
+	for root.ParentWorkflowID.Valid && !seen[root.ParentWorkflowID.Int64] {
+		parent, err := wm.WorkflowRepo.FindByID(root.ParentWorkflowID.Int64)
+		if err != nil {
+			// the parent was deleted, the tree starts at the oldest ancestor still there
+			break
+		}
+		seen[parent.ID] = true
+		root = parent
+	}
+
+	// load the descendants level by level, so a tree too large is cut at its deepest workflows
+	children := make(map[int64][]domain.Workflow)
+	seen = map[int64]bool{root.ID: true}
+	level := []int64{root.ID}
+	truncated := false
+	for len(level) > 0 && !truncated {
+		var next []int64
+		for _, parentID := range level {
+			if truncated {
+				break
+			}
+			found, err := wm.WorkflowRepo.GetChildrenByParentID(parentID, false)
+			if err != nil {
+				return nil, err
+			}
+			if found == nil {
+				continue
+			}
+			for _, child := range *found {
+				if seen[child.ID] {
+					continue
+				}
+				if len(seen) >= maxTreeWorkflows {
+					truncated = true
+					break
+				}
+				seen[child.ID] = true
+				children[parentID] = append(children[parentID], child)
+				next = append(next, child.ID)
+			}
+		}
+		level = next
+	}
+
+	return &models.WorkflowTreeResponse{
+		WorkflowID: wf.ID,
+		Root:       treeNode(*root, children, wf.ID),
+		Truncated:  truncated,
+	}, nil
+}
+
+// treeNode builds the node of a workflow and its subtree from the children loaded for every workflow.
+func treeNode(wf domain.Workflow, children map[int64][]domain.Workflow, requested int64) models.WorkflowTreeNode {
+	node := models.WorkflowTreeNode{
+		ID:           wf.ID,
+		ExternalID:   wf.ExternalID,
+		BusinessKey:  wf.BusinessKey,
+		WorkflowType: wf.WorkflowType,
+		Status:       wf.Status,
+		State:        wf.State,
+		Requested:    wf.ID == requested,
+		Children:     []models.WorkflowTreeNode{},
+	}
+	if wf.ParentWorkflowID.Valid {
+		parentID := wf.ParentWorkflowID.Int64
+		node.ParentWorkflowID = &parentID
+	}
+	switch wf.Status {
+	case "FINISHED":
+		node.Counts.Finished++
+	case "FAILED", "ERROR", "CANCELLED":
+		node.Counts.Failed++
+	default:
+		node.Counts.Running++
+	}
+
+	own := children[wf.ID]
+	sort.Slice(own, func(i, j int) bool { return own[i].ID < own[j].ID })
+	for _, child := range own {
+		childNode := treeNode(child, children, requested)
+		node.Counts.Running += childNode.Counts.Running
+		node.Counts.Finished += childNode.Counts.Finished
+		node.Counts.Failed += childNode.Counts.Failed
+		node.Children = append(node.Children, childNode)
+	}
+	return node
+}
@@ -0,0 +1,12 @@
+-- Signals sent to workflows by external systems, consumed from within a state (MySQL)
+CREATE TABLE IF NOT EXISTS workflow_signals (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    workflow_id BIGINT NOT NULL,
+    name TEXT NOT NULL,
+    payload LONGTEXT,
+    created DATETIME(3),
+    consumed DATETIME(3) NULL,
+    CONSTRAINT fk_workflow_signals_workflow FOREIGN KEY (workflow_id) REFERENCES workflow (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE INDEX idx_workflow_signals_workflow_id_consumed ON workflow_signals (workflow_id, consumed);
@@ -0,0 +1,20 @@
+-- Recurring schedules that create a workflow each time their cron expression fires (MySQL)
+CREATE TABLE IF NOT EXISTS workflow_schedules (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    cron_expression VARCHAR(255) NOT NULL,
+    timezone VARCHAR(64) NOT NULL,
+    workflow_type VARCHAR(255) NOT NULL,
+    executor_group VARCHAR(255) NOT NULL,
+    business_key TEXT,
+    state_vars LONGTEXT,
+    overlap_policy VARCHAR(32) NOT NULL,
+    enabled BOOLEAN NOT NULL,
+    next_run DATETIME(3) NULL,
+    last_run DATETIME(3) NULL,
+    last_workflow_id BIGINT NULL,
+    created DATETIME(3),
+    modified DATETIME(3)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE INDEX idx_workflow_schedules_enabled_next_run ON workflow_schedules (enabled, next_run);
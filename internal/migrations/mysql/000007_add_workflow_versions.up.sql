@@ -0,0 +1,17 @@
+-- Version of the workflow definition an instance was created with, existing instances predate versioning and run version 1
+ALTER TABLE workflow ADD COLUMN workflow_version INT NOT NULL DEFAULT 1;
+-- Version of the definition that new instances are created with
+ALTER TABLE workflow_definitions ADD COLUMN version INT NOT NULL DEFAULT 1;
+
+-- Every version of a workflow definition that has been registered (MySQL)
+CREATE TABLE IF NOT EXISTS workflow_definition_versions (
+    name VARCHAR(255) NOT NULL,
+    version INT NOT NULL,
+    description TEXT,
+    states LONGTEXT,
+    transitions LONGTEXT,
+    flow_chart LONGTEXT,
+    created DATETIME(3),
+    updated DATETIME(3),
+    PRIMARY KEY (name, version)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,3 @@
+DROP INDEX idx_workflow_lease_expires ON workflow;
+ALTER TABLE workflow DROP COLUMN lease_expires;
+ALTER TABLE workflow DROP COLUMN lease_owner;
@@ -0,0 +1 @@
+ALTER TABLE executors DROP COLUMN stopped;
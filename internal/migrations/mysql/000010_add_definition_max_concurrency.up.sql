@@ -0,0 +1,2 @@
+-- Most workflows of the type that executors may hold at the same time across the cluster, 0 is unlimited
+ALTER TABLE workflow_definitions ADD COLUMN max_concurrency INT NOT NULL DEFAULT 0;
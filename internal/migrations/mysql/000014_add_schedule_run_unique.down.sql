@@ -0,0 +1,2 @@
+DROP INDEX idx_workflow_schedule_run ON workflow;
+ALTER TABLE workflow DROP COLUMN schedule_run;
@@ -0,0 +1,4 @@
+-- A schedule run creates its workflow once, two schedulers racing for the same run cannot both insert it.
+-- MySQL has no partial indexes, the unique index is on a column that only holds the external id of scheduled workflows
+ALTER TABLE workflow ADD COLUMN schedule_run VARCHAR(191) AS (CASE WHEN external_id LIKE 'schedule-%' THEN external_id END) STORED;
+CREATE UNIQUE INDEX idx_workflow_schedule_run ON workflow (schedule_run);
@@ -0,0 +1,2 @@
+-- Status a workflow had before it was paused, restored on resume
+ALTER TABLE workflow ADD COLUMN paused_status TEXT NULL;
@@ -0,0 +1,12 @@
+-- Signals sent to workflows by external systems, consumed from within a state
+CREATE TABLE IF NOT EXISTS workflow_signals (
+    id BIGSERIAL PRIMARY KEY,
+    workflow_id BIGINT NOT NULL,
+    name TEXT NOT NULL,
+    payload TEXT,
+    created TIMESTAMPTZ,
+    consumed TIMESTAMPTZ NULL,
+    CONSTRAINT fk_workflow_signals_workflow FOREIGN KEY (workflow_id) REFERENCES workflow (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_workflow_signals_workflow_id_consumed ON workflow_signals (workflow_id, consumed);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS workflow_definition_versions;
+ALTER TABLE workflow_definitions DROP COLUMN IF EXISTS version;
+ALTER TABLE workflow DROP COLUMN IF EXISTS workflow_version;
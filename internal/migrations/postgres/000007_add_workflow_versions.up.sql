@@ -0,0 +1,17 @@
+-- Version of the workflow definition an instance was created with, existing instances predate versioning and run version 1
+ALTER TABLE workflow ADD COLUMN workflow_version INT NOT NULL DEFAULT 1;
+-- Version of the definition that new instances are created with
+ALTER TABLE workflow_definitions ADD COLUMN version INT NOT NULL DEFAULT 1;
+
+-- Every version of a workflow definition that has been registered
+CREATE TABLE IF NOT EXISTS workflow_definition_versions (
+    name TEXT NOT NULL,
+    version INT NOT NULL,
+    description TEXT,
+    states TEXT,
+    transitions TEXT,
+    flow_chart TEXT,
+    created TIMESTAMPTZ,
+    updated TIMESTAMPTZ,
+    PRIMARY KEY (name, version)
+);
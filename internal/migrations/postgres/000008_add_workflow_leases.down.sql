@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_workflow_lease_expires;
+ALTER TABLE workflow DROP COLUMN IF EXISTS lease_expires;
+ALTER TABLE workflow DROP COLUMN IF EXISTS lease_owner;
@@ -0,0 +1,4 @@
+-- Lease of the executor running a workflow, renewed while its state runs and released when it is done
+ALTER TABLE workflow ADD COLUMN lease_owner BIGINT;
+ALTER TABLE workflow ADD COLUMN lease_expires TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS idx_workflow_lease_expires ON workflow (lease_expires);
@@ -0,0 +1,2 @@
+-- Set when an executor shut down gracefully, its workflows were handed back before it stopped
+ALTER TABLE executors ADD COLUMN stopped TIMESTAMPTZ;
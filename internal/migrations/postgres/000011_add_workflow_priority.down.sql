@@ -0,0 +1 @@
+ALTER TABLE workflow DROP COLUMN IF EXISTS priority;
@@ -0,0 +1 @@
+ALTER TABLE executors DROP COLUMN IF EXISTS executor_groups;
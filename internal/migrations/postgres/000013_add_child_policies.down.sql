@@ -0,0 +1,2 @@
+ALTER TABLE workflow DROP COLUMN IF EXISTS failure_policy;
+ALTER TABLE workflow DROP COLUMN IF EXISTS parent_close_policy;
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_workflow_schedule_run;
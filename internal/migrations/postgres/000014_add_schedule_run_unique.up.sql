@@ -0,0 +1,2 @@
+-- A schedule run creates its workflow once, two schedulers racing for the same run cannot both insert it
+CREATE UNIQUE INDEX IF NOT EXISTS idx_workflow_schedule_run ON workflow (external_id) WHERE external_id LIKE 'schedule-%';
@@ -0,0 +1 @@
+ALTER TABLE workflow DROP COLUMN paused_status;
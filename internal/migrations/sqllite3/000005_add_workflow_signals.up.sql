@@ -0,0 +1,12 @@
+-- Signals sent to workflows by external systems, consumed from within a state (SQLite3)
+CREATE TABLE IF NOT EXISTS workflow_signals (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    workflow_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    payload TEXT,
+    created DATETIME,
+    consumed DATETIME NULL,
+    FOREIGN KEY (workflow_id) REFERENCES workflow (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_workflow_signals_workflow_id_consumed ON workflow_signals (workflow_id, consumed);
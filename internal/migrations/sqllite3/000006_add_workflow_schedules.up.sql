@@ -0,0 +1,20 @@
+-- Recurring schedules that create a workflow each time their cron expression fires (SQLite3)
+CREATE TABLE IF NOT EXISTS workflow_schedules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    cron_expression TEXT NOT NULL,
+    timezone TEXT NOT NULL,
+    workflow_type TEXT NOT NULL,
+    executor_group TEXT NOT NULL,
+    business_key TEXT,
+    state_vars TEXT,
+    overlap_policy TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL,
+    next_run DATETIME NULL,
+    last_run DATETIME NULL,
+    last_workflow_id INTEGER NULL,
+    created DATETIME,
+    modified DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_workflow_schedules_enabled_next_run ON workflow_schedules (enabled, next_run);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS workflow_definition_versions;
+ALTER TABLE workflow_definitions DROP COLUMN version;
+ALTER TABLE workflow DROP COLUMN workflow_version;
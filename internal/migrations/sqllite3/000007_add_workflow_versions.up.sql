@@ -0,0 +1,17 @@
+-- Version of the workflow definition an instance was created with, existing instances predate versioning and run version 1
+ALTER TABLE workflow ADD COLUMN workflow_version INTEGER NOT NULL DEFAULT 1;
+-- Version of the definition that new instances are created with
+ALTER TABLE workflow_definitions ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+-- Every version of a workflow definition that has been registered (SQLite3)
+CREATE TABLE IF NOT EXISTS workflow_definition_versions (
+    name TEXT NOT NULL,
+    version INTEGER NOT NULL,
+    description TEXT,
+    states TEXT,
+    transitions TEXT,
+    flow_chart TEXT,
+    created DATETIME,
+    updated DATETIME,
+    PRIMARY KEY (name, version)
+);
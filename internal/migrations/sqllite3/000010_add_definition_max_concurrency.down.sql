@@ -0,0 +1 @@
+ALTER TABLE workflow_definitions DROP COLUMN max_concurrency;
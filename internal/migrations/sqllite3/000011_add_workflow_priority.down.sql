@@ -0,0 +1 @@
+ALTER TABLE workflow DROP COLUMN priority;
@@ -0,0 +1,2 @@
+-- Workflows with a higher priority are claimed first, waiting workflows gain priority as they age
+ALTER TABLE workflow ADD COLUMN priority INTEGER NOT NULL DEFAULT 0;
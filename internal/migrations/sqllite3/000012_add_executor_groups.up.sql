@@ -0,0 +1,2 @@
+-- The executor groups an executor takes workflows from, with their weights, e.g. default:3,reports
+ALTER TABLE executors ADD COLUMN executor_groups TEXT;
@@ -0,0 +1,2 @@
+ALTER TABLE workflow DROP COLUMN failure_policy;
+ALTER TABLE workflow DROP COLUMN parent_close_policy;
@@ -0,0 +1,3 @@
+-- What happens to a child workflow when its parent ends, and what the child failing does to its parent
+ALTER TABLE workflow ADD COLUMN parent_close_policy TEXT NOT NULL DEFAULT '';
+ALTER TABLE workflow ADD COLUMN failure_policy TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/RealZimboGuy/gopherflow/internal/config"
+	domain "github.com/RealZimboGuy/gopherflow/pkg/gopherflow/domain"
+)
+
+// limitedTypes selects the workflow types whose definition sets a max_concurrency.
+const limitedTypes = `(SELECT name FROM workflow_definitions WHERE max_concurrency > 0)`
+
+// endedStatuses are the statuses of workflows that ended, they hold no slot even while their executor is still set.
+const endedStatuses = `('FINISHED', 'FAILED', 'ERROR')`
+
+// freeSlots selects, for every limited workflow type, how many more of its workflows executors may take.
+const freeSlots = `
+	SELECT d.name, d.max_concurrency - COUNT(held.id) AS free
+	FROM workflow_definitions d
+	LEFT JOIN workflow held ON held.workflow_type = d.name AND held.executor_id IS NOT NULL AND held.status NOT IN ` + endedStatuses + `
+	WHERE d.max_concurrency > 0
+	GROUP BY d.name, d.max_concurrency`
+
+// lockForUpdate is appended to a SELECT to lock the rows it reads until the transaction ends. SQLite has no row
+// locks, it allows a single writer at a time instead.
+func lockForUpdate() string {
+	if config.GetSystemSettingString(config.DATABASE_TYPE) == config.DATABASE_TYPE_SQLLITE {
+		return ""
+	}
+	return ` FOR UPDATE`
+}
+
+// pendingLimitedWorkflows selects the pending workflows of limited types, at most as many of each type as it has free
+// slots. The executor group is bound at index i.
+func (r *WorkflowRepository) pendingLimitedWorkflows(i int) string {
+	return `
+		SELECT ` + ALL_COLUMNS + `
+		FROM (
+			SELECT ` + ALL_COLUMNS + `, ROW_NUMBER() OVER (PARTITION BY workflow_type ORDER BY ` + priorityOrder(r.clock) + `) AS type_rank
+			FROM workflow
+			WHERE  ` + dateBeforeNow("next_activation", r.clock) + `
+			  AND status in ('NEW', 'IN_PROGRESS')
+			  AND executor_id IS NULL
+			  AND executor_group = ` + placeholder(i) + `
+			  AND workflow_type IN ` + limitedTypes + `
+		) ranked
+		JOIN (` + freeSlots + `) slots ON slots.name = ranked.workflow_type
+		WHERE ranked.type_rank <= slots.free`
+}
+
+// CountRunningWorkflows returns how many workflows of a type are held by an executor, scheduled or executing. This is
+// the usage the max_concurrency of the definition limits.
+func (r *WorkflowRepository) CountRunningWorkflows(workflowType string) (int, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM workflow WHERE workflow_type = `+placeholder(1)+` AND executor_id IS NOT NULL AND status NOT IN `+endedStatuses, workflowType).Scan(&count)
+	return count, err
+}
+
+// concurrencyLimit returns the type of a workflow and the max_concurrency of its definition, 0 when the type is
+// unlimited or has no definition.
+func (r *WorkflowRepository) concurrencyLimit(id int64) (string, int, error) {
+	var workflowType string
+	var limit int
+	err := r.db.QueryRow(`
+		SELECT d.name, d.max_concurrency
+		FROM workflow w
+		JOIN workflow_definitions d ON d.name = w.workflow_type
+		WHERE w.id = `+placeholder(1), id).Scan(&workflowType, &limit)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", 0, nil
+	}
+	return workflowType, limit, err
+}
+
+// markScheduledWithinLimit marks a workflow of a limited type as scheduled when the type has a free slot. The
+// definition row is locked while the slots are counted, so executors scheduling the same type take turns.
+func (r *WorkflowRepository) markScheduledWithinLimit(workflowType string, id int64, executorId int64, modified time.Time) (bool, error) {
+	marked := false
+	err := r.InTransaction(context.Background(), func(tx *Tx) error {
+		var limit int
+		err := tx.Workflows.db.QueryRow(`SELECT max_concurrency FROM workflow_definitions WHERE name = `+placeholder(1)+lockForUpdate(), workflowType).Scan(&limit)
+		if err != nil {
+			return err
+		}
+		if limit > 0 {
+			running, err := tx.Workflows.CountRunningWorkflows(workflowType)
+			if err != nil || running >= limit {
+				return err
+			}
+		}
+		marked, err = tx.Workflows.markScheduled(id, executorId, modified)
+		return err
+	})
+	return marked, err
+}
+
+// findPendingLimitedWorkflows returns up to size pending workflows of limited types within the free slots of their
+// type, in priority order.
+func (r *WorkflowRepository) findPendingLimitedWorkflows(size int, executorGroup string) ([]domain.Workflow, error) {
+	rows, err := r.db.Query(r.pendingLimitedWorkflows(1)+`
+		ORDER BY `+priorityOrder(r.clock)+`
+		LIMIT `+placeholder(2), executorGroup, size)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanWorkflows(rows)
+}
@@ -31,9 +31,9 @@ func (r *ExecutorRepository) Save(e *domain.Executor) (int64, error) {
 	if lastActive.IsZero() {
 		lastActive = started
 	}
-	vals := []interface{}{e.Name, formatDateInDatabase(started), formatDateInDatabase(lastActive)}
-	pps := []string{placeholder(1), placeholder(2), placeholder(3)}
-	base := `INSERT INTO executors (name, started, last_active) VALUES (` + strings.Join(pps, ", ") + `)`
+	vals := []interface{}{e.Name, e.Groups, formatDateInDatabase(started), formatDateInDatabase(lastActive)}
+	pps := []string{placeholder(1), placeholder(2), placeholder(3), placeholder(4)}
+	base := `INSERT INTO executors (name, executor_groups, started, last_active) VALUES (` + strings.Join(pps, ", ") + `)`
 	if supportsReturning() {
 		query := base + " RETURNING id"
 		if err := r.db.QueryRow(query, vals...).Scan(&e.ID); err != nil {
@@ -62,9 +62,15 @@ func (r *ExecutorRepository) UpdateLastActive(id int64, ts time.Time) error {
 	_, err := r.db.Exec(query, formatDateInDatabase(ts), id)
 	return err
 }
+// MarkStopped records that the executor shut down gracefully at the provided timestamp.
+func (r *ExecutorRepository) MarkStopped(id int64, ts time.Time) error {
+	query := `UPDATE executors SET stopped = ` + placeholder(1) + `, last_active = ` + placeholder(2) + ` WHERE id = ` + placeholder(3) + ``
+	_, err := r.db.Exec(query, formatDateInDatabase(ts), formatDateInDatabase(ts), id)
+	return err
+}
 func (r *ExecutorRepository) GetExecutorsByLastActive(limit int) ([]*domain.Executor, error) {
 	query := `
-		SELECT id, name, started, last_active
+		SELECT id, name, executor_groups, started, last_active, stopped
 		FROM executors
 		ORDER BY last_active DESC
 		LIMIT ` + placeholder(1) + `
@@ -78,10 +84,12 @@ func (r *ExecutorRepository) GetExecutorsByLastActive(limit int) ([]*domain.Exec
 	var executors []*domain.Executor
 	for rows.Next() {
 		var e domain.Executor
+		var groups sql.NullString // executors registered before groups were recorded have none
 		//var lastActive time.Time
-		if err := rows.Scan(&e.ID, &e.Name, &e.Started, &e.LastActive); err != nil {
+		if err := rows.Scan(&e.ID, &e.Name, &groups, &e.Started, &e.LastActive, &e.Stopped); err != nil {
 			return nil, err
 		}
+		e.Groups = groups.String
 
 		//e.LastActive = lastActive.UTC() // treat DB time as UTC
 
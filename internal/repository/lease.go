@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/RealZimboGuy/gopherflow/internal/config"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/core"
+)
+
+// releaseLease is set together with executor_id = NULL, a workflow without an executor holds no lease.
+const releaseLease = `lease_owner = NULL, lease_expires = NULL`
+
+// LeaseDuration is how long an executor holds a workflow without renewing its lease.
+func LeaseDuration() time.Duration {
+	d, err := time.ParseDuration(config.GetSystemSettingString(config.ENGINE_LEASE_DURATION))
+	if err != nil || d <= 0 {
+		slog.Warn("Invalid lease duration, using 60s", "value", config.GetSystemSettingString(config.ENGINE_LEASE_DURATION))
+		return time.Minute
+	}
+	return d
+}
+
+// acquireLease returns the SET expression that gives the lease of a workflow to the executor bound at index i.
+func acquireLease(i int, clock core.Clock) string {
+	return `lease_owner = ` + placeholder(i) + `, lease_expires = ` + leaseExpires(clock)
+}
+
+// leaseExpires returns the expiry of a lease taken or renewed now, formatted like nowFunc.
+func leaseExpires(clock core.Clock) string {
+	expires := clock.Now().Add(LeaseDuration()).UTC()
+	if config.GetSystemSettingString(config.DATABASE_TYPE) == config.DATABASE_TYPE_SQLLITE {
+		return fmt.Sprintf("'%s'", expires.Format("2006-01-02 15:04:05.000"))
+	}
+	return fmt.Sprintf("'%s'", expires.Format("2006-01-02 15:04:05.000000"))
+}
+
+// RenewLeases extends the leases the executor holds on the given workflows and returns how many it still held.
+// The modified column is left alone, a renewal is not a change to the workflow.
+func (r *WorkflowRepository) RenewLeases(executorId int64, ids []int64) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	args := make([]any, 0, len(ids)+1)
+	args = append(args, executorId)
+	in := make([]string, 0, len(ids))
+	for i, id := range ids {
+		args = append(args, id)
+		in = append(in, placeholder(i+2))
+	}
+	query := `
+		UPDATE workflow
+		SET lease_expires = ` + leaseExpires(r.clock) + `
+		WHERE lease_owner = ` + placeholder(1) + ` AND id IN (` + strings.Join(in, ", ") + `)
+	`
+	result, err := r.db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ReclaimExpiredLease takes a workflow away from an executor whose lease on it has expired, guarded by modified
+// like LockWorkflowByModified. It fails when the owner renewed the lease in the meantime. The reclaimed workflow is
+// released and due straight away in the same update, it is never left without an executor and not pending.
+func (r *WorkflowRepository) ReclaimExpiredLease(id int64, modified time.Time) bool {
+	query := `
+		UPDATE workflow
+		SET status = ` + engineStatus("'IN_PROGRESS'") + `, executor_id = NULL, ` + releaseLease + `, retry_count = retry_count + 1,
+		    next_activation = ` + nowFunc(r.clock) + `, modified = ` + nowFunc(r.clock) + `
+		WHERE id = ` + placeholder(1) + ` AND modified = ` + placeholder(2) + `
+		  AND (lease_expires IS NULL OR ` + dateBeforeNow("lease_expires", r.clock) + `)
+	`
+	result, err := r.db.Exec(query, id, formatDateInDatabase(modified))
+	if err != nil {
+		slog.Error("Failed to reclaim expired lease", "error", err, "id", id)
+		return false
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil || rowsAffected != 1 {
+		return false
+	}
+	r.notifyWorkflow(id)
+	return true
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/RealZimboGuy/gopherflow/internal/config"
+	"github.com/lib/pq"
+)
+
+// notifyChannelPrefix is followed by the executor group, Postgres limits channel names to 63 bytes.
+const notifyChannelPrefix = "gopherflow_"
+
+// NotifyChannel returns the Postgres channel that the executors of a group LISTEN on.
+func NotifyChannel(group string) string {
+	channel := notifyChannelPrefix + group
+	if len(channel) > 63 {
+		channel = channel[:63]
+	}
+	return channel
+}
+
+// notificationsEnabled reports whether workflow changes are announced with NOTIFY, which only Postgres supports.
+func notificationsEnabled() bool {
+	return config.GetSystemSettingString(config.DATABASE_TYPE) == config.DATABASE_TYPE_POSTGRES &&
+		config.GetSystemSettingString(config.ENGINE_NOTIFY_ENABLED) != "false"
+}
+
+// notifyWorkflow sends the id of a workflow on the channel of its executor group. Inside a transaction the
+// notification is only delivered once the transaction commits. A failed notification is logged, polling picks the
+// workflow up instead.
+func (r *WorkflowRepository) notifyWorkflow(id int64) {
+	if !notificationsEnabled() {
+		return
+	}
+	query := `SELECT pg_notify(LEFT('` + notifyChannelPrefix + `' || executor_group, 63), CAST(id AS TEXT)) FROM workflow WHERE id = $1`
+	if _, err := r.db.Exec(query, id); err != nil {
+		slog.Warn("Failed to notify workflow change", "workflow_id", id, "error", err)
+	}
+}
+
+// ListenForWorkflows LISTENs on the channels of the executor groups and sends the id of every workflow notified on
+// them until ctx is done. After the connection was lost and restored a 0 is sent, as notifications may have been missed.
+func ListenForWorkflows(ctx context.Context, dbURL string, groups []string) (<-chan int64, error) {
+	listener := pq.NewListener(dbURL, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			slog.Warn("Workflow notification listener event", "event", ev, "error", err)
+		}
+	})
+	for _, group := range groups {
+		if err := listener.Listen(NotifyChannel(group)); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+
+	ids := make(chan int64, 64)
+	go func() {
+		defer close(ids)
+		defer listener.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n := <-listener.Notify:
+				var id int64
+				if n != nil {
+					// a nil notification follows a reconnect
+					id, _ = strconv.ParseInt(n.Extra, 10, 64)
+				}
+				select {
+				case ids <- id:
+				default:
+					// the receiver is busy polling already, it finds this workflow as well
+				}
+			}
+		}
+	}()
+	return ids, nil
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/RealZimboGuy/gopherflow/internal/config"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/core"
+)
+
+// PriorityAging is how long a due workflow waits to gain one priority level, so that a flood of higher priority
+// workflows does not starve the others.
+func PriorityAging() time.Duration {
+	d, err := time.ParseDuration(config.GetSystemSettingString(config.ENGINE_PRIORITY_AGING))
+	if err != nil || d <= 0 {
+		slog.Warn("Invalid priority aging, using 1m", "value", config.GetSystemSettingString(config.ENGINE_PRIORITY_AGING))
+		return time.Minute
+	}
+	return d
+}
+
+// priorityOrder returns the ORDER BY terms that claim pending workflows by their aged priority, the priority plus a
+// level for every PriorityAging they have been due, and the longest due first among equals.
+func priorityOrder(clock core.Clock) string {
+	return fmt.Sprintf("priority + %s / %.3f DESC, next_activation ASC", secondsSince("next_activation", clock), PriorityAging().Seconds())
+}
+
+// agedPriority is the aged priority that priorityOrder sorts by, for workflows ranked after they were read.
+func agedPriority(priority int, nextActivation time.Time, clock core.Clock) float64 {
+	return float64(priority) + clock.Now().Sub(nextActivation).Seconds()/PriorityAging().Seconds()
+}
+
+// UpdatePriority changes the priority of a workflow, it returns false when there is no workflow with the id.
+func (r *WorkflowRepository) UpdatePriority(id int64, priority int) (bool, error) {
+	query := `
+		UPDATE workflow
+		SET priority = ` + placeholder(1) + `, modified = ` + nowFunc(r.clock) + `
+		WHERE id = ` + placeholder(2) + `
+	`
+	result, err := r.db.Exec(query, priority, id)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/core"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/domain"
+)
+
+// ScheduleRepository provides persistence methods for the workflow_schedules table.
+type ScheduleRepository struct {
+	db    DBTX
+	clock core.Clock
+}
+
+func NewScheduleRepository(db *sql.DB, clock core.Clock) *ScheduleRepository {
+	return &ScheduleRepository{db: db, clock: clock}
+}
+
+const scheduleColumns = `id, name, cron_expression, timezone, workflow_type, executor_group, business_key,
+		state_vars, overlap_policy, enabled, next_run, last_run, last_workflow_id, created, modified`
+
+func scanSchedule(row interface{ Scan(dest ...any) error }) (*domain.Schedule, error) {
+	var s domain.Schedule
+	var stateVars sql.NullString
+	err := row.Scan(&s.ID, &s.Name, &s.CronExpression, &s.Timezone, &s.WorkflowType, &s.ExecutorGroup, &s.BusinessKey,
+		&stateVars, &s.OverlapPolicy, &s.Enabled, &s.NextRun, &s.LastRun, &s.LastWorkflowID, &s.Created, &s.Modified)
+	if err != nil {
+		return nil, err
+	}
+	s.StateVars = stateVars.String
+	return &s, nil
+}
+
+// Save inserts a new schedule and returns its generated id.
+func (r *ScheduleRepository) Save(s *domain.Schedule) (int64, error) {
+	now := r.clock.Now().UTC()
+	s.Created = now
+	s.Modified = now
+	base := `
+		INSERT INTO workflow_schedules (name, cron_expression, timezone, workflow_type, executor_group, business_key,
+			state_vars, overlap_policy, enabled, next_run, created, modified)
+		VALUES (` + placeholder(1) + `, ` + placeholder(2) + `, ` + placeholder(3) + `, ` + placeholder(4) + `, ` + placeholder(5) + `, ` + placeholder(6) + `,
+			` + placeholder(7) + `, ` + placeholder(8) + `, ` + placeholder(9) + `, ` + placeholder(10) + `, ` + placeholder(11) + `, ` + placeholder(12) + `)`
+	vals := []any{s.Name, s.CronExpression, s.Timezone, s.WorkflowType, s.ExecutorGroup, s.BusinessKey,
+		s.StateVars, s.OverlapPolicy, s.Enabled, formatDateInDatabaseNull(s.NextRun), formatDateInDatabase(s.Created), formatDateInDatabase(s.Modified)}
+	if supportsReturning() {
+		err := r.db.QueryRow(base+" RETURNING id", vals...).Scan(&s.ID)
+		return s.ID, err
+	}
+	res, err := r.db.Exec(base, vals...)
+	if err != nil {
+		return 0, err
+	}
+	s.ID, err = res.LastInsertId()
+	return s.ID, err
+}
+
+// Update stores the editable fields of a schedule together with its recomputed next run.
+func (r *ScheduleRepository) Update(s *domain.Schedule) error {
+	s.Modified = r.clock.Now().UTC()
+	query := `
+		UPDATE workflow_schedules
+		SET name = ` + placeholder(1) + `,
+			cron_expression = ` + placeholder(2) + `,
+			timezone = ` + placeholder(3) + `,
+			workflow_type = ` + placeholder(4) + `,
+			executor_group = ` + placeholder(5) + `,
+			business_key = ` + placeholder(6) + `,
+			state_vars = ` + placeholder(7) + `,
+			overlap_policy = ` + placeholder(8) + `,
+			enabled = ` + placeholder(9) + `,
+			next_run = ` + placeholder(10) + `,
+			modified = ` + placeholder(11) + `
+		WHERE id = ` + placeholder(12) + `
+	`
+	_, err := r.db.Exec(query, s.Name, s.CronExpression, s.Timezone, s.WorkflowType, s.ExecutorGroup, s.BusinessKey,
+		s.StateVars, s.OverlapPolicy, s.Enabled, formatDateInDatabaseNull(s.NextRun), formatDateInDatabase(s.Modified), s.ID)
+	return err
+}
+
+// FindByID fetches a schedule by its id.
+func (r *ScheduleRepository) FindByID(id int64) (*domain.Schedule, error) {
+	query := `SELECT ` + scheduleColumns + ` FROM workflow_schedules WHERE id = ` + placeholder(1)
+	return scanSchedule(r.db.QueryRow(query, id))
+}
+
+// FindByName fetches a schedule by its unique name.
+func (r *ScheduleRepository) FindByName(name string) (*domain.Schedule, error) {
+	query := `SELECT ` + scheduleColumns + ` FROM workflow_schedules WHERE name = ` + placeholder(1)
+	return scanSchedule(r.db.QueryRow(query, name))
+}
+
+// FindAll returns all schedules ordered by name.
+func (r *ScheduleRepository) FindAll() (*[]domain.Schedule, error) {
+	return r.query(`SELECT ` + scheduleColumns + ` FROM workflow_schedules ORDER BY name`)
+}
+
+// FindDueSchedules returns enabled schedules of the given workflow types whose next run has passed, oldest first.
+// An executor only asks for the types it has registered, the others can not be created by it.
+func (r *ScheduleRepository) FindDueSchedules(workflowTypes []string, limit int) (*[]domain.Schedule, error) {
+	if len(workflowTypes) == 0 {
+		return &[]domain.Schedule{}, nil
+	}
+	args := make([]any, 0, len(workflowTypes)+2)
+	args = append(args, true)
+	in := make([]string, 0, len(workflowTypes))
+	for i, workflowType := range workflowTypes {
+		args = append(args, workflowType)
+		in = append(in, placeholder(i+2))
+	}
+	args = append(args, limit)
+	query := `
+		SELECT ` + scheduleColumns + `
+		FROM workflow_schedules
+		WHERE enabled = ` + placeholder(1) + `
+		AND workflow_type IN (` + strings.Join(in, ", ") + `)
+		AND next_run IS NOT NULL
+		AND ` + dateBeforeNow("next_run", r.clock) + `
+		ORDER BY next_run ASC
+		LIMIT ` + placeholder(len(workflowTypes)+2) + `
+	`
+	return r.query(query, args...)
+}
+
+func (r *ScheduleRepository) query(query string, args ...any) (*[]domain.Schedule, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []domain.Schedule{}
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, *s)
+	}
+	return &schedules, rows.Err()
+}
+
+// ClaimScheduleRun moves a schedule from the run it was due at on to its next run.
+// The update only matches while next_run still holds the due time, so exactly one executor wins each run.
+func (r *ScheduleRepository) ClaimScheduleRun(id int64, dueAt time.Time, nextRun sql.NullTime) bool {
+	query := `
+		UPDATE workflow_schedules
+		SET next_run = ` + placeholder(1) + `,
+			last_run = ` + placeholder(2) + `,
+			modified = ` + nowFunc(r.clock) + `
+		WHERE id = ` + placeholder(3) + `
+		AND next_run = ` + placeholder(4) + `
+	`
+	res, err := r.db.Exec(query, formatDateInDatabaseNull(nextRun), formatDateInDatabase(dueAt), id, formatDateInDatabase(dueAt))
+	if err != nil {
+		return false
+	}
+	n, err := res.RowsAffected()
+	return err == nil && n == 1
+}
+
+// SetLastWorkflow records the workflow created by the most recent run of a schedule.
+func (r *ScheduleRepository) SetLastWorkflow(id int64, workflowID int64) error {
+	query := `
+		UPDATE workflow_schedules
+		SET last_workflow_id = ` + placeholder(1) + `
+		WHERE id = ` + placeholder(2) + `
+	`
+	if _, err := r.db.Exec(query, workflowID, id); err != nil {
+		return fmt.Errorf("failed to set last workflow of schedule: %w", err)
+	}
+	return nil
+}
+
+// DeleteByID removes a schedule, workflows it already created are kept.
+func (r *ScheduleRepository) DeleteByID(id int64) error {
+	query := `DELETE FROM workflow_schedules WHERE id = ` + placeholder(1)
+	_, err := r.db.Exec(query, id)
+	return err
+}
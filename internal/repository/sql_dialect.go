@@ -1,10 +1,15 @@
 package repository
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/RealZimboGuy/gopherflow/internal/config"
 	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/core"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
 )
 
 // placeholder returns the correct bind variable for the given index based on DB type.
@@ -17,6 +22,32 @@ func placeholder(i int) string {
 	return "?"
 }
 
+// placeholders returns n comma separated placeholders numbered from i, for an IN list.
+func placeholders(i int, n int) string {
+	list := make([]string, 0, n)
+	for j := 0; j < n; j++ {
+		list = append(list, placeholder(i+j))
+	}
+	return strings.Join(list, ", ")
+}
+
+// IsUniqueViolation reports whether err is a database refusing a row that would break a unique index.
+func IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1062
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	return false
+}
+
 func nowFunc(clock core.Clock) string {
 	// Format the clock's current time in UTC with microsecond precision
 
@@ -52,6 +83,21 @@ func dateBeforeNow(column string, clock core.Clock) string {
 	}
 }
 
+// secondsSince returns a DB-specific SQL expression of the seconds between the datetime column and the current time.
+func secondsSince(column string, clock core.Clock) string {
+	now := clock.Now().UTC().Format("2006-01-02 15:04:05.000")
+
+	db := config.GetSystemSettingString(config.DATABASE_TYPE)
+	switch db {
+	case config.DATABASE_TYPE_POSTGRES:
+		return fmt.Sprintf("EXTRACT(EPOCH FROM (CAST('%s' AS TIMESTAMPTZ) - %s))", now, column)
+	case config.DATABASE_TYPE_MYSQL:
+		return fmt.Sprintf("TIMESTAMPDIFF(SECOND, %s, '%s')", column, now)
+	default:
+		return fmt.Sprintf("((julianday('%s') - julianday(%s)) * 86400)", now, column)
+	}
+}
+
 func supportsReturning() bool {
 	return config.GetSystemSettingString(config.DATABASE_TYPE) == config.DATABASE_TYPE_POSTGRES
 }
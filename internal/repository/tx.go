@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+)
+
+// DBTX is the part of *sql.DB and *sql.Tx the repositories use, so the same queries run inside a transaction.
+type DBTX interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// Tx is a unit of work, the repositories it holds all write through one database transaction.
+type Tx struct {
+	Workflows *WorkflowRepository
+	Actions   *WorkflowActionRepository
+	Schedules *ScheduleRepository
+}
+
+// InTransaction runs fn in a database transaction, it is committed when fn returns nil and rolled back when fn
+// returns an error or panics. On a repository that is already bound to a transaction fn joins that transaction.
+func (r *WorkflowRepository) InTransaction(ctx context.Context, fn func(tx *Tx) error) error {
+	if r.conn == nil {
+		return fn(&Tx{
+			Workflows: r,
+			Actions:   &WorkflowActionRepository{db: r.db, clock: r.clock},
+			Schedules: &ScheduleRepository{db: r.db, clock: r.clock},
+		})
+	}
+	sqlTx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = sqlTx.Rollback()
+			panic(p)
+		}
+	}()
+	tx := &Tx{
+		Workflows: &WorkflowRepository{db: sqlTx, clock: r.clock},
+		Actions:   &WorkflowActionRepository{db: sqlTx, clock: r.clock},
+		Schedules: &ScheduleRepository{db: sqlTx, clock: r.clock},
+	}
+	if err := fn(tx); err != nil {
+		if rbErr := sqlTx.Rollback(); rbErr != nil {
+			slog.ErrorContext(ctx, "Failed to roll back transaction", "error", rbErr)
+		}
+		return err
+	}
+	return sqlTx.Commit()
+}
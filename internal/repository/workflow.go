@@ -1,7 +1,10 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/RealZimboGuy/gopherflow/internal/config"
@@ -11,13 +14,15 @@ import (
 
 	"log/slog"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
 type WorkflowRepository struct {
-	db    *sql.DB
+	db    DBTX
+	conn  *sql.DB // nil when the repository is bound to a transaction
 	clock core.Clock
 }
 
@@ -44,10 +49,20 @@ type DefinitionStateRow struct {
 
 const ALL_COLUMNS = ` id, status, execution_count, retry_count, created, modified,
 		       next_activation, started, executor_id, executor_group,
-		       workflow_type, external_id, business_key, state, state_vars, parent_workflow_id `
+		       workflow_type, external_id, business_key, state, state_vars, parent_workflow_id,
+		       workflow_version, priority, parent_close_policy, failure_policy `
+
+// operatorStatuses are set from outside the engine (API or console) and must survive the status
+// updates an executor makes while it is still finishing a state for the workflow.
+const operatorStatuses = `('CANCELLED', 'PAUSED')`
+
+// engineStatus returns a SET expression that changes the status unless an operator status is present.
+func engineStatus(status string) string {
+	return "CASE WHEN status IN " + operatorStatuses + " THEN status ELSE " + status + " END"
+}
 
 func NewWorkflowRepository(db *sql.DB, clock core.Clock) *WorkflowRepository {
-	return &WorkflowRepository{db: db, clock: clock}
+	return &WorkflowRepository{db: db, conn: db, clock: clock}
 }
 
 // WakeParentWorkflow sets a parent workflow's next_activation to now
@@ -64,6 +79,7 @@ func (r *WorkflowRepository) WakeParentWorkflow(parentID int64) error {
 	if err != nil {
 		return fmt.Errorf("failed to wake parent workflow: %w", err)
 	}
+	r.notifyWorkflow(parentID)
 
 	return nil
 }
@@ -106,6 +122,10 @@ func (r *WorkflowRepository) GetChildrenByParentID(parentID int64, onlyActive bo
 			&wf.State,
 			&wf.StateVars,
 			&wf.ParentWorkflowID,
+			&wf.WorkflowVersion,
+			&wf.Priority,
+			&wf.ParentClosePolicy,
+			&wf.FailurePolicy,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan child workflow: %w", err)
@@ -141,6 +161,10 @@ func (r *WorkflowRepository) FindByID(id int64) (*domain.Workflow, error) {
 		&wf.State,
 		&wf.StateVars,
 		&wf.ParentWorkflowID,
+		&wf.WorkflowVersion,
+		&wf.Priority,
+		&wf.ParentClosePolicy,
+		&wf.FailurePolicy,
 	)
 
 	if err != nil {
@@ -171,7 +195,7 @@ func toLocalSqlTime(t sql.NullTime) sql.NullTime {
 func (r *WorkflowRepository) Save(wf *domain.Workflow) (int64, error) {
 	// Build dialect-aware placeholders
 	vals := []interface{}{wf.Status, wf.ExecutionCount, wf.RetryCount, formatDateInDatabase(wf.Created), formatDateInDatabase(wf.Modified), formatDateInDatabaseNull(wf.NextActivation), formatDateInDatabaseNull(wf.Started), wf.ExecutorID, wf.ExecutorGroup, wf.WorkflowType, wf.ExternalID, wf.BusinessKey, wf.State,
-		wf.StateVars, wf.ParentWorkflowID}
+		wf.StateVars, wf.ParentWorkflowID, wf.WorkflowVersion, wf.Priority, wf.ParentClosePolicy, wf.FailurePolicy}
 	pps := make([]string, 0, len(vals))
 	for i := range vals {
 		pps = append(pps, placeholder(i+1))
@@ -180,7 +204,7 @@ func (r *WorkflowRepository) Save(wf *domain.Workflow) (int64, error) {
 		status, execution_count, retry_count, created, modified,
 		next_activation, started, executor_id, executor_group,
 		workflow_type, external_id, business_key, state, state_vars,
-		parent_workflow_id
+		parent_workflow_id, workflow_version, priority, parent_close_policy, failure_policy
 	) VALUES (` + strings.Join(pps, ", ") + `)`
 	var err error
 	if supportsReturning() {
@@ -199,6 +223,9 @@ func (r *WorkflowRepository) Save(wf *domain.Workflow) (int64, error) {
 			}
 		}
 	}
+	if err == nil {
+		r.notifyWorkflow(wf.ID)
+	}
 	return wf.ID, err
 }
 
@@ -234,18 +261,316 @@ func formatDateInDatabaseNull(created sql.NullTime) interface{} {
 }
 
 func (r *WorkflowRepository) FindPendingWorkflows(size int, executorGroup string) (*[]domain.Workflow, error) {
+	// workflows of types with a max_concurrency are only selected while the type has free slots
+	query := `
+		SELECT ` + ALL_COLUMNS + `
+		FROM (
+			SELECT ` + ALL_COLUMNS + `
+			FROM workflow
+			WHERE  ` + dateBeforeNow("next_activation", r.clock) + `
+			  AND status in ('NEW', 'IN_PROGRESS')
+			  AND executor_id IS NULL
+			  AND executor_group = ` + placeholder(1) + `
+			  AND workflow_type NOT IN ` + limitedTypes + `
+			UNION ALL` + r.pendingLimitedWorkflows(2) + `
+		) pending
+		ORDER BY ` + priorityOrder(r.clock) + `
+		LIMIT ` + placeholder(3) + `
+	`
+
+	rows, err := r.db.Query(query, executorGroup, executorGroup, size)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workflows, err := scanWorkflows(rows)
+	if err != nil {
+		return nil, err
+	}
+	return &workflows, nil
+}
+
+// scanWorkflows reads rows selected with ALL_COLUMNS.
+func scanWorkflows(rows *sql.Rows) ([]domain.Workflow, error) {
+	var workflows []domain.Workflow
+	for rows.Next() {
+		var wf domain.Workflow
+		err := rows.Scan(
+			&wf.ID,
+			&wf.Status,
+			&wf.ExecutionCount,
+			&wf.RetryCount,
+			&wf.Created,
+			&wf.Modified,
+			&wf.NextActivation,
+			&wf.Started,
+			&wf.ExecutorID,
+			&wf.ExecutorGroup,
+			&wf.WorkflowType,
+			&wf.ExternalID,
+			&wf.BusinessKey,
+			&wf.State,
+			&wf.StateVars,
+			&wf.ParentWorkflowID,
+			&wf.WorkflowVersion,
+			&wf.Priority,
+			&wf.ParentClosePolicy,
+			&wf.FailurePolicy,
+		)
+		if err != nil {
+			return nil, err
+		}
+		workflows = append(workflows, wf)
+	}
+	return workflows, rows.Err()
+}
+
+// MarkWorkflowAsScheduledForExecution schedules a pending workflow for the executor, guarded by modified. It fails
+// when another executor took the workflow or, for a type with a max_concurrency, when the type has no free slot.
+func (r *WorkflowRepository) MarkWorkflowAsScheduledForExecution(id int64, executorId int64, modified time.Time) bool {
+	workflowType, limit, err := r.concurrencyLimit(id)
+	marked := false
+	if err == nil && limit > 0 {
+		marked, err = r.markScheduledWithinLimit(workflowType, id, executorId, modified)
+	} else if err == nil {
+		marked, err = r.markScheduled(id, executorId, modified)
+	}
+	if err != nil {
+		slog.Error("Failed to mark workflow as scheduled", "error", err, "id", id, "executorId", executorId, "modified", modified)
+		return false
+	}
+	return marked
+}
+
+func (r *WorkflowRepository) markScheduled(id int64, executorId int64, modified time.Time) (bool, error) {
 	query := `
+		UPDATE workflow
+		SET status = 'SCHEDULED', modified = ` + nowFunc(r.clock) + `, executor_id = ` + placeholder(1) + `, ` + acquireLease(2, r.clock) + `
+		WHERE id = ` + placeholder(3) + ` AND modified = ` + placeholder(4) + ` AND status IN ('NEW', 'IN_PROGRESS') AND executor_id IS NULL
+	`
+	stringdate := formatDateInDatabase(modified)
+	result, err := r.db.Exec(query, executorId, executorId, id, stringdate)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// ErrClaimUnsupported is returned by ClaimPendingWorkflows on databases without SELECT ... FOR UPDATE SKIP LOCKED,
+// pending workflows are found and marked one by one there instead.
+var ErrClaimUnsupported = errors.New("claiming pending workflows is not supported by this database")
+
+// supportsSkipLocked reports whether the database can skip rows locked by another executor, SQLite cannot.
+func supportsSkipLocked() bool {
+	db := config.GetSystemSettingString(config.DATABASE_TYPE)
+	return db == config.DATABASE_TYPE_POSTGRES || db == config.DATABASE_TYPE_MYSQL
+}
+
+// ClaimPendingWorkflows marks up to size pending workflows of the executor group as scheduled for the executor and
+// returns them. The best size workflows of unlimited types are locked with SKIP LOCKED, rows another executor is
+// claiming at the same time are skipped rather than waited for, so concurrent executors never receive the same
+// workflow and never fail to lock one. Pending workflows of types with a max_concurrency that rank above them by aged
+// priority take their place in the batch, they are marked one by one within the limit of their type.
+func (r *WorkflowRepository) ClaimPendingWorkflows(size int, executorGroup string, executorId int64) (*[]domain.Workflow, error) {
+	if !supportsSkipLocked() {
+		return nil, ErrClaimUnsupported
+	}
+	limited, err := r.findPendingLimitedWorkflows(size, executorGroup)
+	if err != nil {
+		slog.Error("Failed to find workflows of limited types", "error", err)
+		limited = nil
+	}
+
+	var workflows, chosenLimited []domain.Workflow
+	err = r.InTransaction(context.Background(), func(tx *Tx) error {
+		pending := `
 		SELECT ` + ALL_COLUMNS + `
 		FROM workflow
 		WHERE  ` + dateBeforeNow("next_activation", r.clock) + `
 		  AND status in ('NEW', 'IN_PROGRESS')
 		  AND executor_id IS NULL
 		  AND executor_group = ` + placeholder(1) + `
-		ORDER BY next_activation ASC
+		  AND workflow_type NOT IN ` + limitedTypes + `
+		ORDER BY ` + priorityOrder(r.clock) + `
 		LIMIT ` + placeholder(2) + `
+		FOR UPDATE SKIP LOCKED
+	`
+		rows, err := tx.Workflows.db.Query(pending, executorGroup, size)
+		if err != nil {
+			return err
+		}
+		locked, err := scanWorkflows(rows)
+		rows.Close()
+		if err != nil {
+			return err
+		}
+		var chosen []domain.Workflow
+		chosen, chosenLimited = r.rankClaim(locked, limited, size)
+		if len(chosen) == 0 {
+			return nil
+		}
+		// the locked rows that were not chosen are released when the transaction ends
+		args := make([]any, 0, len(chosen)+2)
+		args = append(args, executorId, executorId)
+		for _, wf := range chosen {
+			args = append(args, wf.ID)
+		}
+		update := `
+		UPDATE workflow
+		SET status = 'SCHEDULED', modified = ` + nowFunc(r.clock) + `, executor_id = ` + placeholder(1) + `, ` + acquireLease(2, r.clock) + `
+		WHERE id IN (` + placeholders(3, len(chosen)) + `)`
+		if _, err := tx.Workflows.db.Exec(update, args...); err != nil {
+			return err
+		}
+		rows, err = tx.Workflows.db.Query(`
+		SELECT `+ALL_COLUMNS+`
+		FROM workflow
+		WHERE id IN (`+placeholders(1, len(chosen))+`)
+		ORDER BY `+priorityOrder(r.clock), args[2:]...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		workflows, err = scanWorkflows(rows)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, wf := range chosenLimited {
+		marked, err := r.markScheduledWithinLimit(wf.WorkflowType, wf.ID, executorId, wf.Modified)
+		if err != nil {
+			slog.Error("Failed to claim workflow of limited type", "error", err, "id", wf.ID, "executorId", executorId)
+		} else if marked {
+			workflows = append(workflows, wf)
+		}
+	}
+	sort.SliceStable(workflows, func(i, j int) bool {
+		return agedPriority(workflows[i].Priority, workflows[i].NextActivation.Time, r.clock) >
+			agedPriority(workflows[j].Priority, workflows[j].NextActivation.Time, r.clock)
+	})
+	return &workflows, nil
+}
+
+// rankClaim merges the locked workflows of unlimited types and the pending workflows of limited types, both in
+// priority order, and returns the ones of each that make up the best size of them.
+func (r *WorkflowRepository) rankClaim(unlimited []domain.Workflow, limited []domain.Workflow, size int) ([]domain.Workflow, []domain.Workflow) {
+	var chosen, chosenLimited []domain.Workflow
+	u, l := 0, 0
+	for len(chosen)+len(chosenLimited) < size && (u < len(unlimited) || l < len(limited)) {
+		if l == len(limited) || (u < len(unlimited) &&
+			agedPriority(unlimited[u].Priority, unlimited[u].NextActivation.Time, r.clock) >=
+				agedPriority(limited[l].Priority, limited[l].NextActivation.Time, r.clock)) {
+			chosen = append(chosen, unlimited[u])
+			u++
+		} else {
+			chosenLimited = append(chosenLimited, limited[l])
+			l++
+		}
+	}
+	return chosen, chosenLimited
+}
+
+// MarkWorkflowAsExecuting flags a scheduled workflow as executing, returning false when it was
+// cancelled while waiting in the queue.
+func (r *WorkflowRepository) MarkWorkflowAsExecuting(id int64) bool {
+	query := `
+		UPDATE workflow
+		SET status = 'EXECUTING', modified = ` + nowFunc(r.clock) + `
+		WHERE id = ` + placeholder(1) + ` AND status NOT IN ` + operatorStatuses + `
+	`
+	result, err := r.db.Exec(query, id)
+	if err != nil {
+		slog.Error("Failed to mark workflow as executing", "error", err, "id", id)
+		return false
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false
+	}
+	return rowsAffected == 1
+}
+
+// CancelWorkflow sets the status to CANCELLED for any workflow that has not already ended.
+// next_activation is set so the cancellation service picks it up to run any cleanup,
+// the executor_id is left alone so a running executor can still be found.
+func (r *WorkflowRepository) CancelWorkflow(id int64) (bool, error) {
+	query := `
+		UPDATE workflow
+		SET status = 'CANCELLED', paused_status = NULL, next_activation = ` + nowFunc(r.clock) + `, modified = ` + nowFunc(r.clock) + `
+		WHERE id = ` + placeholder(1) + ` AND status IN ('NEW', 'SCHEDULED', 'EXECUTING', 'IN_PROGRESS', 'LOCK', 'PAUSED')
 	`
+	result, err := r.db.Exec(query, id)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
 
-	rows, err := r.db.Query(query, executorGroup, size)
+// PauseWorkflow sets the status to PAUSED for a workflow that has not ended, remembering the status it had.
+// next_activation is left untouched so that resuming does not lose the schedule.
+func (r *WorkflowRepository) PauseWorkflow(id int64) (bool, error) {
+	// paused_status is assigned first as MySQL applies the assignments left to right
+	query := `
+		UPDATE workflow
+		SET paused_status = status, status = 'PAUSED', modified = ` + nowFunc(r.clock) + `
+		WHERE id = ` + placeholder(1) + ` AND status IN ('NEW', 'SCHEDULED', 'EXECUTING', 'IN_PROGRESS', 'LOCK')
+	`
+	result, err := r.db.Exec(query, id)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// ResumeWorkflow restores the status a paused workflow had. Workflows paused while scheduled or executing
+// go back to IN_PROGRESS, they are picked up again once the executor holding them has let go.
+func (r *WorkflowRepository) ResumeWorkflow(id int64) (bool, error) {
+	query := `
+		UPDATE workflow
+		SET status = CASE WHEN paused_status = 'NEW' THEN 'NEW' ELSE 'IN_PROGRESS' END, paused_status = NULL, modified = ` + nowFunc(r.clock) + `
+		WHERE id = ` + placeholder(1) + ` AND status = 'PAUSED'
+	`
+	result, err := r.db.Exec(query, id)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// FindCancelledWorkflows returns cancelled workflows whose cancellation has not been processed yet,
+// either unowned, owned by the given executor or held by an executor whose lease on them expired.
+func (r *WorkflowRepository) FindCancelledWorkflows(executorGroup string, executorId int64, limit int) (*[]domain.Workflow, error) {
+	query := `
+		SELECT ` + ALL_COLUMNS + `
+		FROM workflow
+		WHERE status = 'CANCELLED'
+		  AND next_activation IS NOT NULL
+		  AND executor_group = ` + placeholder(1) + `
+		  AND (executor_id IS NULL OR executor_id = ` + placeholder(2) + `
+		       OR (lease_expires IS NOT NULL AND ` + dateBeforeNow("lease_expires", r.clock) + `))
+		ORDER BY next_activation ASC
+		LIMIT ` + placeholder(3) + `
+	`
+	rows, err := r.db.Query(query, executorGroup, executorId, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -271,27 +596,31 @@ func (r *WorkflowRepository) FindPendingWorkflows(size int, executorGroup string
 			&wf.State,
 			&wf.StateVars,
 			&wf.ParentWorkflowID,
+			&wf.WorkflowVersion,
+			&wf.Priority,
+			&wf.ParentClosePolicy,
+			&wf.FailurePolicy,
 		)
 		if err != nil {
 			return nil, err
 		}
 		workflows = append(workflows, wf)
 	}
-
 	return &workflows, nil
 }
 
-func (r *WorkflowRepository) MarkWorkflowAsScheduledForExecution(id int64, executorId int64, modified time.Time) bool {
-
+// ClaimCancelledWorkflow takes ownership of an idle cancelled workflow so only one executor runs its cleanup. A
+// workflow still held by an executor whose lease on it expired is taken over.
+func (r *WorkflowRepository) ClaimCancelledWorkflow(id int64, executorId int64) bool {
 	query := `
 		UPDATE workflow
-		SET status = 'SCHEDULED', modified = ` + nowFunc(r.clock) + `, executor_id = ` + placeholder(1) + `
-		WHERE id = ` + placeholder(2) + ` AND modified = ` + placeholder(3) + ` AND status IN ('NEW', 'IN_PROGRESS') AND executor_id IS NULL
+		SET executor_id = ` + placeholder(1) + `, ` + acquireLease(2, r.clock) + `, modified = ` + nowFunc(r.clock) + `
+		WHERE id = ` + placeholder(3) + ` AND status = 'CANCELLED'
+		  AND (executor_id IS NULL OR (lease_expires IS NOT NULL AND ` + dateBeforeNow("lease_expires", r.clock) + `))
 	`
-	stringdate := formatDateInDatabase(modified)
-	result, err := r.db.Exec(query, executorId, id, stringdate)
+	result, err := r.db.Exec(query, executorId, executorId, id)
 	if err != nil {
-		slog.Error("Failed to mark workflow as scheduled", "error", err, "id", id, "executorId", executorId, "modified", modified)
+		slog.Error("Failed to claim cancelled workflow", "error", err, "id", id, "executorId", executorId)
 		return false
 	}
 	rowsAffected, err := result.RowsAffected()
@@ -301,6 +630,17 @@ func (r *WorkflowRepository) MarkWorkflowAsScheduledForExecution(id int64, execu
 	return rowsAffected == 1
 }
 
+// CompleteCancellation marks the cancellation of a workflow as processed.
+func (r *WorkflowRepository) CompleteCancellation(id int64) error {
+	query := `
+		UPDATE workflow
+		SET executor_id = NULL, ` + releaseLease + `, next_activation = NULL, modified = ` + nowFunc(r.clock) + `
+		WHERE id = ` + placeholder(1) + `
+	`
+	_, err := r.db.Exec(query, id)
+	return err
+}
+
 func (r *WorkflowRepository) UpdateState(id int64, state string) error {
 
 	query := `
@@ -309,13 +649,23 @@ func (r *WorkflowRepository) UpdateState(id int64, state string) error {
 		WHERE id = ` + placeholder(2) + `
 	`
 	_, err := r.db.Exec(query, state, id)
+	if err == nil {
+		r.notifyWorkflow(id)
+	}
 	return err
 }
 
+// UpdateWorkflowStatus sets the status of a workflow. A workflow that ends no longer holds its executor or lease, so
+// it stops counting against the max_concurrency of its type.
 func (r *WorkflowRepository) UpdateWorkflowStatus(id int64, status string) error {
+	set := engineStatus(placeholder(1))
+	if status == "FINISHED" || status == "FAILED" || status == "ERROR" {
+		// a workflow that ends while it is being paused ends all the same, there is nothing left to resume
+		set = "CASE WHEN status = 'CANCELLED' THEN status ELSE " + placeholder(1) + " END, executor_id = NULL, " + releaseLease
+	}
 	query := `
 		UPDATE workflow
-		SET status = ` + placeholder(1) + `, modified = ` + nowFunc(r.clock) + `
+		SET status = ` + set + `, modified = ` + nowFunc(r.clock) + `
 		WHERE id = ` + placeholder(2) + `
 	`
 	_, err := r.db.Exec(query, status, id)
@@ -341,6 +691,35 @@ func (r *WorkflowRepository) SaveWorkflowVariables(id int64, vars string) error
 	return err
 }
 
+// FindWorkflowVariablesForUpdate reads the state variables of a workflow. Within a transaction the workflow row stays
+// locked until the transaction ends, so nothing sets a variable between the read and a save.
+func (r *WorkflowRepository) FindWorkflowVariablesForUpdate(id int64) (sql.NullString, error) {
+	var stored sql.NullString
+	err := r.db.QueryRow(`SELECT state_vars FROM workflow WHERE id = `+placeholder(1)+lockForUpdate(), id).Scan(&stored)
+	return stored, err
+}
+
+// SetWorkflowVariable sets a single state variable of a workflow and leaves the others as they are. Within a
+// transaction the workflow row stays locked until the transaction ends.
+func (r *WorkflowRepository) SetWorkflowVariable(id int64, key string, value string) error {
+	stored, err := r.FindWorkflowVariablesForUpdate(id)
+	if err != nil {
+		return err
+	}
+	vars := make(map[string]string)
+	if stored.Valid && stored.String != "" {
+		if err := json.Unmarshal([]byte(stored.String), &vars); err != nil {
+			return fmt.Errorf("reading state variables of workflow %d: %w", id, err)
+		}
+	}
+	vars[key] = value
+	b, err := json.Marshal(vars)
+	if err != nil {
+		return err
+	}
+	return r.SaveWorkflowVariables(id, string(b))
+}
+
 // SaveWorkflowVariablesAndTouch updates state_vars and touches modified timestamp.
 func (r *WorkflowRepository) SaveWorkflowVariablesAndTouch(id int64, vars string) error {
 	query := `
@@ -355,10 +734,13 @@ func (r *WorkflowRepository) SaveWorkflowVariablesAndTouch(id int64, vars string
 func (r *WorkflowRepository) UpdateNextActivationSpecific(id int64, next time.Time) error {
 	query := `
 		UPDATE workflow
-		SET status = 'IN_PROGRESS', next_activation = ` + placeholder(1) + `, modified = ` + nowFunc(r.clock) + `
+		SET status = ` + engineStatus("'IN_PROGRESS'") + `, next_activation = ` + placeholder(1) + `, modified = ` + nowFunc(r.clock) + `
 		WHERE id = ` + placeholder(2) + `
 	`
 	_, err := r.db.Exec(query, formatDateInDatabase(next), id)
+	if err == nil && !next.After(r.clock.Now()) {
+		r.notifyWorkflow(id)
+	}
 	return err
 }
 func (r *WorkflowRepository) UpdateNextActivationOffset(id int64, offset string) error {
@@ -373,23 +755,26 @@ func (r *WorkflowRepository) UpdateNextActivationOffset(id int64, offset string)
 	//	return err
 	//}
 	// Non-Postgres: compute next_activation in Go
-	var dur time.Duration
-	var err error
-	dur, err = ParsePostgresInterval(offset)
+	next := time.Now().UTC().Add(OffsetDuration(offset))
+	query := `
+		UPDATE workflow
+		SET status = ` + engineStatus("'IN_PROGRESS'") + `, next_activation = ` + placeholder(1) + `, modified = ` + nowFunc(r.clock) + `
+		WHERE id = ` + placeholder(2) + `
+	`
+	_, err := r.db.Exec(query, formatDateInDatabase(next), id)
+	return err
+}
+
+// OffsetDuration converts a human friendly offset like "10 minutes" to a duration, a bare number is taken as minutes.
+func OffsetDuration(offset string) time.Duration {
+	dur, err := ParsePostgresInterval(offset)
 	if err != nil {
 		// try to parse as integer minutes from string like "5" or "5 minutes"
 		mins := 0
 		fmt.Sscanf(offset, "%d", &mins)
 		dur = time.Duration(mins) * time.Minute
 	}
-	next := time.Now().UTC().Add(dur)
-	query := `
-		UPDATE workflow
-		SET status = 'IN_PROGRESS', next_activation = ` + placeholder(1) + `, modified = ` + nowFunc(r.clock) + `
-		WHERE id = ` + placeholder(2) + `
-	`
-	_, err = r.db.Exec(query, formatDateInDatabase(next), id)
-	return err
+	return dur
 }
 
 // ParsePostgresInterval converts a PostgreSQL interval string to time.Duration
@@ -435,17 +820,58 @@ func ParsePostgresInterval(interval string) (time.Duration, error) {
 func (r *WorkflowRepository) ClearExecutorId(id int64) error {
 	query := `
 		UPDATE workflow
-		SET executor_id = NULL, modified = ` + nowFunc(r.clock) + `
+		SET executor_id = NULL, ` + releaseLease + `, modified = ` + nowFunc(r.clock) + `
 		WHERE id = ` + placeholder(1) + `
 	`
 	_, err := r.db.Exec(query, id)
 	return err
 }
 
+// ReleaseWorkflow hands a workflow the executor took but never started back, so any executor can pick it up.
+func (r *WorkflowRepository) ReleaseWorkflow(id int64, executorId int64) (bool, error) {
+	released, err := r.ReleaseWorkflows(executorId, []int64{id})
+	return released == 1, err
+}
+
+// ReleaseWorkflows hands the given workflows the executor holds back in one update and returns how many it still
+// held. A scheduled workflow returns to NEW, or to IN_PROGRESS when it ran before, and an executing one that stopped
+// between two states returns to IN_PROGRESS, other statuses are kept.
+func (r *WorkflowRepository) ReleaseWorkflows(executorId int64, ids []int64) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	args := make([]any, 0, len(ids)+1)
+	args = append(args, executorId)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	query := `
+		UPDATE workflow
+		SET status = CASE WHEN status = 'SCHEDULED' THEN (CASE WHEN started IS NULL THEN 'NEW' ELSE 'IN_PROGRESS' END)
+		                  WHEN status = 'EXECUTING' THEN 'IN_PROGRESS' ELSE status END,
+		    executor_id = NULL, ` + releaseLease + `, modified = ` + nowFunc(r.clock) + `
+		WHERE executor_id = ` + placeholder(1) + ` AND id IN (` + placeholders(2, len(ids)) + `)
+	`
+	result, err := r.db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if rowsAffected > 0 {
+		for _, id := range ids {
+			r.notifyWorkflow(id)
+		}
+	}
+	return rowsAffected, nil
+}
+
 func (r *WorkflowRepository) IncrementRetryCounterAndSetNextActivation(id int64, activation time.Time) error {
 	query := `
 		UPDATE workflow
-		SET status = 'IN_PROGRESS', executor_id = NULL, retry_count = retry_count + 1, next_activation = ` + placeholder(1) + `, modified = ` + nowFunc(r.clock) + `
+		SET status = ` + engineStatus("'IN_PROGRESS'") + `, executor_id = NULL, ` + releaseLease + `, retry_count = retry_count + 1, next_activation = ` + placeholder(1) + `, modified = ` + nowFunc(r.clock) + `
 		WHERE id = ` + placeholder(2) + `
 	`
 	_, err := r.db.Exec(query, formatDateInDatabase(activation), id)
@@ -475,6 +901,10 @@ func (r *WorkflowRepository) FindByExternalId(id string) (*domain.Workflow, erro
 		&wf.State,
 		&wf.StateVars,
 		&wf.ParentWorkflowID,
+		&wf.WorkflowVersion,
+		&wf.Priority,
+		&wf.ParentClosePolicy,
+		&wf.FailurePolicy,
 	)
 	if err != nil {
 		return nil, err
@@ -501,16 +931,23 @@ func (r *WorkflowRepository) FindStuckWorkflows(minutesRepair string, executorGr
 	//	`
 	//} else {
 	// Generic flavor without interval math: compare against parameterized cutoff times
+	// A workflow is stuck once the lease of its executor expired. Workflows taken before leases were introduced
+	// have none, they are still judged by their modified time and the last heartbeat of their executor.
 	query = `
 		SELECT ` + ALL_COLUMNS + `
 		FROM workflow
-		WHERE modified < ` + placeholder(1) + `
-		  AND status IN ('SCHEDULED', 'EXECUTING', 'IN_PROGRESS', 'LOCK')
-		  AND executor_group = ` + placeholder(2) + `
-		  AND executor_id NOT IN (
-		      SELECT id
-		      FROM executors
-		      WHERE last_active > ` + placeholder(3) + `
+		WHERE status IN ('SCHEDULED', 'EXECUTING', 'IN_PROGRESS', 'LOCK')
+		  AND executor_group = ` + placeholder(1) + `
+		  AND executor_id IS NOT NULL
+		  AND (
+		      (lease_expires IS NOT NULL AND ` + dateBeforeNow("lease_expires", r.clock) + `)
+		      OR (lease_expires IS NULL
+		          AND modified < ` + placeholder(2) + `
+		          AND executor_id NOT IN (
+		              SELECT id
+		              FROM executors
+		              WHERE last_active > ` + placeholder(3) + `
+		          ))
 		  )
 		ORDER BY next_activation ASC
 		LIMIT ` + placeholder(4) + `
@@ -555,7 +992,7 @@ func (r *WorkflowRepository) FindStuckWorkflows(minutesRepair string, executorGr
 	fmt.Sscanf(minutesRepair, "%d", &mins)
 	cutoff := time.Now().UTC().Add(-time.Duration(mins) * time.Minute)
 	lastActiveCutoff := cutoff
-	rows, err := r.db.Query(query, cutoff, executorGroup, lastActiveCutoff, limit)
+	rows, err := r.db.Query(query, executorGroup, cutoff, lastActiveCutoff, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -580,6 +1017,10 @@ func (r *WorkflowRepository) FindStuckWorkflows(minutesRepair string, executorGr
 			&wf.State,
 			&wf.StateVars,
 			&wf.ParentWorkflowID,
+			&wf.WorkflowVersion,
+			&wf.Priority,
+			&wf.ParentClosePolicy,
+			&wf.FailurePolicy,
 		)
 		if err != nil {
 			return nil, err
@@ -593,7 +1034,7 @@ func (r *WorkflowRepository) FindStuckWorkflows(minutesRepair string, executorGr
 func (r *WorkflowRepository) LockWorkflowByModified(id int64, modified time.Time) bool {
 	query := `
 		UPDATE workflow
-		SET status = 'LOCK', executor_id = NULL, retry_count = retry_count + 1, next_activation = ` + placeholder(1) + `, modified = ` + nowFunc(r.clock) + `
+		SET status = 'LOCK', executor_id = NULL, ` + releaseLease + `, retry_count = retry_count + 1, next_activation = ` + placeholder(1) + `, modified = ` + nowFunc(r.clock) + `
 		WHERE id = ` + placeholder(2) + ` AND modified = ` + placeholder(3) + `
 	`
 	result, err := r.db.Exec(query, formatDateInDatabase(modified), id, formatDateInDatabase(modified))
@@ -643,6 +1084,10 @@ func (r *WorkflowRepository) SearchWorkflows(req models.SearchWorkflowRequest) (
 			&wf.State,
 			&wf.StateVars,
 			&wf.ParentWorkflowID,
+			&wf.WorkflowVersion,
+			&wf.Priority,
+			&wf.ParentClosePolicy,
+			&wf.FailurePolicy,
 		)
 		if err != nil {
 			return nil, err
@@ -748,6 +1193,10 @@ func (r *WorkflowRepository) GetTopExecuting(limit int) (*[]domain.Workflow, err
 			&wf.State,
 			&wf.StateVars,
 			&wf.ParentWorkflowID,
+			&wf.WorkflowVersion,
+			&wf.Priority,
+			&wf.ParentClosePolicy,
+			&wf.FailurePolicy,
 		); err != nil {
 			return nil, err
 		}
@@ -790,6 +1239,10 @@ func (r *WorkflowRepository) GetNextToExecute(limit int) (*[]domain.Workflow, er
 			&wf.State,
 			&wf.StateVars,
 			&wf.ParentWorkflowID,
+			&wf.WorkflowVersion,
+			&wf.Priority,
+			&wf.ParentClosePolicy,
+			&wf.FailurePolicy,
 		); err != nil {
 			return nil, err
 		}
@@ -852,3 +1305,16 @@ func buildWhereClause(req models.SearchWorkflowRequest) (string, []interface{})
 
 	return " WHERE " + strings.Join(andClauses, " AND "), args
 }
+
+// PinWorkflowVersion records the definition version of a workflow that was created without one.
+func (r *WorkflowRepository) PinWorkflowVersion(id int64, version int) error {
+	query := `
+		UPDATE workflow
+		SET workflow_version = ` + placeholder(1) + `
+		WHERE id = ` + placeholder(2) + ` AND workflow_version = 0
+	`
+	if _, err := r.db.Exec(query, version, id); err != nil {
+		return fmt.Errorf("failed to pin workflow version: %w", err)
+	}
+	return nil
+}
@@ -10,7 +10,7 @@ import (
 
 // WorkflowActionRepository provides methods to persist and query workflow action records.
 type WorkflowActionRepository struct {
-	db    *sql.DB
+	db    DBTX
 	clock core.Clock
 }
 
@@ -24,33 +24,37 @@ func (r *WorkflowDefinitionRepository) Save(def *domain.WorkflowDefinition) erro
 	db := config.GetSystemSettingString(config.DATABASE_TYPE)
 	if db == config.DATABASE_TYPE_POSTGRES || db == config.DATABASE_TYPE_SQLLITE {
 		query = `
-		INSERT INTO workflow_definitions (name, description, created, updated, flow_chart)
-		VALUES (` + placeholder(1) + `, ` + placeholder(2) + `, ` + placeholder(3) + `, ` + placeholder(4) + `, ` + placeholder(5) + `)
+		INSERT INTO workflow_definitions (name, description, created, updated, flow_chart, version, max_concurrency)
+		VALUES (` + placeholder(1) + `, ` + placeholder(2) + `, ` + placeholder(3) + `, ` + placeholder(4) + `, ` + placeholder(5) + `, ` + placeholder(6) + `, ` + placeholder(7) + `)
 		ON CONFLICT (name)
 		DO UPDATE SET description = EXCLUDED.description,
 			updated = EXCLUDED.updated,
-			flow_chart = EXCLUDED.flow_chart
+			flow_chart = EXCLUDED.flow_chart,
+			version = EXCLUDED.version,
+			max_concurrency = EXCLUDED.max_concurrency
 	`
 	} else if db == config.DATABASE_TYPE_MYSQL {
 		query = `
-		INSERT INTO workflow_definitions (name, description, created, updated, flow_chart)
-		VALUES (` + placeholder(1) + `, ` + placeholder(2) + `, ` + placeholder(3) + `, ` + placeholder(4) + `, ` + placeholder(5) + `)
+		INSERT INTO workflow_definitions (name, description, created, updated, flow_chart, version, max_concurrency)
+		VALUES (` + placeholder(1) + `, ` + placeholder(2) + `, ` + placeholder(3) + `, ` + placeholder(4) + `, ` + placeholder(5) + `, ` + placeholder(6) + `, ` + placeholder(7) + `)
 		ON DUPLICATE KEY UPDATE description = VALUES(description),
 			updated = VALUES(updated),
-			flow_chart = VALUES(flow_chart)
+			flow_chart = VALUES(flow_chart),
+			version = VALUES(version),
+			max_concurrency = VALUES(max_concurrency)
 	`
 	} else {
 		panic("Unknown database type trying to save workflow definition")
 	}
 
-	_, err := r.db.Exec(query, def.Name, def.Description, def.Created, def.Updated, def.FlowChart)
+	_, err := r.db.Exec(query, def.Name, def.Description, def.Created, def.Updated, def.FlowChart, def.Version, def.MaxConcurrency)
 	return err
 }
 
 // FindByName fetches a workflow definition by its unique name.
 func (r *WorkflowDefinitionRepository) FindByName(name string) (*domain.WorkflowDefinition, error) {
 	query := `
-		SELECT name, description, created, updated, flow_chart
+		SELECT name, description, created, updated, flow_chart, version, max_concurrency
 		FROM workflow_definitions WHERE name = ` + placeholder(1) + `
 	`
 	var def domain.WorkflowDefinition
@@ -60,6 +64,8 @@ func (r *WorkflowDefinitionRepository) FindByName(name string) (*domain.Workflow
 		&def.Created,
 		&def.Updated,
 		&def.FlowChart,
+		&def.Version,
+		&def.MaxConcurrency,
 	)
 	if err != nil {
 		return nil, err
@@ -70,7 +76,7 @@ func (r *WorkflowDefinitionRepository) FindByName(name string) (*domain.Workflow
 // FindAll returns all workflow definitions.
 func (r *WorkflowDefinitionRepository) FindAll() (*[]domain.WorkflowDefinition, error) {
 	query := `
-		SELECT name, description, created, updated, flow_chart
+		SELECT name, description, created, updated, flow_chart, version, max_concurrency
 		FROM workflow_definitions
 		ORDER BY name
 	`
@@ -83,7 +89,7 @@ func (r *WorkflowDefinitionRepository) FindAll() (*[]domain.WorkflowDefinition,
 	defs := make([]domain.WorkflowDefinition, 0)
 	for rows.Next() {
 		var d domain.WorkflowDefinition
-		if err := rows.Scan(&d.Name, &d.Description, &d.Created, &d.Updated, &d.FlowChart); err != nil {
+		if err := rows.Scan(&d.Name, &d.Description, &d.Created, &d.Updated, &d.FlowChart, &d.Version, &d.MaxConcurrency); err != nil {
 			return nil, err
 		}
 		defs = append(defs, d)
@@ -93,3 +99,87 @@ func (r *WorkflowDefinitionRepository) FindAll() (*[]domain.WorkflowDefinition,
 	}
 	return &defs, nil
 }
+
+// SaveVersion inserts a version of a workflow definition or refreshes it when it was registered before.
+func (r *WorkflowDefinitionRepository) SaveVersion(v *domain.WorkflowDefinitionVersion) error {
+	query := ""
+	db := config.GetSystemSettingString(config.DATABASE_TYPE)
+	if db == config.DATABASE_TYPE_POSTGRES || db == config.DATABASE_TYPE_SQLLITE {
+		query = `
+		INSERT INTO workflow_definition_versions (name, version, description, states, transitions, flow_chart, created, updated)
+		VALUES (` + placeholder(1) + `, ` + placeholder(2) + `, ` + placeholder(3) + `, ` + placeholder(4) + `, ` + placeholder(5) + `, ` + placeholder(6) + `, ` + placeholder(7) + `, ` + placeholder(8) + `)
+		ON CONFLICT (name, version)
+		DO UPDATE SET description = EXCLUDED.description,
+			states = EXCLUDED.states,
+			transitions = EXCLUDED.transitions,
+			flow_chart = EXCLUDED.flow_chart,
+			updated = EXCLUDED.updated
+	`
+	} else if db == config.DATABASE_TYPE_MYSQL {
+		query = `
+		INSERT INTO workflow_definition_versions (name, version, description, states, transitions, flow_chart, created, updated)
+		VALUES (` + placeholder(1) + `, ` + placeholder(2) + `, ` + placeholder(3) + `, ` + placeholder(4) + `, ` + placeholder(5) + `, ` + placeholder(6) + `, ` + placeholder(7) + `, ` + placeholder(8) + `)
+		ON DUPLICATE KEY UPDATE description = VALUES(description),
+			states = VALUES(states),
+			transitions = VALUES(transitions),
+			flow_chart = VALUES(flow_chart),
+			updated = VALUES(updated)
+	`
+	} else {
+		panic("Unknown database type trying to save workflow definition version")
+	}
+
+	_, err := r.db.Exec(query, v.Name, v.Version, v.Description, v.States, v.Transitions, v.FlowChart, v.Created, v.Updated)
+	return err
+}
+
+// FindVersion fetches one version of a workflow definition.
+func (r *WorkflowDefinitionRepository) FindVersion(name string, version int) (*domain.WorkflowDefinitionVersion, error) {
+	query := `
+		SELECT name, version, description, states, transitions, flow_chart, created, updated
+		FROM workflow_definition_versions WHERE name = ` + placeholder(1) + ` AND version = ` + placeholder(2) + `
+	`
+	var v domain.WorkflowDefinitionVersion
+	err := r.db.QueryRow(query, name, version).Scan(
+		&v.Name,
+		&v.Version,
+		&v.Description,
+		&v.States,
+		&v.Transitions,
+		&v.FlowChart,
+		&v.Created,
+		&v.Updated,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// FindVersions returns every registered version of a workflow definition, newest first.
+func (r *WorkflowDefinitionRepository) FindVersions(name string) (*[]domain.WorkflowDefinitionVersion, error) {
+	query := `
+		SELECT name, version, description, states, transitions, flow_chart, created, updated
+		FROM workflow_definition_versions
+		WHERE name = ` + placeholder(1) + `
+		ORDER BY version DESC
+	`
+	rows, err := r.db.Query(query, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make([]domain.WorkflowDefinitionVersion, 0)
+	for rows.Next() {
+		var v domain.WorkflowDefinitionVersion
+		if err := rows.Scan(&v.Name, &v.Version, &v.Description, &v.States, &v.Transitions, &v.FlowChart, &v.Created, &v.Updated); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &versions, nil
+}
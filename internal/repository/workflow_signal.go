@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/domain"
+)
+
+// SaveSignal stores a signal for a workflow and returns its ID.
+func (r *WorkflowRepository) SaveSignal(s *domain.WorkflowSignal) (int64, error) {
+	base := `
+		INSERT INTO workflow_signals (workflow_id, name, payload, created)
+		VALUES (` + placeholder(1) + `, ` + placeholder(2) + `, ` + placeholder(3) + `, ` + placeholder(4) + `)`
+	if supportsReturning() {
+		err := r.db.QueryRow(base+" RETURNING id", s.WorkflowID, s.Name, s.Payload, formatDateInDatabase(s.Created)).Scan(&s.ID)
+		return s.ID, err
+	}
+	res, err := r.db.Exec(base, s.WorkflowID, s.Name, s.Payload, formatDateInDatabase(s.Created))
+	if err != nil {
+		return 0, err
+	}
+	s.ID, err = res.LastInsertId()
+	return s.ID, err
+}
+
+// FindPendingSignals returns the signals of a workflow that have not been consumed, oldest first.
+func (r *WorkflowRepository) FindPendingSignals(workflowID int64) (*[]domain.WorkflowSignal, error) {
+	query := `
+		SELECT id, workflow_id, name, payload, created, consumed
+		FROM workflow_signals
+		WHERE workflow_id = ` + placeholder(1) + ` AND consumed IS NULL
+		ORDER BY id ASC
+	`
+	rows, err := r.db.Query(query, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	signals := []domain.WorkflowSignal{}
+	for rows.Next() {
+		var s domain.WorkflowSignal
+		if err := rows.Scan(&s.ID, &s.WorkflowID, &s.Name, &s.Payload, &s.Created, &s.Consumed); err != nil {
+			return nil, err
+		}
+		signals = append(signals, s)
+	}
+	return &signals, rows.Err()
+}
+
+// MarkSignalsConsumed records that the given signals have been handled by their workflow.
+func (r *WorkflowRepository) MarkSignalsConsumed(ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	in := make([]string, len(ids))
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		in[i] = placeholder(i + 1)
+		args[i] = id
+	}
+	query := `
+		UPDATE workflow_signals
+		SET consumed = ` + nowFunc(r.clock) + `
+		WHERE consumed IS NULL AND id IN (` + strings.Join(in, ", ") + `)
+	`
+	if _, err := r.db.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to mark signals consumed: %w", err)
+	}
+	return nil
+}
+
+// WakeWorkflow makes a waiting workflow due now so that it is picked up on the next poll.
+func (r *WorkflowRepository) WakeWorkflow(id int64) error {
+	query := `
+		UPDATE workflow
+		SET next_activation = ` + placeholder(1) + `
+		WHERE id = ` + placeholder(2) + `
+		AND status IN ('NEW', 'SCHEDULED', 'IN_PROGRESS')
+	`
+	if _, err := r.db.Exec(query, formatDateInDatabase(r.clock.Now()), id); err != nil {
+		return fmt.Errorf("failed to wake workflow: %w", err)
+	}
+	r.notifyWorkflow(id)
+	return nil
+}
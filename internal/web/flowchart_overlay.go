@@ -12,20 +12,27 @@ import (
 // ExecutedPath captures which edges and nodes a specific workflow instance
 // actually traversed, derived from its WorkflowAction history.
 type ExecutedPath struct {
-	Edges   [][2]string     // deduped executed (from,to) pairs, in first-seen order
-	Visited map[string]bool // every node touched, including the current state
-	Current string          // the workflow's current state ("you are here")
+	Edges       [][2]string     // deduped executed (from,to) pairs, in first-seen order
+	Visited     map[string]bool // every node touched, including the current state
+	Compensated map[string]bool // nodes undone by a compensation after the workflow failed
+	Current     string          // the workflow's current state ("you are here")
 }
 
 // extractExecutedPath walks the action history and pulls out the transitions
 // that were actually executed. Transitions are recorded by the executor as
-// Type:"TRANSITION", Name:<fromState>, Text:"From <from> to <to>".
+// Type:"TRANSITION", Name:<fromState>, Text:"From <from> to <to>", and moves
+// to an error state the same way with Type:"ERROR_TRANSITION".
 // Records whose Text does not match that exact shape are skipped defensively.
+// Compensations are recorded as Type:"COMPENSATED", Name:<state>.
 func extractExecutedPath(actions []domain.WorkflowAction, currentState string) ExecutedPath {
-	p := ExecutedPath{Visited: make(map[string]bool)}
+	p := ExecutedPath{Visited: make(map[string]bool), Compensated: make(map[string]bool)}
 	seen := make(map[string]bool)
 	for _, a := range actions {
-		if a.Type != "TRANSITION" {
+		if a.Type == "COMPENSATED" && a.Name != "" {
+			p.Compensated[a.Name] = true
+			continue
+		}
+		if a.Type != "TRANSITION" && a.Type != "ERROR_TRANSITION" {
 			continue
 		}
 		from := a.Name
@@ -57,11 +64,13 @@ func extractExecutedPath(actions []domain.WorkflowAction, currentState string) E
 //   - executed transitions  -> bold blue linkStyle
 //   - visited normal nodes  -> blue fill tint (semantic start/end/error/manual
 //     nodes keep their meaningful colour)
+//   - compensated nodes     -> amber fill with a dashed outline
 //   - current state         -> bold blue ring, appended LAST so it always wins
 //
 // linkStyle indices are resolved by parsing the actual stored text: Mermaid
 // numbers links by declaration order, and the only link statements emitted by
-// buildFlowChart are bare "<from> --> <to>" lines (no edge labels).
+// flowChartOf are bare "<from> --> <to>" lines and dashed failure routes
+// "<from> -.->|failure| <to>".
 func annotateFlowChart(flowChart string, path ExecutedPath, nodeTypes map[string]models.StateType) string {
 	if strings.TrimSpace(flowChart) == "" {
 		return flowChart
@@ -71,13 +80,22 @@ func annotateFlowChart(flowChart string, path ExecutedPath, nodeTypes map[string
 	linkIdx := 0
 	for _, ln := range strings.Split(flowChart, "\n") {
 		t := strings.TrimSpace(ln)
-		if !strings.Contains(t, "-->") {
+		arrow := "-->"
+		if strings.Contains(t, "-.->") {
+			arrow = "-.->"
+		} else if !strings.Contains(t, arrow) {
 			continue
 		}
-		parts := strings.SplitN(t, "-->", 2)
+		parts := strings.SplitN(t, arrow, 2)
 		if len(parts) == 2 {
 			from := strings.TrimSpace(parts[0])
 			to := strings.TrimSpace(parts[1])
+			if strings.HasPrefix(to, "|") {
+				// drop the edge label
+				if end := strings.Index(to[1:], "|"); end >= 0 {
+					to = strings.TrimSpace(to[end+2:])
+				}
+			}
 			edgeIndex[from+"\x00"+to] = linkIdx
 		}
 		linkIdx++
@@ -104,7 +122,7 @@ func annotateFlowChart(flowChart string, path ExecutedPath, nodeTypes map[string
 	}
 	sort.Strings(visited)
 	for _, name := range visited {
-		if name == path.Current {
+		if name == path.Current || path.Compensated[name] {
 			continue
 		}
 		if nodeTypes[name] == models.StateNormal {
@@ -112,6 +130,18 @@ func annotateFlowChart(flowChart string, path ExecutedPath, nodeTypes map[string
 		}
 	}
 
+	compensated := make([]string, 0, len(path.Compensated))
+	for n := range path.Compensated {
+		compensated = append(compensated, n)
+	}
+	sort.Strings(compensated)
+	for _, name := range compensated {
+		if name == path.Current {
+			continue
+		}
+		b.WriteString("    style " + name + " fill:#fef3c7,stroke:#d97706,stroke-dasharray: 4 2;\n")
+	}
+
 	if path.Current != "" {
 		b.WriteString("    style " + path.Current + " stroke:#1d4ed8,stroke-width:4px;\n")
 	}
@@ -64,6 +64,17 @@ func TestExtractExecutedPath_NoTransitions(t *testing.T) {
 	}
 }
 
+func TestExtractExecutedPath_ErrorTransition(t *testing.T) {
+	actions := []domain.WorkflowAction{
+		{Type: "ERROR_TRANSITION", Name: "Process", Text: "From Process to Failed"},
+		{Type: "TRANSITION", Name: "Start", Text: "From Start to Process"},
+	}
+	p := extractExecutedPath(actions, "Failed")
+	if es := edgeSet(p.Edges); !es["Start>Process"] || !es["Process>Failed"] {
+		t.Fatalf("expected the move to the error state as an edge, got %v", p.Edges)
+	}
+}
+
 const sampleFlow = `flowchart TD
     Start --> Process
     Process --> Wait
@@ -134,3 +145,38 @@ func TestAnnotateFlowChart_EmptyFlowChartUnchanged(t *testing.T) {
 		t.Fatalf("empty flowchart must be returned unchanged, got %q", got)
 	}
 }
+
+func TestAnnotateFlowChart_FailureRouteEdge(t *testing.T) {
+	flow := sampleFlow + "\n    Process -.->|failure| Failed"
+	path := ExecutedPath{
+		Edges:   [][2]string{{"Start", "Process"}, {"Process", "Failed"}},
+		Visited: map[string]bool{"Start": true, "Process": true, "Failed": true},
+		Current: "Failed",
+	}
+	out := annotateFlowChart(flow, path, nil)
+
+	// The dashed failure route is declared after the four solid links.
+	if !strings.Contains(out, "linkStyle 0,4 stroke:#2563eb,stroke-width:3px;") {
+		t.Fatalf("missing/incorrect linkStyle line for failure route:\n%s", out)
+	}
+}
+
+func TestAnnotateFlowChart_CompensatedNodes(t *testing.T) {
+	actions := []domain.WorkflowAction{
+		{Type: "TRANSITION", Name: "Start", Text: "From Start to Process"},
+		{Type: "TRANSITION", Name: "Process", Text: "From Process to Wait"},
+		{Type: "COMPENSATED", Name: "Process", Text: "Compensated by UndoProcess"},
+	}
+	path := extractExecutedPath(actions, "Wait")
+	if !path.Compensated["Process"] {
+		t.Fatalf("expected Process to be compensated, got %v", path.Compensated)
+	}
+
+	out := annotateFlowChart(sampleFlow, path, map[string]models.StateType{"Process": models.StateNormal})
+	if !strings.Contains(out, "style Process fill:#fef3c7,stroke:#d97706,stroke-dasharray: 4 2;") {
+		t.Fatalf("missing compensated style for Process:\n%s", out)
+	}
+	if strings.Contains(out, "style Process fill:#dbeafe") {
+		t.Fatalf("compensated node must not get the generic visited fill:\n%s", out)
+	}
+}
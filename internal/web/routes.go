@@ -5,46 +5,53 @@ import (
 	"net/http"
 )
 
-func (c *WebController) RegisterRoutes() {
+func (c *WebController) RegisterRoutes(mux *http.ServeMux) {
 
 	// Static files (images)
 	imagesSub, err := fs.Sub(templatesFS, "images")
 	if err != nil {
 		panic(err)
 	}
-	http.Handle("/images/", http.StripPrefix("/images/", http.FileServer(http.FS(imagesSub))))
+	mux.Handle("/images/", http.StripPrefix("/images/", http.FileServer(http.FS(imagesSub))))
 
 	// Public routes
-	http.HandleFunc("GET /login", c.loginPageHandler)
-	http.HandleFunc("POST /login", c.loginSubmitHandler)
+	mux.HandleFunc("GET /login", c.loginPageHandler)
+	mux.HandleFunc("POST /login", c.loginSubmitHandler)
 
 	// Protected routes
-	http.HandleFunc("/", c.RequireAuth(c.handler))
-	http.HandleFunc("POST /logout", c.RequireAuth(c.logoutHandler))
+	mux.HandleFunc("/", c.RequireAuth(c.handler))
+	mux.HandleFunc("POST /logout", c.RequireAuth(c.logoutHandler))
 	// Home dashboard fragments
-	http.HandleFunc("GET /home/overview", c.RequireAuth(c.overviewHandler))
-	http.HandleFunc("GET /home/inprogresscount", c.RequireAuth(c.inProgressTopHandler))
-	http.HandleFunc("GET /home/nextexecutioncount", c.RequireAuth(c.nextExecutionTopHandler))
+	mux.HandleFunc("GET /home/overview", c.RequireAuth(c.overviewHandler))
+	mux.HandleFunc("GET /home/inprogresscount", c.RequireAuth(c.inProgressTopHandler))
+	mux.HandleFunc("GET /home/nextexecutioncount", c.RequireAuth(c.nextExecutionTopHandler))
 	// Settings page
-	http.HandleFunc("GET /settings", c.RequireAuth(c.settingsHandler))
+	mux.HandleFunc("GET /settings", c.RequireAuth(c.settingsHandler))
 	// Search page and results
-	http.HandleFunc("GET /search", c.RequireAuth(c.searchPageHandler))
-	http.HandleFunc("GET /search/results", c.RequireAuth(c.searchResultsHandler))
-	http.HandleFunc("GET /details/{id}", c.RequireAuth(c.workflowDetailsHandler))
+	mux.HandleFunc("GET /search", c.RequireAuth(c.searchPageHandler))
+	mux.HandleFunc("GET /search/results", c.RequireAuth(c.searchResultsHandler))
+	mux.HandleFunc("GET /details/{id}", c.RequireAuth(c.workflowDetailsHandler))
 	// Executors page
-	http.HandleFunc("GET /executors", c.RequireAuth(c.executorsHandler))
+	mux.HandleFunc("GET /executors", c.RequireAuth(c.executorsHandler))
 	// Full page list of definitions
-	http.HandleFunc("GET /definitions", c.RequireAuth(c.definitionsHandler))
+	mux.HandleFunc("GET /definitions", c.RequireAuth(c.definitionsHandler))
 	// Detail fragment; support both /definitions/{name} and /definitions/{group}/{name}
-	http.HandleFunc("GET /definitions/{name}", c.RequireAuth(c.definitionByNameHandler))
-	http.HandleFunc("GET /definitions/{group}/{name}", c.RequireAuth(c.definitionByNameHandler))
+	mux.HandleFunc("GET /definitions/{name}", c.RequireAuth(c.definitionByNameHandler))
+	mux.HandleFunc("GET /definitions/{group}/{name}", c.RequireAuth(c.definitionByNameHandler))
 	// Create workflow page for a given definition
-	http.HandleFunc("GET /definitions/{name}/create", c.RequireAuth(c.createWorkflowPageHandler))
+	mux.HandleFunc("GET /definitions/{name}/create", c.RequireAuth(c.createWorkflowPageHandler))
+	// Schedule management pages
+	mux.HandleFunc("GET /schedules", c.RequireAuth(c.schedulesHandler))
+	mux.HandleFunc("GET /schedules/create", c.RequireAuth(c.scheduleFormHandler))
+	mux.HandleFunc("POST /schedules/create", c.RequireAuth(c.scheduleSubmitHandler))
+	mux.HandleFunc("GET /schedules/{id}/edit", c.RequireAuth(c.scheduleFormHandler))
+	mux.HandleFunc("POST /schedules/{id}/edit", c.RequireAuth(c.scheduleSubmitHandler))
+	mux.HandleFunc("POST /schedules/{id}/delete", c.RequireAuth(c.deleteScheduleHandler))
 	// User management pages
-	http.HandleFunc("GET /users", c.RequireAuth(c.usersHandler))
-	http.HandleFunc("GET /users/create", c.RequireAuth(c.createUserHandler))
-	http.HandleFunc("POST /users/create", c.RequireAuth(c.createUserSubmitHandler))
-	http.HandleFunc("GET /users/{id}/edit", c.RequireAuth(c.editUserHandler))
-	http.HandleFunc("POST /users/{id}/edit", c.RequireAuth(c.editUserSubmitHandler))
-	http.HandleFunc("POST /users/{id}/delete", c.RequireAuth(c.deleteUserHandler))
+	mux.HandleFunc("GET /users", c.RequireAuth(c.usersHandler))
+	mux.HandleFunc("GET /users/create", c.RequireAuth(c.createUserHandler))
+	mux.HandleFunc("POST /users/create", c.RequireAuth(c.createUserSubmitHandler))
+	mux.HandleFunc("GET /users/{id}/edit", c.RequireAuth(c.editUserHandler))
+	mux.HandleFunc("POST /users/{id}/edit", c.RequireAuth(c.editUserSubmitHandler))
+	mux.HandleFunc("POST /users/{id}/delete", c.RequireAuth(c.deleteUserHandler))
 }
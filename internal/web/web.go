@@ -6,11 +6,13 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/RealZimboGuy/gopherflow/internal/config"
 	"github.com/RealZimboGuy/gopherflow/internal/controllers"
 	"github.com/RealZimboGuy/gopherflow/internal/engine"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/core"
 	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/domain"
 	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/models"
 
@@ -59,6 +61,7 @@ type workflowRow struct {
 	Status         string
 	State          string
 	ExecutorGroup  string
+	Priority       int
 	NextActivation string
 	Created        string
 	Modified       string
@@ -222,6 +225,7 @@ type WorkflowDefinitionModel struct {
 	FlowChart string
 	Created   string
 	Updated   string
+	Issues    int
 }
 
 func (wc *WebController) definitionsHandler(w http.ResponseWriter, r *http.Request) {
@@ -239,6 +243,7 @@ func (wc *WebController) definitionsHandler(w http.ResponseWriter, r *http.Reque
 			FlowChart: d.FlowChart,
 			Created:   d.Created.Local().Format("2006-01-02 15:04:05"),
 			Updated:   d.Updated.Local().Format("2006-01-02 15:04:05"),
+			Issues:    len(wc.manager.DefinitionIssues(d.Name)),
 		})
 	}
 
@@ -309,6 +314,8 @@ func (wc *WebController) workflowDetailsHandler(w http.ResponseWriter, r *http.R
 		NextActivation   string
 		StartedAt        string
 		ParentWorkflowID sql.NullInt64
+		Version          int
+		Priority         int
 	}
 	// Format times safely
 	formatTS := func(t time.Time) string { return t.Local().Format("2006-01-02 15:04:05") }
@@ -331,6 +338,8 @@ func (wc *WebController) workflowDetailsHandler(w http.ResponseWriter, r *http.R
 		NextActivation:   nextAct,
 		StartedAt:        startedAt,
 		ParentWorkflowID: wf.ParentWorkflowID,
+		Version:          wf.WorkflowVersion,
+		Priority:         wf.Priority,
 	}
 
 	type defVM struct {
@@ -350,6 +359,12 @@ func (wc *WebController) workflowDetailsHandler(w http.ResponseWriter, r *http.R
 			Created:     def.Created.Local().Format("2006-01-02 15:04:05"),
 			Updated:     def.Updated.Local().Format("2006-01-02 15:04:05"),
 		}
+		// Workflows pinned to an older version are drawn with the flow chart of that version
+		if wf.WorkflowVersion > 0 && wf.WorkflowVersion != def.Version {
+			if v, err := wc.manager.GetWorkflowDefinitionVersion(wf.WorkflowType, wf.WorkflowVersion); err == nil && v != nil {
+				dvm.FlowChart = v.FlowChart
+			}
+		}
 	}
 
 	type actionVM struct {
@@ -390,13 +405,14 @@ func (wc *WebController) workflowDetailsHandler(w http.ResponseWriter, r *http.R
 		StateVars          map[string]string
 		States             []stateOption
 		ChildWorkflows     []workflowVM
+		Tree               *models.WorkflowTreeResponse
 	}
 
 	// Build States options from workflow definition if available (fallback: current state only)
 	var stateOptions []stateOption
 	nodeTypes := make(map[string]models.StateType)
 	// Prefer states from the workflow implementation via engine registry
-	if inst, err := engine.CreateWorkflowInstance(wc.manager, wf.WorkflowType); err == nil && inst != nil {
+	if inst, err := engine.CreateWorkflowInstanceVersion(wc.manager, wf.WorkflowType, wf.WorkflowVersion); err == nil && inst != nil {
 		for _, s := range inst.GetAllStates() {
 			stateOptions = append(stateOptions, stateOption{Name: s.Name})
 			nodeTypes[s.Name] = s.StateType
@@ -444,6 +460,14 @@ func (wc *WebController) workflowDetailsHandler(w http.ResponseWriter, r *http.R
 		}
 	}
 
+	// The tree of parent and child workflows, only shown when the workflow is part of one
+	var tree *models.WorkflowTreeResponse
+	if wf.ParentWorkflowID.Valid || len(childWorkflowsVM) > 0 {
+		if tree, err = wc.manager.WorkflowTree(wf.ID); err != nil {
+			slog.Error("Failed to load workflow tree", "workflow_id", wf.ID, "error", err)
+		}
+	}
+
 	data := detailModel{
 		Title:              fmt.Sprintf("Workflow %d - %s", wf.ID, wf.WorkflowType),
 		RequestURI:         r.URL.Path,
@@ -454,6 +478,7 @@ func (wc *WebController) workflowDetailsHandler(w http.ResponseWriter, r *http.R
 		StateVars:          stateVars,
 		States:             stateOptions,
 		ChildWorkflows:     childWorkflowsVM,
+		Tree:               tree,
 	}
 
 	// Full page render when not HTMX: include header/nav and wrap content so direct URL has full layout
@@ -498,18 +523,38 @@ func (wc *WebController) definitionByNameHandler(w http.ResponseWriter, r *http.
 	}
 	// Map to a view model with formatted dates
 	type defVM struct {
-		Name        string
-		Description string
-		FlowChart   string
-		Created     string
-		Updated     string
+		Name           string
+		Description    string
+		FlowChart      string
+		Created        string
+		Updated        string
+		Version        int
+		MaxConcurrency int
+		Running        int
 	}
 	dvm := defVM{
-		Name:        def.Name,
-		Description: def.Description,
-		FlowChart:   def.FlowChart,
-		Created:     def.Created.Local().Format("2006-01-02 15:04:05"),
-		Updated:     def.Updated.Local().Format("2006-01-02 15:04:05"),
+		Name:           def.Name,
+		Description:    def.Description,
+		FlowChart:      def.FlowChart,
+		Created:        def.Created.Local().Format("2006-01-02 15:04:05"),
+		Updated:        def.Updated.Local().Format("2006-01-02 15:04:05"),
+		Version:        def.Version,
+		MaxConcurrency: def.MaxConcurrency,
+	}
+	if running, err := wc.manager.RunningWorkflows(def.Name); err == nil {
+		dvm.Running = running
+	} else {
+		slog.Error("Failed to count running workflows", "name", def.Name, "error", err)
+	}
+	type versionVM struct {
+		Version int
+		Updated string
+	}
+	var versionRows []versionVM
+	if versions, err := wc.manager.ListWorkflowDefinitionVersions(def.Name); err == nil && versions != nil {
+		for _, v := range *versions {
+			versionRows = append(versionRows, versionVM{Version: v.Version, Updated: v.Updated.Local().Format("2006-01-02 15:04:05")})
+		}
 	}
 
 	// Build Overview rows: list all states from workflow and merge DB counts
@@ -553,6 +598,8 @@ func (wc *WebController) definitionByNameHandler(w http.ResponseWriter, r *http.
 		Title              string
 		RequestURI         string
 		WorkflowDefinition defVM
+		Versions           []versionVM
+		Issues             []core.DefinitionIssue
 		States             []stateRow
 		Totals             struct{ New, Scheduled, Executing, InProgress, Finished int }
 	}
@@ -561,6 +608,8 @@ func (wc *WebController) definitionByNameHandler(w http.ResponseWriter, r *http.
 		Title:              "Workflow Definition - " + def.Name,
 		RequestURI:         r.URL.Path,
 		WorkflowDefinition: dvm,
+		Versions:           versionRows,
+		Issues:             wc.manager.DefinitionIssues(def.Name),
 		States:             stateRows,
 		Totals:             totals,
 	}
@@ -589,6 +638,7 @@ type ExecutorModel struct {
 	Host      string
 	StartedAt string
 	LastAlive string
+	Stopped   string
 	CssClass  string
 }
 
@@ -731,6 +781,7 @@ func (wc *WebController) searchResultsHandler(w http.ResponseWriter, r *http.Req
 				Status:         wf.Status,
 				State:          wf.State,
 				ExecutorGroup:  wf.ExecutorGroup,
+				Priority:       wf.Priority,
 				NextActivation: nextAct,
 				Created:        wf.Created.Local().Format("2006-01-02 15:04:05"),
 				Modified:       wf.Modified.Local().Format("2006-01-02 15:04:05"),
@@ -777,7 +828,7 @@ func (wc *WebController) searchResultsHandler(w http.ResponseWriter, r *http.Req
 
 func getNextActivationString(wf domain.Workflow) string {
 	var nextAct string
-	if wf.Status == "FINISHED" || wf.Status == "FAILED" {
+	if wf.Status == "FINISHED" || wf.Status == "FAILED" || wf.Status == "CANCELLED" {
 		nextAct = "-"
 	} else if wf.NextActivation.Valid {
 		t := wf.NextActivation.Time.Local()
@@ -815,14 +866,23 @@ func (wc *WebController) executorsHandler(w http.ResponseWriter, r *http.Request
 	}
 	models := make([]ExecutorModel, 0, len(execs))
 	for _, e := range execs {
-		models = append(models, ExecutorModel{
+		m := ExecutorModel{
 			ID:        e.ID,
-			Group:     "default", // grouping not modeled yet
+			Group:     e.Groups,
 			Host:      e.Name,
 			StartedAt: e.Started.Local().Format("2006-01-02 15:04:05"),
 			LastAlive: friendlyTimeAgo(e.LastActive.Local()),
+			Stopped:   "-",
 			CssClass:  statusCssClass(e.LastActive.Local()),
-		})
+		}
+		if m.Group == "" {
+			m.Group = "-"
+		}
+		if e.Stopped.Valid {
+			m.Stopped = e.Stopped.Time.Local().Format("2006-01-02 15:04:05")
+			m.CssClass = "bg-gray-200"
+		}
+		models = append(models, m)
 	}
 	data := struct {
 		Title       string
@@ -864,6 +924,10 @@ func (wc *WebController) settingsHandler(w http.ResponseWriter, r *http.Request)
 		{Key: "GFLOW_ENGINE_BATCH_SIZE", Value: config.GetSystemSettingString(config.ENGINE_BATCH_SIZE)},
 		{Key: "GFLOW_ENGINE_EXECUTOR_GROUP", Value: config.GetSystemSettingString(config.ENGINE_EXECUTOR_GROUP)},
 		{Key: "GFLOW_ENGINE_EXECUTOR_SIZE", Value: config.GetSystemSettingString(config.ENGINE_EXECUTOR_SIZE)},
+		{Key: "GFLOW_ENGINE_NOTIFY_ENABLED", Value: config.GetSystemSettingString(config.ENGINE_NOTIFY_ENABLED)},
+		{Key: "GFLOW_ENGINE_LEASE_DURATION", Value: config.GetSystemSettingString(config.ENGINE_LEASE_DURATION)},
+		{Key: "GFLOW_ENGINE_SHUTDOWN_GRACE_PERIOD", Value: config.GetSystemSettingString(config.ENGINE_SHUTDOWN_GRACE_PERIOD)},
+		{Key: "GFLOW_ENGINE_PRIORITY_AGING", Value: config.GetSystemSettingString(config.ENGINE_PRIORITY_AGING)},
 		{Key: "GFLOW_WEB_SESSION_EXPIRY_HOURS", Value: config.GetSystemSettingString(config.WEB_SESSION_EXPIRY_HOURS)},
 	}
 	data := struct {
@@ -1456,3 +1520,216 @@ func (wc *WebController) deleteUserHandler(w http.ResponseWriter, r *http.Reques
 	// Redirect to users list
 	http.Redirect(w, r, "/users", http.StatusSeeOther)
 }
+
+type scheduleVM struct {
+	ID             int64
+	Name           string
+	CronExpression string
+	Timezone       string
+	WorkflowType   string
+	ExecutorGroup  string
+	BusinessKey    string
+	StateVars      string
+	OverlapPolicy  string
+	Enabled        bool
+	NextRun        string
+	LastRun        string
+	LastWorkflowID int64
+}
+
+func toScheduleVM(s domain.Schedule) scheduleVM {
+	vm := scheduleVM{
+		ID:             s.ID,
+		Name:           s.Name,
+		CronExpression: s.CronExpression,
+		Timezone:       s.Timezone,
+		WorkflowType:   s.WorkflowType,
+		ExecutorGroup:  s.ExecutorGroup,
+		BusinessKey:    s.BusinessKey,
+		StateVars:      s.StateVars,
+		OverlapPolicy:  s.OverlapPolicy,
+		Enabled:        s.Enabled,
+		LastWorkflowID: s.LastWorkflowID.Int64,
+	}
+	if s.NextRun.Valid {
+		vm.NextRun = s.NextRun.Time.Local().Format("2006-01-02 15:04:05")
+	}
+	if s.LastRun.Valid {
+		vm.LastRun = s.LastRun.Time.Local().Format("2006-01-02 15:04:05")
+	}
+	return vm
+}
+
+// schedulesHandler lists the recurring schedules
+func (wc *WebController) schedulesHandler(w http.ResponseWriter, r *http.Request) {
+	schedules, err := wc.manager.ListSchedules()
+	if err != nil {
+		slog.Error("Failed to get schedules", "error", err)
+		http.Error(w, "Failed to load schedules", http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]scheduleVM, 0, len(*schedules))
+	for _, s := range *schedules {
+		list = append(list, toScheduleVM(s))
+	}
+
+	data := struct {
+		Title       string
+		CurrentPath string
+		Schedules   []scheduleVM
+	}{
+		Title:       "Schedules",
+		CurrentPath: r.URL.Path,
+		Schedules:   list,
+	}
+
+	tmpl, err := template.New("").Funcs(template.FuncMap{"hasPrefix": hasPrefix}).ParseFS(
+		templatesFS,
+		"templates/fragments/header.html",
+		"templates/fragments/nav.html",
+		"templates/schedules/schedules.html",
+	)
+	if err != nil {
+		slog.Error("Failed to parse schedules template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tmpl.ExecuteTemplate(w, "schedules", data); err != nil {
+		slog.Error("Failed to execute schedules template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// scheduleFormHandler displays the form to create a schedule, or to edit one when the path has an id
+func (wc *WebController) scheduleFormHandler(w http.ResponseWriter, r *http.Request) {
+	vm := scheduleVM{Enabled: true, Timezone: "UTC", OverlapPolicy: models.OverlapAllow}
+	if idStr := r.PathValue("id"); idStr != "" {
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid schedule ID", http.StatusBadRequest)
+			return
+		}
+		s, err := wc.manager.GetSchedule(id)
+		if err != nil {
+			slog.Error("Error finding schedule", "id", id, "error", err)
+			http.Error(w, "Schedule not found", http.StatusNotFound)
+			return
+		}
+		vm = toScheduleVM(*s)
+	}
+	wc.renderScheduleForm(w, r, vm, "")
+}
+
+func (wc *WebController) renderScheduleForm(w http.ResponseWriter, r *http.Request, vm scheduleVM, formError string) {
+	title, action := "Create Schedule", "/schedules/create"
+	if vm.ID != 0 {
+		title, action = "Edit Schedule", fmt.Sprintf("/schedules/%d/edit", vm.ID)
+	}
+
+	var workflowTypes []string
+	if defs, err := wc.manager.ListWorkflowDefinitions(); err == nil && defs != nil {
+		for _, d := range *defs {
+			workflowTypes = append(workflowTypes, d.Name)
+		}
+	}
+
+	data := struct {
+		Title         string
+		CurrentPath   string
+		Action        string
+		Error         string
+		Schedule      scheduleVM
+		WorkflowTypes []string
+	}{
+		Title:         title,
+		CurrentPath:   r.URL.Path,
+		Action:        action,
+		Error:         formError,
+		Schedule:      vm,
+		WorkflowTypes: workflowTypes,
+	}
+
+	tmpl, err := template.New("").Funcs(template.FuncMap{"hasPrefix": hasPrefix}).ParseFS(
+		templatesFS,
+		"templates/fragments/header.html",
+		"templates/fragments/nav.html",
+		"templates/schedules/schedule_form.html",
+	)
+	if err != nil {
+		slog.Error("Failed to parse schedule form template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if formError != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	if err := tmpl.ExecuteTemplate(w, "schedule_form", data); err != nil {
+		slog.Error("Failed to execute schedule form template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// scheduleSubmitHandler creates or updates a schedule from the form, showing the form again when it is invalid
+func (wc *WebController) scheduleSubmitHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form submission", http.StatusBadRequest)
+		return
+	}
+
+	s := &domain.Schedule{}
+	if idStr := r.PathValue("id"); idStr != "" {
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid schedule ID", http.StatusBadRequest)
+			return
+		}
+		existing, err := wc.manager.GetSchedule(id)
+		if err != nil {
+			slog.Error("Error finding schedule", "id", id, "error", err)
+			http.Error(w, "Schedule not found", http.StatusNotFound)
+			return
+		}
+		s = existing
+	}
+
+	s.Name = strings.TrimSpace(r.FormValue("name"))
+	s.CronExpression = strings.TrimSpace(r.FormValue("cronExpression"))
+	s.Timezone = strings.TrimSpace(r.FormValue("timezone"))
+	s.WorkflowType = r.FormValue("workflowType")
+	s.ExecutorGroup = strings.TrimSpace(r.FormValue("executorGroup"))
+	s.BusinessKey = strings.TrimSpace(r.FormValue("businessKey"))
+	s.StateVars = strings.TrimSpace(r.FormValue("stateVars"))
+	s.OverlapPolicy = r.FormValue("overlapPolicy")
+	s.Enabled = r.FormValue("enabled") == "on"
+
+	if err := wc.manager.SaveSchedule(s); err != nil {
+		if errors.Is(err, engine.ErrInvalidSchedule) {
+			wc.renderScheduleForm(w, r, toScheduleVM(*s), err.Error())
+			return
+		}
+		slog.Error("Failed to save schedule", "error", err)
+		http.Error(w, "Failed to save schedule", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/schedules", http.StatusSeeOther)
+}
+
+// deleteScheduleHandler removes a schedule, workflows it already created are kept
+func (wc *WebController) deleteScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid schedule ID", http.StatusBadRequest)
+		return
+	}
+	if err := wc.manager.DeleteSchedule(id); err != nil {
+		slog.Error("Failed to delete schedule", "id", id, "error", err)
+		http.Error(w, "Failed to delete schedule", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/schedules", http.StatusSeeOther)
+}
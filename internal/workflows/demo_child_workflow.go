@@ -71,6 +71,11 @@ func (w *DemoChildWorkflow) GetRetryConfig() models.RetryConfig {
 	}
 }
 
+// ResultVariables hands the processing result to the parent when the child finishes
+func (w *DemoChildWorkflow) ResultVariables() []string {
+	return []string{"result"}
+}
+
 // GetChildWorkflows implements the ParentChildCapable interface
 func (w *DemoChildWorkflow) GetChildWorkflows(ctx context.Context) ([]domain.Workflow, error) {
 	// Child workflows don't have children, but we implement this for interface compliance
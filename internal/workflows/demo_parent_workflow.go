@@ -51,7 +51,7 @@ func (w *DemoParentWorkflow) StateTransitions() map[string][]string {
 	return map[string][]string{
 		ParentInit:            {ParentSpawnChildren},
 		ParentSpawnChildren:   {ParentWaitForChildren},
-		ParentWaitForChildren: {ParentFinish, ParentWaitForChildren},
+		ParentWaitForChildren: {ParentFinish},
 	}
 }
 
@@ -59,7 +59,7 @@ func (w *DemoParentWorkflow) GetAllStates() []models.WorkflowState {
 	return []models.WorkflowState{
 		{Name: ParentInit, StateType: models.StateStart},
 		{Name: ParentSpawnChildren, StateType: models.StateNormal},
-		{Name: ParentWaitForChildren, StateType: models.StateNormal},
+		{Name: ParentWaitForChildren, StateType: models.StateNormal, Join: &models.JoinPolicy{Type: models.JoinAll}},
 		{Name: ParentFinish, StateType: models.StateEnd},
 	}
 }
@@ -125,63 +125,25 @@ func (w *DemoParentWorkflow) ParentSpawnChildren(ctx context.Context) (*models.N
 	}, nil
 }
 
-// ParentWaitForChildren checks if all children are complete
+// ParentWaitForChildren runs once every child has ended, the engine waits for them through the join of the state
 func (w *DemoParentWorkflow) ParentWaitForChildren(ctx context.Context) (*models.NextState, error) {
-	slog.InfoContext(ctx, "Waiting for child workflows to complete", "workflow_id", w.WorkflowState.ID)
+	summary := w.ChildSummary
+	slog.InfoContext(ctx, "Child workflows ended", "workflow_id", w.WorkflowState.ID,
+		"finished", summary.Finished, "failed", summary.Failed)
 
-	// Get all child workflows
-	children, err := w.GetChildWorkflows(ctx)
+	// the engine copied the result of every finished child into the state variables
+	results, err := gopherflow.ChildWorkflowResults(w.StateVariables)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get child workflows: %w", err)
+		return nil, fmt.Errorf("failed to read child workflow results: %w", err)
 	}
-
-	// Count how many children are complete
-	completedCount := 0
-	for _, child := range children {
-		if child.Status == "FINISHED" {
-			completedCount++
-			slog.InfoContext(ctx, "Child workflow is complete",
-				"child_id", child.ID,
-				"business_key", child.BusinessKey)
-		} else {
-			slog.InfoContext(ctx, "Child workflow is still running",
-				"child_id", child.ID,
-				"business_key", child.BusinessKey,
-				"status", child.Status)
-		}
-	}
-
-	// Parse children_count from state variables
-	expectedChildren := 2 // Default if we can't parse
-	if countStr, ok := w.StateVariables["children_count"]; ok {
-		if count, err := fmt.Sscanf(countStr, "%d", &expectedChildren); err != nil || count != 1 {
-			// If there's an error parsing, just use our default
-			expectedChildren = 2
-		}
+	for externalID, result := range results {
+		slog.InfoContext(ctx, "Child workflow result",
+			"external_id", externalID,
+			"result", result["result"])
 	}
 
-	// If not all children are complete, wait and check again later
-	if completedCount < expectedChildren {
-		slog.InfoContext(ctx, "Not all children complete, waiting",
-			"completed", completedCount,
-			"expected", expectedChildren)
-
-		// We'll check again in 5 seconds
-		return &models.NextState{
-			Name:                ParentWaitForChildren,
-			ActionLog:           fmt.Sprintf("Waiting for children: %d/%d complete", completedCount, expectedChildren),
-			NextExecutionOffset: "10 minutes",
-		}, nil
-	}
-
-	// All children are complete, collect their results
-	slog.InfoContext(ctx, "All children complete, proceeding to finish")
-
-	// In a real workflow, you might want to process results from children here
-	// For this demo, we just move to finish
-
 	return &models.NextState{
 		Name:      ParentFinish,
-		ActionLog: "All child workflows complete",
+		ActionLog: fmt.Sprintf("Child workflows ended: %d finished, %d failed", summary.Finished, summary.Failed),
 	}, nil
 }
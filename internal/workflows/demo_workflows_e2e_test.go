@@ -26,6 +26,7 @@ type stubRepo struct {
 
 	wakeCalls int
 	saved     []*domain.Workflow
+	results   map[string]string
 }
 
 func (r *stubRepo) UpdateWorkflowStatus(_ int64, status string) error { r.status = status; return nil }
@@ -36,6 +37,16 @@ func (r *stubRepo) SaveWorkflowVariablesAndTouch(_ int64, vars string) error {
 	r.vars = vars
 	return nil
 }
+func (r *stubRepo) FindWorkflowVariablesForUpdate(_ int64) (sql.NullString, error) {
+	return sql.NullString{String: r.vars, Valid: r.vars != ""}, nil
+}
+func (r *stubRepo) SetWorkflowVariable(_ int64, key string, value string) error {
+	if r.results == nil {
+		r.results = make(map[string]string)
+	}
+	r.results[key] = value
+	return nil
+}
 func (r *stubRepo) WakeParentWorkflow(_ int64) error { r.wakeCalls++; return nil }
 func (r *stubRepo) Save(wf *domain.Workflow) (int64, error) {
 	r.saved = append(r.saved, wf)
@@ -75,6 +86,32 @@ func (r *stubRepo) GetDefinitionStateOverview(_ string) ([]repository.Definition
 	return nil, nil
 }
 func (r *stubRepo) FindByExternalId(_ string) (*domain.Workflow, error) { return nil, nil }
+func (r *stubRepo) MarkWorkflowAsExecuting(_ int64) bool                { r.status = "EXECUTING"; return true }
+func (r *stubRepo) CancelWorkflow(_ int64) (bool, error)                { return true, nil }
+func (r *stubRepo) FindCancelledWorkflows(_ string, _ int64, _ int) (*[]domain.Workflow, error) {
+	return nil, nil
+}
+func (r *stubRepo) ClaimCancelledWorkflow(_ int64, _ int64) bool       { return true }
+func (r *stubRepo) CompleteCancellation(_ int64) error                 { return nil }
+func (r *stubRepo) PauseWorkflow(_ int64) (bool, error)                { return true, nil }
+func (r *stubRepo) ResumeWorkflow(_ int64) (bool, error)               { return true, nil }
+func (r *stubRepo) SaveSignal(_ *domain.WorkflowSignal) (int64, error) { return 1, nil }
+func (r *stubRepo) FindPendingSignals(_ int64) (*[]domain.WorkflowSignal, error) {
+	return nil, nil
+}
+func (r *stubRepo) MarkSignalsConsumed(_ []int64) error     { return nil }
+func (r *stubRepo) WakeWorkflow(_ int64) error              { return nil }
+func (r *stubRepo) PinWorkflowVersion(_ int64, _ int) error { return nil }
+func (r *stubRepo) RenewLeases(_ int64, ids []int64) (int64, error) {
+	return int64(len(ids)), nil
+}
+func (r *stubRepo) ReclaimExpiredLease(_ int64, _ time.Time) bool  { return true }
+func (r *stubRepo) ReleaseWorkflow(_ int64, _ int64) (bool, error) { return true, nil }
+func (r *stubRepo) ReleaseWorkflows(_ int64, ids []int64) (int64, error) {
+	return int64(len(ids)), nil
+}
+func (r *stubRepo) CountRunningWorkflows(_ string) (int, error) { return 0, nil }
+func (r *stubRepo) UpdatePriority(_ int64, _ int) (bool, error) { return true, nil }
 
 // stubActions records action types/names so we can assert the executor's
 // transition log matches expectations.
@@ -94,6 +131,7 @@ func runChildStep(t *testing.T, repo *stubRepo, clock core.Clock) []string {
 	wf := &workflows.DemoChildWorkflow{Clock: clock}
 	wf.Setup(&domain.Workflow{
 		ID:               1,
+		ExternalID:       "child-1",
 		State:            repo.state,
 		StateVars:        sql.NullString{String: repo.vars, Valid: repo.vars != ""},
 		ParentWorkflowID: sql.NullInt64{Int64: 99, Valid: true},
@@ -140,8 +178,9 @@ func TestDemoChildWorkflow_EndToEnd(t *testing.T) {
 	if repo.status != "FINISHED" {
 		t.Fatalf("after pass 2 expected status=FINISHED, got %q", repo.status)
 	}
-	if repo.wakeCalls != 1 {
-		t.Fatalf("expected one WakeParentWorkflow call, got %d", repo.wakeCalls)
+	// once from ChildWakeParent and once by the engine when the child finished
+	if repo.wakeCalls != 2 {
+		t.Fatalf("expected two WakeParentWorkflow calls, got %d", repo.wakeCalls)
 	}
 	if !containsAction(acts2, "TRANSITION:ChildWakeParent") || !containsAction(acts2, "END:ChildFinish") {
 		t.Errorf("pass 2 missing expected transitions/end, got: %v", acts2)
@@ -151,6 +190,10 @@ func TestDemoChildWorkflow_EndToEnd(t *testing.T) {
 	if !strings.Contains(repo.vars, "Processed after") {
 		t.Errorf("expected state vars to retain processing result, got %q", repo.vars)
 	}
+	// and the result was handed to the parent
+	if result := repo.results[models.ChildResultKey("child-1")]; !strings.Contains(result, "Processed after") {
+		t.Errorf("expected the processing result handed to the parent, got %q", result)
+	}
 }
 
 func TestDemoParentWorkflow_EndToEnd(t *testing.T) {
@@ -5,6 +5,7 @@ import (
 	"log/slog"
 
 	domain "github.com/RealZimboGuy/gopherflow/pkg/gopherflow/domain"
+	models "github.com/RealZimboGuy/gopherflow/pkg/gopherflow/models"
 )
 
 // BaseWorkflow holds common workflow state and provides shared setup logic.
@@ -12,6 +13,9 @@ type BaseWorkflow struct {
 	StateVariables map[string]string
 	WorkflowState  *domain.Workflow
 	ChildWorkflows []domain.Workflow
+	ChildSummary   models.ChildSummary     // outcome of the children once the join of the current state resolved
+	Signals        []domain.WorkflowSignal // pending signals, oldest first
+	consumed       []int64
 }
 
 // Setup initializes the base workflow with the given workflow instance and parses state variables from JSON, if present.
@@ -34,3 +38,42 @@ func (b *BaseWorkflow) Setup(wf *domain.Workflow) {
 func (b *BaseWorkflow) SetChildWorkflows(children []domain.Workflow) {
 	b.ChildWorkflows = children
 }
+
+func (b *BaseWorkflow) SetChildSummary(summary models.ChildSummary) {
+	b.ChildSummary = summary
+}
+
+func (b *BaseWorkflow) SetSignals(signals []domain.WorkflowSignal) {
+	b.Signals = signals
+	b.consumed = nil
+}
+
+// HasSignal reports whether a signal with the given name is pending, without consuming it.
+func (b *BaseWorkflow) HasSignal(name string) bool {
+	for _, s := range b.Signals {
+		if s.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ConsumeSignal takes the oldest pending signal with the given name. The engine marks it consumed once the
+// state that took it has transitioned; if the state fails the signal is delivered again on the retry.
+func (b *BaseWorkflow) ConsumeSignal(name string) (*domain.WorkflowSignal, bool) {
+	for i, s := range b.Signals {
+		if s.Name == name {
+			b.Signals = append(b.Signals[:i:i], b.Signals[i+1:]...)
+			b.consumed = append(b.consumed, s.ID)
+			return &s, true
+		}
+	}
+	return nil, false
+}
+
+// TakeConsumedSignals returns the IDs of the signals consumed since the last call, for the engine to persist.
+func (b *BaseWorkflow) TakeConsumedSignals() []int64 {
+	ids := b.consumed
+	b.consumed = nil
+	return ids
+}
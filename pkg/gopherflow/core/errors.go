@@ -0,0 +1,103 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrWorkflowCancelled is the cause attached to a state's context when its workflow is cancelled.
+// States can check it with context.Cause(ctx) to tell a cancellation apart from a shutdown.
+var ErrWorkflowCancelled = errors.New("workflow cancelled")
+
+// ErrStateTimeout is the cause attached to a state's context when the state runs past its timeout.
+var ErrStateTimeout = errors.New("state timed out")
+
+// PermanentError marks a state error that will never succeed on a retry. The workflow is failed straight away,
+// or moved to State when it names a declared state.
+type PermanentError struct {
+	Err   error
+	State string
+}
+
+func (e *PermanentError) Error() string {
+	if e.Err == nil {
+		return "permanent error"
+	}
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent wraps err so that the engine fails the workflow without retrying.
+func Permanent(err error) error {
+	return &PermanentError{Err: err}
+}
+
+// PermanentWithState wraps err so that the engine moves the workflow to the given state without retrying.
+func PermanentWithState(err error, state string) error {
+	return &PermanentError{Err: err, State: state}
+}
+
+// RetryAfterError asks for the next retry to happen after the given delay instead of the retry policy interval,
+// for example when an API answered 429 with a Retry-After header. It still counts towards MaxRetryCount.
+type RetryAfterError struct {
+	Err   error
+	After time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	if e.Err == nil {
+		return "retry after " + e.After.String()
+	}
+	return e.Err.Error()
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
+// RetryAfter wraps err so that the engine retries the state after the given delay.
+func RetryAfter(err error, after time.Duration) error {
+	return &RetryAfterError{Err: err, After: after}
+}
+
+// IssueSeverity tells whether a definition issue stops the workflow from being registered.
+type IssueSeverity string
+
+const (
+	IssueError   IssueSeverity = "ERROR"   // the workflow cannot run correctly
+	IssueWarning IssueSeverity = "WARNING" // the workflow runs, but part of its graph is likely a mistake
+)
+
+// DefinitionIssue is a problem found in the state graph of a registered workflow.
+type DefinitionIssue struct {
+	Workflow string
+	Version  int
+	State    string // empty when the issue is not about a single state
+	Severity IssueSeverity
+	Message  string
+}
+
+func (i DefinitionIssue) Error() string {
+	if i.State == "" {
+		return fmt.Sprintf("workflow %s v%d: %s", i.Workflow, i.Version, i.Message)
+	}
+	return fmt.Sprintf("workflow %s v%d state %s: %s", i.Workflow, i.Version, i.State, i.Message)
+}
+
+// DefinitionError is returned from setup when registered workflows have errors in their state graphs.
+type DefinitionError struct {
+	Issues []DefinitionIssue
+}
+
+func (e *DefinitionError) Error() string {
+	msgs := make([]string, 0, len(e.Issues))
+	for _, i := range e.Issues {
+		msgs = append(msgs, i.Error())
+	}
+	return "invalid workflow definitions: " + strings.Join(msgs, "; ")
+}
@@ -1,6 +1,9 @@
 package core
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/domain"
 	models "github.com/RealZimboGuy/gopherflow/pkg/gopherflow/models"
 )
@@ -18,3 +21,53 @@ type Workflow interface {
 	GetAllStates() []models.WorkflowState // where to start
 	GetRetryConfig() models.RetryConfig
 }
+
+// CancelHandler can be implemented by workflows that need to clean up when cancelled.
+// CancelState names a declared state whose method is run once, with a fresh context, before the
+// cancellation is recorded; the NextState it returns is ignored apart from the ActionLog.
+type CancelHandler interface {
+	CancelState() string
+}
+
+// DefaultTimeoutProvider can be implemented by workflows to bound every state that does not declare its own
+// Timeout. A zero duration leaves those states unbounded.
+type DefaultTimeoutProvider interface {
+	DefaultStateTimeout() time.Duration
+}
+
+// FailureHandler can be implemented by workflows to move to a declared error state once the retries of any state
+// are exhausted, instead of ending with status FAILED. A state's own FailureState takes precedence.
+type FailureHandler interface {
+	FailureState() string
+}
+
+// Versioned can be implemented by workflows whose states or transitions change while instances are in flight.
+// Each instance is pinned to the version it was created with; workflows without it are version 1.
+type Versioned interface {
+	Version() int
+}
+
+// ConcurrencyLimited can be implemented by workflows of which only a limited number may be scheduled or running at
+// the same time across all executors, for example ones calling a rate limited API. Zero or less is unlimited.
+type ConcurrencyLimited interface {
+	MaxConcurrency() int
+}
+
+// ResultProducer can be implemented by child workflows to hand state variables to their parent. When the child
+// finishes the variables it names are copied into the state variables of the parent, under
+// models.ChildResultKey of its external id, and the parent is woken. Naming none copies all of them.
+type ResultProducer interface {
+	ResultVariables() []string
+}
+
+// VersionKey is the registry key for an older version of a workflow type. The current version stays registered
+// under the plain type name, older ones under VersionKey(name, version) for as long as instances still use them.
+func VersionKey(name string, version int) string {
+	return fmt.Sprintf("%s@v%d", name, version)
+}
+
+// State variables the engine sets when it moves a workflow to an error state.
+const (
+	VarFailedState  = "failedState"  // state that failed
+	VarFailureError = "failureError" // error returned by that state
+)
@@ -1,10 +1,15 @@
 package domain
 
-import "time"
+import (
+	"database/sql"
+	"time"
+)
 
 type Executor struct {
-	ID         int64     // BIGSERIAL
-	Name       string    // TEXT
-	Started    time.Time // TIMESTAMP
-	LastActive time.Time // TIMESTAMP
+	ID         int64        // BIGSERIAL
+	Name       string       // TEXT
+	Groups     string       // TEXT, the executor groups it takes workflows from, e.g. "default:3,reports"
+	Started    time.Time    // TIMESTAMP
+	LastActive time.Time    // TIMESTAMP
+	Stopped    sql.NullTime // TIMESTAMP, set when the executor shut down gracefully
 }
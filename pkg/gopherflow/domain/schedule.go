@@ -0,0 +1,24 @@
+package domain
+
+import (
+	"database/sql"
+	"time"
+)
+
+type Schedule struct {
+	ID             int64         // BIGSERIAL
+	Name           string        // TEXT, unique
+	CronExpression string        // TEXT, five field cron expression
+	Timezone       string        // TEXT, IANA zone the expression is evaluated in
+	WorkflowType   string        // TEXT
+	ExecutorGroup  string        // TEXT
+	BusinessKey    string        // TEXT, template
+	StateVars      string        // TEXT, JSON object of templates
+	OverlapPolicy  string        // TEXT, ALLOW or SKIP
+	Enabled        bool          // BOOLEAN
+	NextRun        sql.NullTime  // TIMESTAMP, NULL when disabled
+	LastRun        sql.NullTime  // TIMESTAMP
+	LastWorkflowID sql.NullInt64 // BIGINT, workflow created by the last run
+	Created        time.Time     // TIMESTAMP
+	Modified       time.Time     // TIMESTAMP
+}
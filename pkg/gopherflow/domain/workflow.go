@@ -4,20 +4,24 @@ import "time"
 import "database/sql"
 
 type Workflow struct {
-	ID               int64
-	Status           string
-	ExecutionCount   int
-	RetryCount       int
-	Created          time.Time
-	Modified         time.Time
-	NextActivation   sql.NullTime
-	Started          sql.NullTime
-	ExecutorID       sql.NullString
-	ExecutorGroup    string
-	WorkflowType     string
-	ExternalID       string
-	BusinessKey      string
-	State            string
-	StateVars        sql.NullString
-	ParentWorkflowID sql.NullInt64
+	ID                int64
+	Status            string
+	ExecutionCount    int
+	RetryCount        int
+	Created           time.Time
+	Modified          time.Time
+	NextActivation    sql.NullTime
+	Started           sql.NullTime
+	ExecutorID        sql.NullString
+	ExecutorGroup     string
+	WorkflowType      string
+	ExternalID        string
+	BusinessKey       string
+	State             string
+	StateVars         sql.NullString
+	ParentWorkflowID  sql.NullInt64
+	WorkflowVersion   int    // version of the definition the workflow is pinned to, 0 until first picked up
+	Priority          int    // higher is claimed first, 0 by default
+	ParentClosePolicy string // what happens to a child when its parent ends, see models.ParentClosePolicy
+	FailurePolicy     string // what a child failing does to its parent, see models.ChildFailurePolicy
 }
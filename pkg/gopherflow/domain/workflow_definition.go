@@ -3,9 +3,23 @@ package domain
 import "time"
 
 type WorkflowDefinition struct {
+	Name           string
+	Description    string
+	Created        time.Time
+	Updated        time.Time
+	FlowChart      string
+	Version        int // version new instances are created with
+	MaxConcurrency int // most workflows of the type held by executors at the same time, 0 is unlimited
+}
+
+// WorkflowDefinitionVersion is the shape of one registered version of a workflow definition.
+type WorkflowDefinitionVersion struct {
 	Name        string
+	Version     int
 	Description string
+	States      string // JSON list of the states
+	Transitions string // JSON map of state to the states it can move to
+	FlowChart   string
 	Created     time.Time
 	Updated     time.Time
-	FlowChart   string
 }
@@ -0,0 +1,15 @@
+package domain
+
+import (
+	"database/sql"
+	"time"
+)
+
+type WorkflowSignal struct {
+	ID         int64        // BIGSERIAL
+	WorkflowID int64        // BIGINT (foreign key)
+	Name       string       // TEXT
+	Payload    string       // TEXT, JSON sent with the signal
+	Created    time.Time    // TIMESTAMP
+	Consumed   sql.NullTime // TIMESTAMP, NULL while pending
+}
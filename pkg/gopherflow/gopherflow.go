@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/RealZimboGuy/gopherflow/internal/config"
@@ -32,9 +33,11 @@ import (
 )
 
 // App wires together the workflow engine, repositories, and HTTP server.
+// It is an http.Handler serving the API and web console, so it can be mounted on any server.
 type App struct {
 	DB               *sql.DB
 	Manager          *engine.WorkflowManager
+	Mux              *http.ServeMux
 	WorkflowRegistry map[string]func() core.Workflow
 	Repos            struct {
 		Workflows   *repository.WorkflowRepository
@@ -42,19 +45,61 @@ type App struct {
 		Executors   *repository.ExecutorRepository
 		Definitions *repository.WorkflowDefinitionRepository
 		Users       *repository.UserRepository
+		Schedules   *repository.ScheduleRepository
 	}
+	shutdownOnce sync.Once
 }
 type logHandler struct {
 	slog.Handler
 	Clock core.Clock
 }
 
-func Setup(registry map[string]func() core.Workflow) *App {
+// Options configures an App. Fields left empty are taken from the environment, so several Apps in one process can
+// each use their own database and executor groups. The SQL dialect is GFLOW_DATABASE_TYPE for the whole process,
+// the databases of all Apps have to be of that type.
+type Options struct {
+	Clock          core.Clock     // the real clock when nil
+	Mux            *http.ServeMux // the routes are registered on a new mux when nil
+	DatabaseURL    string         // the Postgres or MySQL database, GFLOW_DATABASE_URL
+	SqliteFileName string         // the SQLite database file, GFLOW_DATABASE_SQLLITE_FILE_NAME
+	ExecutorGroup  string         // the executor groups served, GFLOW_ENGINE_EXECUTOR_GROUP
+}
+
+func Setup(registry map[string]func() core.Workflow) (*App, error) {
 	return SetupWithClock(registry, core.NewRealClock())
 }
 
 // SetupWithClock sets up the database, repositories, workflow manager, and HTTP mux.
-func SetupWithClock(registry map[string]func() core.Workflow, clock core.Clock) *App {
+// It returns a *core.DefinitionError, before touching the database, when a registered workflow has an invalid state graph.
+func SetupWithClock(registry map[string]func() core.Workflow, clock core.Clock) (*App, error) {
+	return SetupWithOptions(registry, Options{Clock: clock})
+}
+
+// SetupWithMux is SetupWithClock registering the routes on the given mux, which is used as App.Mux.
+func SetupWithMux(registry map[string]func() core.Workflow, clock core.Clock, mux *http.ServeMux) (*App, error) {
+	return SetupWithOptions(registry, Options{Clock: clock, Mux: mux})
+}
+
+// SetupWithOptions is SetupWithClock with the database, executor groups and mux of the App given by opts.
+func SetupWithOptions(registry map[string]func() core.Workflow, opts Options) (*App, error) {
+	if err := engine.ValidateRegistry(registry); err != nil {
+		return nil, err
+	}
+	clock := opts.Clock
+	if clock == nil {
+		clock = core.NewRealClock()
+	}
+	mux := opts.Mux
+	if mux == nil {
+		mux = http.NewServeMux()
+	}
+	if opts.DatabaseURL == "" {
+		opts.DatabaseURL = config.GetSystemSettingString(config.DATABASE_URL)
+	}
+	if opts.SqliteFileName == "" {
+		opts.SqliteFileName = config.GetSystemSettingString(config.DATABASE_SQLLITE_FILE_NAME)
+	}
+
 	databaseType := config.GetSystemSettingString(config.DATABASE_TYPE)
 	if databaseType == "" || (databaseType != config.DATABASE_TYPE_POSTGRES &&
 		databaseType != config.DATABASE_TYPE_MYSQL &&
@@ -65,14 +110,14 @@ func SetupWithClock(registry map[string]func() core.Workflow, clock core.Clock)
 	var db *sql.DB
 	switch databaseType {
 	case config.DATABASE_TYPE_POSTGRES:
-		db = setupPostgresDatabase()
+		db = setupPostgresDatabase(opts.DatabaseURL)
 	case config.DATABASE_TYPE_SQLLITE:
-		db = setupSqlLiteDatabase()
+		db = setupSqlLiteDatabase(opts.SqliteFileName)
 	case config.DATABASE_TYPE_MYSQL:
-		db = setupMysqlDatabase()
+		db = setupMysqlDatabase(opts.DatabaseURL)
 	}
 
-	app := &App{DB: db}
+	app := &App{DB: db, Mux: mux}
 
 	// Repositories
 	app.Repos.Workflows = repository.NewWorkflowRepository(db, clock)
@@ -80,6 +125,7 @@ func SetupWithClock(registry map[string]func() core.Workflow, clock core.Clock)
 	app.Repos.Executors = repository.NewExecutorRepository(db, clock)
 	app.Repos.Definitions = repository.NewWorkflowDefinitionRepository(db, clock)
 	app.Repos.Users = repository.NewUserRepository(db, clock)
+	app.Repos.Schedules = repository.NewScheduleRepository(db, clock)
 
 	// Workflows manager
 	app.Manager = engine.NewWorkflowManager(
@@ -87,24 +133,46 @@ func SetupWithClock(registry map[string]func() core.Workflow, clock core.Clock)
 		app.Repos.Actions,
 		app.Repos.Executors,
 		app.Repos.Definitions,
+		app.Repos.Schedules,
 		&registry,
 		clock,
 	)
+	app.Manager.SetDatabaseURL(opts.DatabaseURL)
+	if opts.ExecutorGroup != "" {
+		app.Manager.SetExecutorGroups(opts.ExecutorGroup)
+	}
 
-	controllers.NewWorkflowsController(app.Repos.Workflows, app.Repos.Actions, app.Manager, app.Repos.Users).RegisterRoutes()
-	controllers.NewActionsController(app.Repos.Workflows, app.Repos.Actions, app.Repos.Users).RegisterRoutes()
-	controllers.NewExecutorsController(app.Repos.Executors, app.Repos.Users).RegisterRoutes()
-	controllers.NewUsersController(app.Repos.Users).RegisterRoutes()
-	web.NewWebController(app.Manager, app.Repos.Users).RegisterRoutes()
+	controllers.NewWorkflowsController(app.Repos.Workflows, app.Repos.Actions, app.Manager, app.Repos.Users).RegisterRoutes(mux)
+	controllers.NewActionsController(app.Repos.Workflows, app.Repos.Actions, app.Repos.Users).RegisterRoutes(mux)
+	controllers.NewExecutorsController(app.Repos.Executors, app.Repos.Users).RegisterRoutes(mux)
+	controllers.NewUsersController(app.Repos.Users).RegisterRoutes(mux)
+	controllers.NewSchedulesController(app.Manager, app.Repos.Users).RegisterRoutes(mux)
+	web.NewWebController(app.Manager, app.Repos.Users).RegisterRoutes(mux)
 
-	return app
+	return app, nil
 }
 
-// Run starts the workflow engine and HTTP server.
+// ServeHTTP serves the API and web console routes of the App.
+func (a *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.Mux.ServeHTTP(w, r)
+}
+
+// RunEngine runs the workflow engine without starting an HTTP server, for an App mounted on the caller's server.
+// It returns once ctx is cancelled, the engine has drained and the database has been closed.
+func (a *App) RunEngine(ctx context.Context) {
+	a.Manager.StartEngine(ctx, pollInterval())
+	a.Shutdown()
+}
+
+// Run starts the workflow engine and HTTP server. When ctx is cancelled the server stops accepting requests,
+// the engine drains its workflows and Run returns once the database has been closed.
 func (a *App) Run(ctx context.Context) error {
 	// start engine in background
-	dur, _ := time.ParseDuration(config.GetSystemSettingString(config.ENGINE_CHECK_DB_INTERVAL))
-	go a.Manager.StartEngine(ctx, dur)
+	engineStopped := make(chan struct{})
+	go func() {
+		defer close(engineStopped)
+		a.Manager.StartEngine(ctx, pollInterval())
+	}()
 
 	addr := ":" + config.GetSystemSettingString(config.ENGINE_SERVER_WEB_PORT)
 	if v := os.Getenv("HTTP_ADDR"); v != "" {
@@ -114,17 +182,22 @@ func (a *App) Run(ctx context.Context) error {
 
 	server := &http.Server{
 		Addr:        addr,
+		Handler:     a,
 		BaseContext: func(net.Listener) context.Context { return ctx },
 	}
 
 	// Graceful shutdown
+	stopped := make(chan struct{})
 	go func() {
+		defer close(stopped)
 		<-ctx.Done()
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		if err := server.Shutdown(shutdownCtx); err != nil {
 			slog.Error("Server shutdown error", "error", err)
 		}
+		// the engine still needs the database to hand its workflows back
+		<-engineStopped
 		a.Shutdown()
 	}()
 
@@ -132,10 +205,16 @@ func (a *App) Run(ctx context.Context) error {
 	if err != nil && err != http.ErrServerClosed {
 		return err
 	}
+	<-stopped
 	return nil
 }
 
+// Shutdown closes the database, it is safe to call more than once.
 func (a *App) Shutdown() {
+	a.shutdownOnce.Do(a.shutdown)
+}
+
+func (a *App) shutdown() {
 	//remove any global setups to clean up resources
 	//WorkflowRegistry = make(map[string]func() core.Workflow)
 	
@@ -145,14 +224,16 @@ func (a *App) Shutdown() {
 	}
 	slog.Info("DB connection closed")
 	
-	// Clear the default HTTP mux to reset routes
-	http.DefaultServeMux = new(http.ServeMux)
-	
 	slog.Info("Shutdown complete")
 }
 
-func setupPostgresDatabase() *sql.DB {
-	dbURL := config.GetSystemSettingString(config.DATABASE_URL)
+// pollInterval is how often the engine checks the database for workflows, ENGINE_CHECK_DB_INTERVAL.
+func pollInterval() time.Duration {
+	dur, _ := time.ParseDuration(config.GetSystemSettingString(config.ENGINE_CHECK_DB_INTERVAL))
+	return dur
+}
+
+func setupPostgresDatabase(dbURL string) *sql.DB {
 	if dbURL == "" {
 		panic("GFLOW_DATABASE_URL must be set when using POSTGRES")
 	}
@@ -171,8 +252,7 @@ func setupPostgresDatabase() *sql.DB {
 	return db
 }
 
-func setupSqlLiteDatabase() *sql.DB {
-	fileName := config.GetSystemSettingString(config.DATABASE_SQLLITE_FILE_NAME)
+func setupSqlLiteDatabase(fileName string) *sql.DB {
 	if fileName == "memory" {
 		// Use shared in-memory database, primarily for testing
 		fileName = "file::memory:?cache=shared"
@@ -199,8 +279,7 @@ func setupSqlLiteDatabase() *sql.DB {
 	return db
 }
 
-func setupMysqlDatabase() *sql.DB {
-	dbURL := config.GetSystemSettingString(config.DATABASE_URL)
+func setupMysqlDatabase(dbURL string) *sql.DB {
 	if dbURL == "" {
 		panic("GFLOW_DATABASE_URL must be set when using MYSQL")
 	}
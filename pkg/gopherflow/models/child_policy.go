@@ -0,0 +1,31 @@
+package models
+
+// ParentClosePolicy decides what happens to a child workflow that is still running when its parent ends.
+type ParentClosePolicy string
+
+const (
+	ParentCloseAbandon         ParentClosePolicy = "ABANDON"           // The child keeps running (default)
+	ParentCloseCancelOnFailure ParentClosePolicy = "CANCEL_ON_FAILURE" // The child is cancelled when the parent fails, errors or is cancelled
+	ParentCloseCancel          ParentClosePolicy = "CANCEL"            // The child is cancelled whenever the parent ends
+)
+
+// ChildFailurePolicy decides what a child workflow that fails, errors or is cancelled does to its parent.
+type ChildFailurePolicy string
+
+const (
+	ChildFailureIgnore     ChildFailurePolicy = "IGNORE"      // The parent is only woken (default)
+	ChildFailureNotify     ChildFailurePolicy = "NOTIFY"      // The parent is sent a ChildFailedSignal
+	ChildFailureFailParent ChildFailurePolicy = "FAIL_PARENT" // The parent is sent a ChildFailedSignal and fails on its next run
+)
+
+// ChildFailedSignal is the signal a parent is sent when a child with the NOTIFY or FAIL_PARENT policy fails. Its
+// payload is a ChildFailure as JSON.
+const ChildFailedSignal = "child_failed"
+
+// ChildFailure describes the child workflow that failed in the payload of a ChildFailedSignal.
+type ChildFailure struct {
+	WorkflowID int64              `json:"workflowId"`
+	ExternalID string             `json:"externalId"`
+	Status     string             `json:"status"`
+	Policy     ChildFailurePolicy `json:"policy"`
+}
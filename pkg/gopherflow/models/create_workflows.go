@@ -14,6 +14,8 @@ type CreateWorkflowRequest struct {
 	// Optional scheduling inputs
 	NextActivation       *time.Time `json:"nextActivation,omitempty"`
 	NextActivationOffset string     `json:"nextActivationOffset,omitempty"`
+	// Higher priorities are claimed first, 0 by default
+	Priority int `json:"priority,omitempty"`
 }
 
 // createWorkflowResponse is returned on successful creation.
@@ -46,4 +48,7 @@ type WorkflowApiResponse struct {
 	BusinessKey    string            `json:"businessKey"`
 	State          string            `json:"state"`
 	StateVars      map[string]string `json:"stateVars,omitempty"`
+	// version of the workflow definition the instance is pinned to
+	WorkflowVersion int `json:"workflowVersion"`
+	Priority        int `json:"priority"`
 }
@@ -0,0 +1,56 @@
+package models
+
+import "time"
+
+type JoinType string
+
+const (
+	JoinAll      JoinType = "All"      // Every child has ended (default)
+	JoinAny      JoinType = "Any"      // One child has finished, or every child has ended
+	JoinNOfM     JoinType = "NOfM"     // Count children have finished, or too few are left running to get there
+	JoinFailFast JoinType = "FailFast" // Every child has ended, or one of them has failed
+)
+
+// JoinPolicy makes a state wait for the child workflows of its workflow. The engine only runs the state once the
+// join has resolved, a child that ends wakes its parent to check. The state finds the outcome in its ChildSummary.
+type JoinPolicy struct {
+	Type            JoinType
+	Count           int           // Children that must finish for JoinNOfM
+	RecheckInterval time.Duration // How often the children are checked without a wake up, 1 minute when zero
+}
+
+// ChildSummary counts the outcomes of the child workflows of a workflow when the join of its state resolved.
+// A child has finished with status FINISHED, failed with status FAILED, ERROR or CANCELLED and is running otherwise.
+type ChildSummary struct {
+	Total     int
+	Finished  int
+	Failed    int
+	Running   int
+	Satisfied bool // whether the join got what it waited for, for JoinAll and JoinFailFast that no child failed
+}
+
+// Resolved reports whether the join no longer waits for the children in the summary, and sets Satisfied.
+func (p *JoinPolicy) Resolved(s *ChildSummary) bool {
+	switch p.Type {
+	case JoinAny:
+		s.Satisfied = s.Finished > 0
+		return s.Satisfied || s.Running == 0
+	case JoinNOfM:
+		s.Satisfied = s.Finished >= p.Count
+		return s.Satisfied || s.Finished+s.Running < p.Count
+	case JoinFailFast:
+		s.Satisfied = s.Failed == 0
+		return !s.Satisfied || s.Running == 0
+	default:
+		s.Satisfied = s.Failed == 0
+		return s.Running == 0
+	}
+}
+
+// Recheck returns how long a waiting workflow is parked before the children are checked again.
+func (p *JoinPolicy) Recheck() time.Duration {
+	if p.RecheckInterval <= 0 {
+		return time.Minute
+	}
+	return p.RecheckInterval
+}
@@ -4,11 +4,26 @@ import "time"
 
 // ChildWorkflowRequest represents a request to spawn a child workflow
 type ChildWorkflowRequest struct {
-	WorkflowType   string            // Type of child workflow to spawn
-	BusinessKey    string            // Business key for the child workflow
-	ExternalId     string            // External Id for the child workflow
-	InitialState   string            // Initial state for the child workflow
-	StateVariables map[string]string // Initial state variables for the child workflow
+	WorkflowType         string             // Type of child workflow to spawn
+	BusinessKey          string             // Business key for the child workflow
+	ExternalId           string             // External Id for the child workflow
+	InitialState         string             // State the child workflow starts in, the initial state of its type when empty
+	StateVariables       map[string]string  // Initial state variables for the child workflow
+	Priority             int                // Priority of the child workflow, higher is claimed first
+	ExecutorGroup        string             // Executor group that runs the child workflow, the group of the parent when empty
+	NextActivation       time.Time          // specific time the child workflow starts, straight away when zero
+	NextActivationOffset string             // a human friendly delay before the child workflow starts ie 10 minutes
+	ParentClosePolicy    ParentClosePolicy  // What happens to the child when the parent ends, ABANDON when empty
+	FailurePolicy        ChildFailurePolicy // What the child failing does to the parent, IGNORE when empty
+}
+
+// ChildResultPrefix starts the state variables holding the results of finished child workflows.
+const ChildResultPrefix = "child_result."
+
+// ChildResultKey returns the state variable of a parent holding the result of its child with the external id, a
+// JSON object of the variables the child handed over.
+func ChildResultKey(externalID string) string {
+	return ChildResultPrefix + externalID
 }
 
 type NextState struct {
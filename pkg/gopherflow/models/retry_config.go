@@ -1,11 +1,26 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+type BackoffStrategy string
+
+const (
+	BackoffLinear      BackoffStrategy = "Linear"      // Sliding scale between min and max (default)
+	BackoffExponential BackoffStrategy = "Exponential" // Min multiplied by Multiplier for every retry, capped at max
+)
 
 type RetryConfig struct {
 	MaxRetryCount    int
 	RetryIntervalMin time.Duration
 	RetryIntervalMax time.Duration
+	Backoff          BackoffStrategy // How the interval grows, empty means BackoffLinear
+	Multiplier       float64         // Growth factor for BackoffExponential, defaults to 2
+	Jitter           float64         // Fraction of the interval (0-1) randomly added or removed to spread retries
 }
 
 // create a function that is a sliding scale between the min and max based on the number of retries
@@ -20,3 +35,78 @@ func (rc *RetryConfig) SlidingInterval(retryNum int) time.Duration {
 	scale := float64(retryNum) / float64(rc.MaxRetryCount)
 	return rc.RetryIntervalMin + time.Duration(scale*float64(rc.RetryIntervalMax-rc.RetryIntervalMin))
 }
+
+// DefaultExponentialMax caps exponential backoff when RetryIntervalMax is not set.
+const DefaultExponentialMax = 24 * time.Hour
+
+// ExponentialInterval returns min * multiplier^retryNum, capped at max, or DefaultExponentialMax when max is not set.
+func (rc *RetryConfig) ExponentialInterval(retryNum int) time.Duration {
+	if retryNum < 0 {
+		retryNum = 0
+	}
+	if rc.RetryIntervalMin <= 0 {
+		return rc.RetryIntervalMin
+	}
+	limit := rc.exponentialMax()
+	// the interval is capped before it is converted, a large retry count would overflow a time.Duration
+	interval := float64(rc.RetryIntervalMin) * math.Pow(rc.multiplier(), float64(retryNum))
+	if interval > float64(limit) {
+		return limit
+	}
+	return time.Duration(interval)
+}
+
+// NextInterval returns the delay before the given retry attempt according to the backoff strategy and jitter.
+func (rc *RetryConfig) NextInterval(retryNum int) time.Duration {
+	var interval time.Duration
+	switch rc.Backoff {
+	case BackoffExponential:
+		interval = rc.ExponentialInterval(retryNum)
+	default:
+		interval = rc.SlidingInterval(retryNum)
+	}
+	if rc.Jitter <= 0 {
+		return interval
+	}
+	jitter := math.Min(rc.Jitter, 1)
+	interval += time.Duration(float64(interval) * jitter * (rand.Float64()*2 - 1))
+	limit := rc.RetryIntervalMax
+	if rc.Backoff == BackoffExponential {
+		limit = rc.exponentialMax()
+	}
+	if limit > 0 && interval > limit {
+		return limit
+	}
+	return max(interval, 0)
+}
+
+// String describes the policy, it is recorded with every retry.
+func (rc *RetryConfig) String() string {
+	backoff := rc.Backoff
+	if backoff == "" {
+		backoff = BackoffLinear
+	}
+	s := fmt.Sprintf("%s retries:%d min:%s max:%s", backoff, rc.MaxRetryCount, rc.RetryIntervalMin, rc.RetryIntervalMax)
+	if backoff == BackoffExponential {
+		s += fmt.Sprintf(" multiplier:%g", rc.multiplier())
+	}
+	if rc.Jitter > 0 {
+		s += fmt.Sprintf(" jitter:%g", rc.Jitter)
+	}
+	return s
+}
+
+// exponentialMax is the cap of exponential backoff, RetryIntervalMax or DefaultExponentialMax when it is not set.
+func (rc *RetryConfig) exponentialMax() time.Duration {
+	if rc.RetryIntervalMax <= 0 {
+		return DefaultExponentialMax
+	}
+	return rc.RetryIntervalMax
+}
+
+func (rc *RetryConfig) multiplier() float64 {
+	if rc.Multiplier <= 0 {
+		return 2
+	}
+	return rc.Multiplier
+}
@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// Overlap policies decide what a schedule does when the workflow of its previous run is still active.
+const (
+	OverlapAllow = "ALLOW" // always create a new workflow
+	OverlapSkip  = "SKIP"  // skip the run while the previous workflow has not ended
+)
+
+// ScheduleRequest is the payload for creating or updating a schedule.
+// BusinessKey and the StateVars values may contain the placeholders ${scheduleName} and ${scheduledTime}.
+type ScheduleRequest struct {
+	Name           string            `json:"name"`
+	CronExpression string            `json:"cronExpression"`
+	Timezone       string            `json:"timezone"`
+	WorkflowType   string            `json:"workflowType"`
+	ExecutorGroup  string            `json:"executorGroup"`
+	BusinessKey    string            `json:"businessKey"`
+	StateVars      map[string]string `json:"stateVars"`
+	OverlapPolicy  string            `json:"overlapPolicy"`
+	Enabled        bool              `json:"enabled"`
+}
+
+// ScheduleApiResponse represents the API response for a schedule.
+type ScheduleApiResponse struct {
+	ID             int64             `json:"id"`
+	Name           string            `json:"name"`
+	CronExpression string            `json:"cronExpression"`
+	Timezone       string            `json:"timezone"`
+	WorkflowType   string            `json:"workflowType"`
+	ExecutorGroup  string            `json:"executorGroup"`
+	BusinessKey    string            `json:"businessKey"`
+	StateVars      map[string]string `json:"stateVars,omitempty"`
+	OverlapPolicy  string            `json:"overlapPolicy"`
+	Enabled        bool              `json:"enabled"`
+	NextRun        *time.Time        `json:"nextRun,omitempty"`
+	LastRun        *time.Time        `json:"lastRun,omitempty"`
+	LastWorkflowID int64             `json:"lastWorkflowId,omitempty"`
+	Created        time.Time         `json:"created"`
+	Modified       time.Time         `json:"modified"`
+}
@@ -27,3 +27,34 @@ type UpdateWorkflowStateAndWaitRequest struct {
 type UpdateWorkflowStateResponse struct {
 	OK bool `json:"ok"`
 }
+
+// UpdatePriorityRequest changes the priority a workflow is claimed with.
+type UpdatePriorityRequest struct {
+	Priority int `json:"priority"`
+}
+
+type UpdatePriorityResponse struct {
+	OK bool `json:"ok"`
+}
+
+type CancelWorkflowResponse struct {
+	OK bool `json:"ok"`
+}
+
+type PauseWorkflowResponse struct {
+	OK bool `json:"ok"`
+}
+
+type ResumeWorkflowResponse struct {
+	OK bool `json:"ok"`
+}
+
+// BulkStatusChangeResponse reports how many of the workflows matched by a search filter were changed.
+type BulkStatusChangeResponse struct {
+	Matched int `json:"matched"`
+	Changed int `json:"changed"`
+}
+
+type SendSignalResponse struct {
+	SignalID int64 `json:"signalId"`
+}
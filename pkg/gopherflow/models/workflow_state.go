@@ -1,6 +1,13 @@
 package models
 
+import "time"
+
 type WorkflowState struct {
-	Name      string    // Name of the state
-	StateType StateType // Type of the state (e.g., Start, Normal, End)
+	Name         string        // Name of the state
+	StateType    StateType     // Type of the state (e.g., Start, Normal, End)
+	Timeout      time.Duration // Maximum time the state method may run, zero falls back to the workflow default
+	RetryConfig  *RetryConfig  // Retry policy for errors in this state, nil uses the workflow's GetRetryConfig
+	FailureState string        // State to move to once the retries are exhausted, empty falls back to the workflow's FailureHandler
+	Compensation string        // Method, func(ctx context.Context) error, that undoes this state when the workflow fails
+	Join         *JoinPolicy   // Wait for the child workflows before running this state, nil runs it straight away
 }
@@ -0,0 +1,30 @@
+package models
+
+// WorkflowTreeCounts counts the workflows of a subtree by outcome, the workflow at its top included. A workflow has
+// finished with status FINISHED, failed with status FAILED, ERROR or CANCELLED and is running otherwise.
+type WorkflowTreeCounts struct {
+	Running  int `json:"running"`
+	Finished int `json:"finished"`
+	Failed   int `json:"failed"`
+}
+
+// WorkflowTreeNode is a workflow in the tree of its parent and child workflows.
+type WorkflowTreeNode struct {
+	ID               int64              `json:"id"`
+	ExternalID       string             `json:"externalId"`
+	BusinessKey      string             `json:"businessKey"`
+	WorkflowType     string             `json:"workflowType"`
+	Status           string             `json:"status"`
+	State            string             `json:"state"`
+	ParentWorkflowID *int64             `json:"parentWorkflowId,omitempty"`
+	Requested        bool               `json:"requested"` // the workflow the tree was asked for
+	Counts           WorkflowTreeCounts `json:"counts"`
+	Children         []WorkflowTreeNode `json:"children"`
+}
+
+// WorkflowTreeResponse is the tree a workflow belongs to, from its topmost ancestor down to every descendant.
+type WorkflowTreeResponse struct {
+	WorkflowID int64            `json:"workflowId"`
+	Root       WorkflowTreeNode `json:"root"`
+	Truncated  bool             `json:"truncated"` // the tree had more workflows than are returned, the deepest were left out
+}
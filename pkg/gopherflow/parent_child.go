@@ -2,6 +2,7 @@ package gopherflow
 
 import (
 	"encoding/json"
+	"strings"
 
 	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/models"
 )
@@ -35,3 +36,21 @@ func ParseChildWorkflowResults(stateVars map[string]string, key string) (map[str
 
 	return results, nil
 }
+
+// ChildWorkflowResults returns the results the finished children of a parent handed over, keyed by the external id
+// of the child. Only children implementing core.ResultProducer hand over results.
+func ChildWorkflowResults(stateVars map[string]string) (map[string]map[string]string, error) {
+	results := make(map[string]map[string]string)
+	for key := range stateVars {
+		externalID, ok := strings.CutPrefix(key, models.ChildResultPrefix)
+		if !ok {
+			continue
+		}
+		result, err := ParseChildWorkflowResults(stateVars, key)
+		if err != nil {
+			return nil, err
+		}
+		results[externalID] = result
+	}
+	return results, nil
+}
@@ -0,0 +1,150 @@
+package common
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RealZimboGuy/gopherflow/internal/repository"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/core"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/domain"
+)
+
+// ClaimResult is the outcome of competing executors taking all pending workflows of a group.
+type ClaimResult struct {
+	Claimed     int
+	LockFailed  int
+	Duplicates  int
+	EmptyHanded int
+	Duration    time.Duration
+	PerSecond   float64
+	Description string
+}
+
+// CompareClaimThroughput lets executors compete for the same pending workflows, first by finding and marking
+// them one by one and then with ClaimPendingWorkflows, and logs how fast each approach schedules them all.
+func CompareClaimThroughput(t *testing.T, db *sql.DB, workflows int, executors int, batchSize int) {
+	repo := repository.NewWorkflowRepository(db, core.NewRealClock())
+
+	findAndMark := runClaimRace(t, repo, "claim-find-mark", workflows, executors, batchSize, func(executorID int64) ([]int64, int, error) {
+		pending, err := repo.FindPendingWorkflows(batchSize, "claim-find-mark")
+		if err != nil {
+			return nil, 0, err
+		}
+		var ids []int64
+		failed := 0
+		for _, wf := range *pending {
+			if repo.MarkWorkflowAsScheduledForExecution(wf.ID, executorID, wf.Modified) {
+				ids = append(ids, wf.ID)
+			} else {
+				failed++
+			}
+		}
+		if len(*pending) == 0 {
+			return nil, 0, nil
+		}
+		return ids, failed, nil
+	})
+	findAndMark.Description = "find and mark"
+
+	skipLocked := runClaimRace(t, repo, "claim-skip-locked", workflows, executors, batchSize, func(executorID int64) ([]int64, int, error) {
+		claimed, err := repo.ClaimPendingWorkflows(batchSize, "claim-skip-locked", executorID)
+		if err != nil {
+			return nil, 0, err
+		}
+		var ids []int64
+		for _, wf := range *claimed {
+			ids = append(ids, wf.ID)
+		}
+		return ids, 0, nil
+	})
+	skipLocked.Description = "skip locked"
+
+	for _, r := range []ClaimResult{findAndMark, skipLocked} {
+		t.Logf("%-13s: %d workflows by %d executors in %v (%.0f/s), %d lock failures",
+			r.Description, r.Claimed, executors, r.Duration, r.PerSecond, r.LockFailed)
+		if r.Claimed != workflows || r.Duplicates != 0 {
+			t.Errorf("%s: expected %d workflows claimed once, got %d with %d duplicates", r.Description, workflows, r.Claimed, r.Duplicates)
+		}
+		if r.EmptyHanded != 0 {
+			t.Errorf("%s: expected no executor to claim nothing while workflows were pending, %d did", r.Description, r.EmptyHanded)
+		}
+	}
+	if skipLocked.LockFailed != 0 {
+		t.Errorf("Expected no lock failures when claiming with skip locked, got %d", skipLocked.LockFailed)
+	}
+}
+
+// runClaimRace creates the pending workflows of a group and runs claim on every executor until none are left.
+// claim returns the ids it scheduled and the number of workflows it lost to another executor. An executor that
+// claims nothing stops once the others can be holding all workflows still pending, the other executors lock at most
+// batchSize each, so more pending workflows than that means it came back empty-handed while work remained.
+func runClaimRace(t *testing.T, repo *repository.WorkflowRepository, group string, workflows int, executors int, batchSize int, claim func(executorID int64) ([]int64, int, error)) ClaimResult {
+	past := time.Now().Add(-time.Minute).UTC()
+	for i := 0; i < workflows; i++ {
+		_, err := repo.Save(&domain.Workflow{
+			Status:         "NEW",
+			Created:        past,
+			Modified:       past,
+			NextActivation: sql.NullTime{Time: past, Valid: true},
+			ExecutorGroup:  group,
+			WorkflowType:   "QuickWorkflow",
+			ExternalID:     fmt.Sprintf("%s-%d", group, i),
+			BusinessKey:    fmt.Sprintf("%s-%d", group, i),
+			State:          StateInit,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create workflow: %v", err)
+		}
+	}
+
+	var mu sync.Mutex
+	seen := make(map[int64]bool, workflows)
+	result := ClaimResult{}
+	var wg sync.WaitGroup
+	start := time.Now()
+	for e := 1; e <= executors; e++ {
+		wg.Add(1)
+		go func(executorID int64) {
+			defer wg.Done()
+			for {
+				ids, failed, err := claim(executorID)
+				if err != nil {
+					t.Errorf("Executor %d failed to claim workflows: %v", executorID, err)
+					return
+				}
+				mu.Lock()
+				result.LockFailed += failed
+				for _, id := range ids {
+					if seen[id] {
+						result.Duplicates++
+					}
+					seen[id] = true
+				}
+				mu.Unlock()
+				if len(ids) > 0 || failed > 0 {
+					continue
+				}
+				heldByOthers := (executors - 1) * batchSize
+				pending, err := repo.FindPendingWorkflows(heldByOthers+1, group)
+				if err != nil {
+					t.Errorf("Executor %d failed to find pending workflows: %v", executorID, err)
+					return
+				}
+				if len(*pending) <= heldByOthers {
+					return
+				}
+				mu.Lock()
+				result.EmptyHanded++
+				mu.Unlock()
+			}
+		}(int64(e))
+	}
+	wg.Wait()
+	result.Duration = time.Since(start)
+	result.Claimed = len(seen)
+	result.PerSecond = float64(result.Claimed) / result.Duration.Seconds()
+	return result
+}
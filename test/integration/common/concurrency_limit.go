@@ -0,0 +1,184 @@
+package common
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RealZimboGuy/gopherflow/internal/repository"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/core"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/domain"
+)
+
+// ClaimWithinConcurrencyLimit lets executors compete for pending workflows of a type limited to limit running
+// workflows and of an unlimited type, and checks that no more than limit of the limited type are ever taken.
+func ClaimWithinConcurrencyLimit(t *testing.T, db *sql.DB, limit int, executors int) {
+	const group = "concurrency-limit"
+	const limitedType = "LimitedWorkflow"
+	repo := repository.NewWorkflowRepository(db, core.NewRealClock())
+	defs := repository.NewWorkflowDefinitionRepository(db, core.NewRealClock())
+	now := time.Now()
+	if err := defs.Save(&domain.WorkflowDefinition{Name: limitedType, Created: now, Updated: now, Version: 1, MaxConcurrency: limit}); err != nil {
+		t.Fatalf("Failed to save workflow definition: %v", err)
+	}
+
+	past := now.Add(-time.Minute).UTC()
+	for i := 0; i < limit*3; i++ {
+		for _, workflowType := range []string{limitedType, "QuickWorkflow"} {
+			_, err := repo.Save(&domain.Workflow{
+				Status:         "NEW",
+				Created:        past,
+				Modified:       past,
+				NextActivation: sql.NullTime{Time: past, Valid: true},
+				ExecutorGroup:  group,
+				WorkflowType:   workflowType,
+				ExternalID:     fmt.Sprintf("%s-%s-%d", group, workflowType, i),
+				BusinessKey:    fmt.Sprintf("%s-%d", group, i),
+				State:          StateInit,
+			})
+			if err != nil {
+				t.Fatalf("Failed to create workflow: %v", err)
+			}
+		}
+	}
+
+	claimed := func(executorID int64) []domain.Workflow {
+		workflows, err := repo.ClaimPendingWorkflows(limit*2, group, executorID)
+		if errors.Is(err, repository.ErrClaimUnsupported) {
+			var pending *[]domain.Workflow
+			if pending, err = repo.FindPendingWorkflows(limit*2, group); err == nil {
+				var marked []domain.Workflow
+				for _, wf := range *pending {
+					if repo.MarkWorkflowAsScheduledForExecution(wf.ID, executorID, wf.Modified) {
+						marked = append(marked, wf)
+					}
+				}
+				workflows = &marked
+			}
+		}
+		if err != nil {
+			t.Errorf("Executor %d failed to claim workflows: %v", executorID, err)
+			return nil
+		}
+		return *workflows
+	}
+
+	var mu sync.Mutex
+	var limited []int64
+	var wg sync.WaitGroup
+	for e := 1; e <= executors; e++ {
+		wg.Add(1)
+		go func(executorID int64) {
+			defer wg.Done()
+			for {
+				workflows := claimed(executorID)
+				if len(workflows) == 0 {
+					return
+				}
+				mu.Lock()
+				for _, wf := range workflows {
+					if wf.WorkflowType == limitedType {
+						limited = append(limited, wf.ID)
+					}
+				}
+				mu.Unlock()
+			}
+		}(int64(e))
+	}
+	wg.Wait()
+
+	if len(limited) != limit {
+		t.Fatalf("Expected %d workflows of the limited type claimed, got %d", limit, len(limited))
+	}
+	running, err := repo.CountRunningWorkflows(limitedType)
+	if err != nil || running != limit {
+		t.Errorf("Expected %d workflows of the limited type running, got %d (%v)", limit, running, err)
+	}
+	if unlimited, _ := repo.CountRunningWorkflows("QuickWorkflow"); unlimited != limit*3 {
+		t.Errorf("Expected all %d workflows of the unlimited type claimed, got %d", limit*3, unlimited)
+	}
+
+	// a workflow handed back frees a slot for exactly one more
+	if err := repo.ClearExecutorId(limited[0]); err != nil {
+		t.Fatalf("Failed to release workflow: %v", err)
+	}
+	if err := repo.UpdateWorkflowStatus(limited[0], "FINISHED"); err != nil {
+		t.Fatalf("Failed to finish workflow: %v", err)
+	}
+	if next := claimed(int64(executors + 1)); len(next) != 1 || next[0].WorkflowType != limitedType {
+		t.Errorf("Expected one more workflow of the limited type once a slot was freed, got %d", len(next))
+	}
+
+	// so does a workflow that failed while its executor held it
+	if err := repo.UpdateWorkflowStatus(limited[1], "FAILED"); err != nil {
+		t.Fatalf("Failed to fail workflow: %v", err)
+	}
+	if failed, err := repo.FindByID(limited[1]); err != nil || failed.ExecutorID.Valid {
+		t.Errorf("Expected the failed workflow to no longer hold its executor, got %v (%v)", failed, err)
+	}
+	if next := claimed(int64(executors + 2)); len(next) != 1 || next[0].WorkflowType != limitedType {
+		t.Errorf("Expected one more workflow of the limited type once a workflow failed, got %d", len(next))
+	}
+}
+
+// ClaimLimitedAmongUnlimitedWork fills a group with more workflows of an unlimited type than one claim takes and
+// with higher priority workflows of a type limited to limit running workflows, and checks that a claim still takes
+// the limited ones within their free slots.
+func ClaimLimitedAmongUnlimitedWork(t *testing.T, db *sql.DB, limit int, batchSize int) {
+	const group = "limited-among-unlimited"
+	const limitedType = "LimitedPriorityWorkflow"
+	repo := repository.NewWorkflowRepository(db, core.NewRealClock())
+	defs := repository.NewWorkflowDefinitionRepository(db, core.NewRealClock())
+	now := time.Now()
+	if err := defs.Save(&domain.WorkflowDefinition{Name: limitedType, Created: now, Updated: now, Version: 1, MaxConcurrency: limit}); err != nil {
+		t.Fatalf("Failed to save workflow definition: %v", err)
+	}
+
+	past := now.Add(-time.Minute).UTC()
+	create := func(workflowType string, priority int, i int) {
+		_, err := repo.Save(&domain.Workflow{
+			Status:         "NEW",
+			Created:        past,
+			Modified:       past,
+			NextActivation: sql.NullTime{Time: past, Valid: true},
+			ExecutorGroup:  group,
+			WorkflowType:   workflowType,
+			ExternalID:     fmt.Sprintf("%s-%s-%d", group, workflowType, i),
+			BusinessKey:    fmt.Sprintf("%s-%d", group, i),
+			State:          StateInit,
+			Priority:       priority,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create workflow: %v", err)
+		}
+	}
+	for i := 0; i < batchSize*3; i++ {
+		create("QuickWorkflow", 0, i)
+	}
+	for i := 0; i < limit*2; i++ {
+		create(limitedType, 10, i)
+	}
+
+	workflows, err := repo.ClaimPendingWorkflows(batchSize, group, 1)
+	if err != nil {
+		t.Fatalf("Failed to claim workflows: %v", err)
+	}
+	limited := 0
+	for _, wf := range *workflows {
+		if wf.WorkflowType == limitedType {
+			limited++
+		}
+	}
+	if len(*workflows) != batchSize || limited != limit {
+		t.Errorf("Expected %d workflows claimed of which %d of the limited type, got %d of which %d", batchSize, limit, len(*workflows), limited)
+	}
+	if len(*workflows) > 0 && (*workflows)[0].WorkflowType != limitedType {
+		t.Errorf("Expected the higher priority workflows of the limited type claimed first, got %s", (*workflows)[0].WorkflowType)
+	}
+	if running, err := repo.CountRunningWorkflows(limitedType); err != nil || running != limit {
+		t.Errorf("Expected %d workflows of the limited type running, got %d (%v)", limit, running, err)
+	}
+}
@@ -218,22 +218,15 @@ func (w *ParentWorkflow) ParentProcessChildrenResults(ctx context.Context) (*mod
 		return nil, fmt.Errorf("failed to get child workflows: %w", err)
 	}
 
-	// Process results from children
+	// The engine handed the results of the finished children over
+	childResults, err := gopherflow.ChildWorkflowResults(w.StateVariables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse child workflow results: %w", err)
+	}
 	results := make(map[string]string)
 	for i, child := range children {
-		if child.StateVars.Valid {
-			childResults, err := gopherflow.ParseChildWorkflowResults(
-				map[string]string{"child_result": child.StateVars.String},
-				"child_result",
-			)
-			if err != nil {
-				slog.WarnContext(ctx, "Failed to parse child results", "error", err)
-				continue
-			}
-
-			if childResults != nil {
-				results[fmt.Sprintf("child_%d_result", i+1)] = childResults["result"]
-			}
+		if childResult, ok := childResults[child.ExternalID]; ok {
+			results[fmt.Sprintf("child_%d_result", i+1)] = childResult["result"]
 		}
 	}
 
@@ -294,6 +287,11 @@ func (w *ChildWorkflow) GetRetryConfig() models.RetryConfig {
 	}
 }
 
+// ResultVariables hands the result of the processing to the parent
+func (w *ChildWorkflow) ResultVariables() []string {
+	return []string{"result"}
+}
+
 // WakeParent implements the ParentChildCapable interface
 func (w *ChildWorkflow) WakeParent(ctx context.Context) error {
 	slog.InfoContext(ctx, "Waking parent workflow")
@@ -0,0 +1,22 @@
+package mysql
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/RealZimboGuy/gopherflow/test/integration/common"
+)
+
+func TestClaimThroughput(t *testing.T) {
+	container, dsn := SetupMySQLTestInstance(t.Context())
+	defer container.Terminate(t.Context())
+
+	db, err := sql.Open("mysql", strings.TrimPrefix(dsn, "mysql://"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	common.CompareClaimThroughput(t, db, 2000, 8, 50)
+}
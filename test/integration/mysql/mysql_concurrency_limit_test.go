@@ -0,0 +1,35 @@
+package mysql
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/RealZimboGuy/gopherflow/test/integration/common"
+)
+
+func TestClaimWithinConcurrencyLimit(t *testing.T) {
+	container, dsn := SetupMySQLTestInstance(t.Context())
+	defer container.Terminate(t.Context())
+
+	db, err := sql.Open("mysql", strings.TrimPrefix(dsn, "mysql://"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	common.ClaimWithinConcurrencyLimit(t, db, 3, 4)
+}
+
+func TestClaimLimitedAmongUnlimitedWork(t *testing.T) {
+	container, dsn := SetupMySQLTestInstance(t.Context())
+	defer container.Terminate(t.Context())
+
+	db, err := sql.Open("mysql", strings.TrimPrefix(dsn, "mysql://"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	common.ClaimLimitedAmongUnlimitedWork(t, db, 2, 5)
+}
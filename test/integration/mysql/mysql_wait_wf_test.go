@@ -36,7 +36,10 @@ func TestStartupAppAndWaitWorkflow(t *testing.T) {
 				return &common.WaitWorkflow{}
 			},
 		}
-		app := gopherflow.SetupWithClock(workflowRegistry, clock)
+		app, err := gopherflow.SetupWithClock(workflowRegistry, clock)
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
 
 		// Start the app in a goroutine so it doesn't block
 		go func() {
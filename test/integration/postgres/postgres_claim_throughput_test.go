@@ -0,0 +1,21 @@
+package postgres
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/RealZimboGuy/gopherflow/test/integration/common"
+)
+
+func TestClaimThroughput(t *testing.T) {
+	container, dsn := SetupPostgresTestInstance(t.Context())
+	defer container.Terminate(t.Context())
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	common.CompareClaimThroughput(t, db, 2000, 8, 50)
+}
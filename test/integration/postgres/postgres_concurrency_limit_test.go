@@ -0,0 +1,34 @@
+package postgres
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/RealZimboGuy/gopherflow/test/integration/common"
+)
+
+func TestClaimWithinConcurrencyLimit(t *testing.T) {
+	container, dsn := SetupPostgresTestInstance(t.Context())
+	defer container.Terminate(t.Context())
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	common.ClaimWithinConcurrencyLimit(t, db, 3, 4)
+}
+
+func TestClaimLimitedAmongUnlimitedWork(t *testing.T) {
+	container, dsn := SetupPostgresTestInstance(t.Context())
+	defer container.Terminate(t.Context())
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	common.ClaimLimitedAmongUnlimitedWork(t, db, 2, 5)
+}
@@ -33,7 +33,10 @@ func TestStartupAppAndCreateWorkflow(t *testing.T) {
 				return &common.QuickWorkflow{}
 			},
 		}
-		app := gopherflow.SetupWithClock(workflowRegistry, clock)
+		app, err := gopherflow.SetupWithClock(workflowRegistry, clock)
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
 
 		// Start the app in a goroutine so it doesn't block
 		go func() {
@@ -30,7 +30,10 @@ func TestStartupAppAndGetExecutor(t *testing.T) {
 				return &workflows.GetIpWorkflow{}
 			},
 		}
-		app := gopherflow.SetupWithClock(workflowRegistry, clock)
+		app, err := gopherflow.SetupWithClock(workflowRegistry, clock)
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
 
 		// Start the app in a goroutine so it doesn't block
 		go func() {
@@ -47,7 +47,10 @@ func TestStartupAppAndRepairWorkflow(t *testing.T) {
 				}
 			},
 		}
-		app := gopherflow.SetupWithClock(workflowRegistry, clock)
+		app, err := gopherflow.SetupWithClock(workflowRegistry, clock)
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
 
 		// Start the app in a goroutine so it doesn't block
 		go func() {
@@ -117,7 +120,10 @@ func TestStartupAppAndRepairWorkflow(t *testing.T) {
 		os.Setenv("HTTP_ADDR", ":"+strconv.Itoa(port))
 
 		// Create a new app instance instead of reusing the old one
-		app = gopherflow.SetupWithClock(workflowRegistry, clock)
+		app, err = gopherflow.SetupWithClock(workflowRegistry, clock)
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
 
 		appCtx2, cancel2 := context.WithCancel(t.Context())
 		// Start the app in a goroutine so it doesn't block
@@ -0,0 +1,64 @@
+package sqllite
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/core"
+	"github.com/RealZimboGuy/gopherflow/pkg/gopherflow/domain"
+)
+
+func TestTwoAppsWithTheirOwnDatabaseAndGroups(t *testing.T) {
+	RunTestWithSetup(t, func(t *testing.T, port int) {
+		gopherflow.SetupLogger(slog.LevelWarn)
+
+		type running struct {
+			app      *gopherflow.App
+			group    string
+			fileName string
+			done     chan struct{}
+		}
+		ctx, cancel := context.WithCancel(t.Context())
+		var apps []running
+		defer func() {
+			cancel()
+			for _, r := range apps {
+				<-r.done
+				os.Remove(r.fileName)
+			}
+		}()
+		for i, group := range []string{"orders", "reports"} {
+			fileName := fmt.Sprintf("gopherflow-test-%d-%d.db", port, i)
+			app, err := gopherflow.SetupWithOptions(map[string]func() core.Workflow{}, gopherflow.Options{SqliteFileName: fileName, ExecutorGroup: group})
+			if err != nil {
+				t.Fatalf("Setup failed: %v", err)
+			}
+			r := running{app: app, group: group, fileName: fileName, done: make(chan struct{})}
+			go func() {
+				defer close(r.done)
+				app.RunEngine(ctx)
+			}()
+			apps = append(apps, r)
+		}
+
+		// every app registers its executor in its own database, serving its own group
+		for _, r := range apps {
+			var executors []*domain.Executor
+			for deadline := time.Now().Add(5 * time.Second); len(executors) == 0 && time.Now().Before(deadline); {
+				time.Sleep(50 * time.Millisecond)
+				var err error
+				if executors, err = r.app.Repos.Executors.GetExecutorsByLastActive(10); err != nil {
+					t.Fatalf("Failed to list executors: %v", err)
+				}
+			}
+			if len(executors) != 1 || executors[0].Groups != r.group {
+				t.Errorf("Expected one executor serving %s, got %+v", r.group, executors)
+			}
+		}
+	})
+}
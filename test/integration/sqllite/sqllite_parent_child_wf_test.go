@@ -2,6 +2,7 @@ package sqllite
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"os"
 	"testing"
@@ -46,7 +47,13 @@ func TestParentChildWorkflowRepository(t *testing.T) {
 				business_key TEXT,
 				state TEXT,
 				state_vars TEXT,
-				parent_workflow_id INTEGER NULL REFERENCES workflow(id)
+				parent_workflow_id INTEGER NULL REFERENCES workflow(id),
+				lease_owner INTEGER,
+				lease_expires DATETIME,
+				workflow_version INTEGER NOT NULL DEFAULT 1,
+				priority INTEGER NOT NULL DEFAULT 0,
+				parent_close_policy TEXT NOT NULL DEFAULT '',
+				failure_policy TEXT NOT NULL DEFAULT ''
 			);
 		`)
 		if err != nil {
@@ -300,6 +307,44 @@ func TestParentChildWorkflowRepository(t *testing.T) {
 				t.Errorf("Expected parent workflow next_activation to be close to now, got diff: %v", timeDiff)
 			}
 		})
+
+		// Test SetWorkflowVariable
+		t.Run("SetWorkflowVariable", func(t *testing.T) {
+			parentWf := &domain.Workflow{
+				Status:         "IN_PROGRESS",
+				ExecutionCount: 1,
+				RetryCount:     0,
+				Created:        clock.Now(),
+				Modified:       clock.Now(),
+				NextActivation: sql.NullTime{Time: clock.Now(), Valid: true},
+				ExecutorGroup:  "DEFAULT",
+				WorkflowType:   "ParentWorkflow",
+				BusinessKey:    "parent-4",
+				State:          "ParentWaitForChildren",
+				StateVars:      sql.NullString{String: `{"children_count":"1"}`, Valid: true},
+			}
+			parentID, err := wfRepo.Save(parentWf)
+			if err != nil {
+				t.Fatalf("Failed to save parent workflow: %v", err)
+			}
+
+			// a child hands its result to the parent, the other variables stay as they are
+			if err := wfRepo.SetWorkflowVariable(parentID, "child_result.child-4", `{"result":"success"}`); err != nil {
+				t.Fatalf("Failed to set workflow variable: %v", err)
+			}
+
+			parentAfter, err := wfRepo.FindByID(parentID)
+			if err != nil {
+				t.Fatalf("Failed to get parent workflow: %v", err)
+			}
+			var vars map[string]string
+			if err := json.Unmarshal([]byte(parentAfter.StateVars.String), &vars); err != nil {
+				t.Fatalf("Failed to parse state vars: %v", err)
+			}
+			if vars["children_count"] != "1" || vars["child_result.child-4"] != `{"result":"success"}` {
+				t.Errorf("Expected the child result added to the state vars, got %v", vars)
+			}
+		})
 	})
 }
 